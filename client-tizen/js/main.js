@@ -1,5 +1,9 @@
 // Global State
 let ws = null;
+// Server-sent events, when a proxy breaks the WebSocket; messages to the
+// server are POSTed to ssePostUrl
+let sse = null;
+let ssePostUrl = null;
 let config = {
     serverIp: "",
     serverPort: "8080",
@@ -8,6 +12,25 @@ let config = {
 let isSettingsOpen = false;
 let retryTimeout = null;
 
+// Must match protocolVersion in server/hub.go
+const PROTOCOL_VERSION = 3;
+
+// Recent console output, uploaded when the server sends request_logs
+const LOG_BUFFER_SIZE = 500;
+const logBuffer = [];
+['log', 'warn', 'error'].forEach(function(level) {
+    const original = console[level].bind(console);
+    console[level] = function() {
+        const line = new Date().toISOString() + " " + level.toUpperCase() + " " +
+            Array.prototype.map.call(arguments, String).join(" ");
+        logBuffer.push(line);
+        if (logBuffer.length > LOG_BUFFER_SIZE) {
+            logBuffer.shift();
+        }
+        original.apply(null, arguments);
+    };
+});
+
 // Tizen Key Codes
 const KEYS = {
     RETURN: 10009,
@@ -41,29 +64,66 @@ window.onload = function() {
 };
 
 // --- Configuration ---
+function newClientId() {
+    let id = '';
+    for (let i = 0; i < 16; i++) {
+        id += Math.floor(Math.random() * 16).toString(16);
+    }
+    return id;
+}
+
 function loadConfig() {
     const savedIp = localStorage.getItem('serverIp');
     const savedPort = localStorage.getItem('serverPort');
     const savedName = localStorage.getItem('clientName');
+    const savedRoom = localStorage.getItem('room');
     const savedTheme = localStorage.getItem('themeMode');
     const savedZoom = localStorage.getItem('zoom');
+    const savedRotation = localStorage.getItem('rotation');
+    let savedId = localStorage.getItem('clientId');
 
     if (savedIp) config.serverIp = savedIp;
     if (savedPort) config.serverPort = savedPort;
     if (savedName) config.clientName = savedName;
+    config.room = savedRoom || '';
     config.themeMode = savedTheme || 'dark';
     config.zoom = parseInt(savedZoom) || 100;
+    config.rotation = parseInt(savedRotation) || 0;
+    applyRotation();
+
+    // Stable ID for the server, kept across renames and reconnects
+    if (!savedId) {
+        savedId = newClientId();
+        localStorage.setItem('clientId', savedId);
+    }
+    config.clientId = savedId;
 
     // Pre-fill inputs
     document.getElementById('serverIp').value = config.serverIp;
     document.getElementById('serverPort').value = config.serverPort;
     document.getElementById('clientName').value = config.clientName;
+    document.getElementById('room').value = config.room;
+}
+
+// Turn the whole page for portrait-mounted TVs (degrees clockwise)
+function applyRotation() {
+    const degrees = config.rotation;
+    const body = document.body;
+    body.style.transformOrigin = 'top left';
+    body.style.width = (degrees === 90 || degrees === 270) ? '100vh' : '100%';
+    body.style.height = (degrees === 90 || degrees === 270) ? '100vw' : '100%';
+    body.style.transform = {
+        90: 'rotate(90deg) translateY(-100%)',
+        180: 'rotate(180deg) translate(-100%, -100%)',
+        270: 'rotate(270deg) translateX(-100%)'
+    }[degrees] || '';
 }
 
 function saveSettings() {
     const ip = document.getElementById('serverIp').value.trim();
     const port = document.getElementById('serverPort').value.trim();
     const name = document.getElementById('clientName').value.trim();
+    const room = document.getElementById('room').value.trim();
 
     if (!ip) {
         alert("Server IP is required");
@@ -73,10 +133,12 @@ function saveSettings() {
     config.serverIp = ip;
     config.serverPort = port || "8080";
     config.clientName = name || ("Client-Tizen-" + Math.floor(Math.random() * 1000));
+    config.room = room;
 
     localStorage.setItem('serverIp', config.serverIp);
     localStorage.setItem('serverPort', config.serverPort);
     localStorage.setItem('clientName', config.clientName);
+    localStorage.setItem('room', config.room);
 
     closeSettings();
     connect(); // Reconnect with new settings
@@ -107,62 +169,128 @@ function updateStatus(text, color) {
     el.style.color = color;
 }
 
+// Widget version from config.xml, reported in the handshake.
+function appVersion() {
+    try {
+        return tizen.application.getAppInfo().version;
+    } catch (e) {
+        return "unknown";
+    }
+}
+
+// Host part of server URLs; IPv6 literals must be bracketed.
+function serverHost() {
+    const ip = config.serverIp.indexOf(':') !== -1 && config.serverIp[0] !== '['
+        ? `[${config.serverIp}]`
+        : config.serverIp;
+    return `${ip}:${config.serverPort}`;
+}
+
 // --- WebSocket Logic ---
+function handshakeMessage() {
+    return {
+        type: "handshake",
+        payload: {
+            name: config.clientName,
+            id: config.clientId,
+            theme: config.themeMode || 'dark',
+            zoom: config.zoom || 100,
+            rotation: config.rotation || 0,
+            protocol: PROTOCOL_VERSION,
+            version: appVersion(),
+            room: config.room || ''
+        }
+    };
+}
+
+// sendToServer sends msg over whichever connection is up
+function sendToServer(msg) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(msg));
+    } else if (ssePostUrl) {
+        fetch(ssePostUrl, {
+            method: 'POST',
+            headers: {'Content-Type': 'text/plain'}, // No CORS preflight
+            body: JSON.stringify(msg)
+        }).catch(e => console.warn("Message not sent", e));
+    }
+}
+
+function onServerMessage(data) {
+    const msg = JSON.parse(data);
+    handleMessage(msg);
+    // Confirm messages that ask for it (result switches from the admin UI)
+    if (msg.msgId) {
+        sendToServer({ type: "ack", replyTo: msg.msgId });
+    }
+}
+
+function onConnected() {
+    updateStatus("Connected: " + config.clientName, "lime");
+    sendToServer(handshakeMessage());
+
+    // Set title
+    document.title = config.clientName;
+
+    // Hide status after a while
+    setTimeout(() => {
+        if ((ws && ws.readyState === WebSocket.OPEN) || ssePostUrl) {
+            document.getElementById('statusIndicator').style.display = 'none';
+        }
+    }, 5000);
+}
+
+function onDisconnected() {
+    document.getElementById('statusIndicator').style.display = 'block';
+    updateStatus("Disconnected. Retrying...", "red");
+    retryTimeout = setTimeout(connect, 3000);
+}
+
 function connect() {
     if (ws) {
+        ws.onclose = null;
         ws.close();
         ws = null;
     }
+    if (sse) {
+        sse.close();
+        sse = null;
+        ssePostUrl = null;
+    }
     if (retryTimeout) clearTimeout(retryTimeout);
 
-    const wsUrl = `ws://${config.serverIp}:${config.serverPort}/ws`;
+    const wsUrl = `ws://${serverHost()}/ws`;
     updateStatus("Connecting to " + wsUrl + "...", "orange");
     console.log("Connecting to", wsUrl);
 
     try {
+        let opened = false;
         ws = new WebSocket(wsUrl);
 
         ws.onopen = function() {
             console.log("WS Connected");
-            updateStatus("Connected: " + config.clientName, "lime");
-            
-            // Handshake
-            ws.send(JSON.stringify({
-                type: "handshake",
-                payload: {
-                    name: config.clientName,
-                    id: config.clientName,
-                    theme: config.themeMode || 'dark',
-                    zoom: config.zoom || 100
-                }
-            }));
-            
-            // Set title
-            document.title = config.clientName;
-            
-            // Hide status after a while
-            setTimeout(() => {
-                if (ws && ws.readyState === WebSocket.OPEN) {
-                    document.getElementById('statusIndicator').style.display = 'none';
-                }
-            }, 5000);
+            opened = true;
+            onConnected();
         };
 
         ws.onmessage = function(event) {
-            const msg = JSON.parse(event.data);
-            handleMessage(msg);
+            onServerMessage(event.data);
         };
 
         ws.onclose = function() {
             console.log("WS Closed");
-            document.getElementById('statusIndicator').style.display = 'block';
-            updateStatus("Disconnected. Retrying...", "red");
-            retryTimeout = setTimeout(connect, 3000);
+            ws = null;
+            if (!opened) {
+                // Never got through: a proxy may not pass WebSockets on
+                connectSSE();
+                return;
+            }
+            onDisconnected();
         };
 
         ws.onerror = function(e) {
             console.error("WS Error", e);
-            ws.close();
+            if (ws) ws.close();
         };
 
     } catch (e) {
@@ -172,31 +300,280 @@ function connect() {
     }
 }
 
+// The server streams the WebSocket's messages as server-sent events; the
+// first event carries the session to POST our messages to
+function connectSSE() {
+    const sseUrl = `http://${serverHost()}/sse`;
+    updateStatus("Connecting to " + sseUrl + "...", "orange");
+    console.log("Connecting to", sseUrl);
+
+    sse = new EventSource(sseUrl);
+    sse.addEventListener('session', function(event) {
+        console.log("SSE Connected");
+        ssePostUrl = sseUrl + "?session=" + encodeURIComponent(event.data);
+        onConnected();
+    });
+    sse.onmessage = function(event) {
+        onServerMessage(event.data);
+    };
+    sse.onerror = function() {
+        // Reconnect ourselves, trying the WebSocket first again
+        console.log("SSE Closed");
+        sse.close();
+        sse = null;
+        ssePostUrl = null;
+        onDisconnected();
+    };
+}
+
+// A running timer counts down to endsAt (server time), so it is rendered
+// locally between timer updates; time_sync gives the offset to our clock
+let timerState = null;
+let clockOffset = 0;
+
+function renderTimer() {
+    if (!timerState) return;
+    let left = timerState.timeLeft;
+    if (timerState.running && timerState.endsAt) {
+        left = Math.max(0, Math.ceil((timerState.endsAt - Date.now() - clockOffset) / 1000));
+    }
+    // A sport whose clock counts up shows the time played; breaks count down
+    const shown = timerState.clock === 'up' && !timerState.break ? timerState.totalTime - left : left;
+    const clock = document.getElementById('timerClock');
+    if (clock.innerText !== mmss(shown)) {
+        clock.innerText = mmss(shown);
+    }
+    renderPenalties(timerState.timeLeft - left);
+    const period = document.getElementById('timerPeriod');
+    const label = periodLabel(timerState);
+    if (period.innerText !== label) {
+        period.innerText = label;
+    }
+}
+
+// With a match flow on the server: "Period 2" or "Break"
+function periodLabel(state) {
+    if (!state.period) return '';
+    return state.break ? 'Break' : 'Period ' + state.period;
+}
+
+function mmss(seconds) {
+    return Math.floor(seconds / 60).toString().padStart(2, '0') + ':' + (seconds % 60).toString().padStart(2, '0');
+}
+
+// Penalties run with the game clock: elapsed is how far it has counted down
+// since the last timer update
+function renderPenalties(elapsed) {
+    const container = document.getElementById('penalties');
+    const rows = (timerState.penalties || [])
+        .map(p => ({ p: p, left: p.timeLeft - elapsed }))
+        .filter(r => r.left > 0);
+    const key = rows.map(r => r.p.id + ':' + r.left).join(',');
+    if (container.getAttribute('data-key') === key) return;
+    container.setAttribute('data-key', key);
+    container.innerHTML = '';
+    rows.forEach(r => {
+        const row = document.createElement('div');
+        row.className = r.p.team;
+        row.textContent = (r.p.team === 'home' ? 'Home' : 'Away') + (r.p.player ? ' #' + r.p.player : '') + ' ' + mmss(r.left);
+        container.appendChild(row);
+    });
+}
+setInterval(renderTimer, 200);
+
+// With a sport chosen for the room, the overlay shows the score above the
+// clock, or alone for sports without a clock
+let scoreState = null;
+
+function renderScore() {
+    const board = document.getElementById('scoreboard');
+    const on = !!(scoreState && scoreState.scoreboard);
+    board.style.display = on ? 'grid' : 'none';
+    document.getElementById('timerClock').style.display = timerState && timerState.clock === 'none' ? 'none' : '';
+    if (!on) return;
+    const bySets = scoreState.scoreboard === 'sets';
+    const sets = scoreState.sets || [];
+    board.innerHTML = '';
+    const cell = (text, cls) => {
+        const el = document.createElement('div');
+        el.className = cls;
+        el.textContent = text;
+        board.appendChild(el);
+    };
+    ['home', 'away'].forEach(team => {
+        cell(scoreState.serve === team ? '●' : '', 'serve');
+        cell(scoreState[team] || (team === 'home' ? 'Home' : 'Away'), 'name' + (scoreState.winner === team ? ' winner' : ''));
+        cell(bySets ? scoreState.setsWon[team] : '', 'sets');
+        cell(sets.slice(0, -1).map(set => set[team]).join(' '), 'done');
+        cell(sets.length ? sets[sets.length - 1][team] : 0, 'points');
+        cell(scoreState.game ? scoreState.game[team] : '', 'game');
+    });
+}
+
+// The top of the room's radio control; each splits_update replaces the rows,
+// and the newest passing is highlighted
+function renderSplits(state) {
+    document.getElementById('splitsTitle').textContent = state.control ? state.control + (state.class ? ' – ' + state.class : '') : '';
+    const body = document.querySelector('#splitsTable tbody');
+    body.innerHTML = '';
+    (state.rows || []).forEach(r => {
+        const tr = document.createElement('tr');
+        if (r.latest) tr.className = 'latest';
+        const cells = [[r.rank, 'num'], [r.name], [r.club || '']];
+        if (!state.class) cells.push([r.class || '']);
+        cells.push([r.time, 'num'], [r.behind || '', 'num']);
+        cells.forEach(([text, cls]) => {
+            const td = document.createElement('td');
+            td.textContent = text;
+            if (cls) td.className = cls;
+            tr.appendChild(td);
+        });
+        body.appendChild(tr);
+    });
+}
+
+// The buzzer of the room's sport, a square wave for seconds
+function buzz(seconds) {
+    try {
+        const ctx = new (window.AudioContext || window.webkitAudioContext)();
+        const osc = ctx.createOscillator();
+        osc.type = 'square';
+        osc.frequency.value = 440;
+        osc.connect(ctx.destination);
+        osc.onended = () => ctx.close();
+        osc.start();
+        osc.stop(ctx.currentTime + (seconds || 1));
+    } catch (e) {
+        console.error("Buzzer failed: " + e);
+    }
+}
+
+// On a poor link the server asks for lite results (delivery): no images
+// or styles, so the page loads even over bad Wi-Fi. The delivery also names
+// the display's profile, which the server formats names for
+let lite = false;
+let profile = "";
+let resultFile = "";
+
+function resultURL(file) {
+    const query = [];
+    if (lite) query.push("lite=1");
+    if (profile) query.push("profile=" + encodeURIComponent(profile));
+    return `http://${serverHost()}/results/${file}` + (query.length ? "?" + query.join("&") : "");
+}
+
+// Name and address over everything for a few seconds, flashing so the
+// screen stands out among the others in the hall
+let identifyTimer = null;
+function identify(payload) {
+    const el = document.getElementById('identify');
+    document.getElementById('identifyName').textContent = payload.name;
+    document.getElementById('identifyAddr').textContent = payload.addr;
+    clearInterval(identifyTimer);
+    const until = Date.now() + (payload.seconds || 10) * 1000;
+    let on = true;
+    el.classList.add('active');
+    el.style.background = '#facc15';
+    identifyTimer = setInterval(() => {
+        if (Date.now() >= until) {
+            clearInterval(identifyTimer);
+            el.classList.remove('active');
+            return;
+        }
+        on = !on;
+        el.style.background = on ? '#facc15' : '#fff';
+    }, 500);
+}
+
 function handleMessage(msg) {
     const overlay = document.getElementById('timerOverlay');
     const iframe = document.getElementById('resultFrame');
     
     if (msg.type === "timer_update") {
+        timerState = msg.payload;
+        renderTimer();
+        renderScore();
+    } else if (msg.type === "score_update") {
+        scoreState = msg.payload;
+        renderScore();
+    } else if (msg.type === "splits_update") {
+        renderSplits(msg.payload);
+    } else if (msg.type === "buzzer") {
+        if (overlay.classList.contains("active")) {
+            buzz(msg.payload.seconds);
+        }
+    } else if (msg.type === "identify") {
+        identify(msg.payload);
+    } else if (msg.type === "time_sync") {
+        clockOffset = msg.payload.serverTime - Date.now();
+        renderTimer();
+    } else if (msg.type === "state_sync") {
+        // Everything on (re)connect in one message; shown like the separate ones
         const state = msg.payload;
-        const m = Math.floor(state.timeLeft / 60).toString().padStart(2, '0');
-        const s = (state.timeLeft % 60).toString().padStart(2, '0');
-        overlay.innerText = `${m}:${s}`;
+        handleMessage({ type: "display_mode", payload: state.displayMode });
+        handleMessage({ type: "timer_update", payload: state.timer });
+        if (state.score) {
+            handleMessage({ type: "score_update", payload: state.score });
+        }
+        if (state.splits) {
+            handleMessage({ type: "splits_update", payload: state.splits });
+        }
+        if (state.activeResult) {
+            handleMessage({ type: "set_result", payload: { file: state.activeResult } });
+        }
+    } else if (msg.type === "handshake_ack") {
+        if (!msg.payload.compatible) {
+            console.warn("Server " + msg.payload.version + " reports incompatible client: " + msg.payload.warning);
+            updateStatus("Update required: " + msg.payload.warning, "orange");
+        }
+    } else if (msg.type === "set_volume") {
+        // The TV's own volume (tv.audio privilege); there is no API for
+        // the panel's brightness
+        try {
+            tizen.tvaudiocontrol.setVolume(msg.payload);
+        } catch (e) {
+            console.error("Setting the volume failed: " + e);
+        }
+    } else if (msg.type === "request_logs") {
+        fetch(`http://${serverHost()}${msg.payload.uploadUrl}`, {
+            method: 'POST',
+            headers: {'Content-Type': 'text/plain'},
+            body: logBuffer.join("\n") + "\n"
+        }).catch(function(err) {
+            console.error("Log upload failed:", err);
+        });
+    } else if (msg.type === "reload" || msg.type === "clear_cache") {
+        // The app cannot empty the web runtime's cache; a reload at least
+        // fetches the result page again. localStorage keeps the settings.
+        console.log("Reloading on request of the server (" + msg.type + ")");
+        location.reload();
     } else if (msg.type === "display_mode") {
-        if (msg.payload === "show_timer") {
-            overlay.classList.add("active");
+        const splits = document.getElementById('splitsOverlay');
+        if (msg.payload === "show_timer" || msg.payload === "show_splits") {
+            overlay.classList.toggle("active", msg.payload === "show_timer");
+            splits.classList.toggle("active", msg.payload === "show_splits");
             iframe.style.visibility = 'hidden';
             iframe.style.opacity = '0';
         } else {
             overlay.classList.remove("active");
+            splits.classList.remove("active");
             iframe.style.visibility = 'visible';
             iframe.style.opacity = '1';
         }
     } else if (msg.type === "set_result") {
-        // Construct URL
-        const url = `http://${config.serverIp}:${config.serverPort}/results/${msg.payload.file}`;
+        resultFile = msg.payload.file;
+        const url = resultURL(resultFile);
         if (iframe.src !== url) {
             iframe.src = url;
         }
+    } else if (msg.type === "delivery") {
+        if (msg.payload.lite !== lite || (msg.payload.profile || "") !== profile) {
+            lite = msg.payload.lite;
+            profile = msg.payload.profile || "";
+            if (resultFile) {
+                iframe.src = resultURL(resultFile);
+            }
+        }
     } else if (msg.type === "theme_mode") {
         config.themeMode = msg.payload;
         localStorage.setItem('themeMode', msg.payload);
@@ -211,6 +588,10 @@ function handleMessage(msg) {
             iframe.style.width = (100 / scale) + '%';
             iframe.style.height = (100 / scale) + '%';
         }
+    } else if (msg.type === "set_rotation") {
+        config.rotation = msg.payload;
+        localStorage.setItem('rotation', String(msg.payload));
+        applyRotation();
     } else if (msg.type === "update_config") {
         // Handle Rename from Server
         if (msg.payload.key === "ClientName") {
@@ -219,15 +600,7 @@ function handleMessage(msg) {
             document.title = config.clientName;
             
             // Re-handshake
-            ws.send(JSON.stringify({
-                type: "handshake",
-                payload: {
-                    name: config.clientName,
-                    id: config.clientName,
-                    theme: config.themeMode || 'dark',
-                    zoom: config.zoom || 100
-                }
-            }));
+            sendToServer(handshakeMessage());
             
             // Show status briefly
             const s = document.getElementById('statusIndicator');
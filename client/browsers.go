@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Browser families, which differ in their kiosk flags.
+const (
+	familyChromium = "chromium"
+	familyFirefox  = "firefox"
+	familyEpiphany = "epiphany"
+)
+
+// kioskBrowsers are tried in order when client.json names no browser.
+var kioskBrowsers = []struct {
+	Command string
+	Family  string
+}{
+	{"chromium-browser", familyChromium},
+	{"chromium", familyChromium},
+	{"google-chrome", familyChromium},
+	{"firefox", familyFirefox},
+	{"firefox-esr", familyFirefox},
+	{"epiphany-browser", familyEpiphany},
+	{"epiphany", familyEpiphany},
+}
+
+// browserFamily guesses the family of a configured browser command.
+func browserFamily(command string) string {
+	name := strings.ToLower(filepath.Base(command))
+	switch {
+	case strings.Contains(name, "firefox"):
+		return familyFirefox
+	case strings.Contains(name, "epiphany"):
+		return familyEpiphany
+	}
+	return familyChromium
+}
+
+// findKioskBrowser returns the browser to use: the one configured in
+// client.json, or the first installed one from kioskBrowsers. The path is
+// empty if none is installed.
+func findKioskBrowser(configured string) (path, family string) {
+	if configured != "" {
+		path, err := exec.LookPath(configured)
+		if err != nil {
+			return "", ""
+		}
+		return path, browserFamily(configured)
+	}
+	for _, b := range kioskBrowsers {
+		if path, err := exec.LookPath(b.Command); err == nil {
+			return path, b.Family
+		}
+	}
+	return "", ""
+}
+
+// browserProfile returns the profile directory of a window. Snap-confined
+// browsers cannot write outside ~/snap/<name>, so theirs lives there.
+func browserProfile(path, family, suffix string) string {
+	dir := os.TempDir()
+	if strings.HasPrefix(path, "/snap/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, "snap", filepath.Base(path), "common")
+		}
+	}
+	return filepath.Join(dir, "display-client-"+map[string]string{
+		familyChromium: "chrome",
+		familyFirefox:  "firefox",
+		familyEpiphany: "epiphany",
+	}[family]+suffix)
+}
+
+// kioskArgs returns the default kiosk flags of a family. Only Chromium
+// honours window placement.
+func kioskArgs(family, profile string, window browserWindow) []string {
+	switch family {
+	case familyFirefox:
+		os.MkdirAll(profile, 0755) // Firefox refuses a profile directory that does not exist
+		return []string{"--kiosk", "--no-remote", "--profile", profile}
+	case familyEpiphany:
+		return []string{"--application-mode", "--private-instance", "--profile=" + profile}
+	}
+	args := []string{
+		"--kiosk",
+		"--no-first-run",
+		"--no-errdialogs",
+		"--disable-infobars",
+		"--disable-restore-session-state",
+		"--check-for-update-interval=31536000",
+		"--start-maximized",
+		"--enable-features=OverlayScrollbar",
+		"--password-store=basic",
+		"--autoplay-policy=no-user-gesture-required", // The buzzer of sport profiles
+		"--user-data-dir=" + profile,
+	}
+	return append(args, window.Args...)
+}
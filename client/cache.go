@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxCachedFile = 50 << 20  // Larger responses are passed through uncached
+	maxCacheBytes = 200 << 20 // Least recently used entries are removed beyond this
+	proxyTimeout  = 10 * time.Second
+	cacheMetaExt  = ".json"
+	cacheBodyExt  = ".body"
+	cachedFromHdr = "X-Display-Cache" // "hit" when served from the cache because the server is unreachable
+)
+
+// resultCache proxies /results/ to the server and keeps a copy of every
+// response, so a display can still show the last results (with an offline
+// banner) while the server is down or restarting.
+type resultCache struct {
+	dir    string
+	client *http.Client
+	mu     sync.Mutex // Serialises writes and pruning
+}
+
+// cacheMeta is stored next to each cached body.
+type cacheMeta struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+func newResultCache(dir string) *resultCache {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Warn("Cannot create result cache, results are not cached", "dir", dir, "err", err)
+	}
+	return &resultCache{dir: dir, client: &http.Client{Timeout: proxyTimeout}}
+}
+
+// key names the cache entry of a path and query.
+func (c *resultCache) key(pathAndQuery string) string {
+	sum := sha256.Sum256([]byte(pathAndQuery))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16]))
+}
+
+// ServeHTTP fetches the result from the server, or serves the cached copy if
+// the server cannot be reached.
+func (c *resultCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathAndQuery := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		pathAndQuery += "?" + r.URL.RawQuery
+	}
+
+	mu.Lock()
+	found := serverFound
+	base := serverURL("http")
+	mu.Unlock()
+
+	if found {
+		err := c.fetch(w, base+pathAndQuery, pathAndQuery)
+		if err == nil {
+			return
+		}
+		slog.Debug("Result fetch failed, trying cache", "path", pathAndQuery, "err", err)
+	}
+	if !c.serveCached(w, r, pathAndQuery) {
+		http.Error(w, "Server unreachable and result not cached", http.StatusBadGateway)
+	}
+}
+
+// fetch proxies url to w and stores a 200 response. It returns an error
+// without writing anything if the server could not be reached or failed, so
+// the caller can fall back to the cache.
+func (c *resultCache) fetch(w http.ResponseWriter, url, pathAndQuery string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "score-display-client/"+version)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(resp.StatusCode) // 404 and the like are answers, not outages
+		io.Copy(w, resp.Body)
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedFile+1))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache") // Always ask us, so a cached copy never outlives an update
+	if len(body) > maxCachedFile {
+		w.Write(body)
+		io.Copy(w, resp.Body)
+		return nil
+	}
+	w.Write(body)
+	if err := c.store(pathAndQuery, contentType, body); err != nil {
+		slog.Warn("Failed to cache result", "path", pathAndQuery, "err", err)
+	}
+	return nil
+}
+
+// store writes a cache entry, replacing any previous one, and prunes.
+func (c *resultCache) store(pathAndQuery, contentType string, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base := c.key(pathAndQuery)
+	if old, err := os.ReadFile(base + cacheBodyExt); err == nil && bytes.Equal(old, body) {
+		now := time.Now() // Unchanged: only mark it as recently used
+		os.Chtimes(base+cacheBodyExt, now, now)
+		return nil
+	}
+	meta, err := json.Marshal(cacheMeta{URL: pathAndQuery, ContentType: contentType, Fetched: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+cacheBodyExt, body); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+cacheMetaExt, meta); err != nil {
+		return err
+	}
+	c.prune()
+	return nil
+}
+
+// serveCached writes the cached copy of pathAndQuery, reporting whether
+// there was one.
+func (c *resultCache) serveCached(w http.ResponseWriter, r *http.Request, pathAndQuery string) bool {
+	base := c.key(pathAndQuery)
+	data, err := os.ReadFile(base + cacheMetaExt)
+	if err != nil {
+		return false
+	}
+	var meta cacheMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return false
+	}
+	f, err := os.Open(base + cacheBodyExt)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", meta.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(cachedFromHdr, "hit")
+	http.ServeContent(w, r, "", meta.Fetched, f)
+	return true
+}
+
+// prune removes the least recently used entries beyond maxCacheBytes.
+// Caller holds c.mu.
+func (c *resultCache) prune() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type body struct {
+		base string
+		size int64
+		used time.Time
+	}
+	var bodies []body
+	var total int64
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), cacheBodyExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		bodies = append(bodies, body{filepath.Join(c.dir, strings.TrimSuffix(e.Name(), cacheBodyExt)), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(bodies, func(i, j int) bool { return bodies[i].used.Before(bodies[j].used) })
+	for _, b := range bodies {
+		if total <= maxCacheBytes {
+			break
+		}
+		os.Remove(b.base + cacheBodyExt)
+		os.Remove(b.base + cacheMetaExt)
+		total -= b.size
+	}
+}
+
+// writeFileAtomic replaces path via a temporary file, so a crash never leaves
+// a half-written cache entry.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	devtoolsGrace       = 45 * time.Second // Time for the browser to start and load the page
+	devtoolsInterval    = 15 * time.Second
+	devtoolsTimeout     = 5 * time.Second
+	devtoolsMaxFailures = 3                // Unanswered checks, or reloads that did not help, in a row before the browser is restarted
+	pageTickMaxAge      = 60 * time.Second // The page's script updates watchdogTick every 5s
+)
+
+// devtoolsTarget is a page or other target from /json/list.
+type devtoolsTarget struct {
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// pageHealth is what healthExpression evaluates to in the display page.
+type pageHealth struct {
+	BodyLength int     `json:"bodyLength"`
+	TickAge    float64 `json:"tickAge"` // Milliseconds since the page's last watchdogTick, -1 before the first
+}
+
+const healthExpression = `({
+	bodyLength: document.body ? document.body.innerHTML.length : 0,
+	tickAge: window.watchdogTick ? Date.now() - window.watchdogTick : -1
+})`
+
+// freePort returns a loopback port that is free right now, for Chromium's
+// --remote-debugging-port.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// devtoolsWatchdog watches the page in a Chromium started with
+// --remote-debugging-port=port until ctx is cancelled. A page that navigated
+// away, stayed blank or whose script stopped is loaded again; a browser that
+// stops answering (hung renderer or browser) is killed, so browserSupervisor
+// restarts it. Process liveness alone misses all of these.
+func devtoolsWatchdog(ctx context.Context, port int, pageURL string, cmd *exec.Cmd) {
+	base := "http://127.0.0.1:" + strconv.Itoa(port)
+	failures, reloads, blank := 0, 0, 0
+	wait := devtoolsGrace
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = devtoolsInterval
+
+		reloaded, err := checkPage(ctx, base, pageURL, &blank)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			failures++
+			slog.Warn("Browser watchdog: check failed", "failures", failures, "err", err)
+		case reloaded:
+			failures = 0
+			reloads++
+		default:
+			failures, reloads = 0, 0
+		}
+		if failures >= devtoolsMaxFailures || reloads >= devtoolsMaxFailures {
+			slog.Error("Browser watchdog: browser not recovering, restarting it", "failures", failures, "reloads", reloads)
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			return
+		}
+	}
+}
+
+// checkPage makes sure the display page is open and alive. Problems it can
+// repair by loading the page again are repaired and logged, reporting
+// reloaded; it returns an error if the browser did not answer.
+func checkPage(ctx context.Context, base, pageURL string, blank *int) (reloaded bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, devtoolsTimeout)
+	defer cancel()
+
+	var targets []devtoolsTarget
+	if err := devtoolsGet(ctx, base+"/json/list", &targets); err != nil {
+		return false, err
+	}
+	var page *devtoolsTarget
+	for i := range targets {
+		if targets[i].Type == "page" {
+			page = &targets[i]
+			break
+		}
+	}
+	if page == nil {
+		slog.Warn("Browser watchdog: no page open, opening the display page")
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, base+"/json/new?"+url.QueryEscape(pageURL), nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return true, nil
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, page.WebSocketDebuggerURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("connect to page: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+
+	if !strings.HasPrefix(page.URL, pageURL) {
+		slog.Warn("Browser watchdog: page navigated away, loading the display page", "url", page.URL)
+		return true, navigate(conn, pageURL)
+	}
+
+	var health pageHealth
+	if err := evaluate(conn, healthExpression, &health); err != nil {
+		return false, fmt.Errorf("page not responding: %w", err)
+	}
+	switch {
+	case health.TickAge > float64(pageTickMaxAge.Milliseconds()):
+		slog.Warn("Browser watchdog: page script stopped, reloading", "lastTick", time.Duration(health.TickAge)*time.Millisecond)
+		return true, navigate(conn, pageURL)
+	case health.BodyLength == 0:
+		*blank++
+		if *blank >= 2 { // Blank on two checks in a row, not just loading
+			*blank = 0
+			slog.Warn("Browser watchdog: blank page, reloading")
+			return true, navigate(conn, pageURL)
+		}
+	default:
+		*blank = 0
+	}
+	return false, nil
+}
+
+// reloadViaDevtools loads the display page again in the Chromium with
+// DevTools on port, after dropping the browser's HTTP cache, for the reload
+// command.
+func reloadViaDevtools(ctx context.Context, port int, pageURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, devtoolsTimeout)
+	defer cancel()
+	var targets []devtoolsTarget
+	if err := devtoolsGet(ctx, "http://127.0.0.1:"+strconv.Itoa(port)+"/json/list", &targets); err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if target.Type != "page" {
+			continue
+		}
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, target.WebSocketDebuggerURL, nil)
+		if err != nil {
+			return fmt.Errorf("connect to page: %w", err)
+		}
+		defer conn.Close()
+		deadline, _ := ctx.Deadline()
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+		if _, err := devtoolsCall(conn, "Network.clearBrowserCache", map[string]any{}); err != nil {
+			slog.Warn("Browser cache not cleared", "err", err)
+		}
+		return navigate(conn, pageURL)
+	}
+	return errors.New("no page open")
+}
+
+func devtoolsGet(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// devtoolsCall sends one DevTools protocol command and waits for its answer,
+// skipping events.
+func devtoolsCall(conn *websocket.Conn, method string, params any) (json.RawMessage, error) {
+	if err := conn.WriteJSON(map[string]any{"id": 1, "method": method, "params": params}); err != nil {
+		return nil, err
+	}
+	for {
+		var resp struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := conn.ReadJSON(&resp); err != nil {
+			return nil, err
+		}
+		if resp.ID != 1 {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, errors.New(resp.Error.Message)
+		}
+		return resp.Result, nil
+	}
+}
+
+func evaluate(conn *websocket.Conn, expression string, v any) error {
+	raw, err := devtoolsCall(conn, "Runtime.evaluate", map[string]any{"expression": expression, "returnByValue": true})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return errors.New(result.ExceptionDetails.Text)
+	}
+	return json.Unmarshal(result.Result.Value, v)
+}
+
+func navigate(conn *websocket.Conn, pageURL string) error {
+	_, err := devtoolsCall(conn, "Page.navigate", map[string]any{"url": pageURL})
+	return err
+}
@@ -2,24 +2,370 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grandcat/zeroconf"
 )
 
 type ServiceEntry struct {
-	Host string
-	Port int
-	IP   string
+	Name string `json:"name"` // serverName of the server
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	IP   string `json:"ip"`
+	// From the TXT record or UDP reply; empty/zero for servers that do not
+	// announce them
+	Version     string `json:"version,omitempty"`
+	Protocol    int    `json:"protocol,omitempty"`
+	TLS         bool   `json:"tls,omitempty"` // Connect with https/wss
+	Competition string `json:"competition,omitempty"`
 }
 
-func findServer() (*ServiceEntry, error) {
-	return findServerWithTimeout(5 * time.Second)
+// addr is the host:port to connect to.
+func (e ServiceEntry) addr() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(e.Port))
 }
 
-func findServerWithTimeout(timeout time.Duration) (*ServiceEntry, error) {
+// matches reports whether the entry is the server preferred names: its
+// serverName or host name (either case), or its ip:port.
+func (e ServiceEntry) matches(preferred string) bool {
+	return strings.EqualFold(e.Name, preferred) ||
+		strings.EqualFold(strings.TrimSuffix(e.Host, "."), strings.TrimSuffix(preferred, ".")) ||
+		e.addr() == preferred
+}
+
+// parseText fills in the metadata of a _display._tcp TXT record.
+func (e *ServiceEntry) parseText(txt []string) {
+	for _, entry := range txt {
+		key, value, _ := strings.Cut(entry, "=")
+		switch key {
+		case "name":
+			if value != "" {
+				e.Name = value
+			}
+		case "version":
+			e.Version = value
+		case "protocol":
+			e.Protocol, _ = strconv.Atoi(value)
+		case "tls":
+			e.TLS = value == "1"
+		case "competition":
+			e.Competition = value
+		}
+	}
+}
+
+// discoverySettle is how long discovery keeps listening after the first
+// server answers, so every server on the network is listed.
+const discoverySettle = time.Second
+
+var (
+	discoveredServers []ServiceEntry // Found in the last discovery round, under mu
+	// rediscover makes discoveryLoop look for servers at once, e.g. after
+	// the preferred server changed
+	rediscover = make(chan struct{}, 1)
+)
+
+// pickAddress chooses the address to connect to for a discovered server.
+// IPv4 is preferred; otherwise a routable IPv6 address is used. Link-local
+// IPv6 addresses are skipped because they need a zone, which URLs cannot carry
+// reliably in browsers.
+func pickAddress(entry *zeroconf.ServiceEntry) string {
+	if len(entry.AddrIPv4) > 0 {
+		return entry.AddrIPv4[0].String()
+	}
+	for _, ip := range entry.AddrIPv6 {
+		if !ip.IsLinkLocalUnicast() {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// Discovery methods (client.json "discovery").
+const (
+	discoveryAuto = "auto" // mDNS, then UDP broadcast if mDNS finds nothing
+	discoveryMDNS = "mdns"
+	discoveryUDP  = "udp"
+)
+
+// UDP broadcast discovery, for networks that filter mDNS. Must match
+// server/discovery.go.
+const (
+	udpDiscoveryPort    = 8089
+	udpDiscoveryRequest = "score-display discover 1"
+)
+
+func validDiscovery(method string) bool {
+	switch method {
+	case "", discoveryAuto, discoveryMDNS, discoveryUDP:
+		return true
+	}
+	return false
+}
+
+// findServer discovers the servers on the network and picks one: the
+// preferredServer of client.json if set (and no other), otherwise current
+// while it is still there, otherwise the first by name. With serverAddress
+// set, that server is used without discovery.
+func findServer(current string) (*ServiceEntry, error) {
+	mu.Lock()
+	address := localConfig.ServerAddress
+	mu.Unlock()
+	if address != "" {
+		return manualServer(address)
+	}
+	servers, err := findServers()
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	discoveredServers = servers
+	preferred := localConfig.PreferredServer
+	mu.Unlock()
+
+	if preferred != "" {
+		for _, s := range servers {
+			if s.matches(preferred) {
+				return &s, nil
+			}
+		}
+		return nil, fmt.Errorf("preferred server %q not found among %d servers", preferred, len(servers))
+	}
+	for _, s := range servers {
+		if s.addr() == current {
+			return &s, nil
+		}
+	}
+	if len(servers) > 1 {
+		slog.Debug("Several servers found, set preferredServer in client.json to choose", "count", len(servers))
+	}
+	return &servers[0], nil
+}
+
+// defaultServerPort is the server's default port, for a serverAddress
+// without one.
+const defaultServerPort = 8080
+
+// parseServerAddress checks a serverAddress: a host or IP, with a port or
+// not, and an http:// or https:// scheme or none.
+func parseServerAddress(address string) (*url.URL, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("server address %q must be host:port or https://host:port", address)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(defaultServerPort))
+	}
+	return u, nil
+}
+
+// manualServer checks that the server at address answers (GET /api/servers,
+// which also says its serverName) and returns it as discovery would.
+func manualServer(address string) (*ServiceEntry, error) {
+	u, err := parseServerAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(u.JoinPath("api/servers").String())
+	if err != nil {
+		return nil, fmt.Errorf("server %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server %s: %s", u.Host, resp.Status)
+	}
+	var servers []struct {
+		Name        string `json:"name"`
+		Version     string `json:"version"`
+		Competition string `json:"competition"`
+		Self        bool   `json:"self"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, fmt.Errorf("server %s: %w", u.Host, err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	entry := ServiceEntry{Name: u.Hostname(), Host: u.Hostname(), IP: u.Hostname(), Port: port, TLS: u.Scheme == "https"}
+	for _, s := range servers {
+		if s.Self {
+			entry.Name, entry.Version, entry.Competition = s.Name, s.Version, s.Competition
+		}
+	}
+	mu.Lock()
+	discoveredServers = []ServiceEntry{entry}
+	mu.Unlock()
+	return &entry, nil
+}
+
+// findServers returns every server that answers, sorted by name.
+func findServers() ([]ServiceEntry, error) {
+	mu.Lock()
+	method := localConfig.Discovery
+	mu.Unlock()
+	var servers []ServiceEntry
+	var err error
+	switch method {
+	case discoveryMDNS:
+		servers, err = findServerWithTimeout(5 * time.Second)
+	case discoveryUDP:
+		servers, err = findServerUDP(3 * time.Second)
+	default:
+		servers, err = findServerWithTimeout(5 * time.Second)
+		if err != nil {
+			var udpErr error
+			servers, udpErr = findServerUDP(3 * time.Second)
+			if udpErr != nil {
+				return nil, fmt.Errorf("mDNS: %v; UDP broadcast: %v", err, udpErr)
+			}
+			slog.Debug("Found server via UDP broadcast after mDNS found none")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if !strings.EqualFold(servers[i].Name, servers[j].Name) {
+			return strings.ToLower(servers[i].Name) < strings.ToLower(servers[j].Name)
+		}
+		return servers[i].addr() < servers[j].addr()
+	})
+	return servers, nil
+}
+
+// switchServer makes name the preferred server, as the admin's switch_server
+// command does, and looks for it at once.
+func switchServer(name string) {
+	mu.Lock()
+	localConfig.PreferredServer = name
+	cfg := localConfig
+	mu.Unlock()
+	if err := saveLocalConfig(cfg); err != nil {
+		slog.Error("Failed to save config", "err", err)
+	}
+	slog.Info("Switching server", "preferred", name)
+	select {
+	case rediscover <- struct{}{}:
+	default:
+	}
+}
+
+// findServerUDP broadcasts a discovery request on every IPv4 network and
+// collects the servers that answer.
+func findServerUDP(timeout time.Duration) ([]ServiceEntry, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	targets := append([]net.IP{net.IPv4bcast}, broadcastAddrs()...)
+	sent := 0
+	for _, ip := range targets {
+		if _, err := conn.WriteToUDP([]byte(udpDiscoveryRequest), &net.UDPAddr{IP: ip, Port: udpDiscoveryPort}); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("failed to send UDP broadcast")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 512)
+	var servers []ServiceEntry
+	seen := make(map[string]bool)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if len(servers) == 0 {
+				return nil, fmt.Errorf("no server answered the UDP broadcast")
+			}
+			return servers, nil
+		}
+		var reply struct {
+			Service     string `json:"service"`
+			Name        string `json:"name"`
+			Host        string `json:"host"`
+			Addr        string `json:"addr"`
+			Port        int    `json:"port"`
+			Version     string `json:"version"`
+			Protocol    int    `json:"protocol"`
+			TLS         bool   `json:"tls"`
+			Competition string `json:"competition"`
+		}
+		if json.Unmarshal(buf[:n], &reply) != nil || reply.Service != "score-display" || reply.Port <= 0 {
+			continue
+		}
+		ip := from.IP.String()
+		if reply.Addr != "" {
+			ip = reply.Addr // The server is bound to one address
+		}
+		entry := ServiceEntry{Name: reply.Name, Host: reply.Host, Port: reply.Port, IP: ip,
+			Version: reply.Version, Protocol: reply.Protocol, TLS: reply.TLS, Competition: reply.Competition}
+		if entry.Name == "" {
+			entry.Name = reply.Host // Servers from before serverName
+		}
+		if seen[entry.addr()] {
+			continue // Answered on several networks
+		}
+		seen[entry.addr()] = true
+		slog.Debug("Found server", "via", "udp", "name", entry.Name, "host", reply.Host, "addr", entry.addr())
+		if len(servers) == 0 {
+			conn.SetReadDeadline(time.Now().Add(discoverySettle))
+		}
+		servers = append(servers, entry)
+	}
+}
+
+// broadcastAddrs returns the directed broadcast address of each IPv4
+// network, since 255.255.255.255 only leaves through one interface.
+func broadcastAddrs() []net.IP {
+	var addrs []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		ifAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range ifAddrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			ip, mask := ipNet.IP.To4(), ipNet.Mask
+			if len(mask) == net.IPv6len {
+				mask = mask[12:]
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^mask[i]
+			}
+			addrs = append(addrs, bcast)
+		}
+	}
+	return addrs
+}
+
+// findServerWithTimeout browses mDNS for servers until timeout, or until
+// discoverySettle after the first one answers.
+func findServerWithTimeout(timeout time.Duration) ([]ServiceEntry, error) {
 	resolver, err := zeroconf.NewResolver(nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize resolver: %w", err)
@@ -35,24 +381,70 @@ func findServerWithTimeout(timeout time.Duration) (*ServiceEntry, error) {
 		return nil, fmt.Errorf("failed to browse: %w", err)
 	}
 
-	fmt.Println("Scanning for Display Server...")
+	slog.Debug("Scanning for Display Server")
+	var servers []ServiceEntry
+	var settle <-chan time.Time
 	for {
 		select {
 		case <-ctx.Done():
-			return nil, fmt.Errorf("no server found within timeout")
+		case <-settle:
 		case entry, ok := <-entries:
-			if !ok {
-				return nil, fmt.Errorf("no server found within timeout")
-			}
-			if len(entry.AddrIPv4) > 0 {
-				ip := entry.AddrIPv4[0].String()
-				log.Printf("Found Server: %s at %s:%d", entry.Instance, ip, entry.Port)
-				return &ServiceEntry{
-					Host: entry.HostName,
-					Port: entry.Port,
-					IP:   ip,
-				}, nil
+			if ok {
+				ip := pickAddress(entry)
+				if ip == "" {
+					continue
+				}
+				found := ServiceEntry{Name: entry.Instance, Host: entry.HostName, Port: entry.Port, IP: ip}
+				found.parseText(entry.Text)
+				slog.Debug("Found server", "instance", entry.Instance, "name", found.Name, "addr", found.addr(), "protocol", found.Protocol, "competition", found.Competition)
+				if settle == nil {
+					settle = time.After(discoverySettle)
+				}
+				servers = append(servers, found)
+				continue
 			}
 		}
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("no server found within timeout")
+		}
+		return servers, nil
+	}
+}
+
+var advertisement *zeroconf.Server
+
+// advertiseText is the TXT record of _displayclient._tcp: the server matches
+// id against connected displays to list the ones that have not connected.
+func advertiseText() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return []string{"txtv=0", "id=" + localConfig.ClientID, "name=" + clientName, "version=" + version, "instance=" + instance}
+}
+
+// advertiseClient announces this client as _displayclient._tcp on port, so
+// the server can see it on the network even before it connects.
+func advertiseClient(port int) {
+	mu.Lock()
+	name := "display-" + localConfig.ClientID // Unique, unlike display names
+	mu.Unlock()
+	var err error
+	advertisement, err = zeroconf.Register(name, "_displayclient._tcp", "local.", port, advertiseText(), nil)
+	if err != nil {
+		slog.Warn("Failed to advertise client via mDNS", "err", err)
+		return
+	}
+	slog.Info("mDNS client advertisement registered", "instance", name+"._displayclient._tcp.local.", "port", port)
+}
+
+// updateAdvertisement republishes the TXT record after a rename.
+func updateAdvertisement() {
+	if advertisement != nil {
+		advertisement.SetText(advertiseText())
+	}
+}
+
+func stopAdvertisement() {
+	if advertisement != nil {
+		advertisement.Shutdown()
 	}
 }
@@ -0,0 +1,8 @@
+package main
+
+import "display/internal/protocol"
+
+// Health is a snapshot of the display's system state. The display page polls
+// it from GET /health and forwards it to the server as a heartbeat message,
+// so overheating or full displays show up in the admin UI.
+type Health = protocol.Health
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// collectHealth reads load, memory, uptime and temperature from /proc and
+// /sys. Values that cannot be read are left at zero.
+func collectHealth() Health {
+	var h Health
+
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			h.Load1, _ = strconv.ParseFloat(fields[0], 64)
+		}
+	}
+
+	if data, err := os.ReadFile("/proc/uptime"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			up, _ := strconv.ParseFloat(fields[0], 64)
+			h.UptimeSec = int64(up)
+		}
+	}
+
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		var total, available float64
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[0] {
+			case "MemTotal:":
+				total, _ = strconv.ParseFloat(fields[1], 64)
+			case "MemAvailable:":
+				available, _ = strconv.ParseFloat(fields[1], 64)
+			}
+		}
+		f.Close()
+		if total > 0 {
+			h.MemUsedPct = round1((total - available) / total * 100)
+		}
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(baseDir, &fs); err == nil && fs.Blocks > 0 {
+		h.DiskUsedPct = round1(float64(fs.Blocks-fs.Bavail) / float64(fs.Blocks) * 100)
+	}
+
+	// Millidegrees Celsius; thermal_zone0 is the SoC on a Raspberry Pi.
+	if data, err := os.ReadFile("/sys/class/thermal/thermal_zone0/temp"); err == nil {
+		if milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err == nil {
+			h.CPUTempC = round1(milli / 1000)
+		}
+	}
+
+	return h
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
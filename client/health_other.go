@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// collectHealth is only implemented for Linux (the Raspberry Pi kiosk).
+func collectHealth() Health {
+	return Health{}
+}
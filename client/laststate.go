@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// The last state each window showed (display mode, result, timer with its
+// deadline, score and splits) is saved under state/, so after a power cut
+// the page shows it again at once, with the offline banner and the result
+// from the cache, instead of waiting for discovery and the server. The
+// first state_sync from the server replaces it.
+
+// stateSaveDelay batches the changes saved together, sparing the SD card
+// while a timer or score changes every second.
+const stateSaveDelay = 5 * time.Second
+
+// persistedTypes are the replayedTypes kept across restarts.
+var persistedTypes = []string{"display_mode", "set_result", "timer_update", "score_update", "splits_update"}
+
+// savedState is the file of one window.
+type savedState struct {
+	Saved         time.Time                  `json:"saved"`
+	Synced        bool                       `json:"synced,omitempty"`
+	ClockOffsetMs int64                      `json:"clockOffsetMs,omitempty"` // Server clock minus ours, for the timer's endsAt
+	Messages      map[string]json.RawMessage `json:"messages"`
+}
+
+func statePath(monitor int) string {
+	return filepath.Join(instanceDir("state"), fmt.Sprintf("monitor-%d.json", monitor))
+}
+
+// restoreState loads the saved state of a new link, before it runs.
+func (l *serverLink) restoreState() {
+	data, err := os.ReadFile(statePath(l.monitor))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Cannot read saved state", "monitor", l.monitor, "err", err)
+		}
+		return
+	}
+	var saved savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		slog.Warn("Ignoring unreadable saved state", "monitor", l.monitor, "err", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range persistedTypes {
+		if msg := saved.Messages[t]; msg != nil {
+			l.last[t] = msg
+		}
+	}
+	if len(l.last) == 0 {
+		return
+	}
+	l.restored = true
+	l.status.Restored = true
+	l.offset, l.synced = time.Duration(saved.ClockOffsetMs)*time.Millisecond, saved.Synced
+	slog.Info("Restored last state", "monitor", l.monitor, "saved", saved.Saved.Format(time.DateTime), "messages", len(l.last))
+}
+
+// dropRestored forgets the restored state once the server sends its own, so
+// a result or score the server no longer has is not replayed or saved.
+// Caller holds l.mu.
+func (l *serverLink) dropRestored() {
+	if !l.restored {
+		return
+	}
+	for _, t := range persistedTypes {
+		delete(l.last, t)
+	}
+	l.restored = false
+	l.status.Restored = false
+}
+
+// scheduleSave saves the state after stateSaveDelay, once for all changes
+// until then. Caller holds l.mu.
+func (l *serverLink) scheduleSave(msgType string) {
+	if !slices.Contains(persistedTypes, msgType) || l.saveTimer != nil {
+		return
+	}
+	l.saveTimer = time.AfterFunc(stateSaveDelay, l.saveState)
+}
+
+func (l *serverLink) saveState() {
+	l.mu.Lock()
+	l.saveTimer = nil
+	saved := savedState{Saved: time.Now(), Synced: l.synced, ClockOffsetMs: l.offset.Milliseconds(), Messages: map[string]json.RawMessage{}}
+	for _, t := range persistedTypes {
+		if data := l.last[t]; data != nil {
+			saved.Messages[t] = data
+		}
+	}
+	l.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		slog.Error("Failed to marshal state", "err", err)
+		return
+	}
+	path := statePath(l.monitor)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Warn("Failed to save state", "monitor", l.monitor, "err", err)
+		return
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		slog.Warn("Failed to save state", "monitor", l.monitor, "err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Brightness and volume follow set_brightness and set_volume (percent), so
+// panels can be dimmed and the buzzer turned down from the Admin UI, e.g.
+// for evening sessions. Brightness goes to a backlight the kernel exposes
+// (the Raspberry Pi touch display, laptops) or over DDC/CI with ddcutil to
+// the monitor; TVs on HDMI usually take neither. Volume goes to the default
+// output through PipeWire (wpctl), PulseAudio (pactl) or ALSA (amixer), or
+// AppleScript on macOS.
+
+// setBrightness sets the screen of monitor to percent.
+func setBrightness(monitor, percent int) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("brightness is not supported on %s", runtime.GOOS)
+	}
+	err := backlightBrightness(percent)
+	if err == nil {
+		return nil
+	}
+	if _, lookErr := exec.LookPath("ddcutil"); lookErr != nil {
+		return fmt.Errorf("%v, and no ddcutil for DDC/CI", err)
+	}
+	mu.Lock()
+	display := screenIndex(monitor) + 1 // ddcutil counts from 1
+	mu.Unlock()
+	// VCP feature 0x10 is the luminance, 0-100 on most monitors
+	return runScreenTool("ddcutil", "--display", strconv.Itoa(display), "setvcp", "10", strconv.Itoa(percent))
+}
+
+// backlightBrightness writes percent of max_brightness to every backlight
+// under /sys/class/backlight. Writing needs the video group (Raspberry Pi
+// OS) or a udev rule.
+func backlightBrightness(percent int) error {
+	dirs, _ := filepath.Glob("/sys/class/backlight/*")
+	if len(dirs) == 0 {
+		return errors.New("no backlight")
+	}
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, "max_brightness"))
+		if err != nil {
+			return err
+		}
+		maxLevel, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		level := (maxLevel*percent + 50) / 100
+		if err := os.WriteFile(filepath.Join(dir, "brightness"), []byte(strconv.Itoa(level)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setVolume sets the default audio output to percent, unmuting it unless
+// percent is 0.
+func setVolume(percent int) error {
+	switch runtime.GOOS {
+	case "linux":
+	case "darwin":
+		return runScreenTool("osascript", "-e", "set volume output volume "+strconv.Itoa(percent))
+	default:
+		return fmt.Errorf("volume is not supported on %s", runtime.GOOS)
+	}
+	muted := map[bool]string{true: "1", false: "0"}[percent == 0]
+	if _, err := exec.LookPath("wpctl"); err == nil {
+		if err := runScreenTool("wpctl", "set-volume", "@DEFAULT_AUDIO_SINK@", fmt.Sprintf("%.2f", float64(percent)/100)); err != nil {
+			return err
+		}
+		return runScreenTool("wpctl", "set-mute", "@DEFAULT_AUDIO_SINK@", muted)
+	}
+	if _, err := exec.LookPath("pactl"); err == nil {
+		if err := runScreenTool("pactl", "set-sink-volume", "@DEFAULT_SINK@", strconv.Itoa(percent)+"%"); err != nil {
+			return err
+		}
+		return runScreenTool("pactl", "set-sink-mute", "@DEFAULT_SINK@", muted)
+	}
+	if _, err := exec.LookPath("amixer"); err == nil {
+		state := map[bool]string{true: "mute", false: "unmute"}[percent == 0]
+		var err error
+		for _, control := range []string{"Master", "PCM"} { // PCM on the Pi's own audio
+			if err = runScreenTool("amixer", "-q", "sset", control, strconv.Itoa(percent)+"%", state); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	return errors.New("no wpctl, pactl or amixer to set the volume")
+}
+
+// adjustLevel runs set for a set_brightness or set_volume command and logs
+// the outcome.
+func adjustLevel(what string, percent int, set func() error) {
+	if err := set(); err != nil {
+		slog.Error("Failed to set "+what, "percent", percent, "err", err)
+		return
+	}
+	slog.Info("Set "+what, "percent", percent)
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"display/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	protocolVersion   = protocol.Version
+	heartbeatInterval = 30 * time.Second
+	reconnectMinDelay = 3 * time.Second
+	reconnectMaxDelay = 30 * time.Second
+	serverReadTimeout = 90 * time.Second // The server pings every 54s
+	linkWriteWait     = 10 * time.Second
+	pageSendBuffer    = 16
+)
+
+// replayedTypes are server messages a page gets again when it (re)connects,
+// in this order, so a reloaded page shows the current state at once.
+var replayedTypes = []string{"handshake_ack", "delivery", "display_mode", "set_result", "timer_update", "score_update", "splits_update"}
+
+// linkStatus tells the page whether the server is reachable.
+type linkStatus struct {
+	Connected bool   `json:"connected"`
+	Server    string `json:"server,omitempty"`
+	Attempt   int    `json:"attempt"`            // Failed connection attempts since the last connection
+	Restored  bool   `json:"restored,omitempty"` // Showing the state saved before a restart (laststate.go)
+}
+
+// serverLink is the connection of one window to the server. The client keeps
+// it, not the page: the page only renders what the link passes on over the
+// local /page WebSocket, and commands that change the client (rename, theme,
+// zoom, rotation, screen power, brightness, volume, server switch, log
+// upload, reload, clear cache) are carried out here, so they work while the browser is reloading
+// or restarting.
+type serverLink struct {
+	monitor int
+
+	writeMu   sync.Mutex // Serializes writes to conn
+	mu        sync.Mutex // Guards the fields below
+	conn      *websocket.Conn
+	sse       *sseStream // Instead of conn behind proxies that break WebSockets
+	status    linkStatus
+	last      map[string][]byte // Latest message of each replayedTypes type
+	pages     map[*pageConn]bool
+	offset    time.Duration // Server clock minus ours, from the last time_sync
+	synced    bool          // A time_sync has arrived
+	restored  bool          // last holds the state saved before a restart, until the first state_sync
+	saveTimer *time.Timer   // Pending saveState
+}
+
+// pageConn is a display page connected to /page.
+type pageConn struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+var (
+	links   = map[int]*serverLink{}
+	linksMu sync.Mutex
+)
+
+// linkMonitor maps monitors the config does not know to the first window,
+// like identity does.
+func linkMonitor(monitor int) int {
+	mu.Lock()
+	defer mu.Unlock()
+	if monitor < 0 || monitor >= len(localConfig.Monitors) {
+		return 0
+	}
+	return monitor
+}
+
+// linkFor returns the link of the window on monitor, starting it on first
+// use.
+func linkFor(ctx context.Context, monitor int) *serverLink {
+	monitor = linkMonitor(monitor)
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	l := links[monitor]
+	if l == nil {
+		l = &serverLink{monitor: monitor, last: map[string][]byte{}, pages: map[*pageConn]bool{}}
+		l.restoreState()
+		links[monitor] = l
+		go l.run(ctx)
+	}
+	return l
+}
+
+// refreshLink tells the server and the page of monitor about changed
+// settings, if its link is running.
+func refreshLink(monitor int) {
+	linksMu.Lock()
+	l := links[linkMonitor(monitor)]
+	linksMu.Unlock()
+	if l != nil {
+		l.refresh()
+	}
+}
+
+// reconnectLinks drops every link's server connection, so they reconnect to
+// the server discovery picked now.
+func reconnectLinks() {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	for _, l := range links {
+		l.mu.Lock()
+		if l.conn != nil {
+			l.conn.Close()
+		}
+		if l.sse != nil {
+			l.sse.stop()
+		}
+		l.mu.Unlock()
+	}
+}
+
+// refreshPages sends every page its settings again, e.g. when the server
+// announces another competition name.
+func refreshPages() {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	for _, l := range links {
+		l.sendConfig()
+	}
+}
+
+// run keeps the link connected until ctx is cancelled, retrying with
+// exponential backoff while the server is unreachable.
+func (l *serverLink) run(ctx context.Context) {
+	delay := reconnectMinDelay
+	for {
+		mu.Lock()
+		found := serverFound
+		host := net.JoinHostPort(serverIP, strconv.Itoa(serverPort))
+		wsURL, sseURL := serverURL("ws")+"/ws", serverURL("http")+"/sse"
+		mu.Unlock()
+
+		wait := 2 * time.Second // Discovery is still looking
+		if found {
+			connected, err := l.connect(ctx, host, wsURL)
+			if errors.Is(err, websocket.ErrBadHandshake) {
+				// The server answered but the upgrade failed: a proxy in
+				// between does not pass WebSockets on
+				slog.Warn("WebSocket upgrade failed, using server-sent events", "monitor", l.monitor, "addr", host)
+				connected, err = l.connectSSE(ctx, host, sseURL)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if connected {
+				delay = reconnectMinDelay
+				slog.Warn("Server connection lost", "monitor", l.monitor, "err", err)
+			} else {
+				slog.Warn("Cannot connect to server", "monitor", l.monitor, "addr", host, "err", err, "retry", delay)
+			}
+			wait = delay
+			delay = min(delay*3/2, reconnectMaxDelay)
+		}
+
+		l.mu.Lock()
+		l.status = linkStatus{Server: host, Attempt: l.status.Attempt + 1, Restored: l.restored}
+		l.mu.Unlock()
+		l.sendStatus()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// connect runs one connection to the server until it fails. connected
+// reports whether the connection was established.
+func (l *serverLink) connect(ctx context.Context, host, wsURL string) (connected bool, err error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second, EnableCompression: true}
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	sessionCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		<-sessionCtx.Done()
+		conn.Close() // Unblocks ReadMessage on shutdown
+	}()
+
+	l.mu.Lock()
+	l.conn = conn
+	l.status = linkStatus{Connected: true, Server: host}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+	}()
+
+	slog.Info("Server connection established", "monitor", l.monitor, "addr", host)
+	if err := l.sendHandshake(); err != nil {
+		return true, err
+	}
+	l.sendStatus()
+	go l.heartbeatLoop(sessionCtx)
+
+	conn.SetReadDeadline(time.Now().Add(serverReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(serverReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(linkWriteWait))
+	})
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		conn.SetReadDeadline(time.Now().Add(serverReadTimeout))
+		if messageType == websocket.BinaryMessage {
+			// Negotiated in the handshake; the rest of the link and the
+			// page only deal in JSON
+			if data, err = protocol.CBORToJSON(data); err != nil {
+				slog.Warn("Ignoring invalid binary message from server", "err", err)
+				continue
+			}
+		}
+		l.receive(data)
+	}
+}
+
+// receive handles a message from the server and acknowledges it if asked to.
+func (l *serverLink) receive(data []byte) {
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		slog.Warn("Ignoring invalid message from server", "err", err)
+		return
+	}
+	l.handle(msg, data)
+	if msg.MsgID != "" {
+		l.send(protocol.Message{Type: "ack", ReplyTo: msg.MsgID})
+	}
+}
+
+// send writes v to the server; it fails while disconnected.
+func (l *serverLink) send(v any) error {
+	l.mu.Lock()
+	conn, sse := l.conn, l.sse
+	l.mu.Unlock()
+	if sse != nil {
+		return sse.send(v)
+	}
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(linkWriteWait))
+	return conn.WriteJSON(v)
+}
+
+func (l *serverLink) sendHandshake() error {
+	mu.Lock()
+	id := identity(l.monitor)
+	theme, room, encoding := themeMode, localConfig.Room, localConfig.Encoding
+	mu.Unlock()
+	var encodings []string
+	if encoding == protocol.EncodingCBOR {
+		encodings = []string{protocol.EncodingCBOR}
+	}
+	return l.send(protocol.Envelope{Type: "handshake", Payload: protocol.Handshake{
+		Name: id.Name, ID: id.ID, Theme: theme, Zoom: id.Zoom, Rotation: id.Rotation,
+		Protocol: protocolVersion, Version: version, Room: room, Encodings: encodings,
+	}})
+}
+
+// validEncoding reports whether client.json's encoding is one the link
+// can ask the server for.
+func validEncoding(encoding string) bool {
+	return encoding == "" || encoding == "json" || encoding == protocol.EncodingCBOR
+}
+
+// heartbeatLoop reports system health to the server.
+func (l *serverLink) heartbeatLoop(ctx context.Context) {
+	for {
+		if err := l.send(protocol.Envelope{Type: "heartbeat", Payload: collectHealth()}); err != nil {
+			slog.Debug("Heartbeat not sent", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(heartbeatInterval):
+		}
+	}
+}
+
+// handle carries out a message from the server; what the page shows is
+// passed on to it.
+func (l *serverLink) handle(msg protocol.Message, data []byte) {
+	switch msg.Type {
+	case "handshake_ack":
+		var ack protocol.HandshakeAck
+		if json.Unmarshal(msg.Payload, &ack) == nil {
+			if !ack.Compatible {
+				slog.Warn("Server reports incompatible client", "server", ack.Version, "warning", ack.Warning)
+			}
+			if ack.Encoding != "" {
+				slog.Debug("Server sends binary messages", "encoding", ack.Encoding)
+			}
+			if l.monitor == 0 {
+				go l.reportNetworkEvents() // The handshake is done, so the server knows who reports
+			}
+		}
+		l.forward(msg.Type, data)
+	case "timer_update", "score_update", "splits_update", "display_mode", "set_result", "delivery":
+		l.forward(msg.Type, data)
+	case "buzzer", "identify":
+		l.broadcast(data) // Not replayed: a reloaded page must not sound or show it again
+	case "result_patch":
+		l.broadcast(data) // Not replayed: a reloaded page loads the whole result
+	case "state_sync":
+		l.syncState(msg.Payload)
+	case "time_sync":
+		var payload protocol.TimeSync
+		if json.Unmarshal(msg.Payload, &payload) == nil && payload.ServerTime > 0 {
+			l.mu.Lock()
+			l.offset = time.UnixMilli(payload.ServerTime).Sub(time.Now())
+			l.synced = true
+			l.mu.Unlock()
+			l.broadcast(l.timeSyncMessage())
+		}
+	case "update_config":
+		var payload struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		if json.Unmarshal(msg.Payload, &payload) == nil && payload.Key == "ClientName" {
+			l.update(configUpdate{LocalConfig: LocalConfig{ClientName: payload.Value}})
+		}
+	case "theme_mode":
+		var theme string
+		if json.Unmarshal(msg.Payload, &theme) == nil {
+			l.update(configUpdate{LocalConfig: LocalConfig{ThemeMode: theme}})
+		}
+	case "set_zoom":
+		var zoom int
+		if json.Unmarshal(msg.Payload, &zoom) == nil {
+			l.update(configUpdate{LocalConfig: LocalConfig{Zoom: zoom}})
+		}
+	case "set_rotation":
+		var rotation int
+		if json.Unmarshal(msg.Payload, &rotation) == nil {
+			l.update(configUpdate{Rotation: &rotation})
+		}
+	case "screen_power":
+		var power string
+		if json.Unmarshal(msg.Payload, &power) == nil && (power == "on" || power == "off") {
+			go switchScreen(power == "on", "server") // cec-client takes seconds
+		}
+	case "set_brightness":
+		var percent int
+		if json.Unmarshal(msg.Payload, &percent) == nil && percent >= 0 && percent <= 100 {
+			go adjustLevel("brightness", percent, func() error { return setBrightness(l.monitor, percent) }) // ddcutil takes seconds
+		}
+	case "set_volume":
+		var percent int
+		if json.Unmarshal(msg.Payload, &percent) == nil && percent >= 0 && percent <= 100 {
+			go adjustLevel("volume", percent, func() error { return setVolume(percent) })
+		}
+	case "power_schedule":
+		var schedule protocol.PowerSchedule
+		if json.Unmarshal(msg.Payload, &schedule) == nil && l.monitor == 0 {
+			savePowerSchedule(schedule) // Every window's link gets it; the room is the same
+		}
+	case "set_wifi":
+		var creds protocol.WifiCredentials
+		if json.Unmarshal(msg.Payload, &creds) == nil && creds.SSID != "" {
+			go l.setWifi(creds) // Joining takes up to wifiConnectTimeout
+		}
+	case "switch_server":
+		var name string
+		if json.Unmarshal(msg.Payload, &name) == nil && name != "" {
+			switchServer(name)
+		}
+	case "reload":
+		go l.reloadDisplay() // DevTools can take seconds
+	case "clear_cache":
+		go l.clearCache()
+	case "request_logs":
+		var payload struct {
+			UploadURL string `json:"uploadUrl"`
+		}
+		if json.Unmarshal(msg.Payload, &payload) == nil && payload.UploadURL != "" {
+			go uploadLogs(payload.UploadURL)
+		}
+	default:
+		slog.Debug("Ignoring message from server", "type", msg.Type)
+	}
+}
+
+// syncState passes a state_sync on to the page as the messages it already
+// knows, which also become what a reloaded page is replayed.
+func (l *serverLink) syncState(payload json.RawMessage) {
+	var state struct {
+		Timer        json.RawMessage `json:"timer"`
+		Score        json.RawMessage `json:"score"`  // Added with the scoreboard
+		Splits       json.RawMessage `json:"splits"` // Only with a splits view
+		ActiveResult string          `json:"activeResult"`
+		DisplayMode  string          `json:"displayMode"`
+	}
+	if err := json.Unmarshal(payload, &state); err != nil {
+		slog.Warn("Ignoring invalid state_sync", "err", err)
+		return
+	}
+	l.mu.Lock()
+	l.dropRestored()
+	l.mu.Unlock()
+	forward := func(msgType string, payload any) {
+		data, err := json.Marshal(protocol.Envelope{Type: msgType, Payload: payload})
+		if err == nil {
+			l.forward(msgType, data)
+		}
+	}
+	forward("display_mode", state.DisplayMode)
+	forward("timer_update", state.Timer)
+	if state.Score != nil {
+		forward("score_update", state.Score)
+	}
+	if state.Splits != nil {
+		forward("splits_update", state.Splits)
+	}
+	if state.ActiveResult != "" {
+		forward("set_result", struct {
+			File string `json:"file"`
+		}{state.ActiveResult})
+	}
+}
+
+// update saves a change from the server and passes it on.
+func (l *serverLink) update(update configUpdate) {
+	if _, err := updateConfig(l.monitor, update); err != nil {
+		return
+	}
+	l.refresh()
+}
+
+// refresh sends the current settings to the server (a repeated handshake
+// updates the display's entry) and to the page.
+func (l *serverLink) refresh() {
+	if err := l.sendHandshake(); err != nil {
+		slog.Debug("Handshake not sent", "err", err)
+	}
+	l.sendConfig()
+}
+
+// uploadLogs posts the recent log to the one-time URL of a request_logs.
+func uploadLogs(uploadURL string) {
+	mu.Lock()
+	base := serverURL("http")
+	mu.Unlock()
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(base+uploadURL, "text/plain", bytes.NewBufferString(recentLogs.String()))
+	if err != nil {
+		slog.Error("Log upload failed", "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Log upload failed", "status", resp.Status)
+	}
+}
+
+// forward remembers a message for pages that connect later (and the next
+// start, see laststate.go) and sends it to the connected ones.
+func (l *serverLink) forward(msgType string, data []byte) {
+	l.mu.Lock()
+	l.last[msgType] = data
+	l.scheduleSave(msgType)
+	l.mu.Unlock()
+	l.broadcast(data)
+}
+
+func (l *serverLink) sendConfig() {
+	l.broadcast(l.configMessage())
+}
+
+func (l *serverLink) sendStatus() {
+	l.broadcast(l.statusMessage())
+}
+
+// configMessage tells the page its settings (name, theme, zoom, rotation,
+// what to show).
+func (l *serverLink) configMessage() []byte {
+	data, _ := json.Marshal(protocol.Envelope{Type: "config", Payload: configFor(l.monitor)})
+	return data
+}
+
+func (l *serverLink) statusMessage() []byte {
+	l.mu.Lock()
+	status := l.status
+	l.mu.Unlock()
+	data, _ := json.Marshal(protocol.Envelope{Type: "status", Payload: status})
+	return data
+}
+
+// pageCount is the number of display pages connected to the link.
+func (l *serverLink) pageCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pages)
+}
+
+// timeSyncMessage is a time_sync with the server's current time, as far as
+// the last one tells; nil before the first. Pages share our clock, so they
+// get the server time rather than the offset.
+func (l *serverLink) timeSyncMessage() []byte {
+	l.mu.Lock()
+	offset, synced := l.offset, l.synced
+	l.mu.Unlock()
+	if !synced {
+		return nil
+	}
+	data, _ := json.Marshal(protocol.Envelope{Type: "time_sync", Payload: protocol.TimeSync{ServerTime: time.Now().Add(offset).UnixMilli()}})
+	return data
+}
+
+func (l *serverLink) broadcast(data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p := range l.pages {
+		select {
+		case p.send <- data:
+		default: // A page that does not keep up reconnects and gets the state replayed
+			delete(l.pages, p)
+			close(p.send)
+		}
+	}
+}
+
+// pageUpgrader accepts the display page; the default origin check only lets
+// pages served by this client connect.
+var pageUpgrader = websocket.Upgrader{}
+
+// servePage connects a display page: it gets its config, the link status and
+// the current state, then everything the server sends.
+func servePage(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		monitor, _ := strconv.Atoi(r.URL.Query().Get("monitor"))
+		conn, err := pageUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade has answered
+		}
+		l := linkFor(ctx, monitor)
+		p := &pageConn{conn: conn, send: make(chan []byte, pageSendBuffer+3+len(replayedTypes))}
+		p.send <- l.configMessage()
+		p.send <- l.statusMessage()
+		if data := l.timeSyncMessage(); data != nil {
+			p.send <- data
+		}
+
+		l.mu.Lock()
+		for _, t := range replayedTypes {
+			if data := l.last[t]; data != nil {
+				p.send <- data
+			}
+		}
+		l.pages[p] = true
+		l.mu.Unlock()
+
+		go p.writePump()
+		// The page sends nothing; reading notices when it goes away
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		l.mu.Lock()
+		if l.pages[p] {
+			delete(l.pages, p)
+			close(p.send)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (p *pageConn) writePump() {
+	defer p.conn.Close()
+	for data := range p.send {
+		p.conn.SetWriteDeadline(time.Now().Add(linkWriteWait))
+		if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+	}
+	p.conn.WriteMessage(websocket.CloseMessage, nil)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var logLevel = new(slog.LevelVar)
+
+// recentLogs keeps the tail of the log in memory so the server can fetch it
+// (GET /logs, uploaded on request_logs) without SSH access to the display.
+var recentLogs = &logRing{max: 2000}
+
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func (lr *logRing) Write(p []byte) (int, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lr.lines = append(lr.lines, line)
+	}
+	if over := len(lr.lines) - lr.max; over > 0 {
+		lr.lines = append(lr.lines[:0], lr.lines[over:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the buffered lines, oldest first.
+func (lr *logRing) String() string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return strings.Join(lr.lines, "\n") + "\n"
+}
+
+// parseLogLevel accepts debug, info, warn and error (case-insensitive).
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// setupLogging routes slog (and the standard log package) to stderr, to
+// recentLogs and to a rotating client.log in dir, so a display's log can be
+// collected after an event.
+func setupLogging(level, format, dir string) (io.Closer, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(lvl)
+
+	var out io.Writer = io.MultiWriter(os.Stderr, recentLogs)
+	var closer io.Closer = io.NopCloser(nil)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("create log directory: %w", err)
+		}
+		file := &lumberjack.Logger{
+			Filename:   filepath.Join(dir, "client.log"),
+			MaxSize:    10, // MB
+			MaxBackups: 10,
+			MaxAge:     90, // Days
+		}
+		out = io.MultiWriter(os.Stderr, recentLogs, file)
+		closer = file
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+// fatal logs at error level and exits, replacing log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
+
+	"display/internal/protocol"
 )
 
 //go:embed static
@@ -29,39 +37,129 @@ var (
 	themeMode   string
 	zoomLevel   int
 	baseDir     string
+	instance    string // -instance; empty for the only client on a machine
 	serverFound bool
+	serverEntry ServiceEntry // The server discovery picked, with what it announced
+	localConfig LocalConfig  // Last loaded/saved client.json, so rewrites keep every field
 	mu          sync.Mutex
 )
 
+// version is set at build time: -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
 type LocalConfig struct {
+	ClientID   string `json:"clientId,omitempty"` // Generated once; identifies this display to the server across renames and reconnects
 	ClientName string `json:"clientName"`
+	Room       string `json:"room,omitempty"` // Results subfolder of the arena this display belongs to; empty = the default room
 	ThemeMode  string `json:"themeMode,omitempty"`
 	Zoom       int    `json:"zoom,omitempty"`
+	Rotation   int    `json:"rotation,omitempty"` // Degrees clockwise, for portrait-mounted screens (rotate.go)
+	// Kiosk browser command (default: the first of Chromium, Firefox, Epiphany
+	// that is installed) and its arguments, which replace the built-in kiosk
+	// flags; the URL is appended (browsers.go)
+	Browser     string   `json:"browser,omitempty"`
+	BrowserArgs []string `json:"browserArgs,omitempty"`
+	// How to find the server: auto (mDNS, then UDP broadcast), mdns or udp (discovery.go)
+	Discovery string `json:"discovery,omitempty"`
+	// serverName (or host, or ip:port) of the server to use when several are
+	// found; no other server is used while it is set (discovery.go)
+	PreferredServer string `json:"preferredServer,omitempty"`
+	// Address of the server ("host:port", or "https://host:port"), used
+	// without discovery on networks that pass neither mDNS nor broadcast
+	// (discovery.go)
+	ServerAddress string `json:"serverAddress,omitempty"`
+	// Wayland compositor to wrap the kiosk browser in: auto, none, cage or labwc (wayland.go)
+	Compositor string `json:"compositor,omitempty"`
+	// Daily screen on/off times and how to switch (power.go)
+	ScreenPower ScreenPowerConfig `json:"screenPower,omitzero"`
+	// The room's schedule as last sent by the server (power_schedule), kept
+	// so it is followed while the server is away; screenPower's on/off
+	// times take precedence (power.go)
+	PowerSchedule *protocol.PowerSchedule `json:"powerSchedule,omitempty"`
+	// Recovery from a lost network or failing discovery (netwatch.go)
+	NetworkWatchdog NetworkWatchdogConfig `json:"networkWatchdog,omitzero"`
+	// One browser window per monitor (see monitors.go); empty = a single window
+	Monitors []MonitorConfig `json:"monitors,omitempty"`
+	// Kiosk Chromium is checked through its DevTools port and reloaded or
+	// restarted when the page hangs, goes blank or navigates away (devtools.go)
+	DisableBrowserWatchdog bool `json:"disableBrowserWatchdog,omitempty"`
+	// Auto-update settings (see update.go)
+	DisableAutoUpdate bool   `json:"disableAutoUpdate,omitempty"`
+	UpdatePublicKey   string `json:"updatePublicKey,omitempty"` // Base64 ed25519 key; auto-update is off without it
+	// Encoding of frequent server messages: json (default) or cbor, which
+	// is smaller and cheaper to parse on Pi Zero-class displays (link.go)
+	Encoding string `json:"encoding,omitempty"`
+	// Logging (flags -log-level/-log-format override these)
+	LogLevel  string `json:"logLevel,omitempty"`  // debug, info, warn or error
+	LogFormat string `json:"logFormat,omitempty"` // text or json
 }
 
 type ConfigResponse struct {
 	WsUrl         string `json:"wsUrl"`
 	ServerBaseUrl string `json:"serverBaseUrl"`
+	ClientID      string `json:"clientId"`
 	ClientName    string `json:"clientName"`
+	Room          string `json:"room"`
 	ThemeMode     string `json:"themeMode"`
 	Zoom          int    `json:"zoom"`
+	Rotation      int    `json:"rotation"`
+	RotateInPage  bool   `json:"rotateInPage"` // The screen could not be rotated; the page rotates itself
+	Show          string `json:"show"`         // all, results or timer (per monitor)
 	Connected     bool   `json:"connected"`
+	Version       string `json:"version"` // Reported to the server in the handshake
+	ServerName    string `json:"serverName"`
+	Competition   string `json:"competition"` // competitionName the server announces
+	Setup         bool   `json:"setup"`       // First run: the QR code opens /setup (setup.go)
 }
 
 func init() {
 	ex, err := os.Executable()
 	if err != nil {
-		log.Fatal(err)
+		fatal("Cannot locate executable", "err", err)
 	}
 	baseDir = filepath.Dir(ex)
 }
 
+// validInstance limits instance names to what is safe in file and unit names.
+var validInstance = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// instanceSuffix is appended to the names of everything an instance keeps
+// for itself: config file, browser profile and systemd unit.
+func instanceSuffix() string {
+	if instance == "" {
+		return ""
+	}
+	return "-" + instance
+}
+
+// configPath is client.json next to the executable, or client-<instance>.json.
+func configPath() string {
+	return filepath.Join(baseDir, "client"+instanceSuffix()+".json")
+}
+
+// instanceDir is dir next to the executable, with a subfolder per instance.
+func instanceDir(dir string) string {
+	return filepath.Join(baseDir, dir, instance)
+}
+
+// saveLocalConfig writes client.json (see configPath).
+func saveLocalConfig(cfg LocalConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	if err := os.WriteFile(configPath(), data, 0644); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
+
 func loadOrInitConfig() {
-	configPath := filepath.Join(baseDir, "client.json")
-	data, err := os.ReadFile(configPath)
+	data, err := os.ReadFile(configPath())
 	if err == nil {
 		var cfg LocalConfig
 		if json.Unmarshal(data, &cfg) == nil && cfg.ClientName != "" {
+			localConfig = cfg
 			clientName = cfg.ClientName
 			themeMode = cfg.ThemeMode
 			zoomLevel = cfg.Zoom
@@ -71,7 +169,29 @@ func loadOrInitConfig() {
 			if zoomLevel == 0 {
 				zoomLevel = 100
 			}
-			fmt.Printf("Loaded existing client name: %s\n", clientName)
+			if !validCompositor(localConfig.Compositor) {
+				slog.Warn("Ignoring unknown compositor, using auto", "compositor", localConfig.Compositor)
+				localConfig.Compositor = compositorAuto
+			}
+			if !validDiscovery(localConfig.Discovery) {
+				slog.Warn("Ignoring unknown discovery, using auto", "discovery", localConfig.Discovery)
+				localConfig.Discovery = discoveryAuto
+			}
+			if !validEncoding(localConfig.Encoding) {
+				slog.Warn("Ignoring unknown encoding, using json", "encoding", localConfig.Encoding)
+				localConfig.Encoding = ""
+			}
+			if !validRotation(localConfig.Rotation) {
+				slog.Warn("Ignoring invalid rotation", "rotation", localConfig.Rotation)
+				localConfig.Rotation = 0
+			}
+			slog.Info("Loaded existing client name", "name", clientName)
+			if localConfig.ClientID == "" { // client.json from before IDs existed
+				localConfig.ClientID = newClientID()
+				if err := saveLocalConfig(localConfig); err != nil {
+					slog.Error("Failed to save config", "err", err)
+				}
+			}
 			return
 		}
 	}
@@ -80,81 +200,222 @@ func loadOrInitConfig() {
 
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Printf("Warning: Failed to get hostname: %v. Using 'unknown'", err)
+		slog.Warn("Failed to get hostname, using 'unknown'", "err", err)
 		hostname = "unknown"
 	}
-	clientName = "Client-" + hostname
-	cfg := LocalConfig{ClientName: clientName}
-	data, err = json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		log.Printf("Error: Failed to marshal config: %v", err)
+	clientName = "Client-" + hostname + instanceSuffix()
+	localConfig = LocalConfig{ClientID: newClientID(), ClientName: clientName}
+	firstRun = true
+	if err := saveLocalConfig(localConfig); err != nil {
+		slog.Error("Failed to save config", "err", err)
 		return
 	}
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		log.Printf("Error: Failed to write config file: %v", err)
-		return
+	slog.Info("Generated and saved new client name", "name", clientName)
+}
+
+// configUpdate is a change to one window's settings; empty fields are left
+// as they are.
+type configUpdate struct {
+	LocalConfig
+	Rotation *int `json:"rotation"` // 0 is a valid rotation
+}
+
+// updateConfig applies update to the window on monitor, saves client.json
+// and returns the window's new identity.
+func updateConfig(monitor int, update configUpdate) (monitorIdentity, error) {
+	// Name and zoom of monitors after the first are kept with the monitor
+	mu.Lock()
+	if monitor > 0 && monitor < len(localConfig.Monitors) {
+		m := &localConfig.Monitors[monitor]
+		if update.ClientName != "" {
+			m.Name = update.ClientName
+		}
+		if update.Zoom >= 50 && update.Zoom <= 300 {
+			m.Zoom = update.Zoom
+		}
+		if update.Rotation != nil && validRotation(*update.Rotation) {
+			m.Rotation = *update.Rotation
+		}
+	} else {
+		if update.ClientName != "" {
+			clientName = update.ClientName
+		}
+		if update.Zoom >= 50 && update.Zoom <= 300 {
+			zoomLevel = update.Zoom
+		}
+		if update.Rotation != nil && validRotation(*update.Rotation) {
+			localConfig.Rotation = *update.Rotation
+		}
+	}
+	if update.ThemeMode == "dark" || update.ThemeMode == "light" {
+		themeMode = update.ThemeMode
+	}
+	localConfig.ClientName = clientName
+	localConfig.ThemeMode = themeMode
+	localConfig.Zoom = zoomLevel
+	cfg := localConfig
+	id := identity(monitor)
+	mu.Unlock()
+
+	if update.Rotation != nil {
+		applyRotation(monitor, id.Rotation) // Before the page is told, so it knows who rotates
+	}
+
+	if err := saveLocalConfig(cfg); err != nil {
+		slog.Error("Failed to save config", "err", err)
+		return id, err
 	}
-	fmt.Printf("Generated and saved new client name: %s\n", clientName)
+	slog.Info("Updated config", "monitor", monitor, "name", id.Name, "theme", cfg.ThemeMode, "zoom", id.Zoom, "rotation", id.Rotation)
+	if update.ClientName != "" {
+		updateAdvertisement()
+	}
+	return id, nil
 }
 
-func launchBrowser(url string, kiosk bool) (*exec.Cmd, error) {
-	if kiosk && runtime.GOOS == "linux" {
-		browsers := []string{"chromium-browser", "chromium", "google-chrome"}
-		var browserCmd string
-		for _, b := range browsers {
-			if _, err := exec.LookPath(b); err == nil {
-				browserCmd = b
-				break
-			}
+// serverURL is the address of the server for scheme "http" or "ws", with
+// TLS if the server announces it. Call with mu held.
+func serverURL(scheme string) string {
+	if serverEntry.TLS {
+		scheme += "s"
+	}
+	return scheme + "://" + net.JoinHostPort(serverIP, strconv.Itoa(serverPort)) // Brackets IPv6 literals
+}
+
+// configFor returns the settings of the window on monitor.
+func configFor(monitor int) ConfigResponse {
+	mu.Lock()
+	defer mu.Unlock()
+	id := identity(monitor)
+	return ConfigResponse{
+		WsUrl:         serverURL("ws") + "/ws",
+		ServerBaseUrl: serverURL("http"),
+		ClientID:      id.ID,
+		ClientName:    id.Name,
+		Room:          localConfig.Room,
+		ThemeMode:     themeMode,
+		Zoom:          id.Zoom,
+		Rotation:      id.Rotation,
+		RotateInPage:  pageRotation[monitor],
+		Show:          monitorShow(monitor),
+		Connected:     serverFound,
+		Version:       version,
+		ServerName:    serverEntry.Name,
+		Competition:   serverEntry.Competition,
+		Setup:         firstRun,
+	}
+}
+
+// newClientID returns a random ID for a display that does not have one yet.
+func newClientID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// localOnly refuses requests from other machines: the local web server
+// listens on the LAN for pairing and setup from phones, but some routes are
+// meant for the kiosk browser and scripts on this machine only.
+func localOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			http.Error(w, "Only available on this machine", http.StatusForbidden)
+			return
 		}
+		next(w, r)
+	}
+}
+
+// localHost returns the host the kiosk browser should use to reach the local
+// client server. Loopback only works when bound to all interfaces or loopback.
+func localHost(addr string) string {
+	if ip := net.ParseIP(addr); ip == nil || ip.IsUnspecified() {
+		return "localhost"
+	}
+	return addr
+}
+
+// windowURL is the address of the page a window shows.
+func windowURL(url string, window browserWindow) string {
+	if window.Monitor > 0 {
+		return url + "/?monitor=" + strconv.Itoa(window.Monitor)
+	}
+	return url + "/"
+}
+
+// launchBrowser starts the browser for window. It returns the kiosk browser
+// to supervise, or nil if the browser was handed to the desktop.
+func launchBrowser(url string, kiosk bool, window browserWindow) (*supervisedBrowser, error) {
+	url = windowURL(url, window)
+	suffix := instanceSuffix()
+	if window.Monitor > 0 {
+		suffix += "-" + strconv.Itoa(window.Monitor+1) // One browser process per window, or placement is ignored
+	}
+	if kiosk && runtime.GOOS == "linux" {
+		mu.Lock()
+		configured, customArgs, compositor := localConfig.Browser, localConfig.BrowserArgs, localConfig.Compositor
+		watchdog := !localConfig.DisableBrowserWatchdog
+		mu.Unlock()
 
-		if browserCmd != "" {
-			log.Printf("Launching Kiosk mode using %s...", browserCmd)
-			args := []string{
-				"--kiosk",
-				"--no-first-run",
-				"--no-errdialogs",
-				"--disable-infobars",
-				"--disable-restore-session-state",
-				"--check-for-update-interval=31536000",
-				"--start-maximized",
-				"--enable-features=OverlayScrollbar",
-				"--ozone-platform-hint=auto",
-				"--password-store=basic",
-				"--user-data-dir=" + os.TempDir() + "/display-client-chrome",
-				url,
+		if browserCmd, family := findKioskBrowser(configured); browserCmd != "" {
+			compositor = pickCompositor(compositor)
+			var args []string
+			b := &supervisedBrowser{url: url}
+			if customArgs != nil {
+				args = append(args, customArgs...)
+			} else {
+				b.profile = browserProfile(browserCmd, family, suffix)
+				args = kioskArgs(family, b.profile, window)
+				if family == familyChromium {
+					args = append([]string{chromiumOzoneFlag(compositor)}, args...)
+					if watchdog {
+						port, err := freePort()
+						if err != nil {
+							slog.Warn("No port for the browser watchdog", "err", err)
+						} else {
+							b.debugPort = port
+							args = append(args, "--remote-debugging-port="+strconv.Itoa(port))
+						}
+					}
+				}
 			}
-			cmd := exec.Command(browserCmd, args...)
-			err := cmd.Start()
-			return cmd, err
+			b.cmd = kioskCommand(compositor, browserCmd, append(args, url))
+			slog.Info("Launching kiosk mode", "browser", browserCmd, "monitor", window.Monitor, "command", b.cmd.Args[0])
+			return b, b.cmd.Start()
+		}
+		if configured != "" {
+			slog.Warn("Configured browser not found for kiosk mode", "browser", configured)
+		} else {
+			slog.Warn("No Chromium, Firefox or Epiphany found for kiosk mode")
 		}
-		log.Println("Chromium not found for Kiosk mode.")
 	}
 
-	var err error
+	return nil, openURL(url)
+}
+
+// openURL opens url in the desktop's default browser.
+func openURL(url string) error {
 	switch runtime.GOOS {
 	case "linux":
-		err = exec.Command("xdg-open", url).Start()
+		return exec.Command("xdg-open", url).Start()
 	case "windows":
-		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
 	case "darwin":
-		err = exec.Command("open", url).Start()
-	default:
-		err = fmt.Errorf("unsupported platform")
+		return exec.Command("open", url).Start()
 	}
-	return nil, err
+	return fmt.Errorf("unsupported platform")
 }
 
-func browserSupervisor(ctx context.Context, url string, kiosk bool) {
+func browserSupervisor(ctx context.Context, url string, kiosk bool, window browserWindow) {
 	var currentCmd *exec.Cmd
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Supervisor: Shutdown requested")
+			slog.Info("Supervisor: shutdown requested")
 			if currentCmd != nil && currentCmd.Process != nil {
-				log.Println("Supervisor: Killing browser process...")
+				slog.Info("Supervisor: killing browser process")
 				if err := currentCmd.Process.Kill(); err != nil {
-					log.Printf("Supervisor: Failed to kill process: %v", err)
+					slog.Error("Supervisor: failed to kill process", "err", err)
 				}
 				// Wait with timeout to reap zombie
 				done := make(chan error, 1)
@@ -163,19 +424,19 @@ func browserSupervisor(ctx context.Context, url string, kiosk bool) {
 				}()
 				select {
 				case <-done:
-					log.Println("Supervisor: Browser process cleaned up")
+					slog.Info("Supervisor: browser process cleaned up")
 				case <-time.After(5 * time.Second):
-					log.Println("Supervisor: Wait timeout, process may be zombie")
+					slog.Warn("Supervisor: wait timeout, process may be zombie")
 				}
 			}
 			return
 		default:
 		}
 
-		log.Println("Supervisor: Starting browser...")
-		cmd, err := launchBrowser(url, kiosk)
+		slog.Info("Supervisor: starting browser")
+		b, err := launchBrowser(url, kiosk, window)
 		if err != nil {
-			log.Printf("Supervisor: Failed to start browser: %v. Retrying in 5s...", err)
+			slog.Error("Supervisor: failed to start browser, retrying in 5s", "err", err)
 			select {
 			case <-ctx.Done():
 				return
@@ -184,9 +445,11 @@ func browserSupervisor(ctx context.Context, url string, kiosk bool) {
 			continue
 		}
 
-		if cmd != nil {
+		if b != nil {
+			cmd := b.cmd
 			currentCmd = cmd
-			log.Println("Supervisor: Browser running. Waiting for exit...")
+			setSupervised(window.Monitor, b)
+			slog.Info("Supervisor: browser running, waiting for exit")
 
 			// Wait for process with context cancellation
 			done := make(chan error, 1)
@@ -194,21 +457,31 @@ func browserSupervisor(ctx context.Context, url string, kiosk bool) {
 				done <- cmd.Wait()
 			}()
 
+			watchCtx, stopWatch := context.WithCancel(ctx)
+			if b.debugPort != 0 {
+				go devtoolsWatchdog(watchCtx, b.debugPort, b.url, cmd)
+			}
+
 			select {
 			case <-ctx.Done():
+				stopWatch()
+				setSupervised(window.Monitor, nil)
 				// Context cancelled, kill the process
 				if cmd.Process != nil {
-					log.Println("Supervisor: Killing browser due to shutdown...")
+					slog.Info("Supervisor: killing browser due to shutdown")
 					cmd.Process.Kill()
 					<-done // Wait for it to finish
 				}
 				return
 			case err := <-done:
-				log.Printf("Supervisor: Browser exited (%v). Restarting in 2s...", err)
+				stopWatch()
+				setSupervised(window.Monitor, nil)
+				slog.Warn("Supervisor: browser exited, restarting in 2s", "err", err)
+				b.clearProfile()
 			}
 		} else {
 			if !kiosk {
-				log.Println("Supervisor: Browser launched in detached mode. Supervisor exiting.")
+				slog.Info("Supervisor: browser launched in detached mode, supervisor exiting")
 				return
 			}
 		}
@@ -226,44 +499,119 @@ func discoveryLoop(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Discovery: Shutdown requested")
+			slog.Info("Discovery: shutdown requested")
 			return
 		default:
 		}
 
-		entry, err := findServer()
+		mu.Lock()
+		current, previous := "", serverEntry
+		if serverFound {
+			current = net.JoinHostPort(serverIP, strconv.Itoa(serverPort))
+		}
+		mu.Unlock()
+
+		entry, err := findServer(current)
+		noteDiscovery(err)
+		wait := 30 * time.Second // Continue discovery to handle server IP changes
 		if err == nil {
 			mu.Lock()
 			serverIP = entry.IP
 			serverPort = entry.Port
 			serverFound = true
+			serverEntry = *entry
+			firstRun = false // Set up: /setup closes (setup.go)
 			mu.Unlock()
-			fmt.Printf("Connected to Server at %s:%d\n", serverIP, serverPort)
-			// Continue discovery to handle server IP changes
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(30 * time.Second):
+			if entry.addr() != current || entry.TLS != previous.TLS {
+				slog.Info("Connected to server", "name", entry.Name, "addr", entry.addr(), "competition", entry.Competition)
+				if current != "" {
+					reconnectLinks() // Moved, or switched to another server
+				}
 			}
-		} else {
-			fmt.Printf("Discovery failed: %v. Retrying in 2s...\n", err)
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(2 * time.Second):
+			if entry.Name != previous.Name || entry.Competition != previous.Competition {
+				refreshPages() // The pages show which server and event they are on
 			}
+		} else {
+			slog.Warn("Discovery failed, retrying in 2s", "err", err)
+			wait = 2 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-rediscover:
+		case <-time.After(wait):
 		}
 	}
 }
 
 func main() {
 	kiosk := flag.Bool("kiosk", false, "Run in Kiosk mode (Linux/Raspberry Pi)")
+	addr := flag.String("addr", "", "Address for the local client server to bind to, e.g. 127.0.0.1 (default all interfaces)")
+	port := flag.Int("port", 8081, "Port for the local client server")
+	installSystemd := flag.Bool("install-systemd", false, "Install and enable a systemd user unit that starts this client with the desktop session, then exit")
+	provisionFlag := flag.Bool("provision", false, "Set up this Raspberry Pi as a display (run with sudo): kiosk packages, autologin, no screen blanking, client.json and the systemd unit, then exit")
+	logLevelFlag := flag.String("log-level", "", "Log level: debug, info, warn or error (overrides client.json)")
+	logFormatFlag := flag.String("log-format", "", "Log format: text or json (overrides client.json)")
+	flag.StringVar(&instance, "instance", "", "Instance name when running several clients on one machine (one per monitor); each needs its own -port and uses client-<instance>.json")
 	flag.Parse()
 
-	fmt.Println("Starting Display Client...")
-	fmt.Printf("Running from: %s\n", baseDir)
+	if instance != "" && !validInstance.MatchString(instance) {
+		fatal("Invalid -instance: use up to 32 letters, digits, - and _", "instance", instance)
+	}
+
+	if *provisionFlag {
+		// The unit runs the kiosk with the flags given besides -provision
+		args := []string{"-kiosk=true"}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "provision" && f.Name != "kiosk" {
+				args = append(args, "-"+f.Name+"="+f.Value.String())
+			}
+		})
+		if failed := provision(os.Stdin, args); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *installSystemd {
+		var args []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "install-systemd" {
+				args = append(args, "-"+f.Name+"="+f.Value.String())
+			}
+		})
+		unitPath, err := installSystemdUnit(args)
+		if err != nil {
+			fatal("Failed to install systemd unit", "err", err)
+		}
+		slog.Info("Installed systemd user unit (starts with the next desktop login)", "path", unitPath)
+		slog.Warn("Remove ~/.config/autostart/display.desktop if present, or the client will be started twice")
+		return
+	}
+
+	if *addr != "" && net.ParseIP(*addr) == nil {
+		fatal("Invalid -addr: must be an IP address", "addr", *addr)
+	}
+
 	loadOrInitConfig()
 
+	logLevelName, logFormat := localConfig.LogLevel, localConfig.LogFormat
+	if *logLevelFlag != "" {
+		logLevelName = *logLevelFlag
+	}
+	if *logFormatFlag != "" {
+		logFormat = *logFormatFlag
+	}
+	if logLevelName == "" {
+		logLevelName = "info"
+	}
+	logCloser, err := setupLogging(logLevelName, logFormat, instanceDir("logs"))
+	if err != nil {
+		fatal("Failed to set up logging", "err", err)
+	}
+	defer logCloser.Close()
+	slog.Info("Starting Display Client", "version", version, "dir", baseDir, "instance", instance, "name", clientName)
+
 	// Setup context and signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -275,49 +623,85 @@ func main() {
 	go discoveryLoop(ctx)
 
 	// 2. Start Local Client Server immediately
-	port := 8081
-	url := fmt.Sprintf("http://localhost:%d", port)
+	listenAddr := net.JoinHostPort(*addr, strconv.Itoa(*port))
+	url := "http://" + net.JoinHostPort(localHost(*addr), strconv.Itoa(*port))
+
+	var supervisors sync.WaitGroup
+	for _, window := range browserWindows(localConfig.Monitors) {
+		mu.Lock()
+		rotation := identity(window.Monitor).Rotation
+		mu.Unlock()
+		applyRotation(window.Monitor, rotation)
+		linkFor(ctx, window.Monitor) // Connects to the server before the page loads
+		supervisors.Go(func() { browserSupervisor(ctx, url, *kiosk, window) })
+	}
+	supervisorDone := make(chan struct{})
+	go func() {
+		supervisors.Wait()
+		close(supervisorDone)
+	}()
 
-	go browserSupervisor(ctx, url, *kiosk)
+	go screenScheduleLoop(ctx)
+	go networkWatchdog(ctx)
 
-	fmt.Printf("Starting Local Client Server on port %d...\n", port)
+	// 3. Check the server for client updates
+	restart := make(chan struct{}, 1)
+	go updateLoop(ctx, restart)
+
+	slog.Info("Starting local client server", "addr", listenAddr)
 
 	// Try to use embedded static files first, fallback to filesystem for development
 	var staticFS http.FileSystem
 	staticDir := filepath.Join(baseDir, "static")
 	if _, err := os.Stat(staticDir); err == nil {
 		// Development mode: serve from filesystem
-		fmt.Printf("Serving static files from filesystem: %s\n", staticDir)
+		slog.Info("Serving static files from filesystem", "dir", staticDir)
 		staticFS = http.Dir(staticDir)
 	} else if _, err := os.Stat("client/static"); err == nil {
 		// Development mode: serve from source directory
-		fmt.Println("Serving static files from filesystem: client/static")
+		slog.Info("Serving static files from filesystem", "dir", "client/static")
 		staticFS = http.Dir("client/static")
 	} else {
 		// Production mode: use embedded files
-		fmt.Println("Serving static files from embedded filesystem")
+		slog.Info("Serving static files from embedded filesystem")
 		embeddedFS, err := fs.Sub(staticFiles, "static")
 		if err != nil {
-			log.Fatalf("Failed to access embedded static files: %v", err)
+			fatal("Failed to access embedded static files", "err", err)
 		}
 		staticFS = http.FS(embeddedFS)
 	}
 
 	http.Handle("/", http.FileServer(staticFS))
 
+	// Results are loaded through the client so the last ones can still be
+	// shown while the server is unreachable
+	http.Handle("/results/", newResultCache(instanceDir("cache")))
+
+	// The page's connection to the client, which passes on what the server
+	// sends (link.go)
+	http.HandleFunc("/page", servePage(ctx))
+
 	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		monitor, _ := strconv.Atoi(r.URL.Query().Get("monitor"))
+		config := configFor(monitor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+	})
+
+	// Servers found by the last discovery, and the one in use
+	http.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
 		mu.Lock()
-		config := ConfigResponse{
-			WsUrl:         fmt.Sprintf("ws://%s:%d/ws", serverIP, serverPort),
-			ServerBaseUrl: fmt.Sprintf("http://%s:%d", serverIP, serverPort),
-			ClientName:    clientName,
-			ThemeMode:     themeMode,
-			Zoom:          zoomLevel,
-			Connected:     serverFound,
+		resp := struct {
+			Servers   []ServiceEntry `json:"servers"`
+			Current   string         `json:"current,omitempty"` // ip:port
+			Preferred string         `json:"preferred,omitempty"`
+		}{Servers: discoveredServers, Preferred: localConfig.PreferredServer}
+		if serverFound {
+			resp.Current = net.JoinHostPort(serverIP, strconv.Itoa(serverPort))
 		}
 		mu.Unlock()
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(config)
+		json.NewEncoder(w).Encode(resp)
 	})
 
 	http.HandleFunc("/config/update", func(w http.ResponseWriter, r *http.Request) {
@@ -325,68 +709,132 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		var newCfg LocalConfig
-		if err := json.NewDecoder(r.Body).Decode(&newCfg); err != nil {
+		var update configUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 			http.Error(w, "Invalid body", http.StatusBadRequest)
 			return
 		}
-
-		mu.Lock()
-		if newCfg.ClientName != "" {
-			clientName = newCfg.ClientName
+		monitor, _ := strconv.Atoi(r.URL.Query().Get("monitor"))
+		if _, err := updateConfig(monitor, update); err != nil {
+			http.Error(w, "Failed to save config", http.StatusInternalServerError)
+			return
 		}
-		if newCfg.ThemeMode == "dark" || newCfg.ThemeMode == "light" {
-			themeMode = newCfg.ThemeMode
+		refreshLink(monitor)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Screen on/off, for local scripts; screen_power commands from the server
+	// are handled in link.go
+	http.HandleFunc("/screen", localOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
-		if newCfg.Zoom >= 50 && newCfg.Zoom <= 300 {
-			zoomLevel = newCfg.Zoom
+		var req struct {
+			Power string `json:"power"`
 		}
-		cfg := LocalConfig{ClientName: clientName, ThemeMode: themeMode, Zoom: zoomLevel}
-		mu.Unlock()
-
-		configPath := filepath.Join(baseDir, "client.json")
-		data, err := json.MarshalIndent(cfg, "", "  ")
-		if err != nil {
-			log.Printf("Error: Failed to marshal config: %v", err)
-			http.Error(w, "Failed to marshal config", http.StatusInternalServerError)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Power != "on" && req.Power != "off") {
+			http.Error(w, "power must be on or off", http.StatusBadRequest)
 			return
 		}
-		if err := os.WriteFile(configPath, data, 0644); err != nil {
-			log.Printf("Error: Failed to write config file: %v", err)
-			http.Error(w, "Failed to write config file", http.StatusInternalServerError)
+		if err := switchScreen(req.Power == "on", "server"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Printf("Updated config: name=%s theme=%s zoom=%d\n", clientName, themeMode, zoomLevel)
 		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Picking a server from a phone while the display has none (pair.go),
+	// and setting up a display started for the first time (setup.go)
+	registerPairing(*addr, *port)
+	registerSetup(staticFS)
+
+	// System health, also sent to the server as heartbeat messages
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(collectHealth())
 	})
 
+	// Recent log lines, also uploaded to the server when it sends request_logs.
+	// The server asks through its link, so this is for this machine only.
+	http.HandleFunc("/logs", localOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, recentLogs.String())
+	}))
+
 	// Create HTTP server
 	server := &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
+		Addr: listenAddr,
 	}
 
+	// Bind before reporting readiness to systemd
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		fatal("Server error", "err", err)
+	}
+
+	// Let the server find this display before it connects
+	advertiseClient(*port)
+
 	// Start server in goroutine
 	go func() {
-		log.Printf("Client server listening on port %d\n", port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v", err)
+		slog.Info("Client server listening", "addr", listenAddr)
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("Server error", "err", err)
 		}
 	}()
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("\nShutdown signal received, gracefully shutting down...")
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("systemd notify failed", "err", err)
+	}
+	go systemdWatchdog(ctx)
+
+	// Wait for shutdown signal, Quit in the tray menu or an installed update.
+	// The tray icon needs the main goroutine on macOS (tray.go), so the
+	// waiting happens beside it.
+	restarting := false
+	quit := make(chan struct{})
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		select {
+		case <-sigChan:
+			sdNotify("STOPPING=1")
+			slog.Info("Shutdown signal received, gracefully shutting down")
+		case <-quit:
+			slog.Info("Quit from the tray menu, shutting down")
+		case <-restart:
+			restarting = true
+			slog.Info("Restarting to apply update")
+		}
+		stopTray()
+	}()
+	runTray(url, quit) // Until stopTray(); returns at once without a tray
+	<-waited
 
 	// Cancel context to signal all goroutines
 	cancel()
 
+	// Let the supervisor kill the browser so a restarted client doesn't open a second one
+	select {
+	case <-supervisorDone:
+	case <-time.After(6 * time.Second):
+	}
+
 	// Graceful shutdown with timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		slog.Error("Server shutdown error", "err", err)
+	}
+	stopAdvertisement() // Before a restart re-registers it
+
+	if restarting {
+		if err := restartSelf(); err != nil {
+			fatal("Restart after update failed", "err", err)
+		}
 	}
 
-	log.Println("Client stopped")
+	slog.Info("Client stopped")
 }
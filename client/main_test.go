@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalOnly(t *testing.T) {
+	handler := localOnly(func(w http.ResponseWriter, r *http.Request) {})
+	for addr, want := range map[string]int{
+		"127.0.0.1:40000":    http.StatusOK,
+		"[::1]:40000":        http.StatusOK,
+		"192.168.1.20:40000": http.StatusForbidden,
+		"[fe80::1]:40000":    http.StatusForbidden,
+		"garbage":            http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/logs", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != want {
+			t.Errorf("request from %s: status %d, want %d", addr, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MonitorConfig places one browser window on a monitor and chooses what it
+// shows. With no monitors configured the client opens a single window.
+type MonitorConfig struct {
+	Display  *int   `json:"display,omitempty"`  // Monitor index from xrandr --listmonitors (0 = first); sets position and size
+	Position string `json:"position,omitempty"` // "x,y" of the window, e.g. "1920,0"
+	Size     string `json:"size,omitempty"`     // "widthxheight", e.g. "1920x1080"
+	Show     string `json:"show,omitempty"`     // all (default), results or timer
+	Name     string `json:"name,omitempty"`     // Display name of monitors after the first; default "<clientName> <n>"
+	Zoom     int    `json:"zoom,omitempty"`     // Zoom of monitors after the first
+	Rotation int    `json:"rotation,omitempty"` // Rotation of monitors after the first
+}
+
+var (
+	positionPattern = regexp.MustCompile(`^-?\d+,-?\d+$`)
+	sizePattern     = regexp.MustCompile(`^\d+x\d+$`)
+	// "0: +*HDMI-A-1 1920/527x1080/296+0+0  HDMI-A-1"
+	xrandrMonitor = regexp.MustCompile(`(\d+)/\d+x(\d+)/\d+\+(-?\d+)\+(-?\d+)`)
+)
+
+func (m MonitorConfig) validate() error {
+	switch m.Show {
+	case "", "all", "results", "timer":
+	default:
+		return fmt.Errorf("show %q must be all, results or timer", m.Show)
+	}
+	if m.Position != "" && !positionPattern.MatchString(m.Position) {
+		return fmt.Errorf("position %q must be x,y", m.Position)
+	}
+	if m.Size != "" && !sizePattern.MatchString(m.Size) {
+		return fmt.Errorf("size %q must be widthxheight", m.Size)
+	}
+	if !validRotation(m.Rotation) {
+		return fmt.Errorf("rotation %d must be 0, 90, 180 or 270", m.Rotation)
+	}
+	if m.Display != nil && *m.Display < 0 {
+		return fmt.Errorf("display %d must not be negative", *m.Display)
+	}
+	return nil
+}
+
+// browserWindow is what launchBrowser needs to open one monitor's window.
+type browserWindow struct {
+	Monitor int      // Index in client.json's monitors, passed to the page as ?monitor=
+	Args    []string // Chromium placement flags
+}
+
+// browserWindows returns one window per configured monitor, or a single
+// unplaced window when there are none. Invalid entries are skipped.
+func browserWindows(monitors []MonitorConfig) []browserWindow {
+	if len(monitors) == 0 {
+		return []browserWindow{{}}
+	}
+	var screens [][4]int // x, y, width, height from xrandr, read once
+	var windows []browserWindow
+	for i, m := range monitors {
+		if err := m.validate(); err != nil {
+			slog.Error("Skipping monitor", "monitor", i, "err", err)
+			continue
+		}
+		position, size := m.Position, m.Size
+		if m.Display != nil {
+			if screens == nil {
+				var err error
+				if screens, err = listScreens(); err != nil {
+					slog.Warn("Cannot list monitors, using position and size only", "err", err)
+					screens = [][4]int{}
+				}
+			}
+			if *m.Display < len(screens) {
+				s := screens[*m.Display]
+				position = fmt.Sprintf("%d,%d", s[0], s[1])
+				size = fmt.Sprintf("%dx%d", s[2], s[3])
+			} else {
+				slog.Warn("Monitor not connected", "monitor", i, "display", *m.Display, "connected", len(screens))
+			}
+		}
+		w := browserWindow{Monitor: i}
+		if position != "" {
+			w.Args = append(w.Args, "--window-position="+position)
+		}
+		if size != "" {
+			w.Args = append(w.Args, "--window-size="+strings.Replace(size, "x", ",", 1))
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// listScreens returns the geometry of each connected monitor, in xrandr's
+// order.
+func listScreens() ([][4]int, error) {
+	out, err := exec.Command("xrandr", "--listmonitors").Output()
+	if err != nil {
+		return nil, err
+	}
+	var screens [][4]int
+	for _, line := range strings.Split(string(out), "\n") {
+		match := xrandrMonitor.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		var s [4]int
+		s[2], _ = strconv.Atoi(match[1])
+		s[3], _ = strconv.Atoi(match[2])
+		s[0], _ = strconv.Atoi(match[3])
+		s[1], _ = strconv.Atoi(match[4])
+		screens = append(screens, s)
+	}
+	return screens, nil
+}
+
+// monitorIdentity is how the window on a monitor presents itself.
+type monitorIdentity struct {
+	ID       string
+	Name     string
+	Zoom     int
+	Rotation int
+}
+
+// identity returns the identity of the window on monitor. The first monitor
+// is the client itself; the others are listed as displays of their own, so
+// they can be renamed, zoomed and rotated separately.
+// Caller holds mu.
+func identity(monitor int) monitorIdentity {
+	if monitor <= 0 || monitor >= len(localConfig.Monitors) {
+		return monitorIdentity{ID: localConfig.ClientID, Name: clientName, Zoom: zoomLevel, Rotation: localConfig.Rotation}
+	}
+	m := localConfig.Monitors[monitor]
+	id := monitorIdentity{ID: localConfig.ClientID + "-" + strconv.Itoa(monitor+1), Name: m.Name, Zoom: m.Zoom, Rotation: m.Rotation}
+	if id.Name == "" {
+		id.Name = clientName + " " + strconv.Itoa(monitor+1)
+	}
+	if id.Zoom == 0 {
+		id.Zoom = 100
+	}
+	return id
+}
+
+// monitorShow returns what the window on monitor shows: all, results or timer.
+// Caller holds mu.
+func monitorShow(monitor int) string {
+	if monitor < 0 || monitor >= len(localConfig.Monitors) || localConfig.Monitors[monitor].Show == "" {
+		return "all"
+	}
+	return localConfig.Monitors[monitor].Show
+}
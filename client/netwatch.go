@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"display/internal/protocol"
+)
+
+// The network watchdog notices when this machine loses its network (no
+// interface up with an address) or discovery keeps failing, and after
+// networkWatchdog.afterSeconds tries to recover: with networkWatchdog.script
+// if set, otherwise by turning Wi-Fi off and on (NetworkManager) or taking
+// the interfaces down and up and renewing their DHCP lease. Recoveries back
+// off, doubling up to maxRecoveryBackoff, while the problem lasts. What it
+// saw and did is kept and sent to the server as network_events once the
+// link is connected again, where it shows in the client's history.
+
+const (
+	networkCheckInterval = 15 * time.Second
+	defaultRecoveryAfter = 2 * time.Minute
+	minRecoveryAfter     = 30 * time.Second
+	maxRecoveryBackoff   = 30 * time.Minute
+	recoveryTimeout      = 2 * time.Minute
+	maxNetworkEvents     = 50 // Unreported events kept; older ones are dropped
+)
+
+// NetworkWatchdogConfig is client.json's networkWatchdog.
+type NetworkWatchdogConfig struct {
+	Disable      bool   `json:"disable,omitempty"`
+	AfterSeconds int    `json:"afterSeconds,omitempty"` // How long the problem lasts before recovering; default 120
+	Script       string `json:"script,omitempty"`       // Run instead of the built-in recovery, with the reason as its argument
+}
+
+var (
+	// When discovery started failing, zero while it succeeds, and why.
+	// Guarded by mu.
+	discoveryFailingSince time.Time
+	discoveryError        string
+
+	networkEventsMu sync.Mutex
+	networkEvents   []protocol.NetworkEvent // Not yet reported to the server
+)
+
+// noteDiscovery records the outcome of a discovery round for the watchdog.
+func noteDiscovery(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err == nil {
+		discoveryFailingSince = time.Time{}
+		return
+	}
+	if discoveryFailingSince.IsZero() {
+		discoveryFailingSince = time.Now()
+	}
+	discoveryError = err.Error()
+}
+
+// recordNetworkEvent keeps an event until it is reported.
+func recordNetworkEvent(event, detail string) {
+	networkEventsMu.Lock()
+	defer networkEventsMu.Unlock()
+	networkEvents = append(networkEvents, protocol.NetworkEvent{Time: time.Now(), Event: event, Detail: detail})
+	if len(networkEvents) > maxNetworkEvents {
+		networkEvents = networkEvents[len(networkEvents)-maxNetworkEvents:]
+	}
+}
+
+// reportNetworkEvents sends the unreported events to the server; they are
+// put back for the next connection if that fails.
+func (l *serverLink) reportNetworkEvents() {
+	networkEventsMu.Lock()
+	events := networkEvents
+	networkEvents = nil
+	networkEventsMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	if err := l.send(protocol.Envelope{Type: "network_events", Payload: events}); err != nil {
+		slog.Debug("Network events not reported", "err", err)
+		networkEventsMu.Lock()
+		networkEvents = append(events, networkEvents...)
+		if len(networkEvents) > maxNetworkEvents {
+			networkEvents = networkEvents[len(networkEvents)-maxNetworkEvents:]
+		}
+		networkEventsMu.Unlock()
+		return
+	}
+	slog.Info("Reported network events to server", "events", len(events))
+}
+
+// networkUp reports whether an interface other than loopback is up, has a
+// carrier and an address that is not link-local.
+func networkUp() bool {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return true // Cannot tell; do not act on it
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagRunning == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// networkWatchdog checks the network every networkCheckInterval until ctx
+// is cancelled.
+func networkWatchdog(ctx context.Context) {
+	mu.Lock()
+	cfg := localConfig.NetworkWatchdog
+	mu.Unlock()
+	if cfg.Disable {
+		return
+	}
+	after := defaultRecoveryAfter
+	if cfg.AfterSeconds > 0 {
+		after = max(time.Duration(cfg.AfterSeconds)*time.Second, minRecoveryAfter)
+	}
+	canRecover := cfg.Script != "" || runtime.GOOS == "linux"
+
+	var lostAt, lastRecovery time.Time
+	backoff := after
+	for {
+		now := time.Now()
+		if up := networkUp(); !up && lostAt.IsZero() {
+			lostAt = now
+			slog.Warn("Network link lost")
+			recordNetworkEvent("link_lost", "")
+		} else if up && !lostAt.IsZero() {
+			down := now.Sub(lostAt).Round(time.Second)
+			slog.Info("Network link restored", "after", down)
+			recordNetworkEvent("link_restored", "after "+down.String())
+			lostAt = time.Time{}
+		}
+
+		mu.Lock()
+		failingSince, failure := discoveryFailingSince, discoveryError
+		mu.Unlock()
+		reason := ""
+		if !lostAt.IsZero() && now.Sub(lostAt) >= after {
+			reason = "network link lost"
+		} else if !failingSince.IsZero() && now.Sub(failingSince) >= after {
+			reason = "discovery failing: " + failure
+		}
+		if reason == "" {
+			lastRecovery, backoff = time.Time{}, after
+		} else if canRecover && (lastRecovery.IsZero() || now.Sub(lastRecovery) >= backoff) {
+			if !lastRecovery.IsZero() {
+				backoff = min(backoff*2, maxRecoveryBackoff)
+			}
+			lastRecovery = now
+			recoverNetwork(ctx, cfg.Script, reason)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(networkCheckInterval):
+		}
+	}
+}
+
+// recoverNetwork runs the recovery and records the outcome.
+func recoverNetwork(ctx context.Context, script, reason string) {
+	slog.Warn("Recovering network", "reason", reason, "script", script)
+	ctx, cancel := context.WithTimeout(ctx, recoveryTimeout)
+	defer cancel()
+	var err error
+	if script != "" {
+		var out []byte
+		if out, err = exec.CommandContext(ctx, script, reason).CombinedOutput(); err != nil {
+			err = fmt.Errorf("%s: %v: %s", script, err, strings.TrimSpace(string(out)))
+		}
+	} else {
+		err = cycleNetwork(ctx)
+	}
+	if err != nil {
+		slog.Error("Network recovery failed", "reason", reason, "err", err)
+		recordNetworkEvent("recovery_failed", reason+": "+err.Error())
+		return
+	}
+	recordNetworkEvent("recovery", reason)
+	select {
+	case rediscover <- struct{}{}: // Look for the server at once
+	default:
+	}
+}
+
+// networkInterfaces lists the hardware network interfaces (Linux), Wi-Fi
+// and the others.
+func networkInterfaces() (wireless, wired []string) {
+	dirs, _ := filepath.Glob("/sys/class/net/*")
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue // Loopback, bridges, VPNs
+		}
+		if _, err := os.Stat(filepath.Join(dir, "wireless")); err == nil {
+			wireless = append(wireless, filepath.Base(dir))
+		} else {
+			wired = append(wired, filepath.Base(dir))
+		}
+	}
+	return wireless, wired
+}
+
+// cycleNetwork turns Wi-Fi off and on and reconnects wired interfaces with
+// NetworkManager (Raspberry Pi OS Bookworm), or else takes each interface
+// down and up and renews its lease with dhcpcd (older images; needs root).
+func cycleNetwork(ctx context.Context) error {
+	run := func(name string, args ...string) error {
+		if out, err := exec.CommandContext(ctx, name, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	wireless, wired := networkInterfaces()
+	if len(wireless)+len(wired) == 0 {
+		return errors.New("no network interfaces")
+	}
+
+	if _, err := exec.LookPath("nmcli"); err == nil {
+		if len(wireless) > 0 {
+			if err := run("nmcli", "radio", "wifi", "off"); err != nil {
+				return err
+			}
+			time.Sleep(2 * time.Second)
+			if err := run("nmcli", "radio", "wifi", "on"); err != nil {
+				return err
+			}
+		}
+		for _, iface := range wired {
+			if err := run("nmcli", "device", "connect", iface); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, dhcpErr := exec.LookPath("dhcpcd")
+	for _, iface := range append(wireless, wired...) {
+		if err := run("ip", "link", "set", iface, "down"); err != nil {
+			return err
+		}
+		if err := run("ip", "link", "set", iface, "up"); err != nil {
+			return err
+		}
+		if dhcpErr == nil {
+			if err := run("dhcpcd", "-n", iface); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
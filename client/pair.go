@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Pairing: while a display has not found a server, its page shows a QR code
+// of pair.html (of /setup on a first run, setup.go) on this client's own web
+// server. A phone on the same network scans it, sees the servers discovery
+// found and picks one (or names the display), so nobody has to type IP
+// addresses on a screen without keyboard.
+
+// pairURL is the address of page (pair.html, or setup on a first run) as
+// phones on the network reach it, or "" when the client only listens on
+// loopback or has no LAN address.
+func pairURL(bindAddr string, port int, page string) string {
+	ip := net.ParseIP(bindAddr)
+	if ip != nil && ip.IsLoopback() {
+		return ""
+	}
+	if ip == nil || ip.IsUnspecified() {
+		ip = lanIP()
+	}
+	if ip == nil {
+		return ""
+	}
+	return "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(port)) + "/" + page
+}
+
+// lanIP returns the first private IPv4 address of an interface that is up,
+// the one a phone on the venue Wi-Fi most likely shares a network with.
+func lanIP() net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && ipNet.IP.IsPrivate() {
+				return ipNet.IP
+			}
+		}
+	}
+	return nil
+}
+
+// registerPairing serves the pairing QR code (GET /pair/qr.png) and the
+// server choice of pair.html (POST /pair/server {"name"}).
+func registerPairing(bindAddr string, port int) {
+	http.HandleFunc("/pair/qr.png", func(w http.ResponseWriter, r *http.Request) {
+		page := "pair.html"
+		if setupOpen() {
+			page = "setup"
+		}
+		target := pairURL(bindAddr, port, page)
+		if target == "" {
+			http.Error(w, "no network address to pair over", http.StatusNotFound)
+			return
+		}
+		png, err := qrcode.Encode(target, qrcode.Medium, 256)
+		if err != nil {
+			slog.Error("Failed to encode pairing QR code", "url", target, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(png)
+	})
+
+	http.HandleFunc("/pair/server", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Name string `json:"name"` // serverName, host name or ip:port
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		slog.Info("Server picked by pairing", "name", req.Name, "from", r.RemoteAddr)
+		switchServer(req.Name)
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"display/internal/protocol"
+)
+
+const screenScheduleInterval = 30 * time.Second
+
+// ScreenPowerConfig switches the attached screen on and off, on a daily
+// schedule and on screen_power commands from the server.
+type ScreenPowerConfig struct {
+	Method string `json:"method,omitempty"` // auto (default: CEC if cec-client is installed, else DPMS), cec or dpms
+	On     string `json:"on,omitempty"`     // Daily switch-on time, "07:30"
+	Off    string `json:"off,omitempty"`    // Daily switch-off time, "22:00"; may be before On for daytime-off
+}
+
+func (c ScreenPowerConfig) validate() error {
+	switch c.Method {
+	case "", "auto", "cec", "dpms":
+	default:
+		return fmt.Errorf("method %q must be auto, cec or dpms", c.Method)
+	}
+	if (c.On == "") != (c.Off == "") {
+		return fmt.Errorf("on and off must both be set or both be empty")
+	}
+	for _, t := range []string{c.On, c.Off} {
+		if t != "" && !validClock(t) {
+			return fmt.Errorf("time %q must be HH:MM", t)
+		}
+	}
+	if c.On != "" && c.On == c.Off {
+		return fmt.Errorf("on and off must differ")
+	}
+	return nil
+}
+
+// scheduledOn reports whether the schedule has the screen on at now.
+func (c ScreenPowerConfig) scheduledOn(now time.Time) bool {
+	return clockBetween(now, c.On, c.Off)
+}
+
+// clockBetween reports whether the time of day of now is in [from, to),
+// which wraps past midnight when to is before from. Times compare as
+// strings because both are zero-padded HH:MM.
+func clockBetween(now time.Time, from, to string) bool {
+	clock := now.Format("15:04")
+	if from < to {
+		return clock >= from && clock < to
+	}
+	return clock >= from || clock < to
+}
+
+// validClock reports whether t is a zero-padded "HH:MM".
+func validClock(t string) bool {
+	_, err := time.Parse("15:04", t)
+	return err == nil && len(t) == 5
+}
+
+// validatePowerSchedule checks a schedule from the server before it is
+// saved; the server checks it too, but client.json outlives server versions.
+func validatePowerSchedule(s protocol.PowerSchedule) error {
+	if err := (ScreenPowerConfig{On: s.On, Off: s.Off}).validate(); err != nil {
+		return err
+	}
+	if s.Brightness < 0 || s.Brightness > 100 {
+		return fmt.Errorf("brightness %d must be between 0 and 100", s.Brightness)
+	}
+	for _, d := range s.Dim {
+		if !validClock(d.From) || !validClock(d.To) || d.From == d.To {
+			return fmt.Errorf("dim period %q-%q must be two different HH:MM times", d.From, d.To)
+		}
+		if d.Brightness < 0 || d.Brightness > 100 {
+			return fmt.Errorf("dim brightness %d must be between 0 and 100", d.Brightness)
+		}
+	}
+	return nil
+}
+
+// scheduledBrightness is the brightness the schedule sets at now, or -1 if
+// it leaves the brightness alone.
+func scheduledBrightness(s *protocol.PowerSchedule, now time.Time) int {
+	if s == nil || (s.Brightness == 0 && len(s.Dim) == 0) {
+		return -1
+	}
+	for _, d := range s.Dim {
+		if clockBetween(now, d.From, d.To) {
+			return d.Brightness
+		}
+	}
+	if s.Brightness == 0 {
+		return 100
+	}
+	return s.Brightness
+}
+
+// savePowerSchedule keeps the schedule of power_schedule in client.json, so
+// the screens follow it when the client starts while the server is away.
+// An empty schedule removes the saved one.
+func savePowerSchedule(schedule protocol.PowerSchedule) {
+	if err := validatePowerSchedule(schedule); err != nil {
+		slog.Error("Ignoring power schedule from server", "err", err)
+		return
+	}
+	var saved *protocol.PowerSchedule
+	if schedule.On != "" || schedule.Brightness != 0 || len(schedule.Dim) > 0 {
+		saved = &schedule
+	}
+	mu.Lock()
+	if reflect.DeepEqual(localConfig.PowerSchedule, saved) {
+		mu.Unlock()
+		return
+	}
+	localConfig.PowerSchedule = saved
+	cfg := localConfig
+	mu.Unlock()
+	if err := saveLocalConfig(cfg); err != nil {
+		slog.Error("Failed to save power schedule", "err", err)
+		return
+	}
+	slog.Info("Saved power schedule from server", "on", schedule.On, "off", schedule.Off, "brightness", schedule.Brightness, "dim", len(schedule.Dim))
+}
+
+// setScreenPower switches the screen with CEC (the TV goes to standby) or
+// DPMS (the video signal stops; most monitors and TVs then sleep).
+func setScreenPower(on bool, method string) error {
+	if method == "cec" || method == "" || method == "auto" {
+		if _, err := exec.LookPath("cec-client"); err == nil {
+			return cecPower(on)
+		} else if method == "cec" {
+			return err
+		}
+	}
+	return dpmsPower(on)
+}
+
+// cecPower sends one command to logical address 0 (the TV).
+func cecPower(on bool) error {
+	command := "standby 0"
+	if on {
+		command = "on 0"
+	}
+	cmd := exec.Command("cec-client", "-s", "-d", "1")
+	cmd.Stdin = strings.NewReader(command + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cec-client: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func dpmsPower(on bool) error {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wlr-randr"); err == nil {
+			state := "--off"
+			if on {
+				state = "--on"
+			}
+			for i := 0; ; i++ {
+				output, err := screenOutput("wlr-randr", i)
+				if err != nil {
+					if i == 0 {
+						return err
+					}
+					return nil // Past the last output
+				}
+				if err := runScreenTool("wlr-randr", "--output", output, state); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if _, err := exec.LookPath("xset"); err == nil {
+			state := "off"
+			if on {
+				state = "on"
+			}
+			return runScreenTool("xset", "dpms", "force", state)
+		}
+	}
+	return fmt.Errorf("no cec-client, wlr-randr or xset to switch the screen")
+}
+
+// switchScreen applies a power state and logs the outcome.
+func switchScreen(on bool, reason string) error {
+	mu.Lock()
+	method := localConfig.ScreenPower.Method
+	mu.Unlock()
+	state := map[bool]string{true: "on", false: "off"}[on]
+	if err := setScreenPower(on, method); err != nil {
+		slog.Error("Failed to switch screen", "state", state, "reason", reason, "err", err)
+		return err
+	}
+	slog.Info("Switched screen", "state", state, "reason", reason)
+	return nil
+}
+
+// screenScheduleLoop applies the daily schedule: the local one
+// (screenPower), or else the one the server sent for the room
+// (powerSchedule), with its brightness. It only acts when the scheduled
+// state changes (and once at startup), so a command from the server holds
+// until the next switch time. The saved schedule is read on every tick, so
+// a new one from the server takes effect without a restart.
+func screenScheduleLoop(ctx context.Context) {
+	mu.Lock()
+	local := localConfig.ScreenPower
+	mu.Unlock()
+	if err := local.validate(); err != nil {
+		slog.Error("Ignoring screen power schedule", "err", err)
+		local.On = ""
+	} else if local.On != "" {
+		slog.Info("Screen power schedule", "on", local.On, "off", local.Off)
+	}
+
+	lastPower, lastLevel := "", -1
+	for {
+		now := time.Now()
+		mu.Lock()
+		server := localConfig.PowerSchedule
+		windows := max(len(localConfig.Monitors), 1)
+		mu.Unlock()
+
+		power, reason := "", "schedule"
+		if local.On != "" {
+			power = map[bool]string{true: "on", false: "off"}[local.scheduledOn(now)]
+		} else if server != nil && server.On != "" {
+			power, reason = map[bool]string{true: "on", false: "off"}[clockBetween(now, server.On, server.Off)], "server schedule"
+		}
+		if power != "" && power != lastPower {
+			switchScreen(power == "on", reason) // A failure is logged and not retried until the next switch time
+		}
+		lastPower = power
+
+		if level := scheduledBrightness(server, now); level >= 0 && level != lastLevel {
+			for monitor := range windows {
+				adjustLevel("brightness", level, func() error { return setBrightness(monitor, level) })
+			}
+			lastLevel = level
+		} else if level < 0 {
+			lastLevel = -1
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(screenScheduleInterval):
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"display/internal/protocol"
+)
+
+// Provisioning (client -provision, run with sudo on the Pi) turns a fresh
+// Raspberry Pi OS desktop image into a display: it asks for the display's
+// name, room and server, installs the kiosk browser and the screen tools,
+// logs the user in to the desktop without a password, keeps the screen from
+// blanking, writes client.json and installs the systemd user unit
+// (-install-systemd) for that user. Every step can be run again.
+
+// kioskPackages are what the kiosk (browsers.go), screen power (power.go)
+// and rotation (rotate.go) use.
+var kioskPackages = []string{"chromium", "cec-utils", "wlr-randr"}
+
+// lightdmAutologin is written where raspi-config is missing.
+const lightdmAutologin = "/etc/lightdm/lightdm.conf.d/50-display-client.conf"
+
+// provisionStep is one thing provisioning sets up.
+type provisionStep struct {
+	name string
+	run  func() error
+}
+
+// provision runs the steps for the user who ran sudo, reading the answers
+// from in. It returns how many steps failed.
+func provision(in io.Reader, args []string) int {
+	if runtime.GOOS != "linux" {
+		fatal("-provision sets up Raspberry Pi OS; on this system start the client directly")
+	}
+	if os.Geteuid() != 0 {
+		fatal("-provision needs root: run it as sudo ./client -provision")
+	}
+	kiosk, err := kioskUser()
+	if err != nil {
+		fatal("Cannot tell which user the display runs as; run -provision with sudo from that user", "err", err)
+	}
+
+	answers := bufio.NewScanner(in)
+	ask := func(question, def string) string {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		if !answers.Scan() {
+			fmt.Println()
+			return def
+		}
+		if answer := strings.TrimSpace(answers.Text()); answer != "" {
+			return answer
+		}
+		return def
+	}
+
+	// Keep what an earlier run or the client itself saved, such as the ID
+	var cfg LocalConfig
+	if data, err := os.ReadFile(configPath()); err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			slog.Warn("Ignoring unreadable client.json", "path", configPath(), "err", err)
+			cfg = LocalConfig{}
+		}
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = newClientID()
+	}
+	hostname, _ := os.Hostname()
+	if cfg.ClientName == "" {
+		cfg.ClientName = "Client-" + hostname + instanceSuffix()
+	}
+	fmt.Printf("Setting up this Raspberry Pi as a display for user %s.\n", kiosk.Username)
+	cfg.ClientName = ask("Display name", cfg.ClientName)
+	for {
+		cfg.Room = ask("Room (results subfolder; empty for the main room)", cfg.Room)
+		err := protocol.ValidateRoomName(cfg.Room)
+		if err == nil {
+			break
+		}
+		fmt.Println(err)
+		cfg.Room = ""
+	}
+	cfg.PreferredServer = ask("Server name or ip:port (empty to use the one found)", cfg.PreferredServer)
+
+	steps := []provisionStep{
+		{"Install kiosk browser and screen tools", installKioskPackages},
+		{"Log in to the desktop automatically", func() error { return enableAutologin(kiosk.Username) }},
+		{"Keep the screen from blanking", disableBlanking},
+		{"Write " + filepath.Base(configPath()), func() error { return writeProvisionedConfig(cfg, kiosk) }},
+		{"Start the client with the desktop", func() error { return installKioskUnit(kiosk, args) }},
+	}
+	failed := 0
+	for i, step := range steps {
+		fmt.Printf("[%d/%d] %s\n", i+1, len(steps), step.name)
+		if err := step.run(); err != nil {
+			slog.Error("Provisioning step failed", "step", step.name, "err", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d steps failed; fix them and run -provision again.\n", failed, len(steps))
+		return failed
+	}
+	fmt.Println("Done. Reboot (sudo reboot) and the display starts by itself.")
+	return 0
+}
+
+// kioskUser is the user who ran sudo, or pi.
+func kioskUser() (*user.User, error) {
+	name := os.Getenv("SUDO_USER")
+	if name == "" || name == "root" {
+		name = "pi"
+	}
+	return user.Lookup(name)
+}
+
+// runCommand runs name with args, returning its output in the error.
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installKioskPackages() error {
+	if _, err := exec.LookPath("apt-get"); err != nil {
+		return errors.New("no apt-get: install " + strings.Join(kioskPackages, ", ") + " yourself")
+	}
+	if err := runCommand("apt-get", "update"); err != nil {
+		return err
+	}
+	return runCommand("apt-get", append([]string{"install", "-y"}, kioskPackages...)...)
+}
+
+// enableAutologin uses raspi-config's desktop autologin (B4), or configures
+// LightDM directly without it.
+func enableAutologin(username string) error {
+	if _, err := exec.LookPath("raspi-config"); err == nil {
+		return runCommand("raspi-config", "nonint", "do_boot_behaviour", "B4")
+	}
+	if _, err := os.Stat("/etc/lightdm"); err != nil {
+		return errors.New("neither raspi-config nor LightDM found: turn on desktop autologin yourself")
+	}
+	if err := os.MkdirAll(filepath.Dir(lightdmAutologin), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(lightdmAutologin, []byte("[Seat:*]\nautologin-user="+username+"\n"), 0644)
+}
+
+// disableBlanking turns off the desktop's screen blanking (raspi-config's
+// do_blanking 1 = off); without raspi-config the console's is turned off.
+func disableBlanking() error {
+	if _, err := exec.LookPath("raspi-config"); err == nil {
+		return runCommand("raspi-config", "nonint", "do_blanking", "1")
+	}
+	const cmdline = "/boot/firmware/cmdline.txt"
+	data, err := os.ReadFile(cmdline)
+	if err != nil {
+		return fmt.Errorf("no raspi-config and %w", err)
+	}
+	if strings.Contains(string(data), "consoleblank=0") {
+		return nil
+	}
+	return os.WriteFile(cmdline, []byte(strings.TrimRight(string(data), "\n")+" consoleblank=0\n"), 0644)
+}
+
+// writeProvisionedConfig saves cfg as client.json, owned by the kiosk user
+// so the client can rewrite it.
+func writeProvisionedConfig(cfg LocalConfig, kiosk *user.User) error {
+	if err := saveLocalConfig(cfg); err != nil {
+		return err
+	}
+	return chownTo(configPath(), kiosk)
+}
+
+// installKioskUnit writes and enables the kiosk user's unit (as
+// installSystemdUnit does, without their session bus: the enable link is
+// made by hand) and removes the autostart entry of older images, which would
+// start a second client.
+func installKioskUnit(kiosk *user.User, args []string) error {
+	unitPath, err := writeSystemdUnit(kiosk.HomeDir, args)
+	if err != nil {
+		return err
+	}
+	wants := filepath.Join(filepath.Dir(unitPath), "graphical-session.target.wants")
+	if err := os.MkdirAll(wants, 0755); err != nil {
+		return err
+	}
+	link := filepath.Join(wants, systemdUnitName())
+	if err := os.Remove(link); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Symlink(unitPath, link); err != nil {
+		return err
+	}
+	autostart := filepath.Join(kiosk.HomeDir, ".config", "autostart", "display.desktop")
+	if err := os.Remove(autostart); err == nil {
+		slog.Info("Removed the old autostart entry", "path", autostart)
+	}
+	// Everything under ~/.config/systemd written as root goes to the user
+	if err := chownTo(filepath.Join(kiosk.HomeDir, ".config"), kiosk); err != nil {
+		return err
+	}
+	return filepath.WalkDir(filepath.Join(kiosk.HomeDir, ".config", "systemd"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return chownTo(path, kiosk)
+	})
+}
+
+func chownTo(path string, u *user.User) error {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(path, uid, gid)
+}
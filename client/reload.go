@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// supervisedBrowser is a kiosk browser browserSupervisor keeps running.
+type supervisedBrowser struct {
+	cmd       *exec.Cmd
+	url       string // Display page of the window
+	debugPort int    // DevTools port of a Chromium, for devtoolsWatchdog; 0 if there is none
+	profile   string // Temporary profile directory; "" with custom browserArgs
+
+	mu    sync.Mutex
+	clear bool // Remove the profile once the browser has exited
+}
+
+var (
+	supervised   = map[int]*supervisedBrowser{}
+	supervisedMu sync.Mutex
+)
+
+// setSupervised records the running browser of monitor; nil when it exited.
+func setSupervised(monitor int, b *supervisedBrowser) {
+	supervisedMu.Lock()
+	defer supervisedMu.Unlock()
+	if b == nil {
+		delete(supervised, monitor)
+	} else {
+		supervised[monitor] = b
+	}
+}
+
+func supervisedBrowserOf(monitor int) *supervisedBrowser {
+	supervisedMu.Lock()
+	defer supervisedMu.Unlock()
+	return supervised[monitor]
+}
+
+// kill ends the browser; browserSupervisor starts it again.
+func (b *supervisedBrowser) kill() {
+	if b.cmd.Process != nil {
+		b.cmd.Process.Kill()
+	}
+}
+
+// clearProfile removes the profile if clear_cache asked for it, so the
+// browser starts with an empty cache, no service workers and no storage.
+func (b *supervisedBrowser) clearProfile() {
+	b.mu.Lock()
+	clear := b.clear
+	b.mu.Unlock()
+	if !clear || b.profile == "" {
+		return
+	}
+	if err := os.RemoveAll(b.profile); err != nil {
+		slog.Error("Failed to clear browser profile", "profile", b.profile, "err", err)
+		return
+	}
+	slog.Info("Browser profile cleared", "profile", b.profile)
+}
+
+// reloadDisplay carries out the reload command: it loads the display page
+// again through DevTools, or restarts the browser if it has none. A browser
+// the client does not supervise is asked through the page, which only helps
+// if its JavaScript still runs.
+func (l *serverLink) reloadDisplay() {
+	if b := supervisedBrowserOf(l.monitor); b != nil {
+		if b.debugPort != 0 {
+			err := reloadViaDevtools(context.Background(), b.debugPort, b.url)
+			if err == nil {
+				slog.Info("Display page reloaded", "monitor", l.monitor)
+				return
+			}
+			slog.Warn("Reload through DevTools failed, restarting the browser", "monitor", l.monitor, "err", err)
+		} else {
+			slog.Info("Restarting the browser to reload the display page", "monitor", l.monitor)
+		}
+		b.kill()
+		return
+	}
+	if l.pageCount() == 0 {
+		slog.Warn("Reload requested, but no display page is connected", "monitor", l.monitor)
+		return
+	}
+	slog.Info("Asking the display page to reload", "monitor", l.monitor)
+	l.broadcast([]byte(`{"type":"reload"}`))
+}
+
+// clearCache carries out the clear_cache command: it restarts the browser
+// with its temporary profile removed. Without a supervised browser, or with
+// a profile from custom browserArgs, it can only reload.
+func (l *serverLink) clearCache() {
+	b := supervisedBrowserOf(l.monitor)
+	if b == nil {
+		slog.Warn("No supervised browser to clear the cache of, reloading instead", "monitor", l.monitor)
+		l.reloadDisplay()
+		return
+	}
+	if b.profile == "" {
+		slog.Warn("Browser profile unknown with custom browserArgs, restarting without clearing it", "monitor", l.monitor)
+	} else {
+		slog.Info("Restarting the browser with a cleared profile", "monitor", l.monitor, "profile", b.profile)
+	}
+	b.mu.Lock()
+	b.clear = true
+	b.mu.Unlock()
+	b.kill()
+}
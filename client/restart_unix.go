@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// restartSelf replaces the current process with the (updated) executable,
+// keeping the PID so supervisors such as systemd don't notice the restart.
+func restartSelf() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exePath, err = filepath.EvalSymlinks(exePath); err != nil {
+		return err
+	}
+	return syscall.Exec(exePath, os.Args, os.Environ())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errNoRotationTool means neither wlr-randr (Wayland) nor xrandr (X11) can
+// rotate the screen, so the page rotates itself instead.
+var errNoRotationTool = errors.New("no xrandr or wlr-randr")
+
+func validRotation(degrees int) bool {
+	return degrees == 0 || degrees == 90 || degrees == 180 || degrees == 270
+}
+
+// rotateScreen turns the output at index (in the order the tool lists them)
+// to degrees clockwise.
+func rotateScreen(index, degrees int) error {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wlr-randr"); err == nil {
+			output, err := screenOutput("wlr-randr", index)
+			if err != nil {
+				return err
+			}
+			// Wayland transforms turn counter-clockwise
+			transform := map[int]string{0: "normal", 90: "270", 180: "180", 270: "90"}[degrees]
+			return runScreenTool("wlr-randr", "--output", output, "--transform", transform)
+		}
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if _, err := exec.LookPath("xrandr"); err == nil {
+			output, err := screenOutput("xrandr", index)
+			if err != nil {
+				return err
+			}
+			rotate := map[int]string{0: "normal", 90: "right", 180: "inverted", 270: "left"}[degrees]
+			return runScreenTool("xrandr", "--output", output, "--rotate", rotate)
+		}
+	}
+	return errNoRotationTool
+}
+
+// screenOutput returns the name of the output at index, e.g. HDMI-A-1.
+func screenOutput(tool string, index int) (string, error) {
+	var outputs []string
+	if tool == "xrandr" {
+		out, err := exec.Command("xrandr", "--listmonitors").Output()
+		if err != nil {
+			return "", err
+		}
+		// "0: +*HDMI-A-1 1920/527x1080/296+0+0  HDMI-A-1"
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 4 && xrandrMonitor.MatchString(line) {
+				outputs = append(outputs, fields[len(fields)-1])
+			}
+		}
+	} else {
+		out, err := exec.Command("wlr-randr").Output()
+		if err != nil {
+			return "", err
+		}
+		// Outputs start unindented: `HDMI-A-1 "Samsung ..."`; details are indented
+		for _, line := range strings.Split(string(out), "\n") {
+			if line != "" && line[0] != ' ' && line[0] != '\t' {
+				outputs = append(outputs, strings.Fields(line)[0])
+			}
+		}
+	}
+	if index >= len(outputs) {
+		return "", fmt.Errorf("%s lists %d outputs, need output %d", tool, len(outputs), index)
+	}
+	return outputs[index], nil
+}
+
+func runScreenTool(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// screenIndex returns which output monitor is on: its display setting, or
+// its position in the monitors list. Caller holds mu.
+func screenIndex(monitor int) int {
+	if monitor >= 0 && monitor < len(localConfig.Monitors) && localConfig.Monitors[monitor].Display != nil {
+		return *localConfig.Monitors[monitor].Display
+	}
+	return max(monitor, 0)
+}
+
+var (
+	screenRotated = map[int]bool{} // Monitors whose output rotateScreen turned
+	pageRotation  = map[int]bool{} // Monitors whose page has to rotate itself with CSS
+)
+
+// applyRotation rotates monitor's screen, or leaves it to the page when the
+// screen cannot be rotated. Setting 0 on a screen that was never rotated does
+// nothing, so unrotated displays never touch the screen setup.
+func applyRotation(monitor, degrees int) {
+	mu.Lock()
+	index := screenIndex(monitor)
+	skip := degrees == 0 && !screenRotated[monitor]
+	if skip {
+		pageRotation[monitor] = false
+	}
+	mu.Unlock()
+	if skip {
+		return
+	}
+
+	err := rotateScreen(index, degrees)
+	mu.Lock()
+	screenRotated[monitor] = err == nil && degrees != 0
+	pageRotation[monitor] = err != nil
+	mu.Unlock()
+	switch {
+	case err == nil:
+		slog.Info("Rotated screen", "monitor", monitor, "degrees", degrees)
+	case errors.Is(err, errNoRotationTool):
+		slog.Info("Rotating in the browser", "monitor", monitor, "degrees", degrees, "reason", err)
+	default:
+		slog.Warn("Cannot rotate screen, rotating in the browser", "monitor", monitor, "degrees", degrees, "err", err)
+	}
+}
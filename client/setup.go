@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"display/internal/protocol"
+)
+
+// First-run setup: a display started without client.json serves /setup
+// until it finds a server, and the QR code on its page opens that instead of
+// pair.html. From a phone on the same network, staff set the display's name,
+// room, server address (for networks where discovery finds nothing),
+// rotation, zoom and theme. Once a server is found /setup closes; settings
+// are changed in the Admin UI from then on.
+
+// firstRun is set when client.json was generated at start and cleared when
+// a server is found, under mu.
+var firstRun bool
+
+// setupOpen reports whether /setup is served.
+func setupOpen() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return firstRun
+}
+
+// setupSettings are what /setup shows and saves.
+type setupSettings struct {
+	ClientName    string `json:"clientName"`
+	Room          string `json:"room"`
+	ServerAddress string `json:"serverAddress"` // Empty = discovery
+	Rotation      int    `json:"rotation"`
+	Zoom          int    `json:"zoom"`
+	ThemeMode     string `json:"themeMode"`
+}
+
+func (s setupSettings) validate() error {
+	if s.ClientName == "" || len(s.ClientName) > 64 {
+		return errors.New("name must be 1 to 64 characters")
+	}
+	if err := protocol.ValidateRoomName(s.Room); err != nil {
+		return err
+	}
+	if s.ServerAddress != "" {
+		if _, err := parseServerAddress(s.ServerAddress); err != nil {
+			return err
+		}
+	}
+	if !validRotation(s.Rotation) {
+		return errors.New("rotation must be 0, 90, 180 or 270")
+	}
+	if s.Zoom < 50 || s.Zoom > 300 {
+		return errors.New("zoom must be between 50 and 300")
+	}
+	if s.ThemeMode != "dark" && s.ThemeMode != "light" {
+		return errors.New("theme must be dark or light")
+	}
+	return nil
+}
+
+// registerSetup serves the setup page (GET /setup, setup.html from static),
+// the current settings with the servers found so far (GET /setup/state) and
+// saving them (POST /setup/state). All answer 404 once /setup is closed.
+func registerSetup(static http.FileSystem) {
+	closed := func(w http.ResponseWriter) bool {
+		if setupOpen() {
+			return false
+		}
+		http.Error(w, "This display is set up; change its settings in the Admin UI", http.StatusNotFound)
+		return true
+	}
+
+	http.HandleFunc("/setup", func(w http.ResponseWriter, r *http.Request) {
+		if closed(w) {
+			return
+		}
+		f, err := static.Open("/setup.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "setup.html", info.ModTime(), f)
+	})
+
+	http.HandleFunc("/setup/state", func(w http.ResponseWriter, r *http.Request) {
+		if closed(w) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			mu.Lock()
+			state := struct {
+				setupSettings
+				Servers []ServiceEntry `json:"servers"` // Found by discovery so far
+			}{setupSettings{
+				ClientName:    clientName,
+				Room:          localConfig.Room,
+				ServerAddress: localConfig.ServerAddress,
+				Rotation:      localConfig.Rotation,
+				Zoom:          zoomLevel,
+				ThemeMode:     themeMode,
+			}, append([]ServiceEntry{}, discoveredServers...)}
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(state)
+		case http.MethodPost:
+			var req setupSettings
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid body", http.StatusBadRequest)
+				return
+			}
+			req.ClientName = strings.TrimSpace(req.ClientName)
+			req.Room = strings.Trim(strings.TrimSpace(req.Room), "/")
+			req.ServerAddress = strings.TrimSpace(req.ServerAddress)
+			if err := req.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			localConfig.Room = req.Room
+			localConfig.ServerAddress = req.ServerAddress
+			mu.Unlock()
+			rotation := req.Rotation
+			update := configUpdate{LocalConfig: LocalConfig{ClientName: req.ClientName, Zoom: req.Zoom, ThemeMode: req.ThemeMode}, Rotation: &rotation}
+			if _, err := updateConfig(0, update); err != nil { // Saves client.json
+				http.Error(w, "Failed to save config", http.StatusInternalServerError)
+				return
+			}
+			slog.Info("Set up from the setup page", "name", req.ClientName, "room", req.Room, "server", req.ServerAddress, "from", r.RemoteAddr)
+			refreshLink(0)
+			select {
+			case rediscover <- struct{}{}: // Try the new address at once
+			default:
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
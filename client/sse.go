@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxSSEMessage is the longest message line accepted from the server's
+// server-sent events stream; client lists of big venues are the longest.
+const maxSSEMessage = 1 << 20
+
+// sseStream is a connection to the server's server-sent events fallback,
+// used when a proxy between us and the server breaks WebSockets. The server
+// streams what it would send over the WebSocket; our messages are POSTed.
+type sseStream struct {
+	postURL string
+	stop    context.CancelFunc
+}
+
+// send POSTs v to the server.
+func (s *sseStream) send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: linkWriteWait}
+	resp, err := client.Post(s.postURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
+
+// connectSSE is connect over server-sent events.
+func (l *serverLink) connectSSE(ctx context.Context, host, sseURL string) (connected bool, err error) {
+	sessionCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	req, err := http.NewRequestWithContext(sessionCtx, http.MethodGet, sseURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("server answered %s", resp.Status)
+	}
+	defer func() {
+		l.mu.Lock()
+		l.sse = nil
+		l.mu.Unlock()
+	}()
+
+	// The server writes a comment every 10s, so a silent stream is a dead one
+	watchdog := time.AfterFunc(serverReadTimeout, stop)
+	defer watchdog.Stop()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxSSEMessage)
+	event := ""
+	for scanner.Scan() {
+		watchdog.Reset(serverReadTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			if event != "session" {
+				l.receive([]byte(data))
+				continue
+			}
+			l.mu.Lock()
+			l.sse = &sseStream{postURL: sseURL + "?session=" + url.QueryEscape(data), stop: stop}
+			l.status = linkStatus{Connected: true, Server: host}
+			l.mu.Unlock()
+			connected = true
+
+			slog.Info("Server connection established", "monitor", l.monitor, "addr", host, "transport", "sse")
+			if err := l.sendHandshake(); err != nil {
+				return true, err
+			}
+			l.sendStatus()
+			go l.heartbeatLoop(sessionCtx)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return connected, err
+	}
+	return connected, io.EOF
+}
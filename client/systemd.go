@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state update (e.g. "READY=1") to systemd when running
+// under a Type=notify unit. Without NOTIFY_SOCKET it does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract namespace socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdWatchdog pings the systemd watchdog at half of WatchdogSec until ctx
+// is cancelled. It returns immediately if the unit has no watchdog.
+func systemdWatchdog(ctx context.Context) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	slog.Info("systemd watchdog enabled", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				slog.Warn("systemd watchdog notify failed", "err", err)
+			}
+		}
+	}
+}
+
+// systemdQuote quotes an ExecStart argument if it contains whitespace or quotes.
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return strconv.Quote(arg)
+}
+
+// systemdUnitName is display-client.service, or display-client-<instance>.service.
+func systemdUnitName() string {
+	return "display-client" + instanceSuffix() + ".service"
+}
+
+// installSystemdUnit writes a systemd *user* unit for the current user and
+// enables it. The kiosk browser needs the desktop session's DISPLAY /
+// WAYLAND_DISPLAY, so the unit is tied to graphical-session.target rather
+// than being a system service. Run it as the kiosk user, not with sudo.
+func installSystemdUnit(args []string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	unitPath, err := writeSystemdUnit(home, args)
+	if err != nil {
+		return "", err
+	}
+	for _, cmd := range [][]string{
+		{"systemctl", "--user", "daemon-reload"},
+		{"systemctl", "--user", "enable", systemdUnitName()},
+	} {
+		if out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+			return unitPath, fmt.Errorf("%s: %v: %s", strings.Join(cmd, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return unitPath, nil
+}
+
+// writeSystemdUnit writes the user unit starting this executable with args
+// into ~/.config/systemd/user under home.
+func writeSystemdUnit(home string, args []string) (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	exePath, err = filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return "", err
+	}
+
+	execStart := []string{systemdQuote(exePath)}
+	for _, a := range args {
+		execStart = append(execStart, systemdQuote(a))
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=Display Client%s
+PartOf=graphical-session.target
+After=graphical-session.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s
+WorkingDirectory=%s
+Restart=always
+RestartSec=2
+WatchdogSec=30
+
+[Install]
+WantedBy=graphical-session.target
+`, instanceSuffix(), strings.Join(execStart, " "), filepath.Dir(exePath))
+
+	unitDir := filepath.Join(home, ".config", "systemd", "user")
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return "", err
+	}
+	unitPath := filepath.Join(unitDir, systemdUnitName())
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return "", err
+	}
+	return unitPath, nil
+}
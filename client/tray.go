@@ -0,0 +1,190 @@
+//go:build windows || (darwin && cgo)
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/systray"
+)
+
+// The tray icon is for clients run by hand on Windows and macOS laptops,
+// where a console window was all volunteers saw: its colour shows whether
+// the display is connected (green), looking for the server (amber) or
+// connecting (grey), and its menu opens the display page, renames the
+// display, reconnects and quits. Kiosk displays on Linux have no tray.
+
+const trayPollInterval = 2 * time.Second
+
+var (
+	trayMu      sync.Mutex
+	trayReady   bool // systray.Run is running
+	trayStopped bool // stopTray was called, maybe before it ran
+)
+
+// trayState is what the icon shows.
+type trayState struct {
+	found     bool // Discovery found a server
+	connected bool // A window's link is connected to it
+	server    string
+}
+
+func currentTrayState() trayState {
+	mu.Lock()
+	st := trayState{found: serverFound, server: serverEntry.Name}
+	mu.Unlock()
+	linksMu.Lock()
+	for _, l := range links {
+		l.mu.Lock()
+		st.connected = st.connected || l.status.Connected
+		l.mu.Unlock()
+	}
+	linksMu.Unlock()
+	return st
+}
+
+func (st trayState) text() string {
+	switch {
+	case st.connected:
+		return "Connected to " + st.server
+	case st.found:
+		return "Connecting to " + st.server + "..."
+	}
+	return "Looking for the server..."
+}
+
+func (st trayState) color() color.RGBA {
+	switch {
+	case st.connected:
+		return color.RGBA{0x22, 0xc5, 0x5e, 0xff}
+	case st.found:
+		return color.RGBA{0x94, 0xa3, 0xb8, 0xff}
+	}
+	return color.RGBA{0xf5, 0x9e, 0x0b, 0xff}
+}
+
+// trayPNG is a filled circle of c.
+func trayPNG(c color.RGBA) []byte {
+	const size = 32
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			dx, dy := float64(x)-size/2+0.5, float64(y)-size/2+0.5
+			if dx*dx+dy*dy <= (size/2-2)*(size/2-2) {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// runTray shows the tray icon until stopTray() is called; Quit in its menu
+// closes quit. url is the display page.
+func runTray(url string, quit chan<- struct{}) {
+	systray.Run(func() { trayMenu(url, quit) }, nil)
+}
+
+// stopTray removes the tray icon, making runTray return.
+func stopTray() {
+	trayMu.Lock()
+	trayStopped = true
+	ready := trayReady
+	trayMu.Unlock()
+	if ready {
+		systray.Quit()
+	}
+}
+
+// trayMenu builds the menu and keeps the icon up to date.
+func trayMenu(url string, quit chan<- struct{}) {
+	trayMu.Lock()
+	trayReady = true
+	stopped := trayStopped
+	trayMu.Unlock()
+	if stopped {
+		systray.Quit()
+		return
+	}
+
+	systray.SetTitle("") // macOS shows the title beside the icon
+	status := systray.AddMenuItem("", "")
+	status.Disable()
+	systray.AddSeparator()
+	open := systray.AddMenuItem("Open display", "Show the display page in the browser")
+	rename := systray.AddMenuItem("Rename...", "Change the name the Admin UI shows")
+	reconnect := systray.AddMenuItem("Reconnect", "Look for the server and connect again")
+	systray.AddSeparator()
+	quitItem := systray.AddMenuItem("Quit", "Stop the display client")
+
+	var shown trayState
+	update := func(force bool) {
+		st := currentTrayState()
+		if st == shown && !force {
+			return
+		}
+		shown = st
+		mu.Lock()
+		name := clientName
+		mu.Unlock()
+		systray.SetIcon(trayIcon(st.color()))
+		systray.SetTooltip(name + ": " + st.text())
+		status.SetTitle(st.text())
+	}
+	update(true)
+
+	ticker := time.NewTicker(trayPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			update(false)
+		case <-open.ClickedCh:
+			if err := openURL(url); err != nil {
+				slog.Error("Failed to open the display page", "url", url, "err", err)
+			}
+		case <-rename.ClickedCh:
+			go renameFromTray(func() { update(true) })
+		case <-reconnect.ClickedCh:
+			slog.Info("Reconnecting from the tray menu")
+			reconnectLinks()
+			select {
+			case rediscover <- struct{}{}:
+			default:
+			}
+		case <-quitItem.ClickedCh:
+			close(quit)
+			return
+		}
+	}
+}
+
+// renameFromTray asks for a new display name and saves it as POST
+// /config/update does.
+func renameFromTray(done func()) {
+	mu.Lock()
+	current := clientName
+	mu.Unlock()
+	name, err := askName(current)
+	if err != nil {
+		slog.Error("Failed to ask for a display name", "err", err)
+		return
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == current {
+		return
+	}
+	if _, err := updateConfig(0, configUpdate{LocalConfig: LocalConfig{ClientName: name}}); err != nil {
+		return // Logged by updateConfig
+	}
+	refreshLink(0)
+	done()
+}
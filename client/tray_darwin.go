@@ -0,0 +1,27 @@
+//go:build cgo
+
+package main
+
+import (
+	"errors"
+	"image/color"
+	"os/exec"
+	"strings"
+)
+
+// trayIcon is trayPNG; the macOS menu bar takes PNG.
+func trayIcon(c color.RGBA) []byte {
+	return trayPNG(c)
+}
+
+// askName shows a dialog with current; Cancel returns "".
+func askName(current string) (string, error) {
+	quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(current) + `"`
+	out, err := exec.Command("osascript",
+		"-e", `text returned of (display dialog "Display name" default answer `+quoted+` with title "Rename display")`).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "-128") {
+		return "", nil // Cancel
+	}
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !(darwin && cgo)
+
+package main
+
+// Linux kiosks, and macOS builds without cgo, have no tray icon (tray.go).
+
+func runTray(url string, quit chan<- struct{}) {}
+
+func stopTray() {}
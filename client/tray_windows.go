@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// trayIcon is trayPNG in an ICO file, which Windows needs; ICO files may
+// hold a PNG since Vista.
+func trayIcon(c color.RGBA) []byte {
+	data := trayPNG(c)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, 1})                   // Reserved, icon, one image
+	buf.Write([]byte{32, 32, 0, 0})                                              // Width, height, no palette, reserved
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 32})                     // Planes, bits per pixel
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(data)), 6 + 16}) // Size, offset
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// askName shows an input box with current; Cancel returns "". The name goes
+// to PowerShell in the environment, never in the script, so no quote in it
+// can end the string.
+func askName(current string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-Command",
+		"Add-Type -AssemblyName Microsoft.VisualBasic; "+
+			"[Microsoft.VisualBasic.Interaction]::InputBox('Display name', 'Rename display', $env:SCORE_DISPLAY_NAME)")
+	cmd.Env = append(os.Environ(), "SCORE_DISPLAY_NAME="+current)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const updateCheckInterval = 10 * time.Minute
+
+// UpdateManifest is returned by the server's GET /api/update/{os}/{arch}.
+type UpdateManifest struct {
+	Version   string `json:"version"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Signature string `json:"signature,omitempty"` // Base64 ed25519 signature of the binary
+	URL       string `json:"url"`                 // Path of the binary on the server
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// updateLoop periodically asks the server for a newer client build. When one
+// has been installed it sends on restart and returns, so main can shut down
+// the browser cleanly before re-executing the new binary. Without an
+// updatePublicKey nothing is installed: the server is found by unauthenticated
+// discovery, so only a signature shows a build is genuine.
+func updateLoop(ctx context.Context, restart chan<- struct{}) {
+	if version == "dev" {
+		slog.Info("Update: development build, auto-update disabled")
+		return
+	}
+	noKeyLogged := false
+
+	// First check shortly after start-up, once discovery had a chance to run.
+	wait := 30 * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = updateCheckInterval
+
+		mu.Lock()
+		found := serverFound
+		base := serverURL("http")
+		disabled := localConfig.DisableAutoUpdate
+		publicKey := localConfig.UpdatePublicKey
+		mu.Unlock()
+		if !found || disabled {
+			continue
+		}
+		if publicKey == "" {
+			if !noKeyLogged {
+				slog.Info("Update: no updatePublicKey in client.json, auto-update disabled")
+				noKeyLogged = true
+			}
+			continue
+		}
+
+		installed, err := checkForUpdate(ctx, base, publicKey)
+		if err != nil {
+			slog.Warn("Update check failed", "err", err)
+			continue
+		}
+		if installed {
+			restart <- struct{}{}
+			return
+		}
+	}
+}
+
+// checkForUpdate downloads and installs a newer binary if the server offers
+// one. It reports whether the executable was replaced.
+func checkForUpdate(ctx context.Context, base, publicKey string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/api/update/%s/%s", base, runtime.GOOS, runtime.GOARCH), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil // Server has no build for this platform
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("manifest request failed: %s", resp.Status)
+	}
+	var manifest UpdateManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return false, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version == "" {
+		return false, nil
+	}
+	newer, err := versionNewer(manifest.Version, version)
+	if err != nil {
+		return false, err
+	}
+	if !newer {
+		return false, nil // Same or older build, never downgrade
+	}
+
+	slog.Info("Update: downloading new version", "version", manifest.Version, "running", version)
+	if err := installUpdate(ctx, base, manifest, publicKey); err != nil {
+		return false, err
+	}
+	slog.Info("Update: installed", "version", manifest.Version)
+	return true, nil
+}
+
+// installUpdate downloads the binary next to the executable, verifies its
+// checksum and signature and swaps it in.
+func installUpdate(ctx context.Context, base string, manifest UpdateManifest, publicKey string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exePath, err = filepath.EvalSymlinks(exePath); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+manifest.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	// Same directory as the executable so the final rename is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(exePath), ".client-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if manifest.Size > 0 && n != manifest.Size {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", n, manifest.Size)
+	}
+	sum := hash.Sum(nil)
+	if hex.EncodeToString(sum) != manifest.SHA256 {
+		return errors.New("checksum mismatch, discarding download")
+	}
+	if err := verifySignature(tmp.Name(), publicKey, manifest.Signature); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return replaceExecutable(tmp.Name(), exePath)
+}
+
+func verifySignature(path, publicKey, signature string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("updatePublicKey in client.json is not a base64 ed25519 public key")
+	}
+	if signature == "" {
+		return errors.New("update is not signed, discarding download")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return errors.New("signature verification failed, discarding download")
+	}
+	return nil
+}
+
+// releaseVersion is a version as `git describe --tags` writes it: v1.4.2,
+// v1.5.0-rc.1, or v1.4.2-3-gabc1234 for the third commit after v1.4.2.
+type releaseVersion struct {
+	core    [3]int
+	pre     []string // Pre-release identifiers, none for a release
+	commits int      // Commits after the tag
+}
+
+var describeSuffix = regexp.MustCompile(`^(.+)-(\d+)-g[0-9a-f]+$`)
+
+func parseVersion(s string) (releaseVersion, error) {
+	var v releaseVersion
+	rest := strings.TrimSuffix(strings.TrimPrefix(s, "v"), "-dirty")
+	rest, _, _ = strings.Cut(rest, "+") // Build metadata does not order
+	if m := describeSuffix.FindStringSubmatch(rest); m != nil {
+		rest = m[1]
+		v.commits, _ = strconv.Atoi(m[2])
+	}
+	core, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("version %q is not vMAJOR.MINOR.PATCH", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("version %q is not vMAJOR.MINOR.PATCH", s)
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+// compareVersions orders a and b as semantic versions, with builds after a
+// tag newer than the tag. It returns -1, 0 or 1.
+func compareVersions(a, b releaseVersion) int {
+	for i := range a.core {
+		if c := cmp.Compare(a.core[i], b.core[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.pre) == 0 && len(b.pre) > 0:
+		return 1
+	case len(a.pre) > 0 && len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		if c := comparePrerelease(a.pre[i], b.pre[i]); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(len(a.pre), len(b.pre)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.commits, b.commits)
+}
+
+// comparePrerelease orders pre-release identifiers: numeric ones by value
+// and before alphanumeric ones, which are compared as text.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// versionNewer reports whether offered is a newer version than running.
+func versionNewer(offered, running string) (bool, error) {
+	o, err := parseVersion(offered)
+	if err != nil {
+		return false, err
+	}
+	r, err := parseVersion(running)
+	if err != nil {
+		return false, err
+	}
+	return compareVersions(o, r) > 0, nil
+}
+
+// replaceExecutable moves newPath over exePath. Windows cannot overwrite a
+// running executable, so the old one is moved aside first.
+func replaceExecutable(newPath, exePath string) error {
+	if runtime.GOOS == "windows" {
+		old := exePath + ".old"
+		os.Remove(old)
+		if err := os.Rename(exePath, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(newPath, exePath)
+}
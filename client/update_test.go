@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestVersionNewer(t *testing.T) {
+	tests := []struct {
+		offered, running string
+		newer            bool
+	}{
+		{"v1.2.4", "v1.2.3", true},
+		{"v1.10.0", "v1.9.9", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.2", "v1.2.3", false},            // Downgrade
+		{"v1.2.3-1-gabc1234", "v1.2.3", true},  // Commit after the tag
+		{"v1.2.3", "v1.2.3-2-gabc1234", false}, // Back to the tag
+		{"v1.2.3-dirty", "v1.2.3", false},      // Same commit
+		{"v1.3.0", "v1.3.0-rc.2", true},        // Release after its candidates
+		{"v1.3.0-rc.10", "v1.3.0-rc.2", true},  // Numeric identifiers by value
+		{"v1.3.0-rc.1", "v1.3.0-beta.5", true}, // Others as text
+		{"v1.3.0-rc.1-4-gdeadbee", "v1.3.0-rc.1", true},
+		{"v1.2.3+build.7", "v1.2.3+build.8", false}, // Build metadata does not order
+	}
+	for _, tt := range tests {
+		newer, err := versionNewer(tt.offered, tt.running)
+		if err != nil {
+			t.Errorf("versionNewer(%q, %q): %v", tt.offered, tt.running, err)
+			continue
+		}
+		if newer != tt.newer {
+			t.Errorf("versionNewer(%q, %q) = %v, want %v", tt.offered, tt.running, newer, tt.newer)
+		}
+	}
+}
+
+func TestVersionNewerRejectsOtherVersions(t *testing.T) {
+	for _, v := range []string{"", "abc1234", "v1.2", "v1.2.x", "latest"} {
+		if _, err := versionNewer(v, "v1.0.0"); err == nil {
+			t.Errorf("versionNewer(%q, v1.0.0) accepted", v)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Compositors the kiosk browser can be wrapped in (client.json "compositor").
+// Wrapping is for displays without a desktop session, e.g. a Raspberry Pi OS
+// Lite install where the client is started from a systemd unit on a console.
+const (
+	compositorAuto  = "auto"  // Default: the running session if there is one, else cage, else labwc
+	compositorNone  = "none"  // Always start the browser directly
+	compositorCage  = "cage"  // cage -s -- chromium ...
+	compositorLabwc = "labwc" // labwc -s "chromium ..."
+)
+
+func validCompositor(name string) bool {
+	switch name {
+	case "", compositorAuto, compositorNone, compositorCage, compositorLabwc:
+		return true
+	}
+	return false
+}
+
+// waylandSession reports whether the client runs inside a Wayland desktop
+// session (the default on Raspberry Pi OS Bookworm).
+func waylandSession() bool {
+	return os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("XDG_SESSION_TYPE") == "wayland"
+}
+
+// pickCompositor resolves compositorAuto: a wrapper is only used when there
+// is no display to show the browser on.
+func pickCompositor(name string) string {
+	if name != "" && name != compositorAuto {
+		return name
+	}
+	if waylandSession() || os.Getenv("DISPLAY") != "" {
+		return compositorNone
+	}
+	for _, c := range []string{compositorCage, compositorLabwc} {
+		if _, err := exec.LookPath(c); err == nil {
+			return c
+		}
+	}
+	slog.Warn("No desktop session and neither cage nor labwc installed, starting the browser directly")
+	return compositorNone
+}
+
+// chromiumOzoneFlag selects Chromium's platform for the resolved compositor.
+func chromiumOzoneFlag(compositor string) string {
+	if compositor != compositorNone || waylandSession() {
+		return "--ozone-platform=wayland"
+	}
+	return "--ozone-platform-hint=auto" // X11 session; Chromium falls back sensibly
+}
+
+// kioskCommand returns the command that starts browser with args, wrapped in
+// compositor (as resolved by pickCompositor).
+func kioskCommand(compositor, browser string, args []string) *exec.Cmd {
+	switch compositor {
+	case compositorCage:
+		return exec.Command("cage", append([]string{"-s", "--", browser}, args...)...)
+	case compositorLabwc:
+		line := shellQuote(browser)
+		for _, a := range args {
+			line += " " + shellQuote(a)
+		}
+		return exec.Command("labwc", "-s", line)
+	}
+	return exec.Command(browser, args...)
+}
+
+// shellQuote quotes s for sh, which labwc runs its startup command with.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`;&|<>()*?[]#~{}") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
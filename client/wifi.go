@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"display/internal/protocol"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// set_wifi saves a network on this machine and joins it if it is in range;
+// otherwise it is joined by itself once it is, so a display can be given
+// the venue's network while still at the club. NetworkManager (Raspberry Pi
+// OS Bookworm) gets a connection profile, added over D-Bus so the password
+// is not on a command line other users can read; older images get a network
+// block in wpa_supplicant.conf (needs root), with the passphrase stored hashed.
+// The outcome is reported to the server as a network event.
+
+const (
+	wpaSupplicantConf  = "/etc/wpa_supplicant/wpa_supplicant.conf"
+	wifiConnectTimeout = 45 * time.Second
+	nmProfilePrefix    = "score-display " // Profiles we made, replaced when the network is sent again
+)
+
+// setWifi carries out set_wifi and reports the outcome.
+func (l *serverLink) setWifi(creds protocol.WifiCredentials) {
+	joined, err := saveWifi(creds)
+	switch {
+	case err != nil:
+		slog.Error("Failed to save Wi-Fi network", "ssid", creds.SSID, "err", err)
+		recordNetworkEvent("wifi_failed", creds.SSID+": "+err.Error())
+	case joined:
+		slog.Info("Joined Wi-Fi network", "ssid", creds.SSID)
+		recordNetworkEvent("wifi_joined", creds.SSID)
+	default:
+		slog.Info("Saved Wi-Fi network, joining it when in range", "ssid", creds.SSID)
+		recordNetworkEvent("wifi_saved", creds.SSID)
+	}
+	l.reportNetworkEvents() // On the new network this waits for the next connection
+}
+
+// saveWifi saves the network and reports whether it was joined now.
+func saveWifi(creds protocol.WifiCredentials) (joined bool, err error) {
+	if runtime.GOOS != "linux" {
+		return false, fmt.Errorf("Wi-Fi settings are not supported on %s", runtime.GOOS)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wifiConnectTimeout+15*time.Second)
+	defer cancel()
+	if _, err := exec.LookPath("nmcli"); err == nil && quietRun(ctx, "nmcli", "general", "status") == nil {
+		return networkManagerWifi(ctx, creds)
+	}
+	if _, err := os.Stat(wpaSupplicantConf); err == nil {
+		return false, wpaSupplicantWifi(ctx, creds)
+	}
+	return false, errors.New("neither NetworkManager nor wpa_supplicant found")
+}
+
+// quietRun runs a command and reports only its name and first argument on
+// failure, so an SSID or address in the others stays out of the error.
+func quietRun(ctx context.Context, name string, args ...string) error {
+	if out, err := exec.CommandContext(ctx, name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// networkManagerWifi replaces our profile of the network and tries to
+// bring it up; NetworkManager goes back to the previous network if that
+// fails and joins this one when it appears.
+func networkManagerWifi(ctx context.Context, creds protocol.WifiCredentials) (bool, error) {
+	name := nmProfilePrefix + creds.SSID
+	quietRun(ctx, "nmcli", "connection", "delete", "id", name) // Not there the first time
+	if err := addNetworkManagerProfile(ctx, name, creds); err != nil {
+		return false, fmt.Errorf("adding NetworkManager profile: %w", err)
+	}
+	wait := fmt.Sprint(int(wifiConnectTimeout.Seconds()))
+	if err := quietRun(ctx, "nmcli", "--wait", wait, "connection", "up", "id", name); err != nil {
+		slog.Info("Wi-Fi network not joined now", "ssid", creds.SSID, "err", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// addNetworkManagerProfile adds a profile joining creds' network whenever it
+// is in range, like "nmcli connection add type wifi" but with the password
+// in the D-Bus message instead of the process's arguments.
+func addNetworkManagerProfile(ctx context.Context, name string, creds protocol.WifiCredentials) error {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = uuid[6]&0x0f | 0x40 // Version 4
+	uuid[8] = uuid[8]&0x3f | 0x80
+	settings := map[string]map[string]dbus.Variant{
+		"connection": {
+			"id":          dbus.MakeVariant(name),
+			"uuid":        dbus.MakeVariant(fmt.Sprintf("%x-%x-%x-%x-%x", uuid[:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])),
+			"type":        dbus.MakeVariant("802-11-wireless"),
+			"autoconnect": dbus.MakeVariant(true),
+		},
+		"802-11-wireless": {
+			"ssid":   dbus.MakeVariant([]byte(creds.SSID)),
+			"mode":   dbus.MakeVariant("infrastructure"),
+			"hidden": dbus.MakeVariant(creds.Hidden),
+		},
+		"ipv4": {"method": dbus.MakeVariant("auto")},
+		"ipv6": {"method": dbus.MakeVariant("auto")},
+	}
+	if creds.Password != "" {
+		settings["802-11-wireless-security"] = map[string]dbus.Variant{
+			"key-mgmt": dbus.MakeVariant("wpa-psk"),
+			"psk":      dbus.MakeVariant(creds.Password),
+		}
+	}
+	var path dbus.ObjectPath
+	return conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager/Settings").
+		CallWithContext(ctx, "org.freedesktop.NetworkManager.Settings.AddConnection", 0, settings).Store(&path)
+}
+
+// wpaSupplicantWifi replaces the network's block in wpa_supplicant.conf and
+// has wpa_supplicant read it again.
+func wpaSupplicantWifi(ctx context.Context, creds protocol.WifiCredentials) error {
+	data, err := os.ReadFile(wpaSupplicantConf)
+	if err != nil {
+		return err
+	}
+	block, err := wpaNetworkBlock(creds)
+	if err != nil {
+		return err
+	}
+	conf := removeWpaNetwork(string(data), creds.SSID)
+	if !strings.HasSuffix(conf, "\n") {
+		conf += "\n"
+	}
+	if err := writeFileAtomic(wpaSupplicantConf, []byte(conf+block)); err != nil {
+		return err
+	}
+	wireless, _ := networkInterfaces()
+	for _, iface := range wireless {
+		if err := quietRun(ctx, "wpa_cli", "-i", iface, "reconfigure"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wpaNetworkBlock is the network={...} of creds, with the SSID in hex so no
+// quoting is needed and the passphrase as its PSK.
+func wpaNetworkBlock(creds protocol.WifiCredentials) (string, error) {
+	var b strings.Builder
+	b.WriteString("network={\n\tssid=" + hex.EncodeToString([]byte(creds.SSID)) + "\n")
+	if creds.Hidden {
+		b.WriteString("\tscan_ssid=1\n")
+	}
+	switch p := creds.Password; {
+	case p == "":
+		b.WriteString("\tkey_mgmt=NONE\n")
+	case len(p) == 64:
+		b.WriteString("\tpsk=" + strings.ToLower(p) + "\n") // Already the PSK
+	default:
+		psk, err := pbkdf2.Key(sha1.New, p, []byte(creds.SSID), 4096, 32) // WPA's passphrase to PSK
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("\tpsk=" + hex.EncodeToString(psk) + "\n")
+	}
+	b.WriteString("\tid_str=\"score-display\"\n}\n")
+	return b.String(), nil
+}
+
+// removeWpaNetwork drops the network={...} blocks of ssid, written quoted
+// or in hex, from conf.
+func removeWpaNetwork(conf, ssid string) string {
+	names := map[string]bool{
+		"ssid=" + hex.EncodeToString([]byte(ssid)): true,
+		`ssid="` + ssid + `"`:                      true,
+	}
+	var out, block []string
+	inBlock, drop := false, false
+	for _, line := range strings.Split(conf, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inBlock && strings.HasPrefix(trimmed, "network={"):
+			inBlock, drop, block = true, false, []string{line}
+		case inBlock:
+			block = append(block, line)
+			if names[trimmed] {
+				drop = true
+			}
+			if trimmed == "}" {
+				if !drop {
+					out = append(out, block...)
+				}
+				inBlock = false
+			}
+		default:
+			out = append(out, line)
+		}
+	}
+	if inBlock {
+		out = append(out, block...) // Unterminated: leave it as it was
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,1555 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"display/internal/protocol"
+
+	"github.com/spf13/cobra"
+)
+
+type timerState = protocol.TimerState
+
+type scoreState struct {
+	Sport      string     `json:"sport"`
+	Scoreboard string     `json:"scoreboard"`
+	Home       string     `json:"home"`
+	Away       string     `json:"away"`
+	Sets       []setScore `json:"sets"`
+	SetsWon    setScore   `json:"setsWon"`
+	Game       *struct {
+		Home string `json:"home"`
+		Away string `json:"away"`
+	} `json:"game"`
+	Serve  string `json:"serve"`
+	Winner string `json:"winner"`
+}
+
+type setScore struct {
+	Home int `json:"home"`
+	Away int `json:"away"`
+}
+
+type splitsState struct {
+	Control  string `json:"control"`
+	Class    string `json:"class"`
+	Passings int    `json:"passings"`
+	Rows     []struct {
+		Rank   int    `json:"rank"`
+		Bib    string `json:"bib"`
+		Name   string `json:"name"`
+		Club   string `json:"club"`
+		Class  string `json:"class"`
+		Time   string `json:"time"`
+		Behind string `json:"behind"`
+		Latest bool   `json:"latest"`
+	} `json:"rows"`
+}
+
+type resultFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Actor  string    `json:"actor"`
+	Addr   string    `json:"addr"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Value  string    `json:"value"`
+}
+
+type clientInfo = protocol.ClientInfo
+
+func formatClock(seconds int) string {
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
+
+func timerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "timer",
+		Short: "Start, pause or reset the match timer",
+	}
+
+	action := func(name string) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			var state timerState
+			if err := apiPost("/api/timer", map[string]interface{}{"action": name, "room": room}, &state); err != nil {
+				return err
+			}
+			switch {
+			case state.Period > 0 && state.Break:
+				fmt.Printf("Timer %s (%s left, break after period %d/%d)\n", name, formatClock(state.TimeLeft), state.Period, state.Periods)
+			case state.Period > 0:
+				fmt.Printf("Timer %s (%s left, period %d/%d)\n", name, formatClock(state.TimeLeft), state.Period, state.Periods)
+			default:
+				fmt.Printf("Timer %s (%s left)\n", name, formatClock(state.TimeLeft))
+			}
+			return nil
+		}
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "start",
+			Short: "Start or resume the timer",
+			Args:  cobra.NoArgs,
+			RunE:  action("start"),
+		},
+		&cobra.Command{
+			Use:   "pause",
+			Short: "Pause the timer",
+			Args:  cobra.NoArgs,
+			RunE:  action("pause"),
+		},
+		&cobra.Command{
+			Use:   "next-period",
+			Short: "Set the timer for the next period (needs matchFlow in server.json)",
+			Args:  cobra.NoArgs,
+			RunE:  action("next_period"),
+		},
+		&cobra.Command{
+			Use:   "new-match",
+			Short: "Set the timer for the first period and end all penalties",
+			Args:  cobra.NoArgs,
+			RunE:  action("new_match"),
+		},
+		&cobra.Command{
+			Use:     "reset <duration>",
+			Short:   "Set the timer to a new duration and stop it",
+			Example: "  score-displayctl timer reset 15m",
+			Args:    cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return fmt.Errorf("invalid duration %q: %w", args[0], err)
+				}
+				seconds := int(d.Seconds())
+				if seconds <= 0 {
+					return fmt.Errorf("duration must be at least one second")
+				}
+				var state timerState
+				if err := apiPost("/api/timer", map[string]interface{}{"action": "reset", "seconds": seconds, "room": room}, &state); err != nil {
+					return err
+				}
+				fmt.Printf("Timer reset to %s\n", formatClock(state.TotalTime))
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+func penaltyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "penalty",
+		Short: "Add or remove penalties, which run with the match timer",
+	}
+
+	// post sends a penalty action and lists the penalties still running
+	post := func(body map[string]interface{}) error {
+		body["room"] = room
+		var state timerState
+		if err := apiPost("/api/penalty", body, &state); err != nil {
+			return err
+		}
+		if len(state.Penalties) == 0 {
+			fmt.Println("No penalties running")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTEAM\tPLAYER\tLEFT")
+		for _, p := range state.Penalties {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.ID, p.Team, p.Player, formatClock(p.TimeLeft))
+		}
+		return tw.Flush()
+	}
+
+	var length time.Duration
+	add := &cobra.Command{
+		Use:     "add home|away [player]",
+		Short:   "Start a penalty for a team or one of its players",
+		Example: "  score-displayctl penalty add home 12\n  score-displayctl penalty add away --length 5m",
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]interface{}{"action": "add", "team": args[0], "seconds": int(length.Seconds())}
+			if len(args) == 2 {
+				body["player"] = args[1]
+			}
+			return post(body)
+		},
+	}
+	add.Flags().DurationVar(&length, "length", 2*time.Minute, "Length of the penalty")
+
+	cmd.AddCommand(
+		add,
+		&cobra.Command{
+			Use:   "remove <id>",
+			Short: "End a penalty early, e.g. after a goal",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				id, err := strconv.Atoi(args[0])
+				if err != nil {
+					return fmt.Errorf("invalid penalty ID %q", args[0])
+				}
+				return post(map[string]interface{}{"action": "remove", "id": id})
+			},
+		},
+		&cobra.Command{
+			Use:   "clear",
+			Short: "End all penalties",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return post(map[string]interface{}{"action": "clear"})
+			},
+		},
+	)
+	return cmd
+}
+
+// printScore shows the scoreboard as a table, one line per team.
+func printScore(state scoreState) error {
+	switch {
+	case state.Sport == "":
+		fmt.Println("No sport chosen")
+		return nil
+	case state.Scoreboard == "":
+		fmt.Printf("%s has no scoreboard\n", state.Sport)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEAM\tNAME\tSETS\tSCORE\tGAME\t")
+	for _, team := range []string{"home", "away"} {
+		name, won, game := state.Home, state.SetsWon.Home, ""
+		if team == "away" {
+			name, won = state.Away, state.SetsWon.Away
+		}
+		points := make([]string, len(state.Sets))
+		for i, set := range state.Sets {
+			points[i] = strconv.Itoa(set.Home)
+			if team == "away" {
+				points[i] = strconv.Itoa(set.Away)
+			}
+		}
+		if state.Game != nil {
+			game = state.Game.Home
+			if team == "away" {
+				game = state.Game.Away
+			}
+		}
+		note := ""
+		switch team {
+		case state.Winner:
+			note = "won"
+		case state.Serve:
+			note = "serving"
+		}
+		sets := "-"
+		if state.Scoreboard == "sets" {
+			sets = strconv.Itoa(won)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", team, name, sets, strings.Join(points, " "), game, note)
+	}
+	return tw.Flush()
+}
+
+// sportsCmd lists the sport profiles, built-in and from the server's
+// sportsDir.
+func sportsCmd() *cobra.Command {
+	var reload bool
+	cmd := &cobra.Command{
+		Use:   "sports",
+		Short: "List the sports the server has profiles for",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sports []struct {
+				Name       string `json:"name"`
+				Title      string `json:"title"`
+				Clock      string `json:"clock"`
+				Scoreboard struct {
+					Type string `json:"type"`
+				} `json:"scoreboard"`
+				Periods *struct {
+					Periods       int `json:"periods"`
+					PeriodMinutes int `json:"periodMinutes"`
+				} `json:"periods"`
+			}
+			var err error
+			if reload {
+				err = apiPost("/api/sports/reload", map[string]interface{}{}, &sports)
+			} else {
+				err = apiGet("/api/sports", &sports)
+			}
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tTITLE\tCLOCK\tPERIODS\tSCOREBOARD")
+			for _, s := range sports {
+				clock, periods, board := s.Clock, "-", s.Scoreboard.Type
+				if clock == "" {
+					clock = "down"
+				}
+				if s.Periods != nil {
+					periods = fmt.Sprintf("%d x %d min", s.Periods.Periods, s.Periods.PeriodMinutes)
+				}
+				if board == "" {
+					board = "-"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Title, clock, periods, board)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&reload, "reload", false, "Read the profiles in the server's sportsDir again first")
+	return cmd
+}
+
+func scoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "score",
+		Short: "Choose the room's sport and keep its score",
+	}
+
+	post := func(body map[string]interface{}) error {
+		body["room"] = room
+		var state scoreState
+		if err := apiPost("/api/score", body, &state); err != nil {
+			return err
+		}
+		return printScore(state)
+	}
+	team := func(action string) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			return post(map[string]interface{}{"action": action, "team": args[0]})
+		}
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "show",
+			Short: "Show the score",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var state scoreState
+				if err := apiGet("/api/score?room="+url.QueryEscape(room), &state); err != nil {
+					return err
+				}
+				return printScore(state)
+			},
+		},
+		sportsCmd(),
+		&cobra.Command{
+			Use:     "sport <name>|off",
+			Short:   "Choose the room's sport, starting a new match, or none",
+			Example: "  score-displayctl score sport volleyball",
+			Args:    cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				sport := args[0]
+				if sport == "off" {
+					sport = ""
+				}
+				return post(map[string]interface{}{"action": "sport", "sport": sport})
+			},
+		},
+		&cobra.Command{
+			Use:   "teams <home> <away>",
+			Short: "Name the teams",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return post(map[string]interface{}{"action": "teams", "home": args[0], "away": args[1]})
+			},
+		},
+		&cobra.Command{
+			Use:   "point home|away",
+			Short: "Give a team a point",
+			Args:  cobra.ExactArgs(1),
+			RunE:  team("point"),
+		},
+		&cobra.Command{
+			Use:   "serve home|away",
+			Short: "Set which team serves",
+			Args:  cobra.ExactArgs(1),
+			RunE:  team("serve"),
+		},
+		&cobra.Command{
+			Use:   "undo",
+			Short: "Take back the last point or change of serve",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return post(map[string]interface{}{"action": "undo"})
+			},
+		},
+		&cobra.Command{
+			Use:   "reset",
+			Short: "Start a new match with the same sport and teams",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return post(map[string]interface{}{"action": "reset"})
+			},
+		},
+	)
+	return cmd
+}
+
+// printSplits shows a control's standings, the newest passing marked with *.
+func printSplits(state splitsState) error {
+	if state.Control == "" {
+		fmt.Println("No control shown")
+		return nil
+	}
+	fmt.Printf("%s (%d through)\n", strings.TrimSpace(state.Control+" "+state.Class), state.Passings)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  #\tBIB\tNAME\tCLUB\tCLASS\tTIME\tBEHIND")
+	for _, r := range state.Rows {
+		marker := " "
+		if r.Latest {
+			marker = "*"
+		}
+		fmt.Fprintf(tw, "%s %d\t%s\t%s\t%s\t%s\t%s\t%s\n", marker, r.Rank, r.Bib, r.Name, r.Club, r.Class, r.Time, r.Behind)
+	}
+	return tw.Flush()
+}
+
+func splitsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "splits",
+		Short: "Push intermediate times and choose the control the room's displays rank",
+	}
+
+	var class string
+	var top int
+	show := &cobra.Command{
+		Use:   "show [control]",
+		Short: "Show a control's standings, or the room's view without one",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var state splitsState
+			path := "/api/splits/view?room=" + url.QueryEscape(room)
+			if len(args) == 1 {
+				path = "/api/splits?" + url.Values{"control": {args[0]}, "class": {class}, "top": {strconv.Itoa(top)}}.Encode()
+			}
+			if err := apiGet(path, &state); err != nil {
+				return err
+			}
+			return printSplits(state)
+		},
+	}
+	view := &cobra.Command{
+		Use:     "view <control>|off",
+		Short:   "Rank a control on the room's displays in splits mode",
+		Example: "  score-displayctl splits view radio1 --class H21 --top 8",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			control := args[0]
+			if control == "off" {
+				control = ""
+			}
+			var state splitsState
+			if err := apiPost("/api/splits/view", map[string]interface{}{"control": control, "class": class, "top": top, "room": room}, &state); err != nil {
+				return err
+			}
+			return printSplits(state)
+		},
+	}
+	for _, c := range []*cobra.Command{show, view} {
+		c.Flags().StringVar(&class, "class", "", "Only this class")
+		c.Flags().IntVar(&top, "top", 10, "Competitors shown")
+	}
+
+	var bib, club string
+	push := &cobra.Command{
+		Use:     "push <control> <name> <time>",
+		Short:   "Record a competitor's time at a control, as a radio control would",
+		Example: "  score-displayctl splits push radio1 \"Anna Berg\" 12:34 --class D21 --bib 101",
+		Args:    cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apiPost("/api/splits", map[string]string{"control": args[0], "name": args[1], "time": args[2], "class": class, "bib": bib, "club": club}, nil)
+		},
+	}
+	push.Flags().StringVar(&class, "class", "", "Competitor's class")
+	push.Flags().StringVar(&bib, "bib", "", "Competitor's bib, which identifies them")
+	push.Flags().StringVar(&club, "club", "", "Competitor's club")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the controls with passings",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var controls []struct {
+					Control  string   `json:"control"`
+					Classes  []string `json:"classes"`
+					Passings int      `json:"passings"`
+				}
+				if err := apiGet("/api/splits", &controls); err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "CONTROL\tPASSINGS\tCLASSES")
+				for _, c := range controls {
+					fmt.Fprintf(tw, "%s\t%d\t%s\n", c.Control, c.Passings, strings.Join(c.Classes, ","))
+				}
+				return tw.Flush()
+			},
+		},
+		show,
+		view,
+		push,
+		&cobra.Command{
+			Use:   "clear [control]",
+			Short: "Forget the passings of a control, or of all controls",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				control := ""
+				if len(args) == 1 {
+					control = args[0]
+				}
+				return apiPost("/api/splits/clear", map[string]string{"control": control}, nil)
+			},
+		},
+	)
+	return cmd
+}
+
+// speakerCmd prints the speaker feed, one event per line, until interrupted.
+func speakerCmd() *cobra.Command {
+	var types, control, class string
+	var follow bool
+	cmd := &cobra.Command{
+		Use:     "speaker",
+		Short:   "Follow finishers, lead changes and timer milestones as they happen",
+		Example: "  score-displayctl speaker --types finisher,lead_change --class H21",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{}
+			for key, value := range map[string]string{"types": types, "control": control, "class": class} {
+				if value != "" {
+					q.Set(key, value)
+				}
+			}
+			if follow {
+				q.Set("room", room) // Timer milestones of this room only
+			}
+			resp, err := stream("/api/speaker?" + q.Encode())
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+				if !ok {
+					continue
+				}
+				var ev struct {
+					Type      string    `json:"type"`
+					Time      time.Time `json:"time"`
+					Control   string    `json:"control"`
+					Class     string    `json:"class"`
+					Name      string    `json:"name"`
+					Club      string    `json:"club"`
+					Result    string    `json:"result"`
+					Rank      int       `json:"rank"`
+					Behind    string    `json:"behind"`
+					Previous  string    `json:"previous"`
+					Room      string    `json:"room"`
+					Milestone string    `json:"milestone"`
+					Value     int       `json:"value"`
+				}
+				if json.Unmarshal([]byte(data), &ev) != nil {
+					continue
+				}
+				at, who, where := ev.Time.Local().Format("15:04:05"), ev.Name, strings.TrimSpace(ev.Control+" "+ev.Class)
+				if ev.Club != "" {
+					who += " (" + ev.Club + ")"
+				}
+				switch ev.Type {
+				case "timer":
+					fmt.Printf("%s timer %s %d %s\n", at, ev.Milestone, ev.Value, ev.Room)
+				case "lead_change":
+					before := ""
+					if ev.Previous != "" {
+						before = ", before " + ev.Previous
+					}
+					fmt.Printf("%s %s: %s leads in %s%s\n", at, where, who, ev.Result, before)
+				default:
+					fmt.Printf("%s %s %s: %d. %s %s %s\n", at, ev.Type, where, ev.Rank, who, ev.Result, ev.Behind)
+				}
+			}
+			return scanner.Err()
+		},
+	}
+	cmd.Flags().StringVar(&types, "types", "", "Only these events: passing, finisher, lead_change, timer")
+	cmd.Flags().StringVar(&control, "control", "", "Only this control")
+	cmd.Flags().StringVar(&class, "class", "", "Only this class")
+	cmd.Flags().BoolVar(&follow, "this-room", false, "Only timer milestones of --room")
+	return cmd
+}
+
+func resultsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "results",
+		Short: "List result files and choose the active one",
+	}
+
+	var recursive, long bool
+	var exts string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List available result files (newest first)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{"room": {room}, "details": {"1"}, "ext": {exts}}
+			if recursive {
+				q.Set("recursive", "1")
+			}
+			var files []resultFile
+			if err := apiGet("/api/files?"+q.Encode(), &files); err != nil {
+				return err
+			}
+			var active struct {
+				File string `json:"file"`
+			}
+			if err := apiGet("/api/result?room="+url.QueryEscape(room), &active); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, f := range files {
+				marker := " "
+				if f.Name == active.File {
+					marker = "*"
+				}
+				if long {
+					fmt.Fprintf(tw, "%s %s\t%d\t%s\n", marker, f.Name, f.Size, f.ModTime.Local().Format("2006-01-02 15:04:05"))
+				} else {
+					fmt.Fprintf(tw, "%s %s\n", marker, f.Name)
+				}
+			}
+			return tw.Flush()
+		},
+	}
+	list.Flags().BoolVarP(&recursive, "recursive", "r", false, "Include files in subfolders")
+	list.Flags().BoolVarP(&long, "long", "l", false, "Show size and modification time")
+	list.Flags().StringVar(&exts, "ext", "", "Only list these extensions, e.g. html,txt")
+
+	cmd.AddCommand(
+		list,
+		&cobra.Command{
+			Use:   "set <file>",
+			Short: "Show a result file on all displays (of the room)",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				file := args[0]
+				if room != "" && !strings.HasPrefix(file, room+"/") {
+					file = room + "/" + file // Names as listed without the room folder
+				}
+				if err := apiPost("/api/result", map[string]string{"file": file, "room": room}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Active result set to %s\n", file)
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+func clientsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clients",
+		Short: "Inspect and manage connected displays",
+	}
+
+	var byIP bool
+	ban := &cobra.Command{
+		Use:     "ban <id>",
+		Short:   "Disconnect a client and refuse its ID until the server restarts",
+		Example: "  score-displayctl clients ban 3f2a9c\n  score-displayctl clients ban 3f2a9c --ip",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value := ""
+			if byIP {
+				value = "ip"
+			}
+			if err := apiPost("/api/clients/command", map[string]string{
+				"target":  args[0],
+				"command": "ban",
+				"value":   value,
+			}, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Banned %s\n", args[0])
+			return nil
+		},
+	}
+	ban.Flags().BoolVar(&byIP, "ip", false, "Also refuse the client's IP address")
+
+	var passwordStdin, hidden bool
+	wifi := &cobra.Command{
+		Use:     "wifi <id> <network>",
+		Short:   "Give a client a Wi-Fi network to join now or when it comes in range (needs a controller token)",
+		Example: "  echo 'venue-password' | score-displayctl clients wifi 3f2a9c VenueWiFi --password-stdin",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			creds := protocol.WifiCredentials{SSID: args[1], Hidden: hidden}
+			if passwordStdin {
+				// Not an argument, so it stays out of the shell history
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("read password: %w", err)
+				}
+				creds.Password = strings.TrimRight(line, "\r\n")
+			}
+			value, err := json.Marshal(creds)
+			if err != nil {
+				return err
+			}
+			if err := apiPost("/api/clients/command", map[string]string{
+				"target":  args[0],
+				"command": "set_wifi",
+				"value":   string(value),
+			}, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Sent Wi-Fi network %s to %s\n", args[1], args[0])
+			return nil
+		},
+	}
+	wifi.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Read the network's password from standard input (without it: an open network)")
+	wifi.Flags().BoolVar(&hidden, "hidden", false, "The network does not broadcast its name")
+
+	cmd.AddCommand(
+		ban,
+		wifi,
+		&cobra.Command{
+			Use:   "list",
+			Short: "List connected clients",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var clients []clientInfo
+				if err := apiGet("/api/clients", &clients); err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ID\tNAME\tADDR\tROOM\tMODE\tTHEME\tZOOM\tROT\tLOAD\tTEMP\tRTT\tMISSED")
+				for _, c := range clients {
+					load, temp := "-", "-"
+					if c.Health != nil {
+						load = fmt.Sprintf("%.2f", c.Health.Load1)
+						if c.Health.CPUTempC > 0 {
+							temp = fmt.Sprintf("%.1f°C", c.Health.CPUTempC)
+						}
+					}
+					rtt, missed := "-", "-"
+					if c.Quality != nil {
+						rtt = fmt.Sprintf("%.1fms", c.Quality.LatencyMs)
+						missed = fmt.Sprintf("%d/%d", c.Quality.MissedPongs, c.Quality.Pings)
+						if c.Quality.Unanswered > 0 {
+							rtt = "no answer"
+						}
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d%%\t%d°\t%s\t%s\t%s\t%s\n", c.ID, c.Name, c.Addr, c.Room, c.DisplayMode, c.ThemeMode, c.Zoom, c.Rotation, load, temp, rtt, missed)
+				}
+				return tw.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "discovered",
+			Short: "List clients found on the network via mDNS, connected or not",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var clients []struct {
+					ID        string    `json:"id"`
+					Name      string    `json:"name"`
+					Version   string    `json:"version"`
+					Addr      string    `json:"addr"`
+					LastSeen  time.Time `json:"lastSeen"`
+					Connected bool      `json:"connected"`
+				}
+				if err := apiGet("/api/clients/discovered", &clients); err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ID\tNAME\tADDR\tVERSION\tSEEN\tCONNECTED")
+				for _, c := range clients {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%v\n", c.ID, c.Name, c.Addr, c.Version, c.LastSeen.Local().Format("15:04:05"), c.Connected)
+				}
+				return tw.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "rename <id> <name>",
+			Short: "Give a client a new display name",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "rename",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Renamed %s to %s\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "zoom <id> <percent>",
+			Short: "Set a client's zoom (50 to 300)",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "set_zoom",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Set zoom of %s to %s%%\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "rotate <id> <degrees>",
+			Short: "Rotate a client's screen clockwise (0, 90, 180 or 270), e.g. for portrait-mounted TVs",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "set_rotation",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Set rotation of %s to %s°\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "screen <id> on|off",
+			Short: "Switch a client's screen on or off (CEC or DPMS)",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "screen_power",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Switched screen of %s %s\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "brightness <id> <percent>",
+			Short: "Set a client's screen brightness, 0-100 (backlight or DDC/CI)",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "set_brightness",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Set brightness of %s to %s%%\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "volume <id> <percent>",
+			Short: "Set a client's audio volume, 0-100, e.g. to turn the buzzer down",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "set_volume",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Set volume of %s to %s%%\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "switch-server <id> <server-name>",
+			Short: "Move a client to another server (see 'servers'); it keeps that as its preferred server",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "switch_server",
+					"value":   args[1],
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Switched %s to server %s\n", args[0], args[1])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "identify <id>",
+			Short: "Show a client's name and address full-screen on its display for 10 seconds",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "identify",
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Identifying %s\n", args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "reload <id>",
+			Short: "Load a client's display page again, e.g. when its page is stuck",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "reload",
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Reloading %s\n", args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "clear-cache <id>",
+			Short: "Restart a client's browser with an empty profile, e.g. when it shows stale pages",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "clear_cache",
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Clearing the browser cache of %s\n", args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "kick <id>",
+			Short: "Disconnect a client, e.g. a stale duplicate; it may reconnect",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/command", map[string]string{
+					"target":  args[0],
+					"command": "kick",
+				}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Disconnected %s\n", args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "bans",
+			Short: "List banned client IDs and addresses",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var bans []struct {
+					ID     string    `json:"id"`
+					IP     string    `json:"ip"`
+					Name   string    `json:"name"`
+					Since  time.Time `json:"since"`
+					Reason string    `json:"reason"`
+				}
+				if err := apiGet("/api/clients/bans", &bans); err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ID/IP\tNAME\tSINCE\tBY")
+				for _, b := range bans {
+					target := b.ID
+					if b.IP != "" {
+						target = b.IP
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", target, b.Name, b.Since.Local().Format("15:04:05"), b.Reason)
+				}
+				return tw.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "unban <id-or-ip>",
+			Short: "Let a banned client ID or address connect again",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := apiPost("/api/clients/unban", map[string]string{"target": args[0]}, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Unbanned %s\n", args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "history <id>",
+			Short: "Show when a client connected and disconnected since the server started",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var history struct {
+					Name       string `json:"name"`
+					Connected  bool   `json:"connected"`
+					Connects   int    `json:"connects"`
+					Reconnects int    `json:"reconnects"`
+					Addresses  []struct {
+						IP    string    `json:"ip"`
+						Since time.Time `json:"since"`
+					} `json:"addresses"`
+					Connections []struct {
+						Addr           string     `json:"addr"`
+						Transport      string     `json:"transport"`
+						ConnectedAt    time.Time  `json:"connectedAt"`
+						DisconnectedAt *time.Time `json:"disconnectedAt"`
+						Seconds        float64    `json:"seconds"`
+					} `json:"connections"`
+					NetworkEvents []struct {
+						Time   time.Time `json:"time"`
+						Event  string    `json:"event"`
+						Detail string    `json:"detail"`
+					} `json:"networkEvents"`
+				}
+				if err := apiGet("/api/clients/"+url.PathEscape(args[0])+"/history", &history); err != nil {
+					return err
+				}
+				fmt.Printf("%s: %d connections (%d reconnects), connected: %v\n", history.Name, history.Connects, history.Reconnects, history.Connected)
+				for _, a := range history.Addresses {
+					fmt.Printf("  from %s since %s\n", a.IP, a.Since.Local().Format("15:04:05"))
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "CONNECTED\tDISCONNECTED\tLASTED\tADDR\tTRANSPORT")
+				for _, c := range history.Connections {
+					disconnected := "-"
+					if c.DisconnectedAt != nil {
+						disconnected = c.DisconnectedAt.Local().Format("15:04:05")
+					}
+					lasted := time.Duration(c.Seconds) * time.Second
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.ConnectedAt.Local().Format("15:04:05"), disconnected, lasted, c.Addr, c.Transport)
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+				if len(history.NetworkEvents) > 0 {
+					fmt.Println("Network events reported by the display:")
+				}
+				for _, e := range history.NetworkEvents {
+					fmt.Printf("  %s %s %s\n", e.Time.Local().Format("15:04:05"), e.Event, e.Detail)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "logs <id>",
+			Short: "Print the recent log of a client",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				data, err := apiGetText("/api/clients/" + url.PathEscape(args[0]) + "/logs")
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(data)
+				return err
+			},
+		},
+	)
+	return cmd
+}
+
+func auditCmd() *cobra.Command {
+	var limit int
+	var since time.Duration
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show who changed results, ran the timer or commanded displays",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{"limit": {fmt.Sprint(limit)}}
+			if since > 0 {
+				q.Set("since", time.Now().Add(-since).Format(time.RFC3339))
+			}
+			var entries []auditEntry
+			if err := apiGet("/api/audit?"+q.Encode(), &entries); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "TIME\tSOURCE\tACTOR\tADDR\tACTION\tTARGET\tVALUE")
+			for _, e := range entries {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Source, e.Actor, e.Addr, e.Action, e.Target, e.Value)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 50, "Number of entries to show")
+	cmd.Flags().DurationVar(&since, "since", 0, "Only show entries from this long ago, e.g. 2h")
+	return cmd
+}
+
+// download saves the response to GET path as output, by default under the
+// name the server gives it (fallback without one), and returns the file name
+// and size.
+func download(path, output, fallback string) (string, int64, error) {
+	req, err := newRequest(http.MethodGet, path, "", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	// No timeout: the results folder may take a while
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", 0, err
+	}
+	if output == "" {
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		output = filepath.Base(params["filename"])
+		if output == "." || output == "/" {
+			output = fallback
+		}
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return output, n, err
+}
+
+func archiveCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Download a ZIP of all results, the audit log and the history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, n, err := download("/api/archive", output, "archive.zip")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Archive written to %s (%d bytes)\n", name, n)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write (default the server's name for it)")
+	return cmd
+}
+
+func backupCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Download the config, scenes, sports, bans and displays to restore on a spare server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, n, err := download("/api/backup", output, "backup.json")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Backup written to %s (%d bytes)\n", name, n)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write (default the server's name for it)")
+	return cmd
+}
+
+func restoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <backup.json>",
+		Short: "Restore a backup made with the backup command on this server",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			if !json.Valid(data) {
+				return fmt.Errorf("%s is not a backup", args[0])
+			}
+			var report struct {
+				Config   bool     `json:"config"`
+				Scenes   int      `json:"scenes"`
+				Sports   int      `json:"sports"`
+				Bans     int      `json:"bans"`
+				Displays int      `json:"displays"`
+				Results  int      `json:"results"`
+				Problems []string `json:"problems"`
+			}
+			if err := apiPost("/api/restore", json.RawMessage(data), &report); err != nil {
+				return err
+			}
+			fmt.Printf("Restored: config %v, %d scenes, %d sports, %d bans, %d displays, %d room results\n",
+				report.Config, report.Scenes, report.Sports, report.Bans, report.Displays, report.Results)
+			for _, p := range report.Problems {
+				fmt.Println("Not restored:", p)
+			}
+			return nil
+		},
+	}
+}
+
+func serversCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "servers",
+		Short: "List the display servers on the network, by serverName",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var servers []struct {
+				Name        string `json:"name"`
+				Version     string `json:"version"`
+				Competition string `json:"competition"`
+				Host        string `json:"host"`
+				Addr        string `json:"addr"`
+				Self        bool   `json:"self"`
+			}
+			if err := apiGet("/api/servers", &servers); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tCOMPETITION\tHOST\tADDR\tVERSION\tTHIS")
+			for _, s := range servers {
+				this := ""
+				if s.Self {
+					this = "*"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Competition, s.Host, s.Addr, s.Version, this)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func roomsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rooms",
+		Short: "List rooms with their active result, timer and number of displays",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var rooms []struct {
+				Name         string     `json:"name"`
+				ActiveResult string     `json:"activeResult"`
+				Timer        timerState `json:"timer"`
+				Clients      int        `json:"clients"`
+				Following    bool       `json:"following"`
+				FollowGlob   string     `json:"followPattern"`
+			}
+			if err := apiGet("/api/rooms", &rooms); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROOM\tDISPLAYS\tTIMER\tRESULT\tFOLLOW")
+			for _, r := range rooms {
+				name := r.Name
+				if name == "" {
+					name = "(default)"
+				}
+				timer := formatClock(r.Timer.TimeLeft)
+				if r.Timer.Running {
+					timer += " running"
+				}
+				follow := "-"
+				if r.Following {
+					follow = "newest " + r.FollowGlob
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", name, r.Clients, timer, r.ActiveResult, follow)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func operatorsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "operators",
+		Short: "List connected controllers and the areas they are editing",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var operators []struct {
+				Name  string    `json:"name"`
+				Addr  string    `json:"addr"`
+				Room  string    `json:"room"`
+				Since time.Time `json:"since"`
+				Lock  string    `json:"lock"`
+			}
+			if err := apiGet("/api/operators", &operators); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tADDR\tROOM\tSINCE\tEDITING")
+			for _, o := range operators {
+				room, lock := o.Room, o.Lock
+				if room == "" {
+					room = "(default)"
+				}
+				if lock == "" {
+					lock = "-"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.Name, o.Addr, room, o.Since.Local().Format("15:04:05"), lock)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+// contentSwitch is a result or display mode switch of GET /api/undo.
+type contentSwitch struct {
+	Kind   string    `json:"kind"`
+	Target string    `json:"target"`
+	Before string    `json:"before"`
+	After  string    `json:"after"`
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+}
+
+func (s contentSwitch) String() string {
+	what := "result"
+	if s.Kind == "display_mode" {
+		what = s.Target
+	}
+	return fmt.Sprintf("%s: %s -> %s", what, s.Before, s.After)
+}
+
+func undoCmd() *cobra.Command {
+	var list bool
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Take back the room's last result or display mode switch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				var state struct {
+					Undo []contentSwitch `json:"undo"`
+					Redo []contentSwitch `json:"redo"`
+				}
+				if err := apiGet("/api/undo?room="+url.QueryEscape(room), &state); err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "STACK\tTIME\tBY\tSWITCH")
+				for _, s := range state.Redo {
+					fmt.Fprintf(tw, "redo\t%s\t%s\t%s\n", s.At.Local().Format("15:04:05"), s.Actor, s)
+				}
+				for _, s := range state.Undo {
+					fmt.Fprintf(tw, "undo\t%s\t%s\t%s\n", s.At.Local().Format("15:04:05"), s.Actor, s)
+				}
+				return tw.Flush()
+			}
+			var sw contentSwitch
+			if err := apiPost("/api/undo", map[string]string{"room": room}, &sw); err != nil {
+				return err
+			}
+			fmt.Println("Undone", sw)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&list, "list", "l", false, "List the switches that can be undone and redone instead")
+	return cmd
+}
+
+func redoCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "redo",
+		Short: "Apply the room's last undone switch again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sw contentSwitch
+			if err := apiPost("/api/redo", map[string]string{"room": room}, &sw); err != nil {
+				return err
+			}
+			fmt.Println("Redone", sw)
+			return nil
+		},
+	}
+}
+
+func scenesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scenes",
+		Short: "Save the room's display states as named scenes and recall them",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the room's scenes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var scenes []struct {
+				Name     string                     `json:"name"`
+				Result   string                     `json:"result"`
+				Displays map[string]json.RawMessage `json:"displays"`
+				SavedAt  time.Time                  `json:"savedAt"`
+				SavedBy  string                     `json:"savedBy"`
+			}
+			if err := apiGet("/api/scenes?room="+url.QueryEscape(room), &scenes); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tDISPLAYS\tRESULT\tSAVED\tBY")
+			for _, sc := range scenes {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", sc.Name, len(sc.Displays), sc.Result, sc.SavedAt.Local().Format("2006-01-02 15:04"), sc.SavedBy)
+			}
+			return tw.Flush()
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "save <name>",
+		Short: "Save what the room's displays show now, replacing a scene of that name",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apiPost("/api/scenes/save", map[string]string{"name": args[0], "room": room}, nil)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "recall <name>",
+		Short: "Put the room's displays into a saved scene",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var recall struct {
+				Displays int      `json:"displays"`
+				Missing  []string `json:"missing"`
+			}
+			if err := apiPost("/api/scenes/recall", map[string]string{"name": args[0], "room": room}, &recall); err != nil {
+				return err
+			}
+			fmt.Printf("Scene %s recalled on %d displays\n", args[0], recall.Displays)
+			if len(recall.Missing) > 0 {
+				fmt.Println("Not connected:", strings.Join(recall.Missing, ", "))
+			}
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a scene",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apiPost("/api/scenes/delete", map[string]string{"name": args[0], "room": room}, nil)
+		},
+	})
+	return cmd
+}
+
+func logosCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logos",
+		Short: "Upload the club and country logos shown in rendered results",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the logos",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var logos []struct {
+				Code    string    `json:"code"`
+				Width   int       `json:"width"`
+				Height  int       `json:"height"`
+				Bytes   int       `json:"bytes"`
+				Updated time.Time `json:"updated"`
+			}
+			if err := apiGet("/api/logos", &logos); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CODE\tSIZE\tBYTES\tUPDATED")
+			for _, l := range logos {
+				fmt.Fprintf(tw, "%s\t%dx%d\t%d\t%s\n", l.Code, l.Width, l.Height, l.Bytes, l.Updated.Local().Format("2006-01-02 15:04"))
+			}
+			return tw.Flush()
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "upload <code> <image>",
+		Short: "Upload a PNG, JPEG or GIF as the logo of a code (e.g. ifk-goteborg, swe)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			resp, err := send(http.MethodPost, "/api/logos/"+url.PathEscape(args[0]), "application/octet-stream", f)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if err := checkResponse(resp); err != nil {
+				return err
+			}
+			var logo struct {
+				Width  int `json:"width"`
+				Height int `json:"height"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&logo); err != nil {
+				return err
+			}
+			fmt.Printf("Logo %s saved (%dx%d)\n", args[0], logo.Width, logo.Height)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <code>",
+		Short: "Delete a logo",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apiPost("/api/logos/"+url.PathEscape(args[0])+"/delete", nil, nil)
+		},
+	})
+	return cmd
+}
+
+func remoteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remote",
+		Short: "Show the state of the remote result sources",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sources []struct {
+				URL        string    `json:"url"`
+				File       string    `json:"file"`
+				LastCheck  time.Time `json:"lastCheck"`
+				LastChange time.Time `json:"lastChange"`
+				LastError  string    `json:"lastError"`
+			}
+			if err := apiGet("/api/remote", &sources); err != nil {
+				return err
+			}
+			when := func(t time.Time) string {
+				if t.IsZero() {
+					return "-"
+				}
+				return t.Local().Format("15:04:05")
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "FILE\tCHECKED\tCHANGED\tURL\tERROR")
+			for _, s := range sources {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.File, when(s.LastCheck), when(s.LastChange), s.URL, s.LastError)
+			}
+			return tw.Flush()
+		},
+	}
+}
@@ -0,0 +1,15 @@
+module display/ctl
+
+go 1.25.6
+
+require (
+	display/internal/protocol v0.0.0
+	github.com/spf13/cobra v1.10.2
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.9 // indirect
+)
+
+replace display/internal/protocol => ../internal/protocol
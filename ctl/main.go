@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serverURL string
+	apiToken  string
+	room      string // Room the timer, results, undo and scenes commands act on
+)
+
+// Long enough for /api/clients/{id}/logs, which waits for the display to upload.
+var httpClient = &http.Client{Timeout: 20 * time.Second}
+
+// send performs a request against the server, adding the controller token
+// if one is set.
+func send(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := newRequest(method, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
+// stream opens a GET that runs until the server or the user ends it (the
+// speaker feed), which httpClient's timeout would cut off.
+func stream(path string) (*http.Response, error) {
+	req, err := newRequest(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func newRequest(method, path, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(serverURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+	return req, nil
+}
+
+// apiGet fetches path from the server and decodes the JSON response into out.
+func apiGet(path string, out interface{}) error {
+	resp, err := send(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiGetText fetches path from the server and returns the raw response body.
+func apiGetText(path string) ([]byte, error) {
+	resp, err := send(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// apiPost sends body as JSON to path. If out is non-nil the response is decoded into it.
+func apiPost(path string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := send(http.MethodPost, path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:           "score-displayctl",
+		Short:         "Control a Display Server from the command line",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	defaultServer := os.Getenv("SCORE_DISPLAY_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	root.PersistentFlags().StringVarP(&serverURL, "server", "s", defaultServer, "Display Server base URL (env SCORE_DISPLAY_SERVER)")
+	root.PersistentFlags().StringVar(&apiToken, "token", os.Getenv("SCORE_DISPLAY_CONTROLLER_TOKEN"), "Controller token, if the server has one (env SCORE_DISPLAY_CONTROLLER_TOKEN)")
+
+	root.PersistentFlags().StringVar(&room, "room", os.Getenv("SCORE_DISPLAY_ROOM"), "Room for timer, results, undo and scenes commands, default the main room (env SCORE_DISPLAY_ROOM)")
+
+	root.AddCommand(timerCmd(), penaltyCmd(), scoreCmd(), splitsCmd(), speakerCmd(), resultsCmd(), clientsCmd(), roomsCmd(), operatorsCmd(), undoCmd(), redoCmd(), scenesCmd(), logosCmd(), serversCmd(), remoteCmd(), auditCmd(), archiveCmd(), backupCmd(), restoreCmd(), updateCmd())
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
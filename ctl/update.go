@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// updateCmd manages the signing key used for client auto-updates. The public
+// key goes into each client's client.json as "updatePublicKey"; clients only
+// auto-update with it and refuse builds whose client.sig does not verify.
+func updateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Create signing keys and sign client builds for auto-update",
+	}
+
+	var keyPath string
+	keygen := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an ed25519 signing key and print its public key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(keyPath); err == nil {
+				return fmt.Errorf("%s already exists", keyPath)
+			}
+			pub, priv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return err
+			}
+			encoded := base64.StdEncoding.EncodeToString(priv) + "\n"
+			if err := os.WriteFile(keyPath, []byte(encoded), 0600); err != nil {
+				return err
+			}
+			fmt.Printf("Private key written to %s (keep it secret)\n", keyPath)
+			fmt.Printf("updatePublicKey: %s\n", base64.StdEncoding.EncodeToString(pub))
+			return nil
+		},
+	}
+	keygen.Flags().StringVarP(&keyPath, "key", "k", "update.key", "Private key file to create")
+
+	var signKeyPath string
+	sign := &cobra.Command{
+		Use:   "sign <client-binary>",
+		Short: "Write client.sig next to a client build",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyData, err := os.ReadFile(signKeyPath)
+			if err != nil {
+				return err
+			}
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+			if err != nil || len(key) != ed25519.PrivateKeySize {
+				return errors.New("key file is not a base64 ed25519 private key")
+			}
+			binary, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			sig := ed25519.Sign(ed25519.PrivateKey(key), binary)
+			sigPath := filepath.Join(filepath.Dir(args[0]), "client.sig")
+			if err := os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Signature written to %s\n", sigPath)
+			return nil
+		},
+	}
+	sign.Flags().StringVarP(&signKeyPath, "key", "k", "update.key", "Private key file")
+
+	cmd.AddCommand(keygen, sign)
+	return cmd
+}
@@ -0,0 +1,291 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// EncodingCBOR is the binary encoding (RFC 8949) a client can ask for in its
+// handshake. Messages in BinaryTypes then come as binary frames with the
+// same structure as their JSON; everything else stays JSON text.
+const EncodingCBOR = "cbor"
+
+// BinaryTypes are the messages sent often enough that encoding them in
+// binary is worth it for small displays.
+var BinaryTypes = map[string]bool{"timer_update": true, "score_update": true}
+
+// maxCBORDepth bounds nesting when decoding, so a bad frame cannot exhaust
+// the stack.
+const maxCBORDepth = 64
+
+// JSONToCBOR encodes the JSON value data as CBOR. Integral numbers become
+// CBOR integers, all others float64.
+func JSONToCBOR(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCBOR(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				writeCBORHead(buf, 0, uint64(n))
+			} else {
+				writeCBORHead(buf, 1, uint64(-1-n))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeCBORHead(buf, 0, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xfb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeCBORHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		writeCBORHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		writeCBORHead(buf, 5, uint64(len(v)))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys) // Same message, same bytes
+		for _, k := range keys {
+			writeCBORHead(buf, 3, uint64(len(k)))
+			buf.WriteString(k)
+			if err := encodeCBOR(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot encode %T", v)
+	}
+	return nil
+}
+
+// writeCBORHead writes the initial byte of major type major with argument n
+// in the shortest form.
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// CBORToJSON decodes a CBOR message, as JSONToCBOR makes them, back to JSON.
+// Only what JSON can express is accepted: no byte strings, tags or
+// indefinite lengths, and map keys must be text.
+func CBORToJSON(data []byte) ([]byte, error) {
+	d := cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+var errCBORShort = errors.New("cbor: unexpected end of data")
+
+func (d *cborDecoder) decode(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: nested too deeply")
+	}
+	if d.pos >= len(d.data) {
+		return nil, errCBORShort
+	}
+	initial := d.data[d.pos]
+	major, info := initial>>5, initial&0x1f
+	if major == 7 {
+		d.pos++
+		return d.simple(info)
+	}
+	n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return n, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: integer out of range")
+		}
+		return -1 - int64(n), nil
+	case 3:
+		s, err := d.take(n)
+		return string(s), err
+	case 4:
+		if n > uint64(len(d.data)-d.pos) { // Each item takes a byte at least
+			return nil, errCBORShort
+		}
+		items := make([]any, 0, n)
+		for range n {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if n > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORShort
+		}
+		m := make(map[string]any, n)
+		for range n {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("cbor: map key is not text")
+			}
+			if m[key], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// head reads the initial byte and argument of the item at pos.
+func (d *cborDecoder) head() (uint64, error) {
+	info := d.data[d.pos] & 0x1f
+	d.pos++
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		b, err := d.take(1 << (info - 24))
+		if err != nil {
+			return 0, err
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, nil
+	}
+	return 0, errors.New("cbor: indefinite lengths are not supported")
+}
+
+// simple decodes major type 7: false, true, null and floats.
+func (d *cborDecoder) simple(info byte) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORShort
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// halfToFloat converts an IEEE 754 half-precision float, which other CBOR
+// encoders use for small floats.
+func halfToFloat(h uint16) float64 {
+	exp, frac := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(frac, -24)
+	case 0x1f:
+		f = math.Inf(1)
+		if frac != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(frac+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
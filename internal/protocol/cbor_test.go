@@ -0,0 +1,163 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // JSON after the round trip; "" = same as in
+	}{
+		{"zero", `0`, ""},
+		{"small", `23`, ""},
+		{"one byte", `24`, ""},
+		{"two bytes", `65535`, ""},
+		{"four bytes", `4294967296`, ""},
+		{"max int64", `9223372036854775807`, ""},
+		{"max uint64", `18446744073709551615`, ""},
+		{"minus one", `-1`, ""},
+		{"negative", `-1000`, ""},
+		{"min int64", `-9223372036854775808`, ""},
+		{"float", `1.5`, ""},
+		{"negative float", `-0.25`, ""},
+		{"exponent", `1e3`, `1000`},
+		{"large float", `1e300`, `1e+300`},
+		{"integral float", `2.0`, `2`},
+		{"beyond uint64", `18446744073709551616`, `18446744073709552000`},
+		{"string", `"hello"`, ""},
+		{"empty string", `""`, ""},
+		{"unicode", `"Åsa 🏁"`, ""},
+		{"literals", `[true,false,null]`, ""},
+		{"empty", `[[],{}]`, ""},
+		{"nested", `{"a":[1,{"b":[-2,"c",null]}],"d":{"e":{"f":1.25}}}`, ""},
+		{"map keys sorted", `{"b":1,"a":2}`, `{"a":2,"b":1}`},
+		{"long array", `[` + strings.Repeat(`0,`, 299) + `0]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := JSONToCBOR([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("JSONToCBOR: %v", err)
+			}
+			got, err := CBORToJSON(enc)
+			if err != nil {
+				t.Fatalf("CBORToJSON(%x): %v", enc, err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.in
+			}
+			if string(got) != want {
+				t.Errorf("round trip = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONToCBOREncoding(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // Hex
+	}{
+		{`10`, "0a"},
+		{`24`, "1818"},
+		{`256`, "190100"},
+		{`18446744073709551615`, "1bffffffffffffffff"},
+		{`-1`, "20"},
+		{`-9223372036854775808`, "3b7fffffffffffffff"},
+		{`1.5`, "fb3ff8000000000000"},
+		{`"a"`, "6161"},
+		{`{"b":1,"a":[]}`, "a2616180616201"},
+	}
+	for _, tt := range tests {
+		got, err := JSONToCBOR([]byte(tt.in))
+		if err != nil {
+			t.Errorf("JSONToCBOR(%s): %v", tt.in, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("JSONToCBOR(%s) = %x, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCBORToJSONOtherEncoders(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string // Hex
+		want string
+	}{
+		{"half float", "f93e00", `1.5`},
+		{"negative half float", "f9c400", `-4`},
+		{"single float", "fa3fc00000", `1.5`},
+		{"undefined", "f7", `null`},
+		{"long head for small value", "1b0000000000000001", `1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, _ := hex.DecodeString(tt.in)
+			got, err := CBORToJSON(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("CBORToJSON(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCBORToJSONMalformed(t *testing.T) {
+	nested := func(n int) []byte { // n arrays, each holding the next
+		return append(bytes.Repeat([]byte{0x81}, n-1), 0x80)
+	}
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"truncated head", []byte{0x19, 0x01}},
+		{"truncated uint64", []byte{0x1b, 0xff, 0xff}},
+		{"truncated string", []byte{0x65, 'a', 'b'}},
+		{"truncated array", []byte{0x83, 0x01, 0x02}},
+		{"truncated map", []byte{0xa1, 0x61, 'a'}},
+		{"truncated float", []byte{0xfb, 0x3f, 0xf8}},
+		{"oversized string", []byte{0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{"oversized array", []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"oversized map", []byte{0xba, 0xff, 0xff, 0xff, 0xff, 0x61, 'a', 0x01}},
+		{"negative out of range", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"trailing data", []byte{0x01, 0x02}},
+		{"byte string", []byte{0x41, 0x00}},
+		{"tag", []byte{0xc1, 0x01}},
+		{"indefinite array", []byte{0x9f, 0x01, 0xff}},
+		{"reserved head", []byte{0x1c}},
+		{"non-text key", []byte{0xa1, 0x01, 0x02}},
+		{"simple value", []byte{0xf0}},
+		{"too deep", nested(maxCBORDepth + 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := CBORToJSON(tt.in); err == nil {
+				t.Errorf("CBORToJSON(%x) = %s, want an error", tt.in, got)
+			}
+		})
+	}
+
+	// The deepest nesting allowed still decodes
+	if _, err := CBORToJSON(nested(maxCBORDepth + 1)); err != nil {
+		t.Errorf("nesting of %d: %v", maxCBORDepth+1, err)
+	}
+}
+
+func TestJSONToCBORMalformed(t *testing.T) {
+	for _, in := range []string{``, `{`, `[1,`, `"abc`, `nul`} {
+		if got, err := JSONToCBOR([]byte(in)); err == nil {
+			t.Errorf("JSONToCBOR(%q) = %x, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module display/internal/protocol
+
+go 1.25.6
@@ -0,0 +1,274 @@
+// Package protocol defines the messages the server, the display client and
+// score-displayctl exchange, so they are declared once. The Tizen app and the
+// admin UI speak the same JSON; keep them in step when changing it.
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Version is bumped whenever the WebSocket message format changes. Clients
+// report theirs in the handshake; servers still serve ones from MinVersion.
+const (
+	Version    = 4
+	MinVersion = 1
+	// StateSyncVersion added state_sync; older clients get the state as
+	// separate messages
+	StateSyncVersion = 2
+	// TimeSyncVersion added time_sync and TimerState.EndsAt, so clients
+	// count a running timer down themselves
+	TimeSyncVersion = 3
+	// ResultPatchVersion added result_patch and SetResult.ETag; older
+	// clients reload an updated result instead
+	ResultPatchVersion = 4
+)
+
+// Message is a received message; its payload is decoded once its type is
+// known.
+type Message struct {
+	Type    string          `json:"type"`              // e.g. "timer_update", "handshake"
+	Payload json.RawMessage `json:"payload,omitempty"` // Depends on Type
+	MsgID   string          `json:"msgId,omitempty"`   // Set by senders that want an ack
+	ReplyTo string          `json:"replyTo,omitempty"` // On acks: the msgId being acknowledged
+}
+
+// Envelope is a message to send, with the payload as a value to marshal.
+type Envelope struct {
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+	MsgID   string `json:"msgId,omitempty"`
+}
+
+// Handshake is the first message of a connection, and is sent again when
+// the client's settings change.
+type Handshake struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"` // Persistent client ID
+	Theme string `json:"theme,omitempty"`
+	Zoom  int    `json:"zoom,omitempty"`
+	// Added with remote rotation; omitted = not rotated
+	Rotation int `json:"rotation,omitempty"`
+	// Added in protocol 1; older clients omit them
+	Protocol int    `json:"protocol,omitempty"`
+	Version  string `json:"version,omitempty"`
+	// Added with roles; controllers present the token if the server has one
+	Role  string `json:"role,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Added with rooms; omitted = the default room
+	Room string `json:"room,omitempty"`
+	// Encodings the client can read besides JSON, preferred first, e.g.
+	// EncodingCBOR; omitted = JSON only
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+// HandshakeAck answers a handshake with the server's versions and the role
+// the connection was granted.
+type HandshakeAck struct {
+	Protocol   int    `json:"protocol"`
+	Version    string `json:"version"`
+	Compatible bool   `json:"compatible"`
+	Warning    string `json:"warning,omitempty"`
+	Role       string `json:"role"`
+	Encoding   string `json:"encoding,omitempty"` // Chosen from Handshake.Encodings; omitted = JSON
+}
+
+// Health is the system state a display reports in heartbeat messages.
+type Health struct {
+	Load1       float64 `json:"load1"`              // 1-minute load average
+	MemUsedPct  float64 `json:"memUsedPct"`         // Memory in use, percent
+	DiskUsedPct float64 `json:"diskUsedPct"`        // Disk holding the client binary, percent
+	CPUTempC    float64 `json:"cpuTempC,omitempty"` // SoC temperature (Raspberry Pi), 0 if unknown
+	UptimeSec   int64   `json:"uptimeSec"`          // System uptime
+}
+
+// WifiCredentials is the value of a set_wifi client_command (as JSON) and
+// the payload the display gets: a network it saves and joins when in range.
+type WifiCredentials struct {
+	SSID     string `json:"ssid"`
+	Password string `json:"password,omitempty"` // WPA passphrase (8-63 characters) or 64 hex digits; empty for an open network
+	Hidden   bool   `json:"hidden,omitempty"`   // The network does not broadcast its name
+}
+
+// NetworkEvent is something a display's network watchdog saw or did. The
+// display keeps them while the server is out of reach and sends them as
+// network_events ([]NetworkEvent) once it is connected again.
+type NetworkEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`            // link_lost, link_restored, recovery, recovery_failed, wifi_joined, wifi_saved or wifi_failed
+	Detail string    `json:"detail,omitempty"` // How long the link was down, why it recovered, the error
+}
+
+// ClientHealth is a display's latest Health as the server keeps it.
+type ClientHealth struct {
+	Health
+	ReceivedAt time.Time `json:"receivedAt"` // Set by the server
+}
+
+// ConnQuality is how well the server's pings to a client are answered.
+type ConnQuality struct {
+	LatencyMs     float64 `json:"latencyMs"`     // Average round trip of the last pongs
+	LastLatencyMs float64 `json:"lastLatencyMs"` // Latest round trip
+	MaxLatencyMs  float64 `json:"maxLatencyMs"`  // Slowest of the last pongs
+	Pings         int     `json:"pings"`
+	MissedPongs   int     `json:"missedPongs"`    // Pings not answered before the next one, since connecting
+	Unanswered    int     `json:"unanswered"`     // Pings in a row without a pong right now
+	Lite          bool    `json:"lite,omitempty"` // Poor enough that the display gets reduced content (Delivery)
+}
+
+// Delivery is the payload of delivery: how a display should load results
+// over its current connection. With Lite it asks for ?lite=1 variants (no
+// images, simple styles) and reloads a changing result at most every
+// RefreshSeconds; timer and score updates are unaffected. Profile is the
+// display's name formatting profile, which it asks results for with
+// ?profile=.
+type Delivery struct {
+	Lite           bool   `json:"lite"`
+	RefreshSeconds int    `json:"refreshSeconds,omitempty"`
+	Profile        string `json:"profile,omitempty"`
+}
+
+// Identify is the payload of identify: the display shows its name and
+// address full-screen for Seconds, so someone in the hall can tell which
+// entry of the admin list it is.
+type Identify struct {
+	Name    string `json:"name"`
+	Addr    string `json:"addr"` // IP the server sees the display connect from
+	Seconds int    `json:"seconds"`
+}
+
+// PowerSchedule is a room's daily screen schedule, the payload of
+// power_schedule; the zero value is none. Displays save it and follow it
+// with their own clock, so screens still switch while the server is down.
+// Times are "HH:MM".
+type PowerSchedule struct {
+	On         string      `json:"on,omitempty"`         // Screen on; set together with Off
+	Off        string      `json:"off,omitempty"`        // Screen off; may be before On for daytime-off
+	Brightness int         `json:"brightness,omitempty"` // Percent outside Dim periods; 0 = 100
+	Dim        []DimPeriod `json:"dim,omitempty"`
+}
+
+// DimPeriod lowers the brightness from From to To, e.g. during breaks.
+type DimPeriod struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Brightness int    `json:"brightness"` // Percent
+}
+
+// ClientInfo is the per-client entry of client_list messages and
+// GET /api/clients.
+type ClientInfo struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Addr        string        `json:"addr"`
+	DisplayMode string        `json:"display_mode"`
+	ThemeMode   string        `json:"theme_mode"`
+	Zoom        int           `json:"zoom"`
+	Rotation    int           `json:"rotation"`
+	ScreenPower string        `json:"screen_power,omitempty"`
+	Brightness  *int          `json:"brightness,omitempty"` // Last set_brightness, percent; omitted = none sent
+	Volume      *int          `json:"volume,omitempty"`     // Last set_volume, percent; omitted = none sent
+	Version     string        `json:"version,omitempty"`
+	Role        string        `json:"role"`
+	Room        string        `json:"room"`
+	Protocol    int           `json:"protocol"`
+	Transport   string        `json:"transport"`          // "ws", or "sse" for the fallback
+	Encoding    string        `json:"encoding,omitempty"` // EncodingCBOR when frequent messages go in binary
+	Warning     string        `json:"warning,omitempty"`  // Set when the client's protocol does not match the server's
+	Health      *ClientHealth `json:"health,omitempty"`
+	Quality     *ConnQuality  `json:"quality,omitempty"` // Ping round trips; also refreshed by heartbeats
+}
+
+// TimeSync is the server's clock, sent on joining a room and periodically.
+type TimeSync struct {
+	ServerTime int64 `json:"serverTime"` // Unix milliseconds
+}
+
+// TimerState is the payload of timer_update and the timer of state_sync.
+type TimerState struct {
+	Running   bool  `json:"running"`
+	TimeLeft  int   `json:"timeLeft"`
+	TotalTime int   `json:"totalTime"`
+	EndsAt    int64 `json:"endsAt,omitempty"` // Server time (Unix ms) a running timer reaches zero, see TimeSync
+	// Run with the clock; shared by copies of the state, so it is replaced
+	// rather than changed
+	Penalties []Penalty `json:"penalties,omitempty"`
+	// With periods: the period (from 1) the clock is set for, and whether
+	// it counts down the intermission after it
+	Period  int  `json:"period,omitempty"`
+	Periods int  `json:"periods,omitempty"`
+	Break   bool `json:"break,omitempty"`
+	// From the room's sport profile: "up" shows the time played instead of
+	// the time left, "none" hides the clock
+	Clock string `json:"clock,omitempty"`
+}
+
+// Penalty is a running penalty of a team or one of its players.
+type Penalty struct {
+	ID       int    `json:"id"`
+	Team     string `json:"team"` // "home" or "away"
+	Player   string `json:"player,omitempty"`
+	Duration int    `json:"duration"` // Seconds
+	TimeLeft int    `json:"timeLeft"`
+}
+
+// TimerControl is the payload of timer_control.
+type TimerControl struct {
+	Action  string `json:"action"` // "start", "pause", "reset", "next_period" or "new_match"
+	Seconds int    `json:"seconds"`
+}
+
+// SetResult is the payload of set_result, from controllers and to displays.
+type SetResult struct {
+	File string `json:"file"`
+	ETag string `json:"etag,omitempty"` // Version of the file, with resultDiff on
+}
+
+// ResultPatch is the payload of result_patch: how the rows of File changed
+// from version From to ETag, sent instead of set_result when an HTML result
+// is updated in place, so a display moves and updates rows instead of
+// reloading the page. A page showing another version reloads File.
+type ResultPatch struct {
+	File       string       `json:"file"`
+	From       string       `json:"from"`
+	ETag       string       `json:"etag"`
+	TableCount int          `json:"tableCount"` // Tables in the page, to check it is the one patched
+	Tables     []TablePatch `json:"tables"`     // Only the tables that changed
+}
+
+// TablePatch changes the rows of one table of a result page. Old rows that
+// no op moves or deletes keep their order and fill the positions no op
+// moves or inserts a row to.
+type TablePatch struct {
+	Table    int     `json:"table"`    // Index among the page's tables
+	FromRows int     `json:"fromRows"` // Rows before
+	Rows     int     `json:"rows"`     // Rows after
+	Ops      []RowOp `json:"ops"`
+}
+
+// Row operations of a TablePatch.
+const (
+	RowInsert = "insert" // New row HTML at Row
+	RowUpdate = "update" // The row that ends up at Row gets HTML
+	RowMove   = "move"   // Old row From goes to Row
+	RowDelete = "delete" // Old row From goes away
+)
+
+// RowOp is one change to a table's rows. From counts rows before the patch,
+// Row after it. Key, the row's name as the results parser found it, lets
+// the page check that From is the row meant; "" when rows have no key.
+type RowOp struct {
+	Op   string `json:"op"`
+	Row  int    `json:"row"`
+	From int    `json:"from"`
+	Key  string `json:"key,omitempty"`
+	HTML string `json:"html,omitempty"`
+}
+
+// ClientCommand is the payload of client_command: Command for the display
+// Target, e.g. "rename" with the new name as Value.
+type ClientCommand struct {
+	Target  string `json:"target"`
+	Command string `json:"command"`
+	Value   string `json:"value"`
+}
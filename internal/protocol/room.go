@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+)
+
+// MaxRoomNameLen bounds the room name in handshakes and API calls.
+const MaxRoomNameLen = 64
+
+// ValidateRoomName accepts "" (the default room) or a plain folder name. The
+// server checks rooms with it, and the client's setup page before saving one.
+func ValidateRoomName(name string) error {
+	switch {
+	case name == "":
+		return nil
+	case len(name) > MaxRoomNameLen:
+		return errors.New("room name too long")
+	case strings.ContainsAny(name, `/\`) || name == "." || name == ".." || strings.HasPrefix(name, "."):
+		return errors.New("room must be a folder name")
+	case strings.ContainsFunc(name, func(r rune) bool { return r < ' ' }):
+		return errors.New("room name contains invalid characters")
+	}
+	return nil
+}
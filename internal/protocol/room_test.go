@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRoomName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"", true},
+		{"hall2", true},
+		{"Hall 2 – Ice", true},
+		{strings.Repeat("a", MaxRoomNameLen), true},
+		{strings.Repeat("a", MaxRoomNameLen+1), false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"../x", false},
+		{"tab\there", false},
+		{"new\nline", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateRoomName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("ValidateRoomName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// maxPendingAcks bounds how many outgoing msgIds are remembered. Displays ack
+// within a second or two, so older entries are simply forgotten.
+const maxPendingAcks = 256
+
+// ackRoute says who is waiting for the acks of a message the hub sent.
+type ackRoute struct {
+	origin  *Client // Connection that made the request (usually the admin UI)
+	replyTo string  // The msgId the origin used
+}
+
+// ackTracker maps the msgIds the hub puts on outgoing messages to the request
+// that caused them, so a display's {"type":"ack","replyTo":...} can be relayed
+// to whoever asked.
+type ackTracker struct {
+	mu     sync.Mutex
+	next   uint64
+	routes map[string]ackRoute
+	order  []string // Oldest first, for evicting beyond maxPendingAcks
+}
+
+// track allocates a msgId for a message sent on behalf of origin's request
+// replyTo. It returns "" when the request carried no msgId, so messages
+// nobody is waiting for stay unchanged.
+func (t *ackTracker) track(origin *Client, replyTo string) string {
+	if origin == nil || replyTo == "" {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.routes == nil {
+		t.routes = make(map[string]ackRoute)
+	}
+	t.next++
+	msgID := "s" + strconv.FormatUint(t.next, 10)
+	t.routes[msgID] = ackRoute{origin: origin, replyTo: replyTo}
+	t.order = append(t.order, msgID)
+	if len(t.order) > maxPendingAcks {
+		delete(t.routes, t.order[0])
+		t.order = t.order[1:]
+	}
+	return msgID
+}
+
+func (t *ackTracker) route(msgID string) (ackRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[msgID]
+	return r, ok
+}
+
+// relayAck forwards a display's ack of msgID to the connection that asked for
+// the message, as {"type":"ack","replyTo":<its msgId>,"payload":{"id","name"}}.
+func (h *Hub) relayAck(from *Client, msgID string) {
+	r, ok := h.acks.route(msgID)
+	if !ok {
+		return // Untracked or already evicted
+	}
+	h.mu.Lock()
+	id, name := from.ID, from.Name
+	h.mu.Unlock()
+
+	data, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		ReplyTo string `json:"replyTo"`
+		Payload struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"payload"`
+	}{
+		Type:    "ack",
+		ReplyTo: r.replyTo,
+		Payload: struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}{ID: id, Name: name},
+	})
+	if err != nil {
+		slog.Error("Error marshaling ack message", "err", err)
+		return
+	}
+	h.sendDirect(r.origin, data)
+}
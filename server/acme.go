@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEOptions turn on HTTPS with certificates from Let's Encrypt, obtained
+// and renewed automatically, for servers reachable from the internet under a
+// domain name. The port setting then serves HTTPS and WSS (usually 443), and
+// HTTPPort answers Let's Encrypt's challenges and sends browsers from the
+// internet to HTTPS. Displays, which find the server by IP address and could
+// not check a certificate for the domain, and other local network addresses
+// keep being served plain HTTP on HTTPPort; it is the port announced to them.
+type ACMEOptions struct {
+	// Host names to get certificates for; empty = no HTTPS
+	Domains []string `json:"domains" yaml:"domains" toml:"domains"`
+	// Where Let's Encrypt can warn about certificates that are not renewed (optional)
+	Email string `json:"email" yaml:"email" toml:"email"`
+	// Where certificates and the account key are kept (default ./certs)
+	CacheDir string `json:"cacheDir" yaml:"cacheDir" toml:"cacheDir"`
+	// Port for challenges, redirects and the local network (0 = default, 80)
+	HTTPPort int `json:"httpPort" yaml:"httpPort" toml:"httpPort"`
+}
+
+const (
+	defaultACMECacheDir = "./certs"
+	defaultACMEHTTPPort = 80
+)
+
+func (o ACMEOptions) enabled() bool {
+	return len(o.Domains) > 0
+}
+
+func (o ACMEOptions) validate() error {
+	for _, d := range o.Domains {
+		if d == "" || strings.ContainsAny(d, "*/: ") || net.ParseIP(d) != nil || !strings.Contains(d, ".") {
+			return fmt.Errorf("domains: %q is not a host name like \"display.club.org\"", d)
+		}
+	}
+	if o.Email != "" && !strings.Contains(o.Email, "@") {
+		return fmt.Errorf("email: %q is not an e-mail address", o.Email)
+	}
+	if o.HTTPPort < 0 || o.HTTPPort > 65535 {
+		return fmt.Errorf("httpPort: %d is outside 1-65535", o.HTTPPort)
+	}
+	if !o.enabled() && (o.Email != "" || o.CacheDir != "" || o.HTTPPort != 0) {
+		return errors.New("domains must be set to use HTTPS")
+	}
+	return nil
+}
+
+func (o ACMEOptions) httpPort() int {
+	if o.HTTPPort == 0 {
+		return defaultACMEHTTPPort
+	}
+	return o.HTTPPort
+}
+
+// newCertManager returns the autocert manager of o, which only asks for
+// certificates of the configured domains.
+func newCertManager(o ACMEOptions) *autocert.Manager {
+	dir := o.CacheDir
+	if dir == "" {
+		dir = defaultACMECacheDir
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(o.Domains...),
+		Cache:      autocert.DirCache(dir),
+		Email:      o.Email,
+	}
+}
+
+// acmeHTTPHandler is the plain HTTP side of an HTTPS server: Let's Encrypt's
+// challenges, app for requests from the local network and a redirect to
+// httpsPort for everyone else.
+func acmeHTTPHandler(m *autocert.Manager, httpsPort int, app http.Handler) http.Handler {
+	return m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(splitHostPortSafe(r.RemoteAddr)); ip != nil && isPrivateIP(ip) {
+			app.ServeHTTP(w, r)
+			return
+		}
+		host := splitHostPortSafe(r.Host)
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+}
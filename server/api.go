@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// registerControlAPI exposes the WebSocket control actions as plain HTTP
+// endpoints so the server can be scripted (see score-displayctl). Like
+// controller connections, POSTs need the controller token if one is set. All
+// of them act on the default room unless a "room" is given.
+func registerControlAPI(hub *Hub) {
+	// POST /api/timer {"action": "start"|"pause"|"reset"|"next_period"|"new_match", "seconds": 900, "room": ""}
+	http.HandleFunc("/api/timer", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			Action  string `json:"action"`
+			Seconds int    `json:"seconds"`
+			Room    string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validateTimerControl(payload.Action, payload.Seconds); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkRoom(payload.Room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		timerMgr := hub.Room(payload.Room).Timer
+		switch payload.Action {
+		case "start":
+			timerMgr.Start()
+		case "pause":
+			timerMgr.Pause()
+		case "reset":
+			timerMgr.Reset(payload.Seconds)
+		case "next_period", "new_match":
+			step := timerMgr.NextPeriod
+			if payload.Action == "new_match" {
+				step = timerMgr.NewMatch
+			}
+			if err := step(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+		value := ""
+		if payload.Action == "reset" {
+			value = strconv.Itoa(payload.Seconds)
+		}
+		hub.Audit.Record(apiAudit(r, payload.Room, "timer_"+payload.Action, "", value))
+
+		timerMgr.mu.Lock()
+		state := timerMgr.State
+		timerMgr.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+
+	// POST /api/penalty {"action": "add"|"remove"|"clear", "team": "home", "player": "12", "seconds": 120, "id": 1, "room": ""}
+	http.HandleFunc("/api/penalty", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			penaltyControl
+			Room string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validatePenaltyControl(payload.penaltyControl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkRoom(payload.Room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		timerMgr := hub.Room(payload.Room).Timer
+		target, value, err := timerMgr.ApplyPenalty(payload.penaltyControl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		hub.Audit.Record(apiAudit(r, payload.Room, "penalty_"+payload.Action, target, value))
+
+		timerMgr.mu.Lock()
+		state := timerMgr.State
+		timerMgr.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+
+	// POST /api/score {"action": "point"|"undo"|"serve"|"teams"|"sport"|"reset", "team": "home", "sport": "volleyball", "home": "", "away": "", "room": ""}, GET /api/score?room=
+	http.HandleFunc("/api/score", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			room := r.URL.Query().Get("room")
+			if err := hub.checkRoom(room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(hub.Room(room).Score.Snapshot())
+			return
+		}
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			scoreControl
+			Room string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validateScoreControl(payload.scoreControl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkRoom(payload.Room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		scoreMgr := hub.Room(payload.Room).Score
+		target, value, err := scoreMgr.Apply(payload.scoreControl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		hub.Audit.Record(apiAudit(r, payload.Room, "score_"+payload.Action, target, value))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scoreMgr.Snapshot())
+	})
+
+	// GET /api/sports: the sport profiles a room can play
+	http.HandleFunc("GET /api/sports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.SportProfiles())
+	})
+
+	// POST /api/sports/reload: read the sport profiles again after editing sportsDir
+	http.HandleFunc("/api/sports/reload", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		hub.ReloadSports()
+		hub.Audit.Record(apiAudit(r, "", "sports_reload", "", ""))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.SportProfiles())
+	})
+
+	// POST /api/splits {"control": "radio1", "class": "H21", "bib": "101", "name": "", "club": "", "time": "12:34"} or an
+	// array of them, from a radio control or timing system; GET /api/splits lists the controls, GET
+	// /api/splits?control=radio1&class=H21&top=10 ranks one
+	http.HandleFunc("/api/splits", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			w.Header().Set("Content-Type", "application/json")
+			if q.Get("control") == "" {
+				json.NewEncoder(w).Encode(hub.Splits.Controls())
+				return
+			}
+			view := SplitView{Control: q.Get("control"), Class: q.Get("class")}
+			view.Top, _ = strconv.Atoi(q.Get("top"))
+			view.Top = min(max(view.Top, 0), maxSplitsTop)
+			json.NewEncoder(w).Encode(hub.Splits.Standings(view))
+			return
+		}
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		var passings []Passing
+		var err error
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			err = json.Unmarshal(body, &passings)
+		} else {
+			passings = make([]Passing, 1)
+			err = json.Unmarshal(body, &passings[0])
+		}
+		if err != nil {
+			http.Error(w, "Invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(passings) > maxSplitPush {
+			http.Error(w, "too many passings; send at most "+strconv.Itoa(maxSplitPush)+" at a time", http.StatusRequestEntityTooLarge)
+			return
+		}
+		for i, p := range passings {
+			if err := validatePassing(p); err != nil {
+				http.Error(w, "passing "+strconv.Itoa(i+1)+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := hub.AddPassings(passings); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// POST /api/splits/clear {"control": "radio1"}; "" clears every control
+	http.HandleFunc("/api/splits/clear", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			Control string `json:"control"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		hub.ClearSplits(payload.Control)
+		hub.Audit.Record(apiAudit(r, "", "splits_clear", payload.Control, ""))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// POST /api/splits/view {"control": "radio1", "class": "", "top": 10, "room": ""} shows a control on the room's
+	// displays in show_splits mode ("control": "" shows none), GET /api/splits/view?room=
+	http.HandleFunc("/api/splits/view", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			room := r.URL.Query().Get("room")
+			if err := hub.checkRoom(room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(hub.Splits.Standings(hub.SplitView(room)))
+			return
+		}
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			SplitView
+			Room string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validateSplitView(payload.SplitView); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkRoom(payload.Room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		state := hub.SetSplitView(payload.Room, payload.SplitView)
+		hub.Audit.Record(apiAudit(r, payload.Room, "splits_view", payload.Control, payload.Class))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+
+	// POST /api/result {"file": "results.html", "room": ""}, GET /api/result?room=
+	http.HandleFunc("/api/result", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			room := r.URL.Query().Get("room")
+			if err := hub.checkRoom(room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			active := hub.ActiveResult(room)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				File string `json:"file"`
+			}{File: active})
+			return
+		}
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			File string `json:"file"`
+			Room string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validateResultFile(payload.File); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkRoom(payload.Room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if !roomFile(payload.Room, payload.File) {
+			http.Error(w, "file must be in the room's folder", http.StatusBadRequest)
+			return
+		}
+		hub.SetActiveResult(payload.Room, payload.File, nil, "")
+		hub.Audit.Record(apiAudit(r, payload.Room, "set_result", "", payload.File))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// GET /api/clients
+	http.HandleFunc("/api/clients", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		list := hub.ClientList()
+		if list == nil {
+			list = []ClientInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	// GET /api/rooms
+	http.HandleFunc("GET /api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Rooms())
+	})
+
+	// POST /api/clients/command {"target": "<id>", "command": "rename", "value": "Lobby"}
+	http.HandleFunc("/api/clients/command", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			Target  string `json:"target"`
+			Command string `json:"command"`
+			Value   string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := validateClientCommand(payload.Target, payload.Command, payload.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := hub.checkCommandAllowed(payload.Command); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if !hub.ClientCommand(payload.Target, payload.Command, payload.Value, nil, "") {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		hub.Audit.Record(apiAudit(r, "", payload.Command, payload.Target, auditValue(payload.Command, payload.Value)))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// requirePost rejects anything but POST and cross-origin browser requests.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The end-of-day archive (GET /api/archive) is one ZIP with everything an
+// organizer keeps or publishes after an event:
+//
+//	results/...     every result file, aliases under their name
+//	audit.jsonl     the audit log
+//	history.db      a copy of the history database, with historyDB
+//	scenes.json     the saved scenes
+//	rooms.json      what each room showed when the archive was made
+//
+// It is streamed as it is written, so a large results folder does not have
+// to fit in memory or on disk twice.
+
+var errNoHistory = errors.New("history is not enabled")
+
+// Snapshot writes a consistent copy of the database to path (VACUUM INTO),
+// which must not exist yet. It waits behind queued queries, not writes.
+func (h *History) Snapshot(path string) error {
+	if h == nil {
+		return errNoHistory
+	}
+	_, err := h.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// archiveName is the download name: the competition (or "results") and the
+// local time, e.g. "club-cup-2026-10-16-1830.zip".
+func archiveName(competition string, now time.Time) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, competition)
+	name = strings.Trim(name, "-")
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	if name == "" {
+		name = "results"
+	}
+	return name + "-" + now.Format("2006-01-02-1504") + ".zip"
+}
+
+// addArchiveFile copies the file at src into zw as name, keeping its
+// modification time. A file that vanished since it was listed is skipped.
+func addArchiveFile(zw *zip.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// addArchiveJSON writes v into zw as name.
+func addArchiveJSON(zw *zip.Writer, name string, v any, now time.Time) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeArchive streams the archive into w. historyCopy is the snapshot of
+// the history database, "" without one.
+func writeArchive(w io.Writer, hub *Hub, settings Settings, auditPath, historyCopy string, now time.Time) error {
+	zw := zip.NewWriter(w)
+	files, err := listRoomResults(settings, defaultRoom, listOptions{Recursive: true, All: true})
+	if err != nil {
+		return fmt.Errorf("list results: %w", err)
+	}
+	for _, f := range files {
+		abs, ok := resolveResultPath(settings.ResultsDir, settings.ResultsAliases, f.Name)
+		if !ok {
+			continue
+		}
+		if err := addArchiveFile(zw, "results/"+f.Name, abs); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if auditPath != "" {
+		if err := addArchiveFile(zw, "audit.jsonl", auditPath); err != nil {
+			return fmt.Errorf("audit log: %w", err)
+		}
+	}
+	if historyCopy != "" {
+		if err := addArchiveFile(zw, "history.db", historyCopy); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+	hub.Scenes.mu.Lock()
+	scenes := append([]Scene{}, hub.Scenes.scenes...)
+	hub.Scenes.mu.Unlock()
+	if err := addArchiveJSON(zw, "scenes.json", scenes, now); err != nil {
+		return err
+	}
+	if err := addArchiveJSON(zw, "rooms.json", hub.Rooms(), now); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// registerArchiveAPI serves GET /api/archive (controller token), the ZIP
+// described above.
+func registerArchiveAPI(hub *Hub, cfgMgr *ConfigManager, auditPath string) {
+	http.HandleFunc("GET /api/archive", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		settings := cfgMgr.Current()
+		now := time.Now()
+
+		// Snapshot the database first, so a failure can still be reported
+		historyCopy := ""
+		if hub.History != nil {
+			dir, err := os.MkdirTemp("", "score-display-archive")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer os.RemoveAll(dir)
+			historyCopy = filepath.Join(dir, "history.db")
+			if err := hub.History.Snapshot(historyCopy); err != nil {
+				slog.Error("Failed to copy history for archive", "err", err)
+				http.Error(w, "copy history: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		name := archiveName(settings.CompetitionName, now)
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		if err := writeArchive(w, hub, settings, auditPath, historyCopy, now); err != nil {
+			// The status is sent; a cut-off ZIP is what the client gets
+			slog.Error("Failed to write archive", "name", name, "err", err)
+			return
+		}
+		slog.Info("Archive downloaded", "name", name, "addr", r.RemoteAddr)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRecentAudit is how many entries are kept in memory for /api/audit; the
+// file keeps everything.
+const maxRecentAudit = 10000
+
+// AuditEntry is one control action.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`           // "ws" (admin UI), "api" (HTTP API / score-displayctl) or "follow" (followNewest)
+	Actor  string    `json:"actor"`            // Name and ID of the connection, empty for the API
+	Addr   string    `json:"addr"`             // Remote address of the actor
+	Action string    `json:"action"`           // timer_start, timer_pause, timer_reset, set_result or a client_command
+	Room   string    `json:"room,omitempty"`   // Room of timer and result actions
+	Target string    `json:"target,omitempty"` // Client ID for client commands
+	Value  string    `json:"value,omitempty"`  // Seconds, file, new name, zoom
+}
+
+// AuditLog appends control actions to a JSON lines file (one entry per line)
+// so multi-operator events can be reconstructed afterwards.
+type AuditLog struct {
+	mu     sync.Mutex
+	file   *os.File // nil when only kept in memory
+	recent []AuditEntry
+}
+
+// openAuditLog opens (or creates) path for appending and loads its most
+// recent entries. An empty path keeps the log in memory only.
+func openAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{}
+	if path == "" {
+		return a, nil
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				a.add(e)
+			}
+		}
+		f.Close()
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	a.file = f
+	return a, nil
+}
+
+// add keeps e in memory. Caller holds a.mu (or owns a exclusively).
+func (a *AuditLog) add(e AuditEntry) {
+	a.recent = append(a.recent, e)
+	if len(a.recent) > maxRecentAudit {
+		a.recent = a.recent[len(a.recent)-maxRecentAudit:]
+	}
+}
+
+// Record appends e, stamping the time. A nil log records nothing.
+func (a *AuditLog) Record(e AuditEntry) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.add(e)
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Error marshaling audit entry", "err", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write audit log", "err", err)
+	}
+}
+
+// Entries returns up to limit of the most recent entries after since,
+// oldest first.
+func (a *AuditLog) Entries(since time.Time, limit int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := []AuditEntry{}
+	for i := len(a.recent) - 1; i >= 0 && len(out) < limit; i-- {
+		if !a.recent[i].Time.After(since) {
+			break
+		}
+		out = append(out, a.recent[i])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func (a *AuditLog) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// wsAudit builds the entry for an action sent over c's WebSocket.
+func (c *Client) wsAudit(action, target, value string) AuditEntry {
+	c.Hub.mu.Lock()
+	actor := c.Name
+	if c.ID != "" {
+		actor += " (" + c.ID + ")"
+	}
+	room := c.Room
+	c.Hub.mu.Unlock()
+	if target != "" {
+		room = "" // Client commands address a display, wherever it is
+	}
+	return AuditEntry{Source: "ws", Actor: actor, Addr: c.Addr, Action: action, Room: room, Target: target, Value: value}
+}
+
+// apiAudit builds the entry for an action made through the HTTP API.
+func apiAudit(r *http.Request, room, action, target, value string) AuditEntry {
+	return AuditEntry{Source: "api", Addr: r.RemoteAddr, Action: action, Room: room, Target: target, Value: value}
+}
+
+// registerAuditAPI serves the audit log.
+func registerAuditAPI(audit *AuditLog) {
+	// GET /api/audit?since=<RFC 3339>&limit=500 -> [AuditEntry], oldest first
+	http.HandleFunc("GET /api/audit", func(w http.ResponseWriter, r *http.Request) {
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+		limit := 500
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxRecentAudit)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(audit.Entries(since, limit))
+	})
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A backup (GET /api/backup) is one JSON file with what it takes to carry on
+// with a spare laptop when the server laptop dies mid-event: the config file,
+// scenes, custom sport profiles, bans, the displays known with their mode
+// and screen power, and each room's result. POST /api/restore on the spare
+// puts it all back; results themselves stay on the timing system's share.
+const (
+	backupVersion  = 1
+	maxBackupSize  = 8 << 20
+	maxBackupSport = 200
+)
+
+// Backup is the body of GET /api/backup and POST /api/restore.
+type Backup struct {
+	Version   int                        `json:"version"`
+	CreatedAt time.Time                  `json:"createdAt"`
+	Server    string                     `json:"server"`           // serverName of the server backed up
+	Config    *BackupFile                `json:"config,omitempty"` // Without a config file
+	Scenes    []Scene                    `json:"scenes"`
+	Sports    map[string]json.RawMessage `json:"sports,omitempty"` // sportsDir's profiles by file name
+	Bans      []Ban                      `json:"bans"`
+	Displays  []ClientInfo               `json:"displays"` // Connected when the backup was made
+	Rooms     []RoomInfo                 `json:"rooms"`
+}
+
+// BackupFile is a file of the backup by its name.
+type BackupFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// RestoreReport is the answer of POST /api/restore.
+type RestoreReport struct {
+	Config   bool     `json:"config"`   // The config file was replaced and reloaded
+	Scenes   int      `json:"scenes"`   // Scenes now saved
+	Sports   int      `json:"sports"`   // Profile files written to sportsDir
+	Bans     int      `json:"bans"`     // Bans added
+	Displays int      `json:"displays"` // Displays whose mode is restored, now or when they connect
+	Results  int      `json:"results"`  // Rooms switched to their backed up result
+	Problems []string `json:"problems,omitempty"`
+}
+
+// restoredDisplay is what a restore keeps for a display that has not
+// connected yet, until inherit() applies it.
+type restoredDisplay struct {
+	DisplayMode string
+	ScreenPower string
+}
+
+// makeBackup collects the backup of the running server.
+func makeBackup(hub *Hub, cfgMgr *ConfigManager) (Backup, error) {
+	settings := cfgMgr.Current()
+	b := Backup{
+		Version:   backupVersion,
+		CreatedAt: time.Now(),
+		Server:    settings.ServerName,
+		Bans:      append([]Ban{}, hub.Bans()...),
+		Displays:  []ClientInfo{},
+		Rooms:     hub.Rooms(),
+	}
+	data, err := os.ReadFile(cfgMgr.Path)
+	if err == nil {
+		b.Config = &BackupFile{Name: filepath.Base(cfgMgr.Path), Content: string(data)}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return b, fmt.Errorf("config: %w", err)
+	}
+	hub.Scenes.mu.Lock()
+	b.Scenes = append([]Scene{}, hub.Scenes.scenes...)
+	hub.Scenes.mu.Unlock()
+	if b.Sports, err = readSportFiles(settings.SportsDir); err != nil {
+		return b, fmt.Errorf("sports: %w", err)
+	}
+	for _, c := range hub.ClientList() {
+		if c.Role != roleController {
+			b.Displays = append(b.Displays, c)
+		}
+	}
+	return b, nil
+}
+
+// readSportFiles returns the profile files in dir by name; a missing dir
+// has none.
+func readSportFiles(dir string) (map[string]json.RawMessage, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sports := make(map[string]json.RawMessage)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxSportsFileSize || !json.Valid(data) {
+			slog.Warn("Sport profile left out of backup", "file", file)
+			continue
+		}
+		sports[filepath.Base(file)] = data
+	}
+	return sports, nil
+}
+
+// restoreConfig replaces the config file with b's, after checking that it
+// loads, and reloads it. The file must have the format of the one the server
+// reads: a server started with server.json cannot switch to YAML.
+func restoreConfig(cfgMgr *ConfigManager, file BackupFile) error {
+	if filepath.Ext(file.Name) != filepath.Ext(cfgMgr.Path) {
+		return fmt.Errorf("backup has %s but this server reads %s", file.Name, filepath.Base(cfgMgr.Path))
+	}
+	dir, err := os.MkdirTemp("", "score-display-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	check := filepath.Join(dir, filepath.Base(cfgMgr.Path))
+	if err := os.WriteFile(check, []byte(file.Content), 0644); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(check)
+	if err != nil {
+		return err
+	}
+	if _, err := resolveSettings(cfg, cfgMgr.Flags, cfgMgr.Env); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(cfgMgr.Path, []byte(file.Content)); err != nil {
+		return err
+	}
+	return cfgMgr.Reload()
+}
+
+// restoreSports writes the profile files into dir, replacing those of the
+// same name, and reports how many it wrote.
+func restoreSports(dir string, sports map[string]json.RawMessage) (int, []string) {
+	var problems []string
+	if len(sports) > maxBackupSport {
+		return 0, []string{fmt.Sprintf("sports: more than %d profiles", maxBackupSport)}
+	}
+	if dir == "" {
+		return 0, []string{"sports: no sportsDir configured"}
+	}
+	written := 0
+	for name, data := range sports {
+		if !sportNamePattern.MatchString(strings.TrimSuffix(name, ".json")) || !strings.HasSuffix(name, ".json") {
+			problems = append(problems, "sports: invalid file name "+name)
+			continue
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+			problems = append(problems, fmt.Sprintf("sports: %s: %v", name, err))
+			continue
+		}
+		written++
+	}
+	return written, problems
+}
+
+// replace saves scenes instead of the current ones.
+func (s *sceneStore) replace(scenes []Scene) error {
+	if len(scenes) > maxScenes {
+		return errTooManyScenes
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenes = append([]Scene{}, scenes...)
+	return s.write()
+}
+
+// restoreBans adds bans, disconnecting clients they now turn away, and
+// reports how many were new.
+func (h *Hub) restoreBans(bans []Ban, actor string) int {
+	h.mu.Lock()
+	if h.bans.ids == nil {
+		h.bans.ids, h.bans.ips = make(map[string]Ban), make(map[string]Ban)
+	}
+	added := 0
+	for _, ban := range bans {
+		switch {
+		case ban.ID != "":
+			if _, ok := h.bans.ids[ban.ID]; !ok {
+				h.bans.ids[ban.ID] = ban
+				added++
+			}
+		case ban.IP != "":
+			if _, ok := h.bans.ips[ban.IP]; !ok {
+				h.bans.ips[ban.IP] = ban
+				added++
+			}
+		}
+	}
+	var victims []*Client
+	for client := range h.Clients {
+		if h.banned(client) != "" {
+			victims = append(victims, client)
+			delete(h.Clients, client)
+		}
+	}
+	h.mu.Unlock()
+	for _, victim := range victims {
+		slog.Warn("Client kicked", "name", victim.Name, "id", victim.ID, "addr", victim.Addr, "ban", true, "by", actor)
+		h.turnAway(victim, "Disconnected by an operator")
+	}
+	return added
+}
+
+// restoreDisplays sets the backed up mode and screen power of displays that
+// are connected, and keeps them for inherit() to apply to the others when
+// they connect. Anything but a display mode and on or off is refused and
+// reported.
+func (h *Hub) restoreDisplays(displays []ClientInfo) (int, []string) {
+	restored := 0
+	var problems []string
+	for _, d := range displays {
+		if d.ID == "" || (d.DisplayMode == "" && d.ScreenPower == "") {
+			continue
+		}
+		if err := validateDisplayState(d.DisplayMode, d.ScreenPower); err != nil {
+			problems = append(problems, fmt.Sprintf("displays: %s: %v", d.ID, err))
+			continue
+		}
+		restored++
+		h.mu.Lock()
+		connected := h.byID[d.ID] != nil
+		if !connected {
+			if h.restored == nil {
+				h.restored = make(map[string]restoredDisplay)
+			}
+			h.restored[d.ID] = restoredDisplay{DisplayMode: d.DisplayMode, ScreenPower: d.ScreenPower}
+		}
+		h.mu.Unlock()
+		if !connected {
+			continue
+		}
+		if d.DisplayMode != "" {
+			h.clientCommand(d.ID, d.DisplayMode, "", nil, "", false)
+		}
+		if d.ScreenPower != "" {
+			h.clientCommand(d.ID, "screen_power", d.ScreenPower, nil, "", false)
+		}
+	}
+	return restored, problems
+}
+
+// restore applies b, part by part; a part that fails is reported and the
+// others are still restored.
+func restore(hub *Hub, cfgMgr *ConfigManager, b Backup, actor string) RestoreReport {
+	var report RestoreReport
+	problem := func(part string, err error) {
+		report.Problems = append(report.Problems, part+": "+err.Error())
+	}
+	if b.Config != nil {
+		if err := restoreConfig(cfgMgr, *b.Config); err != nil {
+			problem("config", err)
+		} else {
+			report.Config = true
+		}
+	}
+	settings := cfgMgr.Current()
+	if b.Scenes != nil {
+		scenes := make([]Scene, 0, len(b.Scenes))
+		for _, sc := range b.Scenes {
+			if err := validateScene(sc); err != nil {
+				problem("scenes", err)
+				continue
+			}
+			scenes = append(scenes, sc)
+		}
+		if err := hub.Scenes.replace(scenes); err != nil {
+			problem("scenes", err)
+		} else {
+			report.Scenes = len(scenes)
+		}
+	}
+	if len(b.Sports) > 0 {
+		var problems []string
+		report.Sports, problems = restoreSports(settings.SportsDir, b.Sports)
+		report.Problems = append(report.Problems, problems...)
+		hub.ReloadSports()
+	}
+	report.Bans = hub.restoreBans(b.Bans, actor)
+	var problems []string
+	report.Displays, problems = hub.restoreDisplays(b.Displays)
+	report.Problems = append(report.Problems, problems...)
+	for _, room := range b.Rooms {
+		if room.ActiveResult == "" {
+			continue
+		}
+		if err := validateRoomName(room.Name); err != nil {
+			problem("rooms", fmt.Errorf("%q: %v", room.Name, err))
+			continue
+		}
+		if !hub.roomExists(room.Name) {
+			problem("rooms", fmt.Errorf("room %q does not exist", room.Name))
+			continue
+		}
+		abs, ok := resolveResultPath(settings.ResultsDir, settings.ResultsAliases, room.ActiveResult)
+		if _, err := os.Stat(abs); !ok || err != nil {
+			problem("rooms", fmt.Errorf("result %s of room %q not found", room.ActiveResult, room.Name))
+			continue
+		}
+		hub.switchResult(room.Name, room.ActiveResult, actor, "")
+		report.Results++
+	}
+	return report
+}
+
+// registerBackupAPI serves GET /api/backup and POST /api/restore, both with
+// the controller token.
+func registerBackupAPI(hub *Hub, cfgMgr *ConfigManager) {
+	http.HandleFunc("GET /api/backup", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		b, err := makeBackup(hub, cfgMgr)
+		if err != nil {
+			slog.Error("Failed to make backup", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := strings.TrimSuffix(archiveName(cfgMgr.Current().CompetitionName, b.CreatedAt), ".zip") + "-backup.json"
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(b)
+		slog.Info("Backup downloaded", "name", name, "addr", r.RemoteAddr)
+	})
+
+	http.HandleFunc("POST /api/restore", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBackupSize))
+		if err != nil {
+			http.Error(w, "backup too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var b Backup
+		if err := json.Unmarshal(data, &b); err != nil {
+			http.Error(w, "invalid backup: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if b.Version != backupVersion {
+			http.Error(w, fmt.Sprintf("unsupported backup version %d", b.Version), http.StatusBadRequest)
+			return
+		}
+		report := restore(hub, cfgMgr, b, "api")
+		hub.Audit.Record(apiAudit(r, "", "restore", b.Server, b.CreatedAt.Format(time.RFC3339)))
+		slog.Info("Backup restored", "from", b.Server, "created", b.CreatedAt, "config", report.Config, "scenes", report.Scenes,
+			"sports", report.Sports, "bans", report.Bans, "displays", report.Displays, "results", report.Results, "problems", len(report.Problems))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+}
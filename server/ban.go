@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Operators can disconnect a display (kick) or also keep its ID, and
+// optionally its IP address, from connecting again until the server
+// restarts (ban), for duplicate or rogue connections in the client list.
+
+// Ban is an entry of GET /api/clients/bans.
+type Ban struct {
+	ID     string    `json:"id,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Name   string    `json:"name,omitempty"` // The display's name when it was banned
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"` // Who banned it, like audit actors
+}
+
+// banList holds the bans of this server run. Protected by Hub.mu.
+type banList struct {
+	ids map[string]Ban
+	ips map[string]Ban
+}
+
+// banned reports why client may not stay connected, "" if it may. Caller
+// holds h.mu.
+func (h *Hub) banned(client *Client) string {
+	if _, ok := h.bans.ips[splitHostPortSafe(client.Addr)]; ok {
+		return "address banned"
+	}
+	if _, ok := h.bans.ids[client.ID]; ok && client.ID != "" {
+		return "client banned"
+	}
+	return ""
+}
+
+// kick disconnects client: it gets an error message, its queue is closed
+// and readPump then announces it as client_left. With ban, its ID (and its
+// IP address with byIP) is also turned away until the server restarts, and
+// every other connection using them is disconnected as well.
+func (h *Hub) kick(client *Client, ban, byIP bool, actor string) {
+	h.mu.Lock()
+	victims := []*Client{client}
+	if ban {
+		if h.bans.ids == nil {
+			h.bans.ids, h.bans.ips = make(map[string]Ban), make(map[string]Ban)
+		}
+		now := time.Now()
+		h.bans.ids[client.ID] = Ban{ID: client.ID, Name: client.Name, Since: now, Reason: actor}
+		if byIP {
+			ip := splitHostPortSafe(client.Addr)
+			h.bans.ips[ip] = Ban{IP: ip, Name: client.Name, Since: now, Reason: actor}
+		}
+		for other := range h.Clients {
+			if other != client && h.banned(other) != "" {
+				victims = append(victims, other)
+			}
+		}
+	}
+	for _, victim := range victims {
+		delete(h.Clients, victim)
+	}
+	h.mu.Unlock()
+	for _, victim := range victims {
+		slog.Warn("Client kicked", "name", victim.Name, "id", victim.ID, "addr", victim.Addr, "ban", ban, "byIP", byIP, "by", actor)
+		h.turnAway(victim, "Disconnected by an operator")
+	}
+}
+
+// Bans lists the IDs and addresses turned away, oldest first.
+func (h *Hub) Bans() []Ban {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := slices.Collect(maps.Values(h.bans.ids))
+	list = slices.AppendSeq(list, maps.Values(h.bans.ips))
+	slices.SortFunc(list, func(a, b Ban) int { return a.Since.Compare(b.Since) })
+	return list
+}
+
+// Unban lifts the ban of an ID or IP address. It reports whether there was
+// one.
+func (h *Hub) Unban(idOrIP string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, id := h.bans.ids[idOrIP]
+	_, ip := h.bans.ips[idOrIP]
+	delete(h.bans.ids, idOrIP)
+	delete(h.bans.ips, idOrIP)
+	return id || ip
+}
+
+func registerBanAPI(hub *Hub) {
+	// GET /api/clients/bans
+	http.HandleFunc("GET /api/clients/bans", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Bans())
+	})
+
+	// POST /api/clients/unban {"target": "<id or ip>"}
+	http.HandleFunc("/api/clients/unban", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) || !requireController(hub, w, r) {
+			return
+		}
+		var payload struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Target == "" {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if !hub.Unban(payload.Target) {
+			http.Error(w, "Not banned", http.StatusNotFound)
+			return
+		}
+		slog.Info("Ban lifted", "target", payload.Target)
+		hub.Audit.Record(apiAudit(r, "", "unban", payload.Target, ""))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -1,23 +1,29 @@
 package main
 
 import (
+	"compress/flate"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"display/internal/protocol"
+
 	"github.com/gorilla/websocket"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	// Outgoing messages smaller than this are sent uncompressed: deflate
+	// costs CPU and saves nothing on timer ticks, but result payloads and
+	// client_list broadcasts shrink several times over.
+	compressionThreshold = 256
 )
 
 // isPrivateIP checks if an IP address is in a private range
@@ -25,11 +31,14 @@ func isPrivateIP(ip net.IP) bool {
 	if ip.IsLoopback() {
 		return true
 	}
-	// Check private ranges: 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16
+	// Check private ranges: 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16,
+	// IPv6 unique local fc00::/7 and link-local fe80::/10
 	privateRanges := []string{
 		"10.0.0.0/8",
 		"172.16.0.0/12",
 		"192.168.0.0/16",
+		"fc00::/7",
+		"fe80::/10",
 	}
 	for _, cidr := range privateRanges {
 		_, network, _ := net.ParseCIDR(cidr)
@@ -48,51 +57,150 @@ func splitHostPortSafe(s string) string {
 	if err == nil {
 		return host
 	}
-	return s
+	// Bare IPv6 literal without port, e.g. "[fd00::1]"
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			// No origin header - allow (some clients don't send it)
-			return true
-		}
+// sameHost compares two hosts, treating different spellings of the same IP
+// (e.g. "fd00::1" and "fd00:0::1") as equal.
+func sameHost(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipB != nil && ipA.Equal(ipB)
+}
 
-		u, err := url.Parse(origin)
-		if err != nil {
-			log.Printf("Rejected WebSocket connection with invalid origin %q: %v", origin, err)
-			return false
-		}
-		originHost := u.Hostname()
-		if originHost == "" {
-			log.Printf("Rejected WebSocket connection with empty origin host: %s", origin)
-			return false
-		}
-		requestHost := splitHostPortSafe(r.Host)
+// OriginPolicy widens checkOrigin: allowed lists public host names
+// ("scores.example.com", "*.example.com" for any subdomain) or full origins
+// ("https://scores.example.com:8443"), e.g. the name a reverse proxy serves
+// the admin UI under; disabled accepts every origin, for closed networks.
+type OriginPolicy struct {
+	Allowed  []string
+	Disabled bool
+}
 
-		// Always allow same-host origin (covers LAN hostnames / .local names).
-		if strings.EqualFold(originHost, requestHost) {
-			return true
-		}
+var (
+	originMu     sync.RWMutex
+	originPolicy OriginPolicy
+)
 
-		// Allow localhost
-		if originHost == "localhost" || originHost == "127.0.0.1" || originHost == "::1" {
-			return true
+// setOriginPolicy replaces the policy; the config reload calls it too.
+func setOriginPolicy(p OriginPolicy) {
+	originMu.Lock()
+	originPolicy = p
+	originMu.Unlock()
+}
+
+// validateAllowedOrigin checks an OriginPolicy.Allowed entry.
+func validateAllowedOrigin(entry string) error {
+	host := entry
+	if strings.Contains(entry, "://") {
+		u, err := url.Parse(entry)
+		if err != nil || u.Hostname() == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("%q is not an origin like \"https://scores.example.com\"", entry)
 		}
+		host = u.Hostname()
+	}
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || strings.ContainsAny(host, "*/ ") {
+		return fmt.Errorf("%q is not a host name like \"scores.example.com\" or \"*.example.com\"", entry)
+	}
+	return nil
+}
 
-		// Check if it's a private IP
-		ip := net.ParseIP(originHost)
-		if ip != nil && isPrivateIP(ip) {
+// allows reports whether the policy lists origin (parsed as u).
+func (p OriginPolicy) allows(origin string, u *url.URL) bool {
+	for _, entry := range p.Allowed {
+		if strings.Contains(entry, "://") {
+			if strings.EqualFold(strings.TrimSuffix(entry, "/"), origin) {
+				return true
+			}
+		} else if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(strings.ToLower(u.Hostname()), "."+strings.ToLower(suffix)) {
+				return true
+			}
+		} else if sameHost(u.Hostname(), entry) {
 			return true
 		}
+	}
+	return false
+}
 
-		// Reject all other origins
-		log.Printf("Rejected WebSocket connection from origin: %s", origin)
+// checkOrigin allows requests without an Origin header, same-host origins,
+// localhost and private-network origins, and those of the OriginPolicy. It
+// guards both the WebSocket upgrade and the REST control API.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// No origin header - allow (some clients don't send it)
+		return true
+	}
+	originMu.RLock()
+	policy := originPolicy
+	originMu.RUnlock()
+	if policy.Disabled {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		slog.Warn("Rejected request with invalid origin", "origin", origin, "err", err)
+		return false
+	}
+	originHost := u.Hostname()
+	if originHost == "" {
+		slog.Warn("Rejected request with empty origin host", "origin", origin)
 		return false
-	},
+	}
+	requestHost := splitHostPortSafe(r.Host)
+
+	// Always allow same-host origin (covers LAN hostnames / .local names).
+	if sameHost(originHost, requestHost) {
+		return true
+	}
+
+	// Allow localhost
+	if originHost == "localhost" || originHost == "127.0.0.1" || originHost == "::1" {
+		return true
+	}
+
+	// Check if it's a private IP
+	ip := net.ParseIP(originHost)
+	if ip != nil && isPrivateIP(ip) {
+		return true
+	}
+
+	if policy.allows(origin, u) {
+		return true
+	}
+
+	// Reject all other origins
+	slog.Warn("Rejected request from origin", "origin", origin)
+	return false
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+	// Negotiate permessage-deflate; clients that don't offer it get plain frames.
+	EnableCompression: true,
+}
+
+// Conn is what a Client needs of its WebSocket. *websocket.Conn implements
+// it; anything else that does can be attached to the hub with serveConn, e.g.
+// an in-memory connection.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	NextWriter(messageType int) (io.WriteCloser, error)
+	WriteMessage(messageType int, data []byte) error
+	EnableWriteCompression(enable bool)
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -101,228 +209,326 @@ func (c *Client) readPump() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.Conn.SetPongHandler(func(string) error { c.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.Conn.SetReadLimit(c.limits.maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(c.limits.pongWait))
+	c.Conn.SetPongHandler(func(data string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(c.limits.pongWait))
+		recovered := c.quality.pong(data, time.Now())
+		if recovered {
+			slog.Info("Client answers pings again", "name", c.Name, "addr", c.Addr)
+		}
+		if c.checkLite() || recovered {
+			c.Hub.QualityChanged <- c
+		}
+		return nil
+	})
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				slog.Warn("WebSocket read error", "addr", c.Addr, "err", err)
 			}
 			break
 		}
 
-		// Handle incoming messages
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Invalid JSON: %v", err)
-			continue
+		if !c.handleMessage(message) {
+			return
 		}
+	}
+}
 
-		switch msg.Type {
-		case "timer_control":
-			var payload struct {
-				Action  string `json:"action"`
-				Seconds int    `json:"seconds"`
+// handleMessage carries out one message from the client. It reports false
+// when the connection has to be closed.
+func (c *Client) handleMessage(message []byte) bool {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return c.reject(msg, "invalid JSON")
+	}
+	if controlMessages[msg.Type] && !c.limiter.allow(time.Now()) {
+		return c.reject(msg, "rate limit exceeded")
+	}
+	// Role is only written while handling messages, which are handled one at a
+	// time, so no lock is needed to read it.
+	if controlMessages[msg.Type] && c.Role != roleController {
+		return c.reject(msg, msg.Type+" is only allowed for controllers")
+	}
+
+	switch msg.Type {
+	case "timer_control":
+		var payload protocol.TimerControl
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid timer_control payload")
+		}
+		if err := validateTimerControl(payload.Action, payload.Seconds); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		// Room is only written while handling messages, like Role.
+		timerMgr := c.Hub.Room(c.Room).Timer
+		if payload.Action == "start" {
+			timerMgr.Start()
+			c.Hub.Audit.Record(c.wsAudit("timer_start", "", ""))
+		} else if payload.Action == "pause" {
+			timerMgr.Pause()
+			c.Hub.Audit.Record(c.wsAudit("timer_pause", "", ""))
+		} else if payload.Action == "reset" {
+			timerMgr.Reset(payload.Seconds)
+			c.Hub.Audit.Record(c.wsAudit("timer_reset", "", strconv.Itoa(payload.Seconds)))
+		} else {
+			step := timerMgr.NextPeriod
+			if payload.Action == "new_match" {
+				step = timerMgr.NewMatch
 			}
-			if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-				if payload.Action == "start" {
-					c.TimerMgr.Start()
-				} else if payload.Action == "pause" {
-					c.TimerMgr.Pause()
-				} else if payload.Action == "reset" {
-					c.TimerMgr.Reset(payload.Seconds)
-				}
+			if err := step(); err != nil {
+				return c.reject(msg, err.Error())
 			}
-		case "handshake":
-			var payload struct {
-				Name  string `json:"name"`
-				ID    string `json:"id"`
-				Theme string `json:"theme,omitempty"`
-				Zoom  int    `json:"zoom,omitempty"`
+			c.Hub.Audit.Record(c.wsAudit("timer_"+payload.Action, "", ""))
+		}
+	case "penalty_control":
+		var payload penaltyControl
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid penalty_control payload")
+		}
+		if err := validatePenaltyControl(payload); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		target, value, err := c.Hub.Room(c.Room).Timer.ApplyPenalty(payload)
+		if err != nil {
+			return c.reject(msg, err.Error())
+		}
+		c.Hub.Audit.Record(c.wsAudit("penalty_"+payload.Action, target, value))
+	case "score_control":
+		var payload scoreControl
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid score_control payload")
+		}
+		if err := validateScoreControl(payload); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		target, value, err := c.Hub.Room(c.Room).Score.Apply(payload)
+		if err != nil {
+			return c.reject(msg, err.Error())
+		}
+		c.Hub.Audit.Record(c.wsAudit("score_"+payload.Action, target, value))
+	case "splits_view":
+		var payload SplitView
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid splits_view payload")
+		}
+		if err := validateSplitView(payload); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		c.Hub.SetSplitView(c.Room, payload)
+		c.Hub.Audit.Record(c.wsAudit("splits_view", payload.Control, payload.Class))
+	case "handshake":
+		var payload protocol.Handshake
+		if err := json.Unmarshal(msg.Payload, &payload); err == nil {
+			roomErr := c.Hub.checkRoom(payload.Room)
+			c.Hub.mu.Lock()
+			// The first handshake joins a room, later ones may move.
+			first := !c.joined
+			moved := roomErr == nil && (payload.Room != c.Room || first)
+			if moved {
+				c.Room, c.joined = payload.Room, true
+				c.lock = softLock{} // Locks are per room
 			}
-			if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-				c.Name = payload.Name
-				c.ID = payload.ID
-				if payload.Theme == "light" || payload.Theme == "dark" {
-					c.ThemeMode = payload.Theme
-				}
-				if payload.Zoom >= 50 && payload.Zoom <= 300 {
-					c.Zoom = payload.Zoom
-				}
-				c.Hub.Handshake <- c
+			if c.Transport == transportSSE {
+				payload.Role = roleDisplay // The fallback is read-only
 			}
-		case "set_result":
-			var payload struct {
-				File string `json:"file"`
+			role, granted := c.Hub.grantRole(payload.Role, payload.Token)
+			c.Role = role
+			c.Name = payload.Name
+			c.ID = payload.ID
+			c.Protocol = payload.Protocol
+			c.Version = payload.Version
+			c.Encoding = negotiateEncoding(payload.Encodings, c.Transport)
+			if payload.Theme == "light" || payload.Theme == "dark" {
+				c.ThemeMode = payload.Theme
 			}
-			if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-				c.Hub.mu.Lock()
-				c.Hub.State.ActiveResult = payload.File
-				c.Hub.mu.Unlock()
-				c.Hub.BroadcastJSON(msg)
+			if payload.Zoom >= 50 && payload.Zoom <= 300 {
+				c.Zoom = payload.Zoom
 			}
-		case "client_command":
-			var payload struct {
-				Target  string `json:"target"`
-				Command string `json:"command"`
-				Value   string `json:"value"` // Generic value field
+			if validRotation(payload.Rotation) {
+				c.Rotation = payload.Rotation
 			}
-			if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-				var targetClient *Client
-
-				c.Hub.mu.Lock()
-				for target := range c.Hub.Clients {
-					if target.Conn.RemoteAddr().String() == payload.Target {
-						targetClient = target
-						if payload.Command == "show_timer" || payload.Command == "show_result" {
-							target.DisplayMode = payload.Command // Update state immediately under lock
-						} else if payload.Command == "theme_dark" {
-							target.ThemeMode = "dark"
-						} else if payload.Command == "theme_light" {
-							target.ThemeMode = "light"
-						} else if payload.Command == "set_zoom" {
-							zoom := 100
-							if v := payload.Value; v != "" {
-								if z, err := strconv.Atoi(v); err == nil && z >= 50 && z <= 300 {
-									zoom = z
-								}
-							}
-							target.Zoom = zoom
-						}
-						break
-					}
-				}
-				c.Hub.mu.Unlock()
-
-				if targetClient != nil {
-					if payload.Command == "rename" {
-						// Send update_config to client
-						msgData, err := json.Marshal(struct {
-							Type    string `json:"type"`
-							Payload struct {
-								Key   string `json:"key"`
-								Value string `json:"value"`
-							} `json:"payload"`
-						}{
-							Type: "update_config",
-							Payload: struct {
-								Key   string `json:"key"`
-								Value string `json:"value"`
-							}{Key: "ClientName", Value: payload.Value},
-						})
-						if err != nil {
-							log.Printf("Error marshaling update_config message: %v", err)
-						} else {
-							c.Hub.SendTo <- struct {
-								Client *Client
-								Msg    []byte
-							}{Client: targetClient, Msg: msgData}
-						}
-
-					} else if payload.Command == "theme_dark" || payload.Command == "theme_light" {
-						theme := "dark"
-						if payload.Command == "theme_light" {
-							theme = "light"
-						}
-						msgData, err := json.Marshal(struct {
-							Type    string `json:"type"`
-							Payload string `json:"payload"`
-						}{
-							Type:    "theme_mode",
-							Payload: theme,
-						})
-						if err != nil {
-							log.Printf("Error marshaling theme_mode message: %v", err)
-						} else {
-							c.Hub.SendTo <- struct {
-								Client *Client
-								Msg    []byte
-							}{Client: targetClient, Msg: msgData}
-						}
-
-						// Broadcast updated list (ThemeMode changed)
-						c.Hub.broadcastClientList()
-					} else if payload.Command == "set_zoom" {
-						zoom := 100
-						if v := payload.Value; v != "" {
-							if z, err := strconv.Atoi(v); err == nil && z >= 50 && z <= 300 {
-								zoom = z
-							}
-						}
-						msgData, err := json.Marshal(struct {
-							Type    string `json:"type"`
-							Payload int    `json:"payload"`
-						}{
-							Type:    "set_zoom",
-							Payload: zoom,
-						})
-						if err != nil {
-							log.Printf("Error marshaling set_zoom message: %v", err)
-						} else {
-							c.Hub.SendTo <- struct {
-								Client *Client
-								Msg    []byte
-							}{Client: targetClient, Msg: msgData}
-						}
-						c.Hub.broadcastClientList()
-					} else {
-						// Forward other commands as display_mode
-						msgData, err := json.Marshal(struct {
-							Type    string `json:"type"`
-							Payload string `json:"payload"`
-						}{
-							Type:    "display_mode",
-							Payload: payload.Command,
-						})
-						if err != nil {
-							log.Printf("Error marshaling display_mode message: %v", err)
-						} else {
-							// Send once to the target client (channel is now buffered)
-							c.Hub.SendTo <- struct {
-								Client *Client
-								Msg    []byte
-							}{Client: targetClient, Msg: msgData}
-						}
-
-						// Broadcast updated list (DisplayMode changed)
-						c.Hub.broadcastClientList()
-					}
+			if first {
+				c.Hub.inherit(c)
+			}
+			c.Hub.mu.Unlock()
+			if !granted && !c.reject(msg, "invalid controller token") {
+				return false
+			}
+			if roomErr != nil && !c.reject(msg, roomErr.Error()) {
+				return false
+			}
+			c.Hub.Handshake <- c
+			if moved {
+				for _, data := range c.Hub.joinMessages(c, first) {
+					c.Hub.SendTo <- struct {
+						Client *Client
+						Msg    []byte
+					}{Client: c, Msg: data}
 				}
 			}
 		}
+	case "heartbeat":
+		var health ClientHealth
+		if err := json.Unmarshal(msg.Payload, &health); err == nil {
+			health.ReceivedAt = time.Now()
+			c.Hub.mu.Lock()
+			c.Health = &health
+			c.Hub.mu.Unlock()
+			c.Hub.Heartbeat <- c
+		}
+	case "network_events":
+		// What a display's network watchdog did while the server was out of reach
+		var events []protocol.NetworkEvent
+		if err := json.Unmarshal(msg.Payload, &events); err != nil {
+			return c.reject(msg, "invalid network_events payload")
+		}
+		if len(events) > maxNetworkEvents {
+			events = events[len(events)-maxNetworkEvents:]
+		}
+		for _, e := range events {
+			slog.Warn("Display network event", "client", c.ID, "event", e.Event, "detail", e.Detail, "at", e.Time)
+		}
+		c.Hub.tracker.networkEvents(c.ID, events)
+	case "ack":
+		// A display confirming a message that carried a msgId
+		c.Hub.relayAck(c, msg.ReplyTo)
+	case "get_client_list":
+		c.Hub.SendClientList(c)
+	case "set_result":
+		var payload protocol.SetResult
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid set_result payload")
+		}
+		if err := validateResultFile(payload.File); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		if !roomFile(c.Room, payload.File) {
+			return c.reject(msg, "file must be in the room's folder")
+		}
+		c.Hub.SetActiveResult(c.Room, payload.File, c, msg.MsgID)
+		c.Hub.Audit.Record(c.wsAudit("set_result", "", payload.File))
+	case "client_command":
+		var payload protocol.ClientCommand
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid client_command payload")
+		}
+		if err := validateClientCommand(payload.Target, payload.Command, payload.Value); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		if err := c.Hub.checkCommandAllowed(payload.Command); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		// Controllers only reach the displays in their own room.
+		c.Hub.mu.Lock()
+		target := c.Hub.byID[payload.Target]
+		elsewhere := target != nil && target.Room != c.Room
+		c.Hub.mu.Unlock()
+		if elsewhere || !c.Hub.ClientCommand(payload.Target, payload.Command, payload.Value, c, msg.MsgID) {
+			c.sendError(msg, "client not found") // Not a strike; the display may just have left
+			return true
+		}
+		c.Hub.Audit.Record(c.wsAudit(payload.Command, payload.Target, auditValue(payload.Command, payload.Value)))
+	case "operator_lock":
+		var payload operatorLock
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid operator_lock payload")
+		}
+		if err := validateOperatorLock(payload); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		if payload.Action == "release" {
+			c.Hub.releaseLock(c)
+		} else if err := c.Hub.takeLock(c, payload.Area, payload.Force); err != nil {
+			c.sendError(msg, err.Error()) // Not a strike; someone else got there first
+		}
+	case "scene":
+		var payload sceneControl
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return c.reject(msg, "invalid scene payload")
+		}
+		if err := validateSceneName(payload.Name); err != nil {
+			return c.reject(msg, err.Error())
+		}
+		var err error
+		switch payload.Action {
+		case "save":
+			_, err = c.Hub.SaveScene(c.Room, payload.Name, c.Name)
+		case "recall":
+			var recall SceneRecall
+			if recall, err = c.Hub.RecallScene(c.Room, payload.Name, c); err == nil && len(recall.Missing) > 0 {
+				c.sendError(msg, fmt.Sprintf("%d displays of the scene are not connected", len(recall.Missing)))
+			}
+		case "delete":
+			err = c.Hub.DeleteScene(c.Room, payload.Name)
+		default:
+			return c.reject(msg, "unknown scene action")
+		}
+		if err != nil {
+			c.sendError(msg, err.Error()) // Not a strike; a missing scene is no abuse
+			return true
+		}
+		c.Hub.Audit.Record(c.wsAudit("scene_"+payload.Action, "", payload.Name))
+	case "undo", "redo":
+		step := c.Hub.Undo
+		if msg.Type == "redo" {
+			step = c.Hub.Redo
+		}
+		sw, err := step(c.Room, c.Name)
+		if err != nil {
+			c.sendError(msg, err.Error()) // Not a strike; the stack may just be empty
+			return true
+		}
+		c.Hub.Audit.Record(c.wsAudit(msg.Type, sw.Target, sw.Kind))
 	}
+	return true
 }
 
 // writePump pumps messages from the hub to the websocket connection.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(pingInterval)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
 	}()
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		case <-c.Send.ready:
+			messages, ok := c.Send.take()
+			for _, message := range messages {
+				c.Conn.SetWriteDeadline(time.Now().Add(c.limits.writeWait))
+				c.Conn.EnableWriteCompression(len(message) >= compressionThreshold)
+				frame := websocket.TextMessage
+				if isBinary(message) {
+					frame = websocket.BinaryMessage
+				}
+				w, err := c.Conn.NextWriter(frame)
+				if err != nil {
+					return
+				}
+				w.Write(message)
+
+				if err := w.Close(); err != nil {
+					return
+				}
+			}
 			if !ok {
+				c.Conn.SetWriteDeadline(time.Now().Add(c.limits.writeWait))
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+		case <-ticker.C:
+			payload, missed := c.quality.pingPayload(time.Now())
+			if missed {
+				slog.Warn("Client missed a ping", "addr", c.Addr)
 			}
-			w.Write(message)
-
-			if err := w.Close(); err != nil {
-				return
+			if c.checkLite() || missed {
+				c.Hub.QualityChanged <- c
 			}
-		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			c.Conn.SetWriteDeadline(time.Now().Add(c.limits.writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, payload); err != nil {
 				return
 			}
 		}
@@ -330,78 +536,33 @@ func (c *Client) writePump() {
 }
 
 // serveWs handles websocket requests from the peer.
-func serveWs(hub *Hub, timerMgr *TimerManager, w http.ResponseWriter, r *http.Request) {
+func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("WebSocket upgrade failed", "addr", r.RemoteAddr, "err", err)
 		return
 	}
-	client := &Client{Hub: hub, TimerMgr: timerMgr, Conn: conn, Send: make(chan []byte, 256)}
+	// Fastest level: venue servers are often laptops, and most of the gain
+	// on repetitive JSON comes from any compression at all.
+	conn.SetCompressionLevel(flate.BestSpeed)
+	hub.serveConn(conn, conn.RemoteAddr().String())
+}
+
+// serveConn makes conn from addr a client of h and runs its pumps.
+func (h *Hub) serveConn(conn Conn, addr string) *Client {
+	client := h.newClient(conn, addr, transportWS)
 
 	// Start writePump before sending messages so it can handle them
 	go client.writePump()
 	go client.readPump()
 
-	client.Hub.Register <- client
+	h.Register <- client
 
-	// Send current timer state immediately upon connection
-	timerMgr.mu.Lock()
-	timerStateMsg, err := json.Marshal(struct {
-		Type    string     `json:"type"`
-		Payload TimerState `json:"payload"`
-	}{
-		Type:    "timer_update",
-		Payload: timerMgr.State,
-	})
-	timerMgr.mu.Unlock()
-	if err != nil {
-		log.Printf("Error marshaling timer state: %v", err)
-	} else {
-		client.Send <- timerStateMsg
-	}
-
-	// Send current active result
-	hub.mu.Lock()
-	if hub.State.ActiveResult != "" {
-		resultMsg, err := json.Marshal(struct {
-			Type    string `json:"type"`
-			Payload struct {
-				File string `json:"file"`
-			} `json:"payload"`
-		}{
-			Type: "set_result",
-			Payload: struct {
-				File string `json:"file"`
-			}{File: hub.State.ActiveResult},
-		})
-		hub.mu.Unlock()
-		if err != nil {
-			log.Printf("Error marshaling result message: %v", err)
-		} else {
-			client.Send <- resultMsg
-		}
-	} else {
-		hub.mu.Unlock()
-	}
-
-	// Send initial display mode (defaults to "show_result" if empty)
-	initMode := client.DisplayMode
-	if initMode == "" {
-		initMode = "show_result"
-	}
-	modeMsg, err := json.Marshal(struct {
-		Type    string `json:"type"`
-		Payload string `json:"payload"`
-	}{
-		Type:    "display_mode",
-		Payload: initMode,
-	})
-	if err != nil {
-		log.Printf("Error marshaling display mode message: %v", err)
-	} else {
-		client.Send <- modeMsg
-	}
+	// The state (state_sync, or display mode, timer and active result for
+	// older clients) follows the handshake, once the client's room and
+	// protocol are known (see readPump and joinMessages).
 
 	// Theme and zoom are NOT sent on connect — the client applies its own
 	// persisted values and reports them back via the handshake.
+	return client
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	clientScanInterval = time.Minute
+	clientScanDuration = 10 * time.Second
+	// Displays not seen for this long are dropped (switched off or gone)
+	discoveredExpiry = 3 * clientScanInterval
+)
+
+// DiscoveredClient is a display client that advertises _displayclient._tcp on
+// the network, an entry of GET /api/clients/discovered.
+type DiscoveredClient struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Version   string    `json:"version,omitempty"`
+	Instance  string    `json:"instance,omitempty"` // -instance of the client, if several run on the machine
+	Host      string    `json:"host"`
+	Addr      string    `json:"addr"` // Address of the client's local web server
+	LastSeen  time.Time `json:"lastSeen"`
+	Connected bool      `json:"connected"` // Whether it is connected over the WebSocket
+}
+
+// DiscoveredServer is a display server that advertises _display._tcp, an
+// entry of GET /api/servers. Displays switch between them by Name.
+type DiscoveredServer struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version,omitempty"`
+	Competition string    `json:"competition,omitempty"`
+	Host        string    `json:"host"`
+	Addr        string    `json:"addr"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Self        bool      `json:"self"` // This server
+}
+
+// ClientScanner browses mDNS for display clients, so displays that never
+// manage to connect (wrong network, blocked port, old version) show up too.
+// It lists the other display servers on the network as well.
+type ClientScanner struct {
+	Hub        *Hub
+	ServerName string
+	ifaces     []net.Interface
+	mu         sync.Mutex
+	seen       map[string]DiscoveredClient // By mDNS instance name
+	servers    map[string]DiscoveredServer // By serverName
+}
+
+func NewClientScanner(hub *Hub, serverName, listenAddr string) *ClientScanner {
+	return &ClientScanner{
+		Hub:        hub,
+		ServerName: serverName,
+		ifaces:     interfacesForAddr(listenAddr),
+		seen:       make(map[string]DiscoveredClient),
+		servers:    make(map[string]DiscoveredServer),
+	}
+}
+
+// Run scans until stop is closed.
+func (s *ClientScanner) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ticker := time.NewTicker(clientScanInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.scan(ctx); err != nil {
+			slog.Warn("Cannot scan for display clients", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan browses for clientScanDuration. The resolver reports each instance
+// once per browse, so scanning in rounds keeps LastSeen current.
+func (s *ClientScanner) scan(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, clientScanDuration)
+	defer cancel()
+	var wg sync.WaitGroup
+	var serverErr error
+	wg.Go(func() { serverErr = s.browse(ctx, "_display._tcp", s.addServer) })
+	err := s.browse(ctx, "_displayclient._tcp", s.add)
+	wg.Wait()
+	s.expire(time.Now())
+	if err != nil {
+		return err
+	}
+	return serverErr
+}
+
+// browse passes each instance of service to add until ctx is done.
+func (s *ClientScanner) browse(ctx context.Context, service string, add func(*zeroconf.ServiceEntry, time.Time)) error {
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces(s.ifaces))
+	if err != nil {
+		return err
+	}
+	entries := make(chan *zeroconf.ServiceEntry, 16)
+	if err := resolver.Browse(ctx, service, "local.", entries); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			add(entry, time.Now())
+		}
+	}
+}
+
+// entryAddr is the host:port of the first address an instance announced.
+func entryAddr(entry *zeroconf.ServiceEntry) string {
+	var ip net.IP
+	if len(entry.AddrIPv4) > 0 {
+		ip = entry.AddrIPv4[0]
+	} else if len(entry.AddrIPv6) > 0 {
+		ip = entry.AddrIPv6[0]
+	}
+	if ip == nil {
+		return ""
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port))
+}
+
+func (s *ClientScanner) add(entry *zeroconf.ServiceEntry, now time.Time) {
+	c := DiscoveredClient{Host: strings.TrimSuffix(entry.HostName, "."), LastSeen: now}
+	for _, txt := range entry.Text {
+		key, value, _ := strings.Cut(txt, "=")
+		switch key {
+		case "id":
+			c.ID = value
+		case "name":
+			c.Name = value
+		case "version":
+			c.Version = value
+		case "instance":
+			c.Instance = value
+		}
+	}
+	if c.ID == "" {
+		return // Not a display client we know how to match
+	}
+	c.Addr = entryAddr(entry)
+
+	s.mu.Lock()
+	if _, known := s.seen[entry.Instance]; !known {
+		slog.Info("Discovered display client", "id", c.ID, "name", c.Name, "addr", c.Addr)
+	}
+	s.seen[entry.Instance] = c
+	s.mu.Unlock()
+}
+
+func (s *ClientScanner) addServer(entry *zeroconf.ServiceEntry, now time.Time) {
+	srv := DiscoveredServer{Name: entry.Instance, Host: strings.TrimSuffix(entry.HostName, "."), Addr: entryAddr(entry), LastSeen: now}
+	for _, txt := range entry.Text {
+		key, value, _ := strings.Cut(txt, "=")
+		switch key {
+		case "name":
+			srv.Name = value
+		case "version":
+			srv.Version = value
+		case "competition":
+			srv.Competition = value
+		}
+	}
+	srv.Self = srv.Name == s.ServerName
+	s.mu.Lock()
+	s.servers[srv.Name] = srv
+	s.mu.Unlock()
+}
+
+func (s *ClientScanner) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for instance, c := range s.seen {
+		if now.Sub(c.LastSeen) > discoveredExpiry {
+			delete(s.seen, instance)
+		}
+	}
+	for name, srv := range s.servers {
+		if now.Sub(srv.LastSeen) > discoveredExpiry {
+			delete(s.servers, name)
+		}
+	}
+}
+
+// Servers returns the display servers on the network sorted by name. This
+// server is always listed, even before (or without) mDNS finding it.
+func (s *ClientScanner) Servers() []DiscoveredServer {
+	s.mu.Lock()
+	list := make([]DiscoveredServer, 0, len(s.servers)+1)
+	self := false
+	for _, srv := range s.servers {
+		self = self || srv.Self
+		list = append(list, srv)
+	}
+	s.mu.Unlock()
+	if !self {
+		hostname, _ := os.Hostname()
+		announceMu.Lock()
+		competition := announced.Competition
+		announceMu.Unlock()
+		list = append(list, DiscoveredServer{Name: s.ServerName, Version: version, Competition: competition, Host: hostname, LastSeen: time.Now(), Self: true})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// List returns the discovered clients sorted by name, each marked with
+// whether it is connected.
+func (s *ClientScanner) List() []DiscoveredClient {
+	connected := make(map[string]bool)
+	for _, c := range s.Hub.ClientList() {
+		connected[c.ID] = true
+	}
+	s.mu.Lock()
+	list := make([]DiscoveredClient, 0, len(s.seen))
+	for _, c := range s.seen {
+		c.Connected = connected[c.ID]
+		list = append(list, c)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// registerDiscoveredAPI serves the clients and servers found on the network.
+func registerDiscoveredAPI(scanner *ClientScanner) {
+	// GET /api/clients/discovered -> [DiscoveredClient]
+	http.HandleFunc("GET /api/clients/discovered", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scanner.List())
+	})
+
+	// GET /api/servers -> [DiscoveredServer]
+	http.HandleFunc("GET /api/servers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scanner.Servers())
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"display/internal/protocol"
+)
+
+// Connections are tracked per client ID in memory, with or without
+// historyDB, so a display that keeps dropping off the network can be found
+// while the event runs: GET /api/clients/{id}/history.
+const (
+	maxTrackedClients     = 1000 // IDs kept; the longest unseen are forgotten first
+	maxTrackedConnections = 50   // Latest connections kept per ID
+	maxNetworkEvents      = 50   // Latest network watchdog events kept per ID
+)
+
+// ConnectionRecord is one connection of a client.
+type ConnectionRecord struct {
+	Addr           string     `json:"addr"`
+	Transport      string     `json:"transport"`
+	ConnectedAt    time.Time  `json:"connectedAt"`
+	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"` // nil while connected
+	Seconds        float64    `json:"seconds"`                  // How long it lasted, so far while connected
+}
+
+// AddressChange is when a client first connected from a new IP address.
+type AddressChange struct {
+	IP    string    `json:"ip"`
+	Since time.Time `json:"since"`
+}
+
+// ClientHistory is the answer of GET /api/clients/{id}/history.
+type ClientHistory struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Connected   bool               `json:"connected"`
+	FirstSeen   time.Time          `json:"firstSeen"`
+	LastSeen    time.Time          `json:"lastSeen"`
+	Connects    int                `json:"connects"`
+	Reconnects  int                `json:"reconnects"` // Connects after the first
+	Addresses   []AddressChange    `json:"addresses"`
+	Connections []ConnectionRecord `json:"connections"` // The latest maxTrackedConnections, oldest first
+	// What the display's network watchdog reported, the latest
+	// maxNetworkEvents, oldest first
+	NetworkEvents []protocol.NetworkEvent `json:"networkEvents,omitempty"`
+}
+
+// clientTracker records connections by client ID since the server started.
+type clientTracker struct {
+	mu      sync.Mutex
+	clients map[string]*ClientHistory
+}
+
+// connected records that a connection from addr is listed under id.
+func (t *clientTracker) connected(id, name, addr, transport string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[string]*ClientHistory)
+	}
+	c := t.clients[id]
+	if c == nil {
+		t.evict()
+		c = &ClientHistory{ID: id, FirstSeen: at}
+		t.clients[id] = c
+	}
+	c.Name, c.LastSeen = name, at
+	c.Connects++
+	c.Reconnects = c.Connects - 1
+	ip := splitHostPortSafe(addr)
+	if n := len(c.Addresses); n == 0 || !sameHost(c.Addresses[n-1].IP, ip) {
+		c.Addresses = append(c.Addresses, AddressChange{IP: ip, Since: at})
+	}
+	c.Connections = append(c.Connections, ConnectionRecord{Addr: addr, Transport: transport, ConnectedAt: at})
+	if len(c.Connections) > maxTrackedConnections {
+		c.Connections = c.Connections[len(c.Connections)-maxTrackedConnections:]
+	}
+}
+
+// disconnected ends the connection of id that started at connectedAt.
+func (t *clientTracker) disconnected(id string, connectedAt, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[id]
+	if c == nil {
+		return
+	}
+	c.LastSeen = at
+	for i := range c.Connections {
+		if rec := &c.Connections[i]; rec.ConnectedAt.Equal(connectedAt) && rec.DisconnectedAt == nil {
+			rec.DisconnectedAt = &at
+		}
+	}
+}
+
+// networkEvents records what the network watchdog of id reported.
+func (t *clientTracker) networkEvents(id string, events []protocol.NetworkEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[id]
+	if c == nil {
+		return
+	}
+	c.NetworkEvents = append(c.NetworkEvents, events...)
+	if len(c.NetworkEvents) > maxNetworkEvents {
+		c.NetworkEvents = c.NetworkEvents[len(c.NetworkEvents)-maxNetworkEvents:]
+	}
+}
+
+// evict forgets the longest unseen ID without an open connection once the
+// limit is reached. Caller holds t.mu.
+func (t *clientTracker) evict() {
+	if len(t.clients) < maxTrackedClients {
+		return
+	}
+	var oldest *ClientHistory
+	for _, c := range t.clients {
+		if !c.open() && (oldest == nil || c.LastSeen.Before(oldest.LastSeen)) {
+			oldest = c
+		}
+	}
+	if oldest != nil {
+		delete(t.clients, oldest.ID)
+	}
+}
+
+// open reports whether a connection of c is still open.
+func (c *ClientHistory) open() bool {
+	for _, rec := range c.Connections {
+		if rec.DisconnectedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns a copy of the history of id, or false if it never connected.
+func (t *clientTracker) get(id string, now time.Time) (ClientHistory, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[id]
+	if c == nil {
+		return ClientHistory{}, false
+	}
+	out := *c
+	out.Connected = c.open()
+	out.Addresses = append([]AddressChange(nil), c.Addresses...)
+	out.Connections = append([]ConnectionRecord(nil), c.Connections...)
+	out.NetworkEvents = append([]protocol.NetworkEvent(nil), c.NetworkEvents...)
+	for i := range out.Connections {
+		rec := &out.Connections[i]
+		end := now
+		if rec.DisconnectedAt != nil {
+			end = *rec.DisconnectedAt
+		}
+		rec.Seconds = end.Sub(rec.ConnectedAt).Round(time.Second).Seconds()
+	}
+	return out, true
+}
+
+func registerClientHistoryAPI(hub *Hub) {
+	// GET /api/clients/{id}/history -> ClientHistory
+	http.HandleFunc("GET /api/clients/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		history, ok := hub.tracker.get(r.PathValue("id"), time.Now())
+		if !ok {
+			http.Error(w, "Client not seen since the server started", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	})
+}
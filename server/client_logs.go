@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	logRequestTimeout = 15 * time.Second
+	maxLogUploadSize  = 2 << 20 // 2 MB
+)
+
+// logRequests tracks GET /api/clients/{id}/logs calls waiting for the client
+// to upload its log buffer. Keys are one-time upload tokens.
+type logRequests struct {
+	mu      sync.Mutex
+	pending map[string]chan []byte
+}
+
+func (lr *logRequests) add() (string, chan []byte) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	ch := make(chan []byte, 1)
+	lr.mu.Lock()
+	lr.pending[token] = ch
+	lr.mu.Unlock()
+	return token, ch
+}
+
+func (lr *logRequests) remove(token string) {
+	lr.mu.Lock()
+	delete(lr.pending, token)
+	lr.mu.Unlock()
+}
+
+func (lr *logRequests) deliver(token string, data []byte) bool {
+	lr.mu.Lock()
+	ch, ok := lr.pending[token]
+	delete(lr.pending, token) // Tokens are single use
+	lr.mu.Unlock()
+	if ok {
+		ch <- data
+	}
+	return ok
+}
+
+// registerLogAPI lets operators pull the recent log of a display without SSH.
+// The server sends request_logs over the WebSocket; the display client (or
+// the Tizen app) POSTs its log buffer to the one-time upload URL, and the
+// waiting GET returns it.
+func registerLogAPI(hub *Hub) {
+	requests := &logRequests{pending: make(map[string]chan []byte)}
+
+	// GET /api/clients/{id}/logs -> text/plain log of that client
+	http.HandleFunc("GET /api/clients/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		token, ch := requests.add()
+		defer requests.remove(token)
+
+		sent := hub.SendJSONTo(id, struct {
+			Type    string `json:"type"`
+			Payload struct {
+				RequestID string `json:"requestId"`
+				UploadURL string `json:"uploadUrl"`
+			} `json:"payload"`
+		}{
+			Type: "request_logs",
+			Payload: struct {
+				RequestID string `json:"requestId"`
+				UploadURL string `json:"uploadUrl"`
+			}{RequestID: token, UploadURL: "/api/logs/upload/" + url.PathEscape(token)},
+		})
+		if !sent {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+
+		select {
+		case data := <-ch:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(data)
+		case <-time.After(logRequestTimeout):
+			http.Error(w, "Client did not upload its log in time", http.StatusGatewayTimeout)
+		case <-r.Context().Done():
+		}
+	})
+
+	// POST /api/logs/upload/{token} (text body) from the display page. The
+	// page is served by the local client, so this is always cross-origin;
+	// the single-use token is what authorizes it.
+	http.HandleFunc("POST /api/logs/upload/{token}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLogUploadSize))
+		if err != nil {
+			http.Error(w, "Log too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !requests.deliver(r.PathValue("token"), data) {
+			http.Error(w, "Unknown or expired upload token", http.StatusNotFound)
+			return
+		}
+		slog.Info("Received client log upload", "bytes", len(data))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// When many displays reconnect at once (a switch rebooting, Wi-Fi coming
+// back) every one of them would announce itself to every other connection.
+// The first client event after a quiet period goes out at once; those
+// following within clientEventWindow are merged per client ID and sent
+// together when it ends, and as one client_list when more than
+// clientListThreshold clients changed.
+const (
+	clientEventWindow   = 250 * time.Millisecond
+	clientListThreshold = 10
+)
+
+var coalesceStats = expvar.NewMap("client_events")
+
+// pendingEvent is what happened to one client ID during the window.
+type pendingEvent struct {
+	listed bool // Others knew the ID when the window started
+	left   bool
+	info   ClientInfo
+}
+
+// clientEvents coalesces client_joined, client_left and client_updated.
+type clientEvents struct {
+	mu      sync.Mutex
+	open    bool // A window is running; events wait for flush
+	pending map[string]*pendingEvent
+	order   []string // IDs in the order they first changed
+}
+
+// emit sends eventType for info now, or merges it into the running window.
+func (e *clientEvents) emit(h *Hub, eventType string, info ClientInfo) {
+	e.mu.Lock()
+	if !e.open {
+		e.open = true
+		e.mu.Unlock()
+		time.AfterFunc(clientEventWindow, func() { e.flush(h) })
+		h.sendClientEvent(eventType, info)
+		return
+	}
+	defer e.mu.Unlock()
+	coalesceStats.Add("delayed", 1)
+	if e.pending == nil {
+		e.pending = make(map[string]*pendingEvent)
+	}
+	p := e.pending[info.ID]
+	if p == nil {
+		p = &pendingEvent{listed: eventType != "client_joined"}
+		e.pending[info.ID] = p
+		e.order = append(e.order, info.ID)
+	}
+	p.left = eventType == "client_left"
+	p.info = info
+}
+
+// flush sends what the window collected. The window stays open while events
+// keep coming, so a storm is sent in window-sized batches.
+func (e *clientEvents) flush(h *Hub) {
+	e.mu.Lock()
+	pending, order := e.pending, e.order
+	e.pending, e.order = nil, nil
+	if len(order) == 0 {
+		e.open = false
+		e.mu.Unlock()
+		return
+	}
+	time.AfterFunc(clientEventWindow, func() { e.flush(h) })
+	e.mu.Unlock()
+
+	if len(order) > clientListThreshold {
+		data, err := h.clientListMessage()
+		if err != nil {
+			slog.Error("Error marshaling client list", "err", err)
+			return
+		}
+		coalesceStats.Add("lists", 1)
+		slog.Debug("Client events coalesced into a client list", "clients", len(order))
+		h.broadcastData(data)
+		return
+	}
+	for _, id := range order {
+		p := pending[id]
+		switch {
+		case p.left && p.listed:
+			h.sendClientEvent("client_left", p.info)
+		case p.left:
+			// Joined and left within the window; nobody needs to know
+		case p.listed:
+			h.sendClientEvent("client_updated", p.info)
+		default:
+			h.sendClientEvent("client_joined", p.info)
+		}
+	}
+}
@@ -1,24 +1,947 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type ServerConfig struct {
-	ResultsDir string `json:"resultsDir"`
-	Language   string `json:"language"`
-	Port       int    `json:"port"`
+	ResultsDir string `json:"resultsDir" yaml:"resultsDir" toml:"resultsDir"`
+	// Further results folders by prefix, e.g. {"live": "//timing-pc/results",
+	// "archive": "D:/archive"}; their files are listed and served as live/<file>
+	ResultsAliases map[string]string `json:"resultsAliases" yaml:"resultsAliases" toml:"resultsAliases"`
+	Language       string            `json:"language" yaml:"language" toml:"language"`
+	Port           int               `json:"port" yaml:"port" toml:"port"`
+	ListenAddr     string            `json:"listenAddr" yaml:"listenAddr" toml:"listenAddr"`       // Bind address, e.g. "127.0.0.1" or a NIC IP (empty = all interfaces)
+	MaxClients     int               `json:"maxClients" yaml:"maxClients" toml:"maxClients"`       // 0 = default (100), negative = unlimited
+	TimerPresets   []int             `json:"timerPresets" yaml:"timerPresets" toml:"timerPresets"` // Minutes offered as quick-select buttons in the admin UI
+	UpdatesDir     string            `json:"updatesDir" yaml:"updatesDir" toml:"updatesDir"`       // Client builds served at /api/update/{os}/{arch}
+	LogLevel       string            `json:"logLevel" yaml:"logLevel" toml:"logLevel"`             // debug, info, warn or error
+	LogFormat      string            `json:"logFormat" yaml:"logFormat" toml:"logFormat"`          // text or json
+	LogDir         string            `json:"logDir" yaml:"logDir" toml:"logDir"`                   // Rotating server.log files are written here
+	// Level HTTP requests are logged at (e.g. "info" to see which displays
+	// fetch which results), or "off"; default debug
+	AccessLog string `json:"accessLog" yaml:"accessLog" toml:"accessLog"`
+	// What to do when a display can't keep up: disconnect, drop_oldest or grow
+	SlowClientPolicy string `json:"slowClientPolicy" yaml:"slowClientPolicy" toml:"slowClientPolicy"`
+	// Timeouts, message size and send buffer of display connections
+	Connections ConnectionOptions `json:"connections" yaml:"connections" toml:"connections"`
+	// Phones on /ws/spectate, counted apart from maxClients; 0 = default
+	// (500), negative = unlimited
+	MaxSpectators int `json:"maxSpectators" yaml:"maxSpectators" toml:"maxSpectators"`
+	// Shared secret the admin UI and score-displayctl must present; empty
+	// lets any browser on the network act as a controller
+	ControllerToken string `json:"controllerToken" yaml:"controllerToken" toml:"controllerToken"`
+	// Browser origins allowed to control besides the server's own address,
+	// localhost and private networks: host names ("scores.example.com",
+	// "*.example.com") or origins ("https://scores.example.com:8443"), e.g.
+	// the public name of a reverse proxy
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins" toml:"allowedOrigins"`
+	// Accept every origin, for closed networks with nothing else on them
+	DisableOriginCheck bool `json:"disableOriginCheck" yaml:"disableOriginCheck" toml:"disableOriginCheck"`
+	// HTTPS with Let's Encrypt certificates for these domains
+	ACME ACMEOptions `json:"acme" yaml:"acme" toml:"acme"`
+	// Base path and trusted forwarded headers behind a reverse proxy
+	Proxy ProxyOptions `json:"proxy" yaml:"proxy" toml:"proxy"`
+	// SQLite file recording result switches, timer events and client sessions; empty disables it
+	HistoryDB string `json:"historyDB" yaml:"historyDB" toml:"historyDB"`
+	// Result pages downloaded into the results folder, for timing systems
+	// that publish on the web instead of sharing a folder
+	RemoteSources []RemoteSource `json:"remoteSources" yaml:"remoteSources" toml:"remoteSources"`
+	// Strip scripts, meta refresh and external resources from served HTML
+	// results, for exports from sources that are not trusted
+	SanitizeHTML bool `json:"sanitizeHTML" yaml:"sanitizeHTML" toml:"sanitizeHTML"`
+	// Serve /debug/pprof/ and /api/debug/hub (with the controller token) to
+	// diagnose memory growth or a stuck broadcast while the server runs
+	DebugEndpoints bool `json:"debugEndpoints" yaml:"debugEndpoints" toml:"debugEndpoints"`
+	// Seconds each page of a PDF result is shown before the next (0 = default, 10)
+	PDFPageSeconds int `json:"pdfPageSeconds" yaml:"pdfPageSeconds" toml:"pdfPageSeconds"`
+	// How .csv results are rendered as tables
+	CSV CSVOptions `json:"csv" yaml:"csv" toml:"csv"`
+	// How start lists (IOF XML, or CSV) are shown as a next-starters screen
+	StartList StartListOptions `json:"startList" yaml:"startList" toml:"startList"`
+	// Page long HTML and text results one screen at a time
+	Pagination PaginationOptions `json:"pagination" yaml:"pagination" toml:"pagination"`
+	// Club and country logos shown in rendered tables and start lists
+	Logos LogoOptions `json:"logos" yaml:"logos" toml:"logos"`
+	// Surname casing, club abbreviations and name widths per display profile
+	Formatting FormatOptions `json:"formatting" yaml:"formatting" toml:"formatting"`
+	// Rooms ("" = default room) that switch to each new or updated result
+	// file, mapped to a glob the file name must match ("" = any)
+	FollowNewest map[string]string `json:"followNewest" yaml:"followNewest" toml:"followNewest"`
+	// Rooms ("" = default room) whose displays switch their screens on and
+	// off, and dim them, on a daily schedule they keep following while the
+	// server is down (power_schedule.go)
+	PowerSchedules map[string]PowerSchedule `json:"powerSchedules" yaml:"powerSchedules" toml:"powerSchedules"`
+	// Send displays only the table rows that changed when an HTML result
+	// is updated in place, instead of reloading it (result_diff.go)
+	ResultDiff bool `json:"resultDiff" yaml:"resultDiff" toml:"resultDiff"`
+	// Milliseconds a new or changed result must keep its size before a
+	// followed room shows it (0 = default, 1000; negative = don't wait)
+	FileStableMs int `json:"fileStableMs" yaml:"fileStableMs" toml:"fileStableMs"`
+	// How displays find the server: auto (mDNS and UDP broadcast, the
+	// default), mdns or udp
+	Discovery string `json:"discovery" yaml:"discovery" toml:"discovery"`
+	// Name this server is announced under, so displays can prefer it when
+	// several servers (e.g. test and production) are on the network;
+	// default the host name
+	ServerName string `json:"serverName" yaml:"serverName" toml:"serverName"`
+	// Event the server is running, announced to displays, which show it
+	CompetitionName string `json:"competitionName" yaml:"competitionName" toml:"competitionName"`
+	// Periods of a match, for the timer's "next period"
+	MatchFlow MatchFlow `json:"matchFlow" yaml:"matchFlow" toml:"matchFlow"`
+	// Sport profiles (<name>.json) in addition to the built-in ones
+	SportsDir string `json:"sportsDir" yaml:"sportsDir" toml:"sportsDir"`
+	// Scene recalled in rooms left without activity, e.g.
+	// {"minutes": 45, "scene": "Sponsors"}
+	IdleFallback IdleFallback `json:"idleFallback" yaml:"idleFallback" toml:"idleFallback"`
+	// Mirror another server and take over its displays when it stops
+	// answering, e.g. {"primary": "http://192.168.1.10:8080"}
+	Standby StandbyOptions `json:"standby" yaml:"standby" toml:"standby"`
+}
+
+// configCandidates are tried in order when no config path is given.
+var configCandidates = []string{"server.json", "server.yaml", "server.yml", "server.toml"}
+
+// findConfigFile returns the first existing default config file, or
+// server.json if none exists yet.
+func findConfigFile() string {
+	for _, name := range configCandidates {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return configCandidates[0]
 }
 
+// loadConfig reads a JSON, YAML or TOML config file (chosen by extension),
+// rejecting unknown keys so typos don't silently fall back to defaults.
 func loadConfig(path string) (*ServerConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	var cfg ServerConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+			return nil, err
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown setting %q", undecoded[0].String())
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, describeJSONError(data, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (use .json, .yaml or .toml)", ext)
+	}
+	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
 }
+
+// describeJSONError adds line/column information to encoding/json errors,
+// which otherwise only report a byte offset.
+func describeJSONError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		// Unknown fields and truncated input carry no offset.
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// validate checks value ranges and reports every problem at once.
+func (cfg *ServerConfig) validate() error {
+	var problems []string
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port: %d is outside 1-65535", cfg.Port))
+	}
+	if cfg.ListenAddr != "" && net.ParseIP(cfg.ListenAddr) == nil {
+		problems = append(problems, fmt.Sprintf("listenAddr: %q is not an IP address (e.g. \"127.0.0.1\")", cfg.ListenAddr))
+	}
+	if cfg.Language != "" && !languagePattern.MatchString(cfg.Language) {
+		problems = append(problems, fmt.Sprintf("language: %q is not a language code like \"en\" or \"sv\"", cfg.Language))
+	}
+	if cfg.LogLevel != "" {
+		if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+			problems = append(problems, "logLevel: "+err.Error())
+		}
+	}
+	if cfg.LogFormat != "" && cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("logFormat: %q must be \"text\" or \"json\"", cfg.LogFormat))
+	}
+	if cfg.AccessLog != "" {
+		if _, _, err := parseAccessLog(cfg.AccessLog); err != nil {
+			problems = append(problems, "accessLog: "+err.Error())
+		}
+	}
+	if cfg.SlowClientPolicy != "" {
+		if _, err := parseSlowClientPolicy(cfg.SlowClientPolicy); err != nil {
+			problems = append(problems, "slowClientPolicy: "+err.Error())
+		}
+	}
+	if err := cfg.Connections.validate(); err != nil {
+		problems = append(problems, "connections: "+err.Error())
+	}
+	if err := cfg.ACME.validate(); err != nil {
+		problems = append(problems, "acme: "+err.Error())
+	}
+	if err := cfg.Proxy.validate(); err != nil {
+		problems = append(problems, "proxy: "+err.Error())
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if err := validateAllowedOrigin(origin); err != nil {
+			problems = append(problems, "allowedOrigins: "+err.Error())
+		}
+	}
+	for alias, dir := range cfg.ResultsAliases {
+		if err := validateRoomName(alias); err != nil || alias == "" {
+			problems = append(problems, fmt.Sprintf("resultsAliases: %q must be a plain folder name", alias))
+		} else if dir == "" {
+			problems = append(problems, fmt.Sprintf("resultsAliases: %q has no folder", alias))
+		}
+	}
+	if cfg.FileStableMs > maxFileStableMs {
+		problems = append(problems, fmt.Sprintf("fileStableMs: %d must be at most %d", cfg.FileStableMs, maxFileStableMs))
+	}
+	if cfg.PDFPageSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("pdfPageSeconds: %d must not be negative", cfg.PDFPageSeconds))
+	}
+	if err := cfg.CSV.validate(); err != nil {
+		problems = append(problems, "csv: "+err.Error())
+	}
+	if err := cfg.StartList.validate(); err != nil {
+		problems = append(problems, "startList: "+err.Error())
+	}
+	if err := cfg.Pagination.validate(); err != nil {
+		problems = append(problems, "pagination: "+err.Error())
+	}
+	if err := cfg.Logos.validate(); err != nil {
+		problems = append(problems, "logos: "+err.Error())
+	}
+	if err := cfg.Formatting.validate(); err != nil {
+		problems = append(problems, "formatting: "+err.Error())
+	}
+	if err := cfg.MatchFlow.validate(); err != nil {
+		problems = append(problems, "matchFlow: "+err.Error())
+	}
+	if err := cfg.IdleFallback.validate(); err != nil {
+		problems = append(problems, "idleFallback: "+err.Error())
+	}
+	if err := cfg.Standby.validate(); err != nil {
+		problems = append(problems, "standby: "+err.Error())
+	}
+	problems = append(problems, validateFollowNewest(cfg.FollowNewest)...)
+	problems = append(problems, validatePowerSchedules(cfg.PowerSchedules)...)
+	for i, src := range cfg.RemoteSources {
+		if err := src.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("remoteSources[%d]: %v", i, err))
+		}
+	}
+	for _, m := range cfg.TimerPresets {
+		if m <= 0 {
+			problems = append(problems, fmt.Sprintf("timerPresets: %d is not a positive number of minutes", m))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Za-z]{2})?$`)
+
+// Settings are the effective values after applying defaults, the config
+// file and command-line flags (in that order).
+type Settings struct {
+	ResultsDir       string
+	ResultsAliases   map[string]string
+	Language         string
+	Port             int
+	ListenAddr       string
+	MaxClients       int
+	MaxSpectators    int
+	TimerPresets     []int
+	UpdatesDir       string
+	LogLevel         string
+	LogFormat        string
+	LogDir           string
+	AccessLog        string
+	SlowClientPolicy SlowClientPolicy
+	Connections      ConnectionOptions
+	ControllerToken  string
+	Origins          OriginPolicy
+	Proxy            ProxyOptions
+	ACME             ACMEOptions
+	HistoryDB        string
+	RemoteSources    []RemoteSource
+	SanitizeHTML     bool
+	DebugEndpoints   bool
+	PDFPageSeconds   int
+	CSV              CSVOptions
+	StartList        StartListOptions
+	Pagination       PaginationOptions
+	Logos            LogoOptions
+	Formatting       FormatOptions
+	FollowNewest     map[string]string
+	PowerSchedules   map[string]PowerSchedule
+	ResultDiff       bool
+	FileStableMs     int // 0 = don't wait
+	Discovery        string
+	ServerName       string
+	CompetitionName  string
+	MatchFlow        MatchFlow
+	SportsDir        string
+	IdleFallback     IdleFallback
+	Standby          StandbyOptions
+}
+
+// Overrides holds values that take precedence over the config file, taken
+// from command-line flags or SCORE_DISPLAY_* environment variables. Zero
+// values mean "not set".
+type Overrides struct {
+	ResultsDir         string
+	ResultsAliases     map[string]string
+	Language           string
+	Port               int
+	ListenAddr         string
+	MaxClients         int // Same meaning as ServerConfig.MaxClients
+	MaxSpectators      int // Config file only, same meaning as ServerConfig.MaxSpectators
+	TimerPresets       []int
+	UpdatesDir         string
+	LogLevel           string
+	LogFormat          string
+	LogDir             string
+	AccessLog          string
+	SlowClientPolicy   string
+	Connections        *ConnectionOptions // Config file only
+	ControllerToken    string
+	AllowedOrigins     []string
+	DisableOriginCheck *bool         // nil = not set
+	Proxy              *ProxyOptions // Config file only
+	ACME               *ACMEOptions  // Config file only
+	HistoryDB          string
+	RemoteSources      []RemoteSource // Config file only
+	SanitizeHTML       *bool          // nil = not set
+	DebugEndpoints     *bool          // nil = not set
+	PDFPageSeconds     int
+	CSV                *CSVOptions              // Config file only
+	StartList          *StartListOptions        // Config file only
+	Pagination         *PaginationOptions       // Config file only
+	Logos              *LogoOptions             // Config file only
+	Formatting         *FormatOptions           // Config file only
+	FollowNewest       map[string]string        // Config file only
+	PowerSchedules     map[string]PowerSchedule // Config file only
+	ResultDiff         *bool                    // Config file only
+	FileStableMs       int                      // Config file only, same meaning as ServerConfig.FileStableMs
+	Discovery          string
+	ServerName         string
+	CompetitionName    string
+	MatchFlow          *MatchFlow // Config file only
+	SportsDir          string
+	IdleFallback       *IdleFallback   // Config file only
+	Standby            *StandbyOptions // Set fields replace the config file's
+}
+
+// Environment variables recognised by envOverrides.
+const (
+	envConfig       = "SCORE_DISPLAY_CONFIG"
+	envResultsDir   = "SCORE_DISPLAY_RESULTS_DIR"
+	envAliases      = "SCORE_DISPLAY_RESULTS_ALIASES" // Comma separated prefix=folder, e.g. "live=/mnt/live,archive=/srv/archive"
+	envLanguage     = "SCORE_DISPLAY_LANG"
+	envPort         = "SCORE_DISPLAY_PORT"
+	envListenAddr   = "SCORE_DISPLAY_LISTEN_ADDR"
+	envMaxClients   = "SCORE_DISPLAY_MAX_CLIENTS"
+	envTimerPresets = "SCORE_DISPLAY_TIMER_PRESETS" // Comma separated minutes, e.g. "10,15,20"
+	envUpdatesDir   = "SCORE_DISPLAY_UPDATES_DIR"
+	envLogLevel     = "SCORE_DISPLAY_LOG_LEVEL"
+	envLogFormat    = "SCORE_DISPLAY_LOG_FORMAT"
+	envLogDir       = "SCORE_DISPLAY_LOG_DIR"
+	envAccessLog    = "SCORE_DISPLAY_ACCESS_LOG" // off or a log level
+	envSlowClient   = "SCORE_DISPLAY_SLOW_CLIENT_POLICY"
+	envToken        = "SCORE_DISPLAY_CONTROLLER_TOKEN"
+	envOrigins      = "SCORE_DISPLAY_ALLOWED_ORIGINS"      // Comma separated, e.g. "scores.example.com,*.club.se"
+	envNoOrigins    = "SCORE_DISPLAY_DISABLE_ORIGIN_CHECK" // true or false
+	envHistoryDB    = "SCORE_DISPLAY_HISTORY_DB"
+	envSanitizeHTML = "SCORE_DISPLAY_SANITIZE_HTML"   // true or false
+	envDebug        = "SCORE_DISPLAY_DEBUG_ENDPOINTS" // true or false
+	envPDFPage      = "SCORE_DISPLAY_PDF_PAGE_SECONDS"
+	envDiscovery    = "SCORE_DISPLAY_DISCOVERY" // auto, mdns or udp
+	envServerName   = "SCORE_DISPLAY_SERVER_NAME"
+	envCompetition  = "SCORE_DISPLAY_COMPETITION_NAME"
+	envSportsDir    = "SCORE_DISPLAY_SPORTS_DIR"
+	envStandby      = "SCORE_DISPLAY_STANDBY" // The primary's address
+)
+
+// envOverrides reads the SCORE_DISPLAY_* environment variables, which
+// override both server.json and command-line flags so containers and systemd
+// units can configure the server without editing files.
+func envOverrides() (Overrides, error) {
+	o := Overrides{
+		ResultsDir:       os.Getenv(envResultsDir),
+		Language:         os.Getenv(envLanguage),
+		ListenAddr:       os.Getenv(envListenAddr),
+		UpdatesDir:       os.Getenv(envUpdatesDir),
+		LogLevel:         os.Getenv(envLogLevel),
+		LogFormat:        os.Getenv(envLogFormat),
+		LogDir:           os.Getenv(envLogDir),
+		AccessLog:        os.Getenv(envAccessLog),
+		SlowClientPolicy: os.Getenv(envSlowClient),
+		ControllerToken:  os.Getenv(envToken),
+		HistoryDB:        os.Getenv(envHistoryDB),
+		Discovery:        os.Getenv(envDiscovery),
+		ServerName:       os.Getenv(envServerName),
+		CompetitionName:  os.Getenv(envCompetition),
+		SportsDir:        os.Getenv(envSportsDir),
+	}
+	if v := os.Getenv(envStandby); v != "" {
+		o.Standby = &StandbyOptions{Primary: v}
+	}
+	if v := os.Getenv(envPort); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return o, fmt.Errorf("%s=%q is not a valid port", envPort, v)
+		}
+		o.Port = port
+	}
+	if v := os.Getenv(envMaxClients); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return o, fmt.Errorf("%s=%q is not a number", envMaxClients, v)
+		}
+		o.MaxClients = n
+	}
+	if v := os.Getenv(envPDFPage); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return o, fmt.Errorf("%s=%q must be a positive number of seconds", envPDFPage, v)
+		}
+		o.PDFPageSeconds = n
+	}
+	if v := os.Getenv(envSanitizeHTML); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("%s=%q must be true or false", envSanitizeHTML, v)
+		}
+		o.SanitizeHTML = &b
+	}
+	if v := os.Getenv(envDebug); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("%s=%q must be true or false", envDebug, v)
+		}
+		o.DebugEndpoints = &b
+	}
+	if v := os.Getenv(envNoOrigins); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("%s=%q must be true or false", envNoOrigins, v)
+		}
+		o.DisableOriginCheck = &b
+	}
+	if v := os.Getenv(envOrigins); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			origin := strings.TrimSpace(part)
+			if err := validateAllowedOrigin(origin); err != nil {
+				return o, fmt.Errorf("%s: %w", envOrigins, err)
+			}
+			o.AllowedOrigins = append(o.AllowedOrigins, origin)
+		}
+	}
+	if v := os.Getenv(envAliases); v != "" {
+		o.ResultsAliases = make(map[string]string)
+		for _, part := range strings.Split(v, ",") {
+			alias, dir, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || validateRoomName(alias) != nil || alias == "" || dir == "" {
+				return o, fmt.Errorf("%s=%q must be a comma separated list of prefix=folder", envAliases, v)
+			}
+			o.ResultsAliases[alias] = dir
+		}
+	}
+	if v := os.Getenv(envTimerPresets); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			m, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || m <= 0 {
+				return o, fmt.Errorf("%s=%q must be a comma separated list of minutes", envTimerPresets, v)
+			}
+			o.TimerPresets = append(o.TimerPresets, m)
+		}
+	}
+	return o, nil
+}
+
+func (s *Settings) apply(o Overrides) {
+	if o.ResultsDir != "" {
+		s.ResultsDir = o.ResultsDir
+	}
+	if o.ResultsAliases != nil {
+		s.ResultsAliases = o.ResultsAliases
+	}
+	if o.RemoteSources != nil {
+		s.RemoteSources = o.RemoteSources
+	}
+	if o.CSV != nil {
+		s.CSV = *o.CSV
+	}
+	if o.StartList != nil {
+		s.StartList = *o.StartList
+	}
+	if o.Pagination != nil {
+		s.Pagination = *o.Pagination
+	}
+	if o.Logos != nil {
+		s.Logos = *o.Logos
+	}
+	if o.Formatting != nil {
+		s.Formatting = *o.Formatting
+	}
+	if o.MatchFlow != nil {
+		s.MatchFlow = *o.MatchFlow
+	}
+	if o.IdleFallback != nil {
+		s.IdleFallback = *o.IdleFallback
+	}
+	if o.FollowNewest != nil {
+		s.FollowNewest = o.FollowNewest
+	}
+	if o.PowerSchedules != nil {
+		s.PowerSchedules = o.PowerSchedules
+	}
+	if o.ResultDiff != nil {
+		s.ResultDiff = *o.ResultDiff
+	}
+	if o.FileStableMs > 0 {
+		s.FileStableMs = o.FileStableMs
+	} else if o.FileStableMs < 0 {
+		s.FileStableMs = 0 // Don't wait
+	}
+	if o.PDFPageSeconds > 0 {
+		s.PDFPageSeconds = o.PDFPageSeconds
+	}
+	if o.SanitizeHTML != nil {
+		s.SanitizeHTML = *o.SanitizeHTML
+	}
+	if o.DebugEndpoints != nil {
+		s.DebugEndpoints = *o.DebugEndpoints
+	}
+	if o.Language != "" {
+		s.Language = o.Language
+	}
+	if o.Port != 0 {
+		s.Port = o.Port
+	}
+	if o.ListenAddr != "" {
+		s.ListenAddr = o.ListenAddr
+	}
+	if o.MaxClients > 0 {
+		s.MaxClients = o.MaxClients
+	} else if o.MaxClients < 0 {
+		s.MaxClients = 0 // Unlimited
+	}
+	if o.MaxSpectators > 0 {
+		s.MaxSpectators = o.MaxSpectators
+	} else if o.MaxSpectators < 0 {
+		s.MaxSpectators = 0 // Unlimited
+	}
+	if o.TimerPresets != nil {
+		s.TimerPresets = o.TimerPresets
+	}
+	if o.UpdatesDir != "" {
+		s.UpdatesDir = o.UpdatesDir
+	}
+	if o.LogLevel != "" {
+		s.LogLevel = o.LogLevel
+	}
+	if o.LogFormat != "" {
+		s.LogFormat = o.LogFormat
+	}
+	if o.LogDir != "" {
+		s.LogDir = o.LogDir
+	}
+	if o.AccessLog != "" {
+		s.AccessLog = o.AccessLog
+	}
+	if o.SlowClientPolicy != "" {
+		s.SlowClientPolicy = SlowClientPolicy(o.SlowClientPolicy)
+	}
+	if o.Connections != nil {
+		s.Connections = *o.Connections
+	}
+	if o.ControllerToken != "" {
+		s.ControllerToken = o.ControllerToken
+	}
+	if o.Proxy != nil {
+		s.Proxy = *o.Proxy
+	}
+	if o.ACME != nil {
+		s.ACME = *o.ACME
+	}
+	if o.AllowedOrigins != nil {
+		s.Origins.Allowed = o.AllowedOrigins
+	}
+	if o.DisableOriginCheck != nil {
+		s.Origins.Disabled = *o.DisableOriginCheck
+	}
+	if o.HistoryDB != "" {
+		s.HistoryDB = o.HistoryDB
+	}
+	if o.Discovery != "" {
+		s.Discovery = o.Discovery
+	}
+	if o.ServerName != "" {
+		s.ServerName = o.ServerName
+	}
+	if o.CompetitionName != "" {
+		s.CompetitionName = o.CompetitionName
+	}
+	if o.SportsDir != "" {
+		s.SportsDir = o.SportsDir
+	}
+	if o.Standby != nil {
+		if o.Standby.Primary != "" {
+			s.Standby.Primary = o.Standby.Primary
+		}
+		if o.Standby.TakeoverSeconds != 0 {
+			s.Standby.TakeoverSeconds = o.Standby.TakeoverSeconds
+		}
+	}
+}
+
+// resolveSettings applies defaults, then the config file, then flags, then
+// environment variables.
+func resolveSettings(cfg *ServerConfig, flags, env Overrides) (Settings, error) {
+	s := Settings{
+		ResultsDir:       "./results",
+		Language:         "en",
+		Port:             8080,
+		MaxClients:       100,
+		MaxSpectators:    defaultMaxSpectators,
+		PDFPageSeconds:   defaultPDFPageSeconds,
+		FileStableMs:     defaultFileStableMs,
+		UpdatesDir:       "./updates",
+		LogLevel:         "info",
+		LogFormat:        "text",
+		LogDir:           "./logs",
+		AccessLog:        "debug",
+		SlowClientPolicy: SlowClientDisconnect,
+		Discovery:        discoveryAuto,
+		SportsDir:        "./sports",
+	}
+
+	if cfg != nil {
+		s.apply(Overrides{
+			ResultsDir:         cfg.ResultsDir,
+			ResultsAliases:     cfg.ResultsAliases,
+			Language:           cfg.Language,
+			Port:               cfg.Port,
+			ListenAddr:         cfg.ListenAddr,
+			MaxClients:         cfg.MaxClients,
+			MaxSpectators:      cfg.MaxSpectators,
+			TimerPresets:       cfg.TimerPresets,
+			UpdatesDir:         cfg.UpdatesDir,
+			LogLevel:           cfg.LogLevel,
+			LogFormat:          cfg.LogFormat,
+			LogDir:             cfg.LogDir,
+			AccessLog:          cfg.AccessLog,
+			SlowClientPolicy:   cfg.SlowClientPolicy,
+			Connections:        &cfg.Connections,
+			ControllerToken:    cfg.ControllerToken,
+			AllowedOrigins:     cfg.AllowedOrigins,
+			DisableOriginCheck: &cfg.DisableOriginCheck,
+			Proxy:              &cfg.Proxy,
+			ACME:               &cfg.ACME,
+			HistoryDB:          cfg.HistoryDB,
+			RemoteSources:      cfg.RemoteSources,
+			SanitizeHTML:       &cfg.SanitizeHTML,
+			DebugEndpoints:     &cfg.DebugEndpoints,
+			PDFPageSeconds:     cfg.PDFPageSeconds,
+			CSV:                &cfg.CSV,
+			StartList:          &cfg.StartList,
+			Pagination:         &cfg.Pagination,
+			Logos:              &cfg.Logos,
+			Formatting:         &cfg.Formatting,
+			FollowNewest:       cfg.FollowNewest,
+			PowerSchedules:     cfg.PowerSchedules,
+			ResultDiff:         &cfg.ResultDiff,
+			FileStableMs:       cfg.FileStableMs,
+			Discovery:          cfg.Discovery,
+			ServerName:         cfg.ServerName,
+			CompetitionName:    cfg.CompetitionName,
+			MatchFlow:          &cfg.MatchFlow,
+			SportsDir:          cfg.SportsDir,
+			IdleFallback:       &cfg.IdleFallback,
+			Standby:            &cfg.Standby,
+		})
+	}
+	s.apply(flags)
+	s.apply(env)
+
+	if s.ListenAddr != "" && net.ParseIP(s.ListenAddr) == nil {
+		return s, fmt.Errorf("invalid listen address %q: must be an IP address", s.ListenAddr)
+	}
+	if _, err := parseLogLevel(s.LogLevel); err != nil {
+		return s, err
+	}
+	if s.LogFormat != "text" && s.LogFormat != "json" {
+		return s, fmt.Errorf("unknown log format %q (use text or json)", s.LogFormat)
+	}
+	if _, _, err := parseAccessLog(s.AccessLog); err != nil {
+		return s, err
+	}
+	if _, err := parseSlowClientPolicy(string(s.SlowClientPolicy)); err != nil {
+		return s, err
+	}
+	if s.Discovery != discoveryAuto && s.Discovery != discoveryMDNS && s.Discovery != discoveryUDP {
+		return s, fmt.Errorf("unknown discovery %q (use auto, mdns or udp)", s.Discovery)
+	}
+	if s.ServerName == "" {
+		hostname, _ := os.Hostname()
+		s.ServerName, _, _ = strings.Cut(hostname, ".")
+		if s.ServerName == "" {
+			s.ServerName = "DisplayServer"
+		}
+	}
+	if err := validateServerName(s.ServerName); err != nil {
+		return s, err
+	}
+	if err := validateCompetitionName(s.CompetitionName); err != nil {
+		return s, err
+	}
+	if err := s.Standby.validate(); err != nil {
+		return s, fmt.Errorf("standby: %w", err)
+	}
+	return s, nil
+}
+
+// ensureResultsDir creates the results directory if it does not exist yet.
+func ensureResultsDir(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		slog.Info("Results directory does not exist, creating it", "dir", dir)
+		return os.MkdirAll(dir, 0755)
+	}
+	return nil
+}
+
+// ConfigManager owns the live settings and reloads the config file when it
+// changes, so language, results directory, MaxClients and timer presets can be
+// adjusted mid-event without a restart.
+type ConfigManager struct {
+	Path    string
+	Flags   Overrides
+	Env     Overrides
+	Hub     *Hub
+	Remote  *RemoteFetcher // Restarted when remoteSources change; may be nil
+	mu      sync.RWMutex
+	current Settings
+	modTime time.Time
+}
+
+// NewConfigManager loads the config file (a missing file is not an error) and
+// resolves the initial settings.
+func NewConfigManager(path string, flags, env Overrides) (*ConfigManager, error) {
+	cm := &ConfigManager{Path: path, Flags: flags, Env: env}
+	cfg, err := loadConfig(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		cm.modTime = info.ModTime()
+	}
+	settings, err := resolveSettings(cfg, flags, env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cm.current = settings
+	return cm, nil
+}
+
+// Current returns a copy of the live settings.
+func (cm *ConfigManager) Current() Settings {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.current
+}
+
+// Watch polls the config file for changes until stop is closed.
+func (cm *ConfigManager) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(cm.Path)
+			if err != nil {
+				continue
+			}
+			cm.mu.Lock()
+			changed := !info.ModTime().Equal(cm.modTime)
+			cm.modTime = info.ModTime() // Report a broken file only once
+			cm.mu.Unlock()
+			if changed {
+				if err := cm.Reload(); err != nil {
+					slog.Error("Config reload failed, keeping previous settings", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// Reload re-reads the config file and applies the settings that can change at
+// runtime. Port and listen address changes are logged and need a restart.
+func (cm *ConfigManager) Reload() error {
+	info, err := os.Stat(cm.Path)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(cm.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cm.Path, err)
+	}
+	next, err := resolveSettings(cfg, cm.Flags, cm.Env)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cm.Path, err)
+	}
+	if err := ensureResultsDir(next.ResultsDir); err != nil {
+		return fmt.Errorf("results directory: %w", err)
+	}
+
+	cm.mu.Lock()
+	prev := cm.current
+	cm.modTime = info.ModTime()
+	logOutputChanged := next.LogFormat != prev.LogFormat || next.LogDir != prev.LogDir
+	historyChanged := next.HistoryDB != prev.HistoryDB
+	discoveryChanged := next.Discovery != prev.Discovery || next.ServerName != prev.ServerName
+	proxyChanged := !reflect.DeepEqual(next.Proxy, prev.Proxy) || !reflect.DeepEqual(next.ACME, prev.ACME)
+	standbyChanged := next.Standby != prev.Standby
+	// Listener, proxy, discovery, standby, log output and history settings are fixed for the lifetime of the process.
+	next.Port = prev.Port
+	next.ListenAddr = prev.ListenAddr
+	next.Proxy = prev.Proxy
+	next.ACME = prev.ACME
+	next.Discovery = prev.Discovery
+	next.ServerName = prev.ServerName
+	next.LogFormat = prev.LogFormat
+	next.LogDir = prev.LogDir
+	next.HistoryDB = prev.HistoryDB
+	next.Standby = prev.Standby
+	cm.current = next
+	cm.mu.Unlock()
+
+	if cfg.Port != 0 && cfg.Port != prev.Port && cm.Flags.Port == 0 && cm.Env.Port == 0 {
+		slog.Warn("Config: port change requires a restart", "port", cfg.Port)
+	}
+	if cfg.ListenAddr != prev.ListenAddr && cm.Flags.ListenAddr == "" && cm.Env.ListenAddr == "" {
+		slog.Warn("Config: listenAddr change requires a restart", "listenAddr", cfg.ListenAddr)
+	}
+	if logOutputChanged {
+		slog.Warn("Config: logFormat/logDir changes require a restart")
+	}
+	if historyChanged {
+		slog.Warn("Config: historyDB change requires a restart")
+	}
+	if discoveryChanged {
+		slog.Warn("Config: discovery/serverName change requires a restart")
+	}
+	if proxyChanged {
+		slog.Warn("Config: proxy/acme change requires a restart")
+	}
+	if standbyChanged {
+		slog.Warn("Config: standby change requires a restart")
+	}
+
+	if reflect.DeepEqual(prev, next) {
+		return nil
+	}
+	slog.Info("Config reloaded", "resultsDir", next.ResultsDir, "resultsAliases", next.ResultsAliases, "language", next.Language,
+		"maxClients", next.MaxClients, "maxSpectators", next.MaxSpectators, "timerPresets", next.TimerPresets, "logLevel", next.LogLevel, "accessLog", next.AccessLog,
+		"slowClientPolicy", next.SlowClientPolicy, "connections", next.Connections, "controllerToken", next.ControllerToken != "", "allowedOrigins", next.Origins.Allowed, "disableOriginCheck", next.Origins.Disabled, "remoteSources", len(next.RemoteSources), "sanitizeHTML", next.SanitizeHTML, "debugEndpoints", next.DebugEndpoints, "pdfPageSeconds", next.PDFPageSeconds, "pagination", next.Pagination.Enabled, "logoAliases", len(next.Logos.Aliases), "nameProfiles", len(next.Formatting.Profiles), "followNewest", next.FollowNewest, "powerSchedules", len(next.PowerSchedules), "resultDiff", next.ResultDiff, "fileStableMs", next.FileStableMs, "competitionName", next.CompetitionName, "matchFlow", next.MatchFlow.Periods, "sportsDir", next.SportsDir, "idleFallback", next.IdleFallback)
+	if level, err := parseLogLevel(next.LogLevel); err == nil {
+		logLevel.Set(level)
+	}
+	setAccessLog(next.AccessLog)
+
+	if cm.Hub != nil {
+		sports := loadSportProfiles(next.SportsDir)
+		cm.Hub.mu.Lock()
+		cm.Hub.MaxClients = next.MaxClients
+		cm.Hub.MaxSpectators = next.MaxSpectators
+		cm.Hub.SlowClientPolicy = next.SlowClientPolicy
+		cm.Hub.Connections = next.Connections
+		cm.Hub.ControllerToken = next.ControllerToken
+		cm.Hub.ResultsDir = next.ResultsDir
+		cm.Hub.ResultsAliases = next.ResultsAliases
+		cm.Hub.FollowNewest = next.FollowNewest
+		cm.Hub.PowerSchedules = next.PowerSchedules
+		cm.Hub.Formatting = next.Formatting
+		cm.Hub.ResultDiff = next.ResultDiff
+		cm.Hub.MatchFlow = next.MatchFlow
+		cm.Hub.IdleFallback = next.IdleFallback
+		cm.Hub.Sports = sports
+		cm.Hub.SportsDir = next.SportsDir
+		cm.Hub.mu.Unlock()
+		// Lets the admin UI refresh language, presets and the served path.
+		cm.Hub.BroadcastJSON(struct {
+			Type string `json:"type"`
+		}{Type: "config_changed"})
+	}
+	if cm.Hub != nil && !reflect.DeepEqual(next.PowerSchedules, prev.PowerSchedules) {
+		cm.Hub.pushPowerSchedules()
+	}
+	if cm.Hub != nil && !reflect.DeepEqual(next.Formatting, prev.Formatting) {
+		cm.Hub.pushDeliveries()
+		cm.Hub.splitsChanged(nil)
+	}
+	if cm.Remote != nil {
+		cm.Remote.Apply(next)
+	}
+	if next.CompetitionName != prev.CompetitionName {
+		setCompetitionName(next.CompetitionName)
+	}
+	setOriginPolicy(next.Origins)
+	return nil
+}
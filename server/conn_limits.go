@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of ConnectionOptions.
+const (
+	defaultPongWait       = 60 * time.Second
+	defaultWriteWait      = 10 * time.Second
+	defaultMaxMessageSize = 4096
+	defaultSendBuffer     = 256
+)
+
+// ConnectionOptions tune the display connections, for big venues and slow
+// networks. Zero values use the defaults. Changes apply to connections made
+// afterwards.
+type ConnectionOptions struct {
+	// Seconds without a pong (or any message) before a connection is dropped
+	PongWaitSeconds int `json:"pongWaitSeconds" yaml:"pongWaitSeconds" toml:"pongWaitSeconds"`
+	// Seconds one message may take to write before the connection is dropped
+	WriteWaitSeconds int `json:"writeWaitSeconds" yaml:"writeWaitSeconds" toml:"writeWaitSeconds"`
+	// Largest message a client may send, in bytes
+	MaxMessageSize int `json:"maxMessageSize" yaml:"maxMessageSize" toml:"maxMessageSize"`
+	// Messages that may wait for a slow client before slowClientPolicy applies
+	SendBuffer int `json:"sendBuffer" yaml:"sendBuffer" toml:"sendBuffer"`
+}
+
+func (o ConnectionOptions) validate() error {
+	minPongWait := int(2 * pingInterval / time.Second) // Room for a missed ping
+	switch {
+	case o.PongWaitSeconds != 0 && (o.PongWaitSeconds < minPongWait || o.PongWaitSeconds > 600):
+		return fmt.Errorf("pongWaitSeconds: %d is outside %d-600", o.PongWaitSeconds, minPongWait)
+	case o.WriteWaitSeconds < 0 || o.WriteWaitSeconds > 120:
+		return fmt.Errorf("writeWaitSeconds: %d is outside 1-120", o.WriteWaitSeconds)
+	case o.MaxMessageSize != 0 && (o.MaxMessageSize < 512 || o.MaxMessageSize > 1<<20):
+		return fmt.Errorf("maxMessageSize: %d is outside 512-1048576 bytes", o.MaxMessageSize)
+	case o.SendBuffer != 0 && (o.SendBuffer < 16 || o.SendBuffer > 65536):
+		return fmt.Errorf("sendBuffer: %d is outside 16-65536 messages", o.SendBuffer)
+	}
+	return nil
+}
+
+// connLimits are the effective ConnectionOptions a client is served with,
+// fixed when it connects.
+type connLimits struct {
+	pongWait       time.Duration
+	writeWait      time.Duration
+	maxMessageSize int64
+	sendBuffer     int
+}
+
+func (o ConnectionOptions) limits() connLimits {
+	l := connLimits{pongWait: defaultPongWait, writeWait: defaultWriteWait,
+		maxMessageSize: defaultMaxMessageSize, sendBuffer: defaultSendBuffer}
+	if o.PongWaitSeconds > 0 {
+		l.pongWait = time.Duration(o.PongWaitSeconds) * time.Second
+	}
+	if o.WriteWaitSeconds > 0 {
+		l.writeWait = time.Duration(o.WriteWaitSeconds) * time.Second
+	}
+	if o.MaxMessageSize > 0 {
+		l.maxMessageSize = int64(o.MaxMessageSize)
+	}
+	if o.SendBuffer > 0 {
+		l.sendBuffer = o.SendBuffer
+	}
+	return l
+}
+
+// newClient returns a client of h on transport with the current limits.
+func (h *Hub) newClient(conn Conn, addr, transport string) *Client {
+	h.mu.Lock()
+	limits := h.Connections.limits()
+	h.mu.Unlock()
+	return &Client{Hub: h, Conn: conn, Send: newSendQueue(limits.sendBuffer), Addr: addr, Transport: transport, limits: limits}
+}
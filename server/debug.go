@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof/ on http.DefaultServeMux
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startTime is when the process started, for the uptime in GET /api/debug/hub.
+var startTime = time.Now()
+
+// debugPaths are only served with debugEndpoints on, and then only with the
+// controller token, since profiles and the hub dump reveal more than the
+// admin UI does and a CPU profile or trace slows the server down while it runs.
+var debugPaths = []string{"/debug/pprof", "/api/debug/"}
+
+// debugGuard answers requests for debugPaths with 404 unless the
+// debugEndpoints setting is on, and with 401 without the controller token.
+func debugGuard(cfgMgr *ConfigManager, hub *Hub, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range debugPaths {
+			if !strings.HasPrefix(r.URL.Path, p) {
+				continue
+			}
+			if !cfgMgr.Current().DebugEndpoints {
+				http.NotFound(w, r)
+				return
+			}
+			if !requireController(hub, w, r) {
+				return
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HubDump is GET /api/debug/hub: what the hub holds and how the process is
+// doing, to tell a leak or a stuck broadcast from a busy event.
+type HubDump struct {
+	Uptime     string `json:"uptime"`
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heapAlloc"` // Bytes
+	HeapSys    uint64 `json:"heapSys"`   // Bytes
+	NumGC      uint32 `json:"numGC"`
+	// Queued targeted sends (Hub.SendTo); stays high when Run is stuck
+	SendToBacklog int          `json:"sendToBacklog"`
+	SendToCap     int          `json:"sendToCap"`
+	PendingAcks   int          `json:"pendingAcks"`
+	Listed        int          `json:"listed"` // Handshaken clients in Hub.byID
+	Rooms         []string     `json:"rooms"`
+	Clients       []ClientDump `json:"clients"`
+}
+
+// ClientDump is one connection in a HubDump.
+type ClientDump struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Addr      string         `json:"addr"`
+	Transport string         `json:"transport"`
+	Role      string         `json:"role"`
+	Room      string         `json:"room"`
+	Version   string         `json:"version"`
+	Queue     SendQueueStats `json:"queue"`
+}
+
+func (h *Hub) dump() HubDump {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	d := HubDump{
+		Uptime:        time.Since(startTime).Round(time.Second).String(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapSys:       mem.HeapSys,
+		NumGC:         mem.NumGC,
+		SendToBacklog: len(h.SendTo),
+		SendToCap:     cap(h.SendTo),
+		Rooms:         []string{},
+		Clients:       []ClientDump{},
+	}
+	h.acks.mu.Lock()
+	d.PendingAcks = len(h.acks.routes)
+	h.acks.mu.Unlock()
+
+	h.mu.Lock()
+	d.Listed = len(h.byID)
+	for name := range h.rooms {
+		d.Rooms = append(d.Rooms, name)
+	}
+	clients := make([]*Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		clients = append(clients, client)
+		d.Clients = append(d.Clients, ClientDump{
+			ID: client.ID, Name: client.Name, Addr: client.Addr, Transport: client.Transport,
+			Role: client.Role, Room: client.Room, Version: client.Version,
+		})
+	}
+	h.mu.Unlock()
+	// Queues have their own lock
+	for i, client := range clients {
+		d.Clients[i].Queue = client.Send.stats()
+	}
+	sort.Strings(d.Rooms)
+	sort.Slice(d.Clients, func(i, j int) bool { return d.Clients[i].Addr < d.Clients[j].Addr })
+	return d
+}
+
+// registerDebugAPI serves GET /api/debug/hub; debugGuard decides who may see it.
+func registerDebugAPI(hub *Hub) {
+	http.HandleFunc("GET /api/debug/hub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(hub.dump())
+	})
+}
@@ -1,30 +1,196 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
 	"os"
+	"strconv"
+	"sync"
 
 	"github.com/grandcat/zeroconf"
 )
 
 var server *zeroconf.Server
 
-func startDiscovery(port int) {
-	hostname, _ := os.Hostname()
-	// Service Name: DisplayServer
+// interfacesForAddr returns the network interface that owns listenAddr so mDNS
+// only advertises where the server is actually reachable. Nil means all.
+func interfacesForAddr(listenAddr string) []net.Interface {
+	ip := net.ParseIP(listenAddr)
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		slog.Warn("Failed to list network interfaces", "err", err)
+		return nil
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return []net.Interface{iface}
+			}
+		}
+	}
+	slog.Warn("No network interface found for listen address; advertising on all interfaces", "listenAddr", listenAddr)
+	return nil
+}
+
+// Discovery methods (server.json "discovery").
+const (
+	discoveryAuto = "auto" // mDNS and UDP broadcast
+	discoveryMDNS = "mdns"
+	discoveryUDP  = "udp"
+)
+
+// UDP broadcast discovery, for venue networks whose switches filter
+// multicast (and with it mDNS). A client broadcasts udpDiscoveryRequest to
+// udpDiscoveryPort and every server answers with a udpDiscoveryReply.
+// Must match client/discovery.go.
+const (
+	udpDiscoveryPort    = 8089
+	udpDiscoveryRequest = "score-display discover 1"
+)
+
+type udpDiscoveryReply struct {
+	Service     string `json:"service"` // Always "score-display"
+	Name        string `json:"name"`    // serverName
+	Host        string `json:"host"`
+	Addr        string `json:"addr,omitempty"` // listenAddr, if the server is bound to one address
+	Port        int    `json:"port"`
+	Version     string `json:"version"`
+	Protocol    int    `json:"protocol"`
+	TLS         bool   `json:"tls"`
+	Competition string `json:"competition,omitempty"`
+}
+
+// discoveryInfo is what the server announces about itself, in the mDNS TXT
+// record and in UDP discovery replies.
+type discoveryInfo struct {
+	Name        string // serverName, also the mDNS instance name
+	Competition string // competitionName; can change while the server runs
+	TLS         bool   // Whether displays must use https/wss; they are served plain HTTP even with acme (acme.go)
+}
+
+// text is the _display._tcp TXT record. txtv 1 added protocol, tls and
+// competition; clients ignore keys they do not know.
+func (d discoveryInfo) text() []string {
+	tls := "0"
+	if d.TLS {
+		tls = "1"
+	}
+	txt := []string{"txtv=1", "version=" + version, "protocol=" + strconv.Itoa(protocolVersion), "name=" + d.Name, "tls=" + tls}
+	if d.Competition != "" {
+		txt = append(txt, "competition="+d.Competition)
+	}
+	return txt
+}
+
+var (
+	udpConn    *net.UDPConn
+	announceMu sync.Mutex
+	announced  discoveryInfo
+)
+
+func startDiscovery(method string, info discoveryInfo, listenAddr string, port int) {
+	announceMu.Lock()
+	announced = info
+	announceMu.Unlock()
+	if method != discoveryUDP {
+		startMDNS(info, listenAddr, port)
+	}
+	if method != discoveryMDNS {
+		if err := startUDPDiscovery(listenAddr, port); err != nil {
+			if method == discoveryUDP {
+				fatal("Failed to start UDP discovery", "err", err)
+			}
+			slog.Warn("UDP discovery unavailable, using mDNS only", "port", udpDiscoveryPort, "err", err)
+		}
+	}
+}
+
+func startMDNS(info discoveryInfo, listenAddr string, port int) {
+	// Service Name: serverName (unique per server, or browsers merge them)
 	// Service Type: _display._tcp
 	// Domain: local.
 	var err error
-	server, err = zeroconf.Register("DisplayServer", "_display._tcp", "local.", port, []string{"txtv=0", "version=1.0"}, nil)
+	server, err = zeroconf.Register(info.Name, "_display._tcp", "local.", port, info.text(), interfacesForAddr(listenAddr))
+	if err != nil {
+		fatal("Failed to register mDNS service", "err", err)
+	}
+
+	slog.Info("mDNS service registered", "instance", info.Name+"._display._tcp.local.", "port", port, "competition", info.Competition)
+}
+
+// setCompetitionName announces a changed competitionName; displays pick it up
+// at their next discovery round.
+func setCompetitionName(name string) {
+	announceMu.Lock()
+	announced.Competition = name
+	info := announced
+	announceMu.Unlock()
+	if server != nil {
+		server.SetText(info.text())
+	}
+}
+
+// startUDPDiscovery answers discovery broadcasts. It listens on all
+// interfaces even with a listenAddr, since broadcasts are not delivered to
+// sockets bound to one address; the reply names the address instead.
+func startUDPDiscovery(listenAddr string, port int) error {
+	hostname, _ := os.Hostname()
+	addr := ""
+	if ip := net.ParseIP(listenAddr); ip != nil && !ip.IsUnspecified() {
+		addr = listenAddr
+	}
+	var err error
+	udpConn, err = net.ListenUDP("udp4", &net.UDPAddr{Port: udpDiscoveryPort})
 	if err != nil {
-		log.Fatalf("Failed to register mDNS service: %v", err)
+		return err
 	}
+	go serveUDPDiscovery(udpConn, func() udpDiscoveryReply {
+		announceMu.Lock()
+		defer announceMu.Unlock()
+		return udpDiscoveryReply{Service: "score-display", Name: announced.Name, Host: hostname, Addr: addr, Port: port,
+			Version: version, Protocol: protocolVersion, TLS: announced.TLS, Competition: announced.Competition}
+	})
+	slog.Info("UDP discovery listening", "port", udpDiscoveryPort)
+	return nil
+}
 
-	log.Printf("mDNS Service registered: %s._display._tcp.local. on port %d", hostname, port)
+func serveUDPDiscovery(conn *net.UDPConn, makeReply func() udpDiscoveryReply) {
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if string(buf[:n]) != udpDiscoveryRequest {
+			continue // Only answer our own request, so the port can't be used to reflect traffic
+		}
+		reply, err := json.Marshal(makeReply())
+		if err != nil {
+			continue
+		}
+		if _, err := conn.WriteToUDP(reply, from); err != nil {
+			slog.Debug("UDP discovery reply failed", "to", from.String(), "err", err)
+		}
+	}
 }
 
 func stopDiscovery() {
 	if server != nil {
 		server.Shutdown()
 	}
+	if udpConn != nil {
+		udpConn.Close()
+	}
 }
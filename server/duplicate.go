@@ -0,0 +1,57 @@
+package main
+
+import "time"
+
+// A display whose browser or client restarts reconnects with its ID before
+// the server notices the old socket is gone. The new connection takes over
+// from the old one instead of both being connected: it inherits the state
+// the server keeps per display, and the old one is closed.
+//
+// Only displays from the same address are taken over. Controllers share IDs
+// (every admin tab is "admin"), and two addresses with one ID are more
+// likely two displays cloned from one SD card, which would keep closing each
+// other; they are listed under the newest connection as before.
+
+// replaces reports whether client takes over from old. Caller holds h.mu.
+func (h *Hub) replaces(client, old *Client) bool {
+	return old != nil && old != client && old.ID == client.ID &&
+		old.Role == roleDisplay && client.Role == roleDisplay &&
+		sameHost(splitHostPortSafe(old.Addr), splitHostPortSafe(client.Addr))
+}
+
+// inherit copies what the server keeps for a display from the connection
+// client replaces, or from a restored backup (backup.go), before its room
+// state is sent. Caller holds h.mu.
+func (h *Hub) inherit(client *Client) {
+	old := h.byID[client.ID]
+	if !h.replaces(client, old) {
+		if d, ok := h.restored[client.ID]; ok && validateDisplayState(d.DisplayMode, d.ScreenPower) == nil {
+			if d.DisplayMode != "" {
+				client.DisplayMode = d.DisplayMode
+			}
+			client.ScreenPower = d.ScreenPower
+			delete(h.restored, client.ID)
+		}
+		return
+	}
+	client.DisplayMode = old.DisplayMode
+	client.ScreenPower = old.ScreenPower
+	client.Brightness, client.Volume = old.Brightness, old.Volume
+	if client.Health == nil {
+		client.Health = old.Health
+	}
+}
+
+// takeOver closes old, which client replaces, keeping its history session
+// and list entry for client; listClient then announces client_updated.
+// Caller holds h.mu and must turnAway old after unlocking.
+func (h *Hub) takeOver(client, old *Client) {
+	delete(h.Clients, old)
+	h.byID[client.ID] = client
+	h.tracker.disconnected(old.listedID, old.listedAt, time.Now())
+	old.listedID = "" // Its unregister no longer announces client_left
+	if client.session.IsZero() {
+		client.session, client.sessionID = old.session, old.sessionID
+	}
+	old.session, old.sessionID = time.Time{}, ""
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+
+	"display/internal/protocol"
+)
+
+// negotiateEncoding picks the binary encoding for a client from the ones its
+// handshake offers; "" keeps it on JSON. The SSE fallback is text only.
+func negotiateEncoding(offered []string, transport string) string {
+	if transport == transportWS && slices.Contains(offered, protocol.EncodingCBOR) {
+		return protocol.EncodingCBOR
+	}
+	return ""
+}
+
+// encodedBroadcast is one broadcast, with its binary form made on first use
+// and shared by every client that negotiated it. Only protocol.BinaryTypes
+// have one.
+type encodedBroadcast struct {
+	msg    []byte
+	binary []byte
+	done   bool
+}
+
+// forEncoding returns the message to queue for a client using encoding.
+func (b *encodedBroadcast) forEncoding(encoding string) []byte {
+	if encoding != protocol.EncodingCBOR {
+		return b.msg
+	}
+	if !b.done {
+		b.done = true
+		b.binary = binaryForm(b.msg)
+	}
+	if b.binary == nil {
+		return b.msg
+	}
+	return b.binary
+}
+
+// binaryForm is msg in CBOR if its type is sent in binary, else nil.
+func binaryForm(msg []byte) []byte {
+	var peek struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(msg, &peek) != nil || !protocol.BinaryTypes[peek.Type] {
+		return nil
+	}
+	data, err := protocol.JSONToCBOR(msg)
+	if err != nil {
+		slog.Error("Error encoding binary message", "type", peek.Type, "err", err)
+		return nil
+	}
+	return data
+}
+
+// isBinary tells writePump how to frame a queued message: the hub's JSON
+// messages are objects, so anything not starting with '{' is CBOR.
+func isBinary(msg []byte) bool {
+	return len(msg) > 0 && msg[0] != '{'
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go, so cross-compiling for Windows and ARM keeps working
+)
+
+// historyTimeFormat is fixed width so times sort correctly as text.
+const historyTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// historyQueueSize bounds writes waiting for the database. Writes are queued
+// so a slow disk never holds up the hub.
+const historyQueueSize = 1024
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id    INTEGER PRIMARY KEY,
+	time  TEXT NOT NULL,
+	kind  TEXT NOT NULL, -- result, timer_start, timer_pause, timer_reset, timer_finished
+	room  TEXT NOT NULL DEFAULT '',
+	file  TEXT NOT NULL DEFAULT '',
+	value TEXT NOT NULL DEFAULT '',
+	actor TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_time ON events(time);
+CREATE TABLE IF NOT EXISTS sessions (
+	id              INTEGER PRIMARY KEY,
+	client_id       TEXT NOT NULL,
+	name            TEXT NOT NULL,
+	role            TEXT NOT NULL,
+	room            TEXT NOT NULL DEFAULT '',
+	addr            TEXT NOT NULL,
+	version         TEXT NOT NULL DEFAULT '',
+	connected_at    TEXT NOT NULL,
+	disconnected_at TEXT
+);
+CREATE INDEX IF NOT EXISTS sessions_connected ON sessions(connected_at);
+`
+
+// History is the optional SQLite store of result switches, timer events and
+// client sessions, for reviewing an event afterwards. A nil *History records
+// nothing, so callers need not check whether it is enabled.
+type History struct {
+	db     *sql.DB
+	writes chan historyWrite
+	done   chan struct{}
+}
+
+type historyWrite struct {
+	query string
+	args  []any
+}
+
+// openHistory opens or creates the database at path and starts its writer.
+func openHistory(path string) (*History, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open history database: %w", err)
+	}
+	db.SetMaxOpenConns(1) // SQLite allows one writer; queries wait their turn
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create history tables: %w", err)
+	}
+	// Databases from before rooms existed
+	for _, table := range []string{"events", "sessions"} {
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN room TEXT NOT NULL DEFAULT ''`); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("add room to %s: %w", table, err)
+		}
+	}
+	// Sessions still open were cut short by a crash or restart.
+	if _, err := db.Exec(`UPDATE sessions SET disconnected_at = ? WHERE disconnected_at IS NULL`, historyTime(time.Now())); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("close stale sessions: %w", err)
+	}
+
+	h := &History{db: db, writes: make(chan historyWrite, historyQueueSize), done: make(chan struct{})}
+	go h.writer()
+	return h, nil
+}
+
+func (h *History) writer() {
+	defer close(h.done)
+	for w := range h.writes {
+		if _, err := h.db.Exec(w.query, w.args...); err != nil {
+			slog.Error("Failed to write history", "err", err)
+		}
+	}
+}
+
+// exec queues a write without blocking; if the disk cannot keep up the
+// entry is dropped rather than stalling the caller.
+func (h *History) exec(query string, args ...any) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.writes <- historyWrite{query: query, args: args}:
+	default:
+		slog.Warn("History write queue full, dropping entry")
+	}
+}
+
+// Close flushes queued writes and closes the database.
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	close(h.writes)
+	<-h.done
+	return h.db.Close()
+}
+
+func historyTime(t time.Time) string {
+	return t.UTC().Format(historyTimeFormat)
+}
+
+// RecordEvent stores a result switch (kind "result", with file) or a timer
+// event (value = seconds) in room.
+func (h *History) RecordEvent(room, kind, file, value, actor string) {
+	h.exec(`INSERT INTO events (time, kind, room, file, value, actor) VALUES (?, ?, ?, ?, ?, ?)`,
+		historyTime(time.Now()), kind, room, file, value, actor)
+}
+
+// SessionStarted records a listed client; the start time identifies the
+// session when it ends.
+func (h *History) SessionStarted(start time.Time, info ClientInfo) {
+	h.exec(`INSERT INTO sessions (client_id, name, role, room, addr, version, connected_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		info.ID, info.Name, info.Role, info.Room, info.Addr, info.Version, historyTime(start))
+}
+
+func (h *History) SessionEnded(start time.Time, clientID string) {
+	h.exec(`UPDATE sessions SET disconnected_at = ? WHERE client_id = ? AND connected_at = ?`,
+		historyTime(time.Now()), clientID, historyTime(start))
+}
+
+// HistoryEvent is a row of the events table.
+type HistoryEvent struct {
+	Time  string `json:"time"`
+	Kind  string `json:"kind"`
+	Room  string `json:"room,omitempty"`
+	File  string `json:"file,omitempty"`
+	Value string `json:"value,omitempty"`
+	Actor string `json:"actor,omitempty"`
+}
+
+// ResultHistory summarises when one result file was live.
+type ResultHistory struct {
+	File      string `json:"file"`
+	FirstLive string `json:"firstLive"`
+	LastLive  string `json:"lastLive"`
+	Switches  int    `json:"switches"`
+}
+
+// HistorySession is a row of the sessions table.
+type HistorySession struct {
+	ClientID       string `json:"clientId"`
+	Name           string `json:"name"`
+	Role           string `json:"role"`
+	Room           string `json:"room,omitempty"`
+	Addr           string `json:"addr"`
+	Version        string `json:"version,omitempty"`
+	ConnectedAt    string `json:"connectedAt"`
+	DisconnectedAt string `json:"disconnectedAt,omitempty"` // Empty while connected
+}
+
+// historyRange reads ?since=, ?until= (RFC 3339) and ?limit= shared by the
+// history endpoints.
+func historyRange(r *http.Request) (since, until string, limit int, err error) {
+	since, until = historyTime(time.Time{}), historyTime(time.Now().Add(time.Hour))
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("since must be an RFC 3339 time")
+		}
+		since = historyTime(t)
+	}
+	if v := r.URL.Query().Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("until must be an RFC 3339 time")
+		}
+		until = historyTime(t)
+	}
+	limit = 1000
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return "", "", 0, fmt.Errorf("limit must be a positive number")
+		}
+	}
+	return since, until, limit, nil
+}
+
+// registerHistoryAPI serves the history queries. Without a database they
+// answer 404 so clients can tell the feature is off.
+func registerHistoryAPI(history *History) {
+	handle := func(pattern string, query func(w http.ResponseWriter, r *http.Request, since, until string, limit int) (any, error)) {
+		http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if history == nil {
+				http.Error(w, "History is disabled (set historyDB in server.json)", http.StatusNotFound)
+				return
+			}
+			since, until, limit, err := historyRange(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			out, err := query(w, r, since, until, limit)
+			if err != nil {
+				slog.Error("History query failed", "path", r.URL.Path, "err", err)
+				http.Error(w, "History query failed", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(out)
+		})
+	}
+
+	// GET /api/history/events?kind=result,timer_start&room=&file=&since=&until=&limit= -> [HistoryEvent]
+	handle("GET /api/history/events", func(w http.ResponseWriter, r *http.Request, since, until string, limit int) (any, error) {
+		query := `SELECT time, kind, room, file, value, actor FROM events WHERE time >= ? AND time < ?`
+		args := []any{since, until}
+		if kinds := r.URL.Query().Get("kind"); kinds != "" {
+			list := strings.Split(kinds, ",")
+			query += ` AND kind IN (?` + strings.Repeat(`, ?`, len(list)-1) + `)`
+			for _, k := range list {
+				args = append(args, k)
+			}
+		}
+		if room, ok := r.URL.Query()["room"]; ok {
+			query += ` AND room = ?`
+			args = append(args, room[0])
+		}
+		if file := r.URL.Query().Get("file"); file != "" {
+			query += ` AND file = ?`
+			args = append(args, file)
+		}
+		query += ` ORDER BY time LIMIT ?`
+		rows, err := history.db.Query(query, append(args, limit)...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []HistoryEvent{}
+		for rows.Next() {
+			var e HistoryEvent
+			if err := rows.Scan(&e.Time, &e.Kind, &e.Room, &e.File, &e.Value, &e.Actor); err != nil {
+				return nil, err
+			}
+			out = append(out, e)
+		}
+		return out, rows.Err()
+	})
+
+	// GET /api/history/results?since=&until= -> [ResultHistory], by first time live
+	handle("GET /api/history/results", func(w http.ResponseWriter, r *http.Request, since, until string, limit int) (any, error) {
+		rows, err := history.db.Query(`SELECT file, MIN(time), MAX(time), COUNT(*) FROM events
+			WHERE kind = 'result' AND time >= ? AND time < ? GROUP BY file ORDER BY MIN(time) LIMIT ?`, since, until, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []ResultHistory{}
+		for rows.Next() {
+			var res ResultHistory
+			if err := rows.Scan(&res.File, &res.FirstLive, &res.LastLive, &res.Switches); err != nil {
+				return nil, err
+			}
+			out = append(out, res)
+		}
+		return out, rows.Err()
+	})
+
+	// GET /api/history/sessions?client=<id>&since=&until=&limit= -> [HistorySession]
+	handle("GET /api/history/sessions", func(w http.ResponseWriter, r *http.Request, since, until string, limit int) (any, error) {
+		query := `SELECT client_id, name, role, room, addr, version, connected_at, COALESCE(disconnected_at, '') FROM sessions
+			WHERE connected_at >= ? AND connected_at < ?`
+		args := []any{since, until}
+		if id := r.URL.Query().Get("client"); id != "" {
+			query += ` AND client_id = ?`
+			args = append(args, id)
+		}
+		query += ` ORDER BY connected_at LIMIT ?`
+		rows, err := history.db.Query(query, append(args, limit)...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []HistorySession{}
+		for rows.Next() {
+			var s HistorySession
+			if err := rows.Scan(&s.ClientID, &s.Name, &s.Role, &s.Room, &s.Addr, &s.Version, &s.ConnectedAt, &s.DisconnectedAt); err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, rows.Err()
+	})
+}
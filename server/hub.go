@@ -2,69 +2,136 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"display/internal/protocol"
 )
 
-// Message defines the JSON structure for communication
-type Message struct {
-	Type    string          `json:"type"`              // e.g., "timer", "command", "handshake"
-	Payload json.RawMessage `json:"payload,omitempty"` // Flexible payload
-}
+// The protocol version (protocol.Version) is bumped whenever the WebSocket
+// message format changes. Clients report theirs in the handshake; ones from
+// minProtocolVersion on are still served (v2 added state_sync, v1 clients get
+// separate messages; v3 added time_sync and the timer's endsAt, which older
+// clients ignore; v4 added result_patch, which older clients get as a reload).
+const (
+	protocolVersion    = protocol.Version
+	minProtocolVersion = protocol.MinVersion
+)
+
+// Message is a message from a client (internal/protocol).
+type Message = protocol.Message
 
 type Client struct {
 	Hub         *Hub
-	TimerMgr    *TimerManager
-	Conn        *websocket.Conn
-	Send        chan []byte
+	Conn        Conn // nil for clients on the SSE fallback (sse.go)
+	Send        *sendQueue
+	Addr        string // Remote address
+	Transport   string // transportWS or transportSSE
 	ID          string
 	Name        string
-	DisplayMode string // "show_timer" or "show_result"
-	ThemeMode   string // "dark" or "light"
-	Zoom        int    // Zoom percentage (100 = normal)
-	closeOnce   sync.Once
-}
-
-// closeClientSend safely closes the client's Send channel exactly once
-func (c *Client) closeClientSend() {
-	c.closeOnce.Do(func() {
-		close(c.Send)
-	})
+	DisplayMode string        // "show_timer", "show_result" or "show_splits"
+	ThemeMode   string        // "dark" or "light"
+	Zoom        int           // Zoom percentage (100 = normal)
+	Rotation    int           // Screen rotation in degrees clockwise (0, 90, 180, 270)
+	ScreenPower string        // Last screen_power command: "on", "off" or "" (none sent)
+	Brightness  *int          // Last set_brightness command, percent; nil = none sent
+	Volume      *int          // Last set_volume command, percent; nil = none sent
+	Protocol    int           // Protocol version from the handshake (0 = not reported)
+	Version     string        // Client build version from the handshake
+	Encoding    string        // protocol.EncodingCBOR when negotiated in the handshake (encoding.go), "" = JSON
+	Role        string        // roleDisplay or roleController (roles.go), set by the handshake
+	Room        string        // Room joined in the handshake (room.go), defaultRoom until then
+	joined      bool          // The room's state has been sent, readPump only
+	Health      *ClientHealth // Latest heartbeat, nil until the first one arrives
+	quality     linkQuality   // Ping round trips (latency.go), has its own lock
+	listedID    string        // ID this connection is listed under in Hub.byID ("" = not listed yet)
+	listedAt    time.Time     // When it was listed under listedID (client_history.go)
+	session     time.Time     // When the history session started (history.go), zero until listed
+	sessionID   string        // ID the history session was recorded under
+	limiter     tokenBucket   // Control message rate (ratelimit.go), readPump only
+	limits      connLimits    // Timeouts and sizes from the config when it connected (conn_limits.go)
+	lock        softLock      // Area of the admin UI a controller is editing (operators.go)
+	strikes     strikes       // Rejected messages, readPump only
 }
 
 type Hub struct {
-	Clients    map[*Client]bool
-	Broadcast  chan []byte
-	Register   chan *Client
-	Unregister chan *Client
-	Handshake  chan *Client
-	SendTo     chan struct {
+	Clients       map[*Client]bool
+	byID          map[string]*Client // Handshaken clients by their persistent ID
+	rooms         map[string]*Room   // By name, created on first use (room.go)
+	Broadcast     chan []byte
+	RoomBroadcast chan roomMessage // Broadcasts limited to one room (room.go)
+	Register      chan *Client
+	Unregister    chan *Client
+	Handshake     chan *Client
+	Heartbeat     chan *Client
+	// A client missed a ping or answers again after missing some (latency.go)
+	QualityChanged chan *Client
+	SendTo         chan struct {
 		Client *Client
 		Msg    []byte
 	}
-	State struct {
-		ActiveResult string
-	}
-	MaxClients int        // Maximum allowed clients (0 = unlimited)
-	mu         sync.Mutex // Protects Clients map and State
+	MaxClients       int                        // Maximum allowed clients (0 = unlimited)
+	MaxSpectators    int                        // Maximum spectators, counted apart from MaxClients (0 = unlimited)
+	SlowClientPolicy SlowClientPolicy           // What to do when a client's send queue is full
+	Connections      ConnectionOptions          // Timeouts and sizes for new connections (conn_limits.go)
+	ControllerToken  string                     // Required from controllers when set (roles.go)
+	Audit            *AuditLog                  // Control actions (audit.go); nil records nothing
+	History          *History                   // Result, timer and session history (history.go); nil records nothing
+	Recorder         *Recorder                  // Broadcasts for -replay (recording.go); nil records nothing
+	ResultsDir       string                     // Room folders are looked up here (room.go)
+	ResultsAliases   map[string]string          // ...or here, if an alias has the room's name
+	FollowNewest     map[string]string          // Room -> glob of rooms following the newest result
+	PowerSchedules   map[string]PowerSchedule   // Room -> daily screen schedule (power_schedule.go)
+	Formatting       FormatOptions              // Name formatting and display profiles (names.go)
+	ResultDiff       bool                       // Patch updated results instead of reloading them (result_diff.go)
+	MatchFlow        MatchFlow                  // Periods for next_period (match.go)
+	IdleFallback     IdleFallback               // Scene for rooms left alone (idle.go)
+	Sports           map[string]SportProfile    // Sport profiles by name (sports.go)
+	SportsDir        string                     // Where Sports were read from, besides the built-in ones
+	Splits           *SplitBoard                // Intermediate times from radio controls (splits.go)
+	Speaker          *Speaker                   // The speaker feed (speaker.go); nil publishes nothing
+	Scenes           *sceneStore                // Saved display states (scenes.go)
+	Logos            *logoStore                 // Club and country logos (logos.go)
+	acks             ackTracker                 // Routes display acks back to the requester (ack.go)
+	events           clientEvents               // Coalesces client_joined/left/updated (coalesce.go)
+	clock            Clock                      // Time source of the rooms' timers (clock.go)
+	bans             banList                    // Kicked clients kept out until restart (ban.go)
+	versions         resultVersions             // Last version sent of each diffed result (result_diff.go)
+	tracker          clientTracker              // Connections by client ID (client_history.go)
+	spectators       map[*Client]bool           // Read-only connections on /ws/spectate (spectate.go)
+	restored         map[string]restoredDisplay // By client ID, until the display connects (backup.go)
+	mu               sync.Mutex                 // Protects Clients, byID, rooms, bans, spectators and restored
 }
 
-func NewHub() *Hub {
+// NewHub returns a hub whose timers run on clock (systemClock{} outside tests).
+func NewHub(clock Clock) *Hub {
 	h := &Hub{
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		Handshake:  make(chan *Client),
+		Broadcast:      make(chan []byte),
+		RoomBroadcast:  make(chan roomMessage),
+		Register:       make(chan *Client),
+		Unregister:     make(chan *Client),
+		Handshake:      make(chan *Client),
+		Heartbeat:      make(chan *Client),
+		QualityChanged: make(chan *Client),
 		SendTo: make(chan struct {
 			Client *Client
 			Msg    []byte
 		}, 256),
-		Clients:    make(map[*Client]bool),
-		MaxClients: 100, // Default connection limit
+		Clients:          make(map[*Client]bool),
+		byID:             make(map[string]*Client),
+		spectators:       make(map[*Client]bool),
+		rooms:            make(map[string]*Room),
+		Splits:           NewSplitBoard(),
+		Speaker:          NewSpeaker(),
+		MaxClients:       100, // Default connection limit
+		MaxSpectators:    defaultMaxSpectators,
+		SlowClientPolicy: SlowClientDisconnect,
+		clock:            clock,
 	}
 	return h
 }
@@ -77,161 +144,804 @@ func (h *Hub) Run() {
 			// Check connection limit
 			if h.MaxClients > 0 && len(h.Clients) >= h.MaxClients {
 				h.mu.Unlock()
-				log.Printf("Client rejected (limit reached): %s", client.Conn.RemoteAddr())
-				// Send error message and close
-				errorMsg, err := json.Marshal(struct {
-					Type    string `json:"type"`
-					Payload string `json:"payload"`
-				}{
-					Type:    "error",
-					Payload: "Server connection limit reached",
-				})
-				if err != nil {
-					log.Printf("Error marshaling rejection message: %v", err)
-				} else {
-					select {
-					case client.Send <- errorMsg:
-					default:
-					}
-				}
-				client.closeClientSend()
+				slog.Warn("Client rejected (limit reached)", "addr", client.Addr)
+				h.turnAway(client, "Server connection limit reached")
+				continue
+			}
+			// The ID is known here if the handshake was handled first
+			if reason := h.banned(client); reason != "" {
+				h.mu.Unlock()
+				slog.Debug("Client rejected", "addr", client.Addr, "id", client.ID, "reason", reason)
+				h.turnAway(client, "Banned by an operator")
 				continue
 			}
 			h.Clients[client] = true
 			h.mu.Unlock()
-			log.Printf("Client connected: %s", client.Conn.RemoteAddr())
-			h.broadcastClientList()
+			slog.Info("Client connected", "addr", client.Addr, "transport", client.Transport)
+			// Clients are listed once their handshake tells us their ID, so the
+			// newcomer only gets the current list here.
+			if data, err := h.clientListMessage(); err == nil {
+				h.sendDirect(client, data)
+			}
+			// In case the handshake was processed before this registration
+			h.listClient(client)
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
 			if _, ok := h.Clients[client]; ok {
 				delete(h.Clients, client)
-				client.closeClientSend()
-				log.Printf("Client disconnected: %s", client.Conn.RemoteAddr())
+				client.Send.close()
+				slog.Info("Client disconnected", "addr", client.Addr)
+			}
+			// Slow clients are dropped from Clients without notice, so announce
+			// the departure here. If a newer connection has taken over the ID
+			// (a display reconnecting before the old socket timed out), the
+			// entry stays.
+			left := client.listedID != "" && h.byID[client.listedID] == client
+			var successor *Client
+			if left {
+				delete(h.byID, client.listedID)
+				// Another connection may still be using the ID
+				for other := range h.Clients {
+					if other.ID == client.listedID {
+						successor = other
+						break
+					}
+				}
+			}
+			if client.listedID != "" {
+				h.tracker.disconnected(client.listedID, client.listedAt, time.Now())
 			}
+			client.listedID = ""
+			info := h.clientInfo(client)
+			session, sessionID := client.session, client.sessionID
+			client.session = time.Time{}
+			operator := client.Role == roleController
 			h.mu.Unlock()
-			h.broadcastClientList()
+			if !session.IsZero() {
+				h.History.SessionEnded(session, sessionID)
+			}
+			if successor != nil {
+				h.listClient(successor)
+			} else if left {
+				h.broadcastClientEvent("client_left", info)
+			}
+			if operator {
+				h.broadcastOperators()
+			}
 
 		case client := <-h.Handshake:
-			log.Printf("Client handshake: %s (%s)", client.Name, client.Conn.RemoteAddr())
-			h.broadcastClientList()
-
-		case job := <-h.SendTo:
 			h.mu.Lock()
-			if _, ok := h.Clients[job.Client]; ok {
-				select {
-				case job.Client.Send <- job.Msg:
-				default:
-					delete(h.Clients, job.Client)
-					h.mu.Unlock()
-					job.Client.closeClientSend()
-					continue
-				}
+			warning := compatibilityWarning(client.Protocol)
+			reason := h.banned(client)
+			if reason != "" {
+				delete(h.Clients, client)
+			}
+			operator := client.Role == roleController
+			h.mu.Unlock()
+			if reason != "" {
+				slog.Debug("Client rejected", "addr", client.Addr, "id", client.ID, "reason", reason)
+				h.turnAway(client, "Banned by an operator")
+				continue
+			}
+			slog.Info("Client handshake", "name", client.Name, "addr", client.Addr, "version", client.Version, "protocol", client.Protocol)
+			if warning != "" {
+				slog.Warn("Client is incompatible", "name", client.Name, "reason", warning)
+			}
+			h.sendHandshakeAck(client, warning)
+			h.listClient(client)
+			if operator {
+				h.broadcastOperators()
 			}
+
+		case client := <-h.Heartbeat:
+			h.mu.Lock()
+			health := client.Health
 			h.mu.Unlock()
+			if health != nil && health.CPUTempC >= hotCPUTempC {
+				slog.Warn("Client is overheating", "name", client.Name, "cpuTempC", health.CPUTempC)
+			}
+			h.broadcastClientUpdated(client)
+
+		case client := <-h.QualityChanged:
+			h.broadcastClientUpdated(client)
+			h.sendDelivery(client)
+
+		case job := <-h.SendTo:
+			h.sendDirect(job.Client, job.Msg)
 
 		case message := <-h.Broadcast:
 			h.broadcastData(message)
+
+		case message := <-h.RoomBroadcast:
+			h.broadcastRoomData(message.Room, message.Msg, message.BeforeProtocol, message.FromProtocol)
 		}
 	}
 }
 
+// broadcastData queues an already marshaled message for every client. The
+// lock is only held to copy the client set; queueing never blocks, and each
+// client's writePump does the actual (possibly slow) network write.
 func (h *Hub) broadcastData(message []byte) {
+	h.Recorder.Record(true, "", 0, message)
 	h.mu.Lock()
-	// Collect clients to remove
-	var toRemove []*Client
+	clients := make([]*Client, 0, len(h.Clients))
+	encodings := make([]string, 0, len(h.Clients))
 	for client := range h.Clients {
-		select {
-		case client.Send <- message:
-		default:
-			toRemove = append(toRemove, client)
+		clients = append(clients, client)
+		encodings = append(encodings, client.Encoding)
+	}
+	policy := h.SlowClientPolicy
+	h.mu.Unlock()
+
+	encoded := &encodedBroadcast{msg: message}
+	for i, client := range clients {
+		if !deliver(client, encoded.forEncoding(encodings[i]), policy) {
+			h.dropClient(client)
+		}
+	}
+}
+
+// turnAway sends client an error message and closes its connection once
+// that is written. The caller has removed it from Clients, if it was added.
+func (h *Hub) turnAway(client *Client, text string) {
+	errorMsg, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		Payload string `json:"payload"`
+	}{
+		Type:    "error",
+		Payload: text,
+	})
+	if err != nil {
+		slog.Error("Error marshaling rejection message", "err", err)
+	} else {
+		client.Send.offer(errorMsg, SlowClientDisconnect)
+	}
+	client.Send.close()
+}
+
+// dropClient removes a client that cannot keep up. Its writePump flushes what
+// is queued and closes the connection; readPump then unregisters it, which
+// broadcasts client_left.
+func (h *Hub) dropClient(client *Client) {
+	h.mu.Lock()
+	delete(h.Clients, client)
+	h.mu.Unlock()
+	client.Send.close()
+}
+
+// listClient files a handshaken client under its ID and tells everyone:
+// client_joined for a new ID, client_updated otherwise. When the ID changed
+// (older clients use their name as ID, so a rename changes it) the old entry
+// is announced as client_left first.
+func (h *Hub) listClient(client *Client) {
+	h.mu.Lock()
+	if _, ok := h.Clients[client]; !ok || client.ID == "" {
+		h.mu.Unlock()
+		return
+	}
+	prev := client.listedID
+	var prevInfo ClientInfo
+	if prev != client.ID && prev != "" && h.byID[prev] == client {
+		delete(h.byID, prev)
+		prevInfo = h.clientInfo(client)
+		prevInfo.ID = prev
+	}
+	event := "client_updated"
+	var replaced *Client
+	if old := h.byID[client.ID]; h.replaces(client, old) {
+		// A reconnect before the old socket timed out (duplicate.go)
+		replaced = old
+		h.takeOver(client, old)
+	} else if old != client {
+		// A second connection with the same ID replaces the first in the list.
+		event = "client_joined"
+		h.byID[client.ID] = client
+	}
+	if prev != client.ID {
+		now := time.Now()
+		if prev != "" {
+			h.tracker.disconnected(prev, client.listedAt, now)
 		}
+		client.listedAt = now
+		h.tracker.connected(client.ID, client.Name, client.Addr, client.Transport, now)
 	}
-	// Remove failed clients
-	for _, client := range toRemove {
-		delete(h.Clients, client)
+	client.listedID = client.ID
+	info := h.clientInfo(client)
+	newSession := client.session.IsZero()
+	if newSession {
+		client.session, client.sessionID = time.Now(), client.ID
 	}
+	session := client.session
 	h.mu.Unlock()
 
-	// Close channels outside the lock
-	for _, client := range toRemove {
-		client.closeClientSend()
+	if replaced != nil {
+		slog.Info("Client reconnected, closing its old connection", "name", client.Name, "id", client.ID, "addr", replaced.Addr)
+		h.turnAway(replaced, "Replaced by a new connection")
+	}
+	if newSession {
+		h.History.SessionStarted(session, info)
+	}
+	if prevInfo.ID != "" {
+		h.broadcastClientEvent("client_left", prevInfo)
+	}
+	h.broadcastClientEvent(event, info)
+}
+
+// compatibilityWarning describes why a client's protocol version is not
+// supported by the server, or returns "" if it is.
+func compatibilityWarning(clientProtocol int) string {
+	switch {
+	case clientProtocol == 0:
+		return "client does not report a protocol version (old firmware)"
+	case clientProtocol < minProtocolVersion:
+		return fmt.Sprintf("client protocol v%d is older than server protocol v%d", clientProtocol, protocolVersion)
+	case clientProtocol > protocolVersion:
+		return fmt.Sprintf("client protocol v%d is newer than server protocol v%d, update the server", clientProtocol, protocolVersion)
+	}
+	return ""
+}
+
+// sendHandshakeAck answers a handshake with the server's versions so the
+// client can warn locally as well, and with the role it was granted. Called from Run, so it must not block.
+func (h *Hub) sendHandshakeAck(client *Client, warning string) {
+	h.mu.Lock()
+	role, encoding := client.Role, client.Encoding
+	h.mu.Unlock()
+	data, err := json.Marshal(protocol.Envelope{
+		Type: "handshake_ack",
+		Payload: protocol.HandshakeAck{Protocol: protocolVersion, Version: version, Compatible: warning == "", Warning: warning,
+			Role: role, Encoding: encoding},
+	})
+	if err != nil {
+		slog.Error("Error marshaling handshake_ack message", "err", err)
+		return
 	}
+	h.sendDirect(client, data)
 }
 
-func (h *Hub) broadcastClientList() {
+// sendDirect queues data for one client from inside Run, where sending on
+// h.SendTo could deadlock.
+func (h *Hub) sendDirect(client *Client, data []byte) {
 	h.mu.Lock()
-	type ClientInfo struct {
-		ID          string `json:"id"`
-		Name        string `json:"name"`
-		Addr        string `json:"addr"`
-		DisplayMode string `json:"display_mode"`
-		ThemeMode   string `json:"theme_mode"`
-		Zoom        int    `json:"zoom"`
+	_, ok := h.Clients[client]
+	policy := h.SlowClientPolicy
+	h.mu.Unlock()
+	if ok && !deliver(client, data, policy) {
+		h.dropClient(client)
+	}
+}
+
+// hotCPUTempC is just below the 80°C where a Raspberry Pi starts to throttle;
+// heartbeats at or above it are logged and highlighted in the admin UI.
+const hotCPUTempC = 75.0
+
+// ClientHealth is the system state a display reports in heartbeat messages.
+type ClientHealth = protocol.ClientHealth
+
+// ClientInfo is the per-client entry sent in client_list messages and
+// returned by GET /api/clients.
+type ClientInfo = protocol.ClientInfo
+
+// clientInfo builds the client_list entry for client. Caller holds h.mu.
+func (h *Hub) clientInfo(client *Client) ClientInfo {
+	name := client.Name
+	if name == "" {
+		name = "Unknown"
+	}
+	mode := client.DisplayMode
+	if mode == "" {
+		mode = "show_result" // Default
+	}
+	themeMode := client.ThemeMode
+	if themeMode == "" {
+		themeMode = "dark" // Default
+	}
+	zoom := client.Zoom
+	if zoom == 0 {
+		zoom = 100 // Default
+	}
+	warning := ""
+	if client.ID != "" { // Only judge clients that have completed the handshake
+		warning = compatibilityWarning(client.Protocol)
 	}
+	return ClientInfo{
+		ID:          client.ID,
+		Name:        name,
+		Addr:        client.Addr,
+		DisplayMode: mode,
+		ThemeMode:   themeMode,
+		Zoom:        zoom,
+		Rotation:    client.Rotation,
+		ScreenPower: client.ScreenPower,
+		Brightness:  client.Brightness,
+		Volume:      client.Volume,
+		Version:     client.Version,
+		Role:        client.Role,
+		Room:        client.Room,
+		Protocol:    client.Protocol,
+		Transport:   client.Transport,
+		Encoding:    client.Encoding,
+		Warning:     warning,
+		Health:      client.Health,
+		Quality:     client.quality.snapshot(),
+	}
+}
+
+// ClientList returns a snapshot of all handshaken clients sorted by name.
+func (h *Hub) ClientList() []ClientInfo {
+	h.mu.Lock()
 	var list []ClientInfo
-	for client := range h.Clients {
-		name := client.Name
-		if name == "" {
-			name = "Unknown"
-		}
-		mode := client.DisplayMode
-		if mode == "" {
-			mode = "show_result" // Default
+	for _, client := range h.byID {
+		if _, ok := h.Clients[client]; ok {
+			list = append(list, h.clientInfo(client))
 		}
-		themeMode := client.ThemeMode
-		if themeMode == "" {
-			themeMode = "dark" // Default
-		}
-		zoom := client.Zoom
-		if zoom == 0 {
-			zoom = 100 // Default
-		}
-		list = append(list, ClientInfo{
-			ID:          client.ID,
-			Name:        name,
-			Addr:        client.Conn.RemoteAddr().String(),
-			DisplayMode: mode,
-			ThemeMode:   themeMode,
-			Zoom:        zoom,
-		})
 	}
 	h.mu.Unlock() // Unlock before expensive operations
 
-	// Sort by Name, then Addr (done outside lock)
+	// Sort by Name, then ID (done outside lock)
 	sort.Slice(list, func(i, j int) bool {
 		if list[i].Name != list[j].Name {
 			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
 		}
-		return list[i].Addr < list[j].Addr
+		return list[i].ID < list[j].ID
 	})
+	return list
+}
 
-	msg := struct {
+// clientListMessage marshals the full client_list message.
+func (h *Hub) clientListMessage() ([]byte, error) {
+	list := h.ClientList()
+	if list == nil {
+		list = []ClientInfo{}
+	}
+	return json.Marshal(struct {
 		Type    string       `json:"type"`
 		Payload []ClientInfo `json:"payload"`
 	}{
 		Type:    "client_list",
 		Payload: list,
-	}
+	})
+}
 
-	data, err := json.Marshal(msg)
+// SendClientList queues the full client list for one client. Connections get
+// it on register; afterwards only client_joined/left/updated deltas are
+// broadcast, and a client that lost track can ask again with get_client_list.
+func (h *Hub) SendClientList(client *Client) {
+	data, err := h.clientListMessage()
 	if err != nil {
-		log.Printf("Error marshaling client list: %v", err)
+		slog.Error("Error marshaling client list", "err", err)
 		return
 	}
+	h.SendTo <- struct {
+		Client *Client
+		Msg    []byte
+	}{Client: client, Msg: data}
+}
+
+// broadcastClientEvent sends a client_joined, client_left or client_updated
+// delta to everyone, coalesced with others that follow quickly
+// (coalesce.go). It never blocks, so it is safe to use from inside Run.
+func (h *Hub) broadcastClientEvent(eventType string, info ClientInfo) {
+	h.events.emit(h, eventType, info)
+}
 
-	// Directly call broadcastData instead of sending to channel,
-	// because we are already in the Run loop (or called from it)
-	// and sending to channel would deadlock if channel is unbuffered and we are the reader.
+// sendClientEvent broadcasts a client event right away.
+func (h *Hub) sendClientEvent(eventType string, info ClientInfo) {
+	data, err := json.Marshal(struct {
+		Type    string     `json:"type"`
+		Payload ClientInfo `json:"payload"`
+	}{
+		Type:    eventType,
+		Payload: info,
+	})
+	if err != nil {
+		slog.Error("Error marshaling client event", "type", eventType, "err", err)
+		return
+	}
 	h.broadcastData(data)
 }
 
+// broadcastClientUpdated sends the current state of client as client_updated.
+// Clients that are not listed (no handshake yet) are skipped.
+func (h *Hub) broadcastClientUpdated(client *Client) {
+	h.mu.Lock()
+	listed := client.listedID != "" && h.byID[client.listedID] == client
+	info := h.clientInfo(client)
+	h.mu.Unlock()
+	if listed {
+		h.broadcastClientEvent("client_updated", info)
+	}
+}
+
+// SetActiveResult records the active result file of room and broadcasts it to
+// the clients in that room. When origin asked with a msgId, the displays' acks
+// are relayed to it.
+func (h *Hub) SetActiveResult(room, file string, origin *Client, msgID string) {
+	h.mu.Lock()
+	actor := "api"
+	if origin != nil {
+		actor = origin.Name
+	}
+	h.recordResultSwitch(room, file, actor)
+	h.mu.Unlock()
+	h.switchResult(room, file, actor, h.acks.track(origin, msgID))
+}
+
+// FollowResult is SetActiveResult for the results watcher following the
+// newest file of room.
+func (h *Hub) FollowResult(room, file string) {
+	h.switchResult(room, file, "follow", "")
+}
+
+func (h *Hub) switchResult(room, file, actor, msgID string) {
+	h.mu.Lock()
+	h.room(room).ActiveResult = file
+	h.mu.Unlock()
+	h.History.RecordEvent(room, "result", file, "", actor)
+	msg := newResultMessage(file, msgID)
+	msg.Payload.ETag = h.resultETag(file)
+	h.BroadcastRoomJSON(room, msg)
+}
+
+// RefreshResult re-sends set_result to every room showing file, so displays
+// reload it after its content changed, or with resultDiff sends the changed
+// rows where it can (result_diff.go). Unlike SetActiveResult it is not a
+// switch and is not recorded in the history.
+func (h *Hub) RefreshResult(file string) {
+	h.mu.Lock()
+	var rooms []string
+	for name, r := range h.rooms {
+		if r.ActiveResult == file {
+			rooms = append(rooms, name)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(rooms) == 0 {
+		return
+	}
+	result, patch := h.refreshMessages(file)
+	if result == nil {
+		return
+	}
+	for _, room := range rooms {
+		if patch == nil {
+			h.RoomBroadcast <- roomMessage{Room: room, Msg: result}
+			continue
+		}
+		h.RoomBroadcast <- roomMessage{Room: room, Msg: result, BeforeProtocol: resultPatchProtocol}
+		h.RoomBroadcast <- roomMessage{Room: room, Msg: patch, FromProtocol: resultPatchProtocol}
+	}
+}
+
+// resultMessage is the set_result message.
+type resultMessage struct {
+	Type    string             `json:"type"`
+	MsgID   string             `json:"msgId,omitempty"`
+	Payload protocol.SetResult `json:"payload"`
+}
+
+func newResultMessage(file, msgID string) resultMessage {
+	msg := resultMessage{Type: "set_result", MsgID: msgID}
+	msg.Payload.File = file
+	return msg
+}
+
+// identifySeconds is how long identify shows a display's name and address.
+const identifySeconds = 10
+
+// ClientCommand applies a targeted command (rename, display mode, theme, zoom,
+// rotation, screen power, brightness, volume, switch server, reload, clear
+// cache, identify, kick, ban)
+// to the client with the given ID. It reports whether that client is
+// connected. As with SetActiveResult, origin and msgID request an ack.
+func (h *Hub) ClientCommand(target, command, value string, origin *Client, msgID string) bool {
+	return h.clientCommand(target, command, value, origin, msgID, true)
+}
+
+// clientCommand is ClientCommand; with record, display mode switches go on
+// the room's undo stack (undo.go), which undo and redo themselves skip.
+func (h *Hub) clientCommand(target, command, value string, origin *Client, msgID string, record bool) bool {
+	h.mu.Lock()
+	targetClient := h.byID[target]
+	if targetClient != nil && (command == "kick" || command == "ban") {
+		actor := "api"
+		if origin != nil {
+			actor = origin.Name
+		}
+		h.mu.Unlock()
+		h.kick(targetClient, command == "ban", value == "ip", actor)
+		return true
+	}
+	if targetClient != nil {
+		h.touch(targetClient.Room)
+		if displayModes[command] {
+			if record {
+				h.recordModeSwitch(targetClient, command, origin)
+			}
+			targetClient.DisplayMode = command // Update state immediately under lock
+		} else if command == "theme_dark" {
+			targetClient.ThemeMode = "dark"
+		} else if command == "theme_light" {
+			targetClient.ThemeMode = "light"
+		} else if command == "set_zoom" {
+			zoom := 100
+			if v := value; v != "" {
+				if z, err := strconv.Atoi(v); err == nil && z >= 50 && z <= 300 {
+					zoom = z
+				}
+			}
+			targetClient.Zoom = zoom
+		} else if command == "set_rotation" {
+			if r, err := strconv.Atoi(value); err == nil && validRotation(r) {
+				targetClient.Rotation = r
+			}
+		} else if command == "screen_power" {
+			targetClient.ScreenPower = value
+		} else if command == "set_brightness" || command == "set_volume" {
+			if p, err := strconv.Atoi(value); err == nil && p >= 0 && p <= 100 {
+				if command == "set_brightness" {
+					targetClient.Brightness = &p
+				} else {
+					targetClient.Volume = &p
+				}
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	if targetClient != nil {
+		ackID := h.acks.track(origin, msgID)
+		if command == "rename" {
+			// Send update_config to client
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				} `json:"payload"`
+			}{
+				Type:  "update_config",
+				MsgID: ackID,
+				Payload: struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				}{Key: "ClientName", Value: value},
+			})
+			if err != nil {
+				slog.Error("Error marshaling update_config message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+
+		} else if command == "theme_dark" || command == "theme_light" {
+			theme := "dark"
+			if command == "theme_light" {
+				theme = "light"
+			}
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload string `json:"payload"`
+			}{
+				Type:    "theme_mode",
+				MsgID:   ackID,
+				Payload: theme,
+			})
+			if err != nil {
+				slog.Error("Error marshaling theme_mode message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+
+			// Announce the change (ThemeMode changed)
+			h.broadcastClientUpdated(targetClient)
+		} else if command == "set_zoom" {
+			zoom := 100
+			if v := value; v != "" {
+				if z, err := strconv.Atoi(v); err == nil && z >= 50 && z <= 300 {
+					zoom = z
+				}
+			}
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload int    `json:"payload"`
+			}{
+				Type:    "set_zoom",
+				MsgID:   ackID,
+				Payload: zoom,
+			})
+			if err != nil {
+				slog.Error("Error marshaling set_zoom message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+			h.broadcastClientUpdated(targetClient)
+		} else if command == "set_rotation" {
+			rotation, _ := strconv.Atoi(value)
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload int    `json:"payload"`
+			}{
+				Type:    "set_rotation",
+				MsgID:   ackID,
+				Payload: rotation,
+			})
+			if err != nil {
+				slog.Error("Error marshaling set_rotation message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+			h.broadcastClientUpdated(targetClient)
+		} else if command == "screen_power" {
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload string `json:"payload"`
+			}{
+				Type:    "screen_power",
+				MsgID:   ackID,
+				Payload: value,
+			})
+			if err != nil {
+				slog.Error("Error marshaling screen_power message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+			h.broadcastClientUpdated(targetClient)
+		} else if command == "set_brightness" || command == "set_volume" {
+			// Percent; the display's client picks the backlight, DDC/CI or
+			// mixer to set it with
+			percent, _ := strconv.Atoi(value)
+			msgData, err := json.Marshal(protocol.Envelope{Type: command, Payload: percent, MsgID: ackID})
+			if err != nil {
+				slog.Error("Error marshaling "+command+" message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+			h.broadcastClientUpdated(targetClient)
+		} else if command == "reload" || command == "clear_cache" {
+			// Carried out by the display's client: reload the page, or
+			// restart the browser with an empty profile
+			msgData, err := json.Marshal(struct {
+				Type  string `json:"type"`
+				MsgID string `json:"msgId,omitempty"`
+			}{
+				Type:  command,
+				MsgID: ackID,
+			})
+			if err != nil {
+				slog.Error("Error marshaling "+command+" message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+		} else if command == "identify" {
+			// The display shows its name and address full-screen
+			h.mu.Lock()
+			identify := protocol.Identify{Name: targetClient.Name, Addr: splitHostPortSafe(targetClient.Addr), Seconds: identifySeconds}
+			h.mu.Unlock()
+			msgData, err := json.Marshal(protocol.Envelope{Type: "identify", Payload: identify, MsgID: ackID})
+			if err != nil {
+				slog.Error("Error marshaling identify message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+		} else if command == "set_wifi" {
+			// The display saves the network and joins it when in range
+			creds, _ := parseWifi(value) // Validated by the caller
+			msgData, err := json.Marshal(protocol.Envelope{Type: "set_wifi", Payload: creds, MsgID: ackID})
+			if err != nil {
+				slog.Error("Error marshaling set_wifi message", "err", err)
+			} else {
+				slog.Info("Sending Wi-Fi network to display", "target", target, "ssid", creds.SSID)
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+		} else if command == "switch_server" {
+			// The display saves the name as its preferred server and
+			// reconnects there; it leaves this server's list when it does
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload string `json:"payload"`
+			}{
+				Type:    "switch_server",
+				MsgID:   ackID,
+				Payload: value,
+			})
+			if err != nil {
+				slog.Error("Error marshaling switch_server message", "err", err)
+			} else {
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+		} else {
+			// Forward other commands as display_mode
+			msgData, err := json.Marshal(struct {
+				Type    string `json:"type"`
+				MsgID   string `json:"msgId,omitempty"`
+				Payload string `json:"payload"`
+			}{
+				Type:    "display_mode",
+				MsgID:   ackID,
+				Payload: command,
+			})
+			if err != nil {
+				slog.Error("Error marshaling display_mode message", "err", err)
+			} else {
+				// Send once to the target client (channel is now buffered)
+				h.SendTo <- struct {
+					Client *Client
+					Msg    []byte
+				}{Client: targetClient, Msg: msgData}
+			}
+
+			// Announce the change (DisplayMode changed)
+			h.broadcastClientUpdated(targetClient)
+		}
+	}
+	return targetClient != nil
+}
+
 // Helper to broadcast JSON messages
 func (h *Hub) BroadcastJSON(msg interface{}) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling broadcast message: %v", err)
+		slog.Error("Error marshaling broadcast message", "err", err)
 		return
 	}
 	h.Broadcast <- data
 }
+
+// SendJSONTo marshals msg and queues it for the client with the given ID. It
+// reports whether such a client is connected.
+func (h *Hub) SendJSONTo(id string, msg interface{}) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error marshaling message", "err", err)
+		return false
+	}
+	h.mu.Lock()
+	target := h.byID[id]
+	h.mu.Unlock()
+	if target == nil {
+		return false
+	}
+	h.SendTo <- struct {
+		Client *Client
+		Msg    []byte
+	}{Client: target, Msg: data}
+	return true
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"display/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeConn is an in-memory Conn: what the test puts in in is read by the
+// client's readPump, and every frame writePump writes is kept.
+type fakeConn struct {
+	in        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	written [][]byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{in: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.in:
+		return websocket.TextMessage, data, nil
+	case <-c.closed:
+		return 0, nil, io.EOF
+	}
+}
+
+func (c *fakeConn) NextWriter(int) (io.WriteCloser, error) {
+	select {
+	case <-c.closed:
+		return nil, errors.New("closed")
+	default:
+	}
+	return &fakeWriter{conn: c}, nil
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
+		c.keep(data)
+	}
+	return nil
+}
+
+func (c *fakeConn) EnableWriteCompression(bool)       {}
+func (c *fakeConn) SetReadLimit(int64)                {}
+func (c *fakeConn) SetReadDeadline(time.Time) error   { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error  { return nil }
+func (c *fakeConn) SetPongHandler(func(string) error) {}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) keep(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, append([]byte(nil), data...))
+}
+
+// messages returns the envelopes written so far of type msgType.
+func (c *fakeConn) messages(msgType string) []protocol.Envelope {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []protocol.Envelope
+	for _, data := range c.written {
+		var env protocol.Envelope
+		if json.Unmarshal(data, &env) == nil && env.Type == msgType {
+			out = append(out, env)
+		}
+	}
+	return out
+}
+
+// send feeds the client a message as its peer would.
+func (c *fakeConn) send(t *testing.T, msgType string, payload any) {
+	t.Helper()
+	data, err := json.Marshal(protocol.Envelope{Type: msgType, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.in <- data
+}
+
+type fakeWriter struct {
+	conn *fakeConn
+	buf  []byte
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.conn.keep(w.buf)
+	return nil
+}
+
+// fakeClock is a Clock whose tickers fire when the test says so.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// tick moves the clock on by a second and fires every running ticker,
+// waiting until each has been received.
+func (c *fakeClock) tick() {
+	c.mu.Lock()
+	c.now = c.now.Add(time.Second)
+	now, tickers := c.now, append([]*fakeTicker(nil), c.tickers...)
+	c.mu.Unlock()
+	for _, t := range tickers {
+		t.fire(now)
+	}
+}
+
+type fakeTicker struct {
+	c       chan time.Time
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+}
+
+func (t *fakeTicker) fire(now time.Time) {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return
+	}
+	select {
+	case t.c <- now:
+	case <-time.After(time.Second): // Its goroutine has returned
+	}
+}
+
+func newTestHub(t *testing.T) (*Hub, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)}
+	h := NewHub(clock)
+	h.ResultsDir = t.TempDir()
+	go h.Run()
+	return h, clock
+}
+
+// connect attaches a display with a fake connection and waits for the
+// hub to acknowledge its handshake.
+func connect(t *testing.T, h *Hub, name string) (*Client, *fakeConn) {
+	t.Helper()
+	conn := newFakeConn()
+	client := h.serveConn(conn, "192.168.1.10:40000")
+	conn.send(t, "handshake", protocol.Handshake{Name: name, ID: "id-" + name, Protocol: protocol.Version})
+	waitFor(t, func() bool { return len(conn.messages("handshake_ack")) > 0 }, name+" handshake_ack")
+	return client, conn
+}
+
+func waitFor(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func clientCount(h *Hub) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.Clients)
+}
+
+func TestBroadcastReachesClients(t *testing.T) {
+	h, _ := newTestHub(t)
+	_, a := connect(t, h, "a")
+	_, b := connect(t, h, "b")
+
+	h.BroadcastJSON(protocol.Envelope{Type: "reload"})
+	for name, conn := range map[string]*fakeConn{"a": a, "b": b} {
+		waitFor(t, func() bool { return len(conn.messages("reload")) == 1 }, name+" reload")
+	}
+}
+
+func TestUnregisterRemovesClient(t *testing.T) {
+	h, _ := newTestHub(t)
+	_, conn := connect(t, h, "a")
+	conn.Close()
+	waitFor(t, func() bool { return clientCount(h) == 0 }, "unregister")
+	h.mu.Lock()
+	listed := h.byID["id-a"]
+	h.mu.Unlock()
+	if listed != nil {
+		t.Error("closed client still listed by ID")
+	}
+}
+
+// Clients disconnect while broadcasts, timer ticks and kicks run on other
+// goroutines; with -race this finds unsynchronized access to clients and
+// their queues, and a send on a closed queue panics.
+func TestBroadcastDuringUnregister(t *testing.T) {
+	h, clock := newTestHub(t)
+	const n = 20
+	conns := make([]*fakeConn, n)
+	clients := make([]*Client, n)
+	for i := range conns {
+		clients[i], conns[i] = connect(t, h, fmt.Sprintf("c%d", i))
+	}
+	tm := h.Room("").Timer
+	tm.Reset(1000)
+	tm.Start()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.BroadcastJSON(protocol.Envelope{Type: "reload"})
+			h.BroadcastRoomJSON("", protocol.Envelope{Type: "display_mode", Payload: "show_timer"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				clock.tick()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, c := range clients[n/2:] {
+			h.kick(c, false, false, "test")
+		}
+	}()
+	for _, conn := range conns[:n/2] {
+		conn.Close()
+	}
+	waitFor(t, func() bool { return clientCount(h) == 0 }, "all clients to leave")
+	close(stop)
+	wg.Wait()
+	tm.Pause()
+
+	// The hub still serves newcomers
+	_, conn := connect(t, h, "late")
+	h.BroadcastJSON(protocol.Envelope{Type: "reload"})
+	waitFor(t, func() bool { return len(conn.messages("reload")) > 0 }, "broadcast after the race")
+}
+
+func TestTimerRunsOnInjectedClock(t *testing.T) {
+	h, clock := newTestHub(t)
+	_, conn := connect(t, h, "a")
+	tm := h.Room("").Timer
+	tm.Reset(3)
+	tm.Start()
+	for range 3 {
+		clock.tick()
+	}
+	waitFor(t, func() bool {
+		for _, env := range conn.messages("timer_update") {
+			if state, _ := env.Payload.(map[string]any); state["timeLeft"] == 0.0 {
+				return true
+			}
+		}
+		return false
+	}, "timer_update with no time left")
+	// The tick after reaching zero stops the clock
+	clock.tick()
+	waitFor(t, func() bool {
+		tm.mu.Lock()
+		defer tm.mu.Unlock()
+		return !tm.State.Running && tm.State.EndsAt == 0
+	}, "the timer to stop after running out")
+}
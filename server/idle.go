@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// idleCheckInterval is how often rooms are checked for idleness.
+const idleCheckInterval = 30 * time.Second
+
+// IdleFallback recalls a scene (scenes.go) in rooms where nothing has
+// happened for a while, so screens do not sit on a stale result after the
+// operators have gone home. Anything sent to a room's displays counts as
+// activity: result switches and updates, timer and score updates, splits,
+// and client commands to its displays. A room whose timer runs is never
+// idle, and rooms without a scene of that name are left alone.
+type IdleFallback struct {
+	Minutes int    `json:"minutes" yaml:"minutes" toml:"minutes"` // 0 = off
+	Scene   string `json:"scene" yaml:"scene" toml:"scene"`       // e.g. "Sponsors"
+}
+
+func (f IdleFallback) validate() error {
+	switch {
+	case f.Minutes < 0 || f.Minutes > 24*60:
+		return errors.New("minutes must be between 0 and 1440")
+	case f.Minutes > 0 && f.Scene == "":
+		return errors.New("scene is required")
+	case f.Scene != "":
+		return validateSceneName(f.Scene)
+	}
+	return nil
+}
+
+// touch records activity in room. Caller holds h.mu.
+func (h *Hub) touch(room string) {
+	if r := h.rooms[room]; r != nil {
+		r.activity = time.Now()
+		r.idle = false
+	}
+}
+
+// RunIdleFallback checks the rooms every idleCheckInterval until stop is
+// closed.
+func (h *Hub) RunIdleFallback(stop <-chan struct{}) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			h.checkIdle(now)
+		}
+	}
+}
+
+// checkIdle recalls the fallback scene in each room idle for longer than
+// IdleFallback.Minutes. A room falls back once per idle spell; the scene's
+// own switches count as activity, so it may be recalled once more after
+// another idle period, which changes nothing.
+func (h *Hub) checkIdle(now time.Time) {
+	h.mu.Lock()
+	fallback := h.IdleFallback
+	if fallback.Minutes == 0 {
+		h.mu.Unlock()
+		return
+	}
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		if !r.idle && now.Sub(r.activity) >= time.Duration(fallback.Minutes)*time.Minute {
+			rooms = append(rooms, r)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, r := range rooms {
+		r.Timer.mu.Lock()
+		running := r.Timer.State.Running
+		r.Timer.mu.Unlock()
+		if running {
+			continue
+		}
+		if _, ok := h.Scenes.get(r.Name, fallback.Scene); !ok {
+			continue
+		}
+		slog.Info("Room idle, showing the fallback scene", "room", r.Name, "scene", fallback.Scene, "idleMinutes", fallback.Minutes)
+		if _, err := h.RecallScene(r.Name, fallback.Scene, nil); err != nil {
+			slog.Warn("Failed to recall the fallback scene", "room", r.Name, "err", err)
+			continue
+		}
+		h.mu.Lock()
+		r.idle = true
+		h.mu.Unlock()
+		h.Audit.Record(AuditEntry{Source: "idle", Action: "scene_recall", Room: r.Name, Value: fallback.Scene})
+	}
+}
+
+func (f IdleFallback) String() string {
+	if f.Minutes == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%s after %dm", f.Scene, f.Minutes)
+}
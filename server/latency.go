@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"display/internal/protocol"
+)
+
+const (
+	// pingInterval is how often writePump pings: often enough to measure
+	// latency, while the pong wait still lets a few pings go unanswered before
+	// the connection is dropped.
+	pingInterval = 10 * time.Second
+	// latencySamples is how many round trips the rolling latency averages.
+	latencySamples = 6
+	// A link whose average round trip reaches poorLatency, or that leaves
+	// poorUnanswered pings in a row unanswered, is flagged lite (lite.go);
+	// the flag clears once the average is under half of it with every ping
+	// answered, so a link near the limit does not flap.
+	poorLatency    = 400 * time.Millisecond
+	poorUnanswered = 3 // Including the ping in flight
+)
+
+// ConnQuality is how well a client's WebSocket answers pings, part of
+// ClientInfo.
+type ConnQuality = protocol.ConnQuality
+
+// linkQuality measures round trips from ping/pong timestamps. writePump
+// sends the time in the ping payload, which the client echoes in its pong.
+type linkQuality struct {
+	mu         sync.Mutex
+	samples    []time.Duration // Ring of the latest round trips
+	next       int
+	last       time.Duration
+	pings      int
+	missed     int
+	unanswered int
+	lite       bool
+}
+
+// pingPayload records a ping and returns its payload. It reports whether
+// the previous ping went unanswered.
+func (q *linkQuality) pingPayload(now time.Time) (payload []byte, missed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pings > 0 && q.unanswered > 0 {
+		q.missed++
+		missed = true
+	}
+	q.pings++
+	q.unanswered++
+	return strconv.AppendInt(nil, now.UnixNano(), 10), missed
+}
+
+// pong records the pong to a ping sent by pingPayload. It reports whether
+// the client answers again after missing pings.
+func (q *linkQuality) pong(data string, now time.Time) (recovered bool) {
+	sent, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return false // Unsolicited pong
+	}
+	rtt := now.Sub(time.Unix(0, sent))
+	if rtt < 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	recovered = q.unanswered > 1
+	q.unanswered = 0
+	q.last = rtt
+	if len(q.samples) < latencySamples {
+		q.samples = append(q.samples, rtt)
+	} else {
+		q.samples[q.next] = rtt
+		q.next = (q.next + 1) % latencySamples
+	}
+	return recovered
+}
+
+// checkLite updates the lite flag and reports whether it changed.
+func (q *linkQuality) checkLite() (lite, changed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var sum time.Duration
+	for _, s := range q.samples {
+		sum += s
+	}
+	var avg time.Duration
+	if len(q.samples) > 0 {
+		avg = sum / time.Duration(len(q.samples))
+	}
+	next := q.lite
+	switch {
+	case avg >= poorLatency || q.unanswered >= poorUnanswered:
+		next = true
+	case avg < poorLatency/2 && q.unanswered <= 1:
+		next = false
+	}
+	changed, q.lite = next != q.lite, next
+	return q.lite, changed
+}
+
+// isLite reports the lite flag.
+func (q *linkQuality) isLite() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lite
+}
+
+// snapshot returns the quality for ClientInfo; nil before the first ping.
+func (q *linkQuality) snapshot() *ConnQuality {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pings == 0 {
+		return nil
+	}
+	var sum, slowest time.Duration
+	for _, s := range q.samples {
+		sum += s
+		slowest = max(slowest, s)
+	}
+	c := &ConnQuality{
+		LastLatencyMs: millis(q.last),
+		MaxLatencyMs:  millis(slowest),
+		Pings:         q.pings,
+		MissedPongs:   q.missed,
+		Unanswered:    max(q.unanswered-1, 0), // The ping in flight is not late yet
+		Lite:          q.lite,
+	}
+	if len(q.samples) > 0 {
+		c.LatencyMs = millis(sum / time.Duration(len(q.samples)))
+	}
+	return c
+}
+
+// millis rounds d to tenths of a millisecond.
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"display/internal/protocol"
+
+	"golang.org/x/net/html"
+)
+
+// Displays on a poor link (latency.go flags it lite) are sent a delivery
+// message asking them to load results as ?lite=1 variants, without images,
+// media or the export's own styles, and to reload a changing result at most
+// every liteRefreshSeconds. Timer, score and splits updates are small and
+// keep coming as they are, so the numbers that matter stay current on bad
+// Wi-Fi. A new connection starts with full content. The delivery message
+// also names the display's profile for name formatting (names.go).
+
+const liteRefreshSeconds = 30
+
+// liteCSS replaces the styles dropped from a lite result.
+const liteCSS = `<style>body{font-family:sans-serif;margin:4px}table{border-collapse:collapse;width:100%}td,th{padding:1px 4px;text-align:left}</style>`
+
+// checkLite updates the client's lite flag from its link quality, logging a
+// change, and reports whether it changed.
+func (c *Client) checkLite() bool {
+	lite, changed := c.quality.checkLite()
+	if changed && lite {
+		slog.Warn("Client link is poor, sending reduced content", "name", c.Name, "addr", c.Addr)
+	} else if changed {
+		slog.Info("Client link is good again, sending full content", "name", c.Name, "addr", c.Addr)
+	}
+	return changed
+}
+
+// deliveryMessage marshals the delivery message for a link that is lite or
+// not and a display of profile; nil if that fails.
+func deliveryMessage(lite bool, profile string) []byte {
+	payload := protocol.Delivery{Lite: lite, Profile: profile}
+	if lite {
+		payload.RefreshSeconds = liteRefreshSeconds
+	}
+	data, err := json.Marshal(protocol.Envelope{Type: "delivery", Payload: payload})
+	if err != nil {
+		slog.Error("Error marshaling delivery message", "err", err)
+		return nil
+	}
+	return data
+}
+
+// sendDelivery tells a display how to load results after its link quality
+// changed. It runs on the hub goroutine, so it sends directly.
+func (h *Hub) sendDelivery(client *Client) {
+	h.mu.Lock()
+	display := client.Role == roleDisplay && client.ID != ""
+	profile := h.Formatting.displayProfile(client.ID, client.Name)
+	h.mu.Unlock()
+	if !display {
+		return
+	}
+	if data := deliveryMessage(client.quality.isLite(), profile); data != nil {
+		h.sendDirect(client, data)
+	}
+}
+
+// pushDeliveries sends every connected display its delivery again after the
+// formatting setting changed, so it loads results with its new profile.
+func (h *Hub) pushDeliveries() {
+	h.mu.Lock()
+	var displays []*Client
+	var profiles []string
+	for client := range h.Clients {
+		if client.Role == roleDisplay && client.ID != "" {
+			displays = append(displays, client)
+			profiles = append(profiles, h.Formatting.displayProfile(client.ID, client.Name))
+		}
+	}
+	h.mu.Unlock()
+	for i, client := range displays {
+		if data := deliveryMessage(client.quality.isLite(), profiles[i]); data != nil {
+			h.SendTo <- struct {
+				Client *Client
+				Msg    []byte
+			}{Client: client, Msg: data}
+		}
+	}
+}
+
+// serveLiteHTML serves the result page at path through liteResultHTML,
+// sanitized first if sanitize is set.
+func serveLiteHTML(w http.ResponseWriter, r *http.Request, path string, sanitize bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Failed to read result", http.StatusInternalServerError)
+		return
+	}
+	if sanitize {
+		w.Header().Set("Content-Security-Policy", "default-src 'self' data:; style-src 'self' 'unsafe-inline'; script-src 'none'; frame-src 'none'; object-src 'none'")
+		data = sanitizeResultHTML(data)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(liteResultHTML(data)))
+}
+
+// liteDroppedElements are removed together with their content in a lite
+// result.
+var liteDroppedElements = map[string]bool{
+	"picture": true,
+	"video":   true,
+	"audio":   true,
+	"svg":     true,
+	"canvas":  true,
+	"object":  true,
+	"iframe":  true,
+	"style":   true,
+}
+
+// liteDroppedVoidElements are removed on their own: images, and links to
+// stylesheets, icons and fonts.
+var liteDroppedVoidElements = map[string]bool{
+	"img":    true,
+	"source": true,
+	"embed":  true,
+	"link":   true,
+}
+
+// liteDroppedAttrs style an element or load a background image.
+var liteDroppedAttrs = map[string]bool{
+	"style":      true,
+	"background": true,
+	"bgcolor":    true,
+}
+
+// liteResultHTML strips images, media and styling from a result page and
+// adds liteCSS, leaving the text and tables. Like sanitizeResultHTML it
+// copies untouched markup byte for byte.
+func liteResultHTML(data []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+	z := html.NewTokenizer(bytes.NewReader(data))
+	skipping, depth := "", 0 // Element whose content is being dropped
+	styled := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				out.Write(z.Raw())
+			}
+			break
+		}
+		raw := append([]byte(nil), z.Raw()...)
+
+		if skipping != "" {
+			if name, _ := z.TagName(); string(name) == skipping {
+				switch tt {
+				case html.StartTagToken:
+					depth++
+				case html.EndTagToken:
+					if depth--; depth == 0 {
+						skipping = ""
+					}
+				}
+			}
+			continue
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			if tt == html.EndTagToken {
+				if name, _ := z.TagName(); liteDroppedElements[string(name)] {
+					continue
+				}
+			}
+			out.Write(raw)
+			continue
+		}
+
+		tok := z.Token()
+		switch {
+		case liteDroppedElements[tok.Data]:
+			if tt == html.StartTagToken {
+				skipping, depth = tok.Data, 1
+			}
+			continue
+		case liteDroppedVoidElements[tok.Data]:
+			continue
+		case tok.Data == "body" && !styled:
+			out.WriteString(liteCSS) // No <head>: browsers move it there
+			styled = true
+		}
+		kept := tok.Attr[:0:0]
+		for _, a := range tok.Attr {
+			if !liteDroppedAttrs[strings.ToLower(a.Key)] {
+				kept = append(kept, a)
+			}
+		}
+		if len(kept) != len(tok.Attr) {
+			tok.Attr = kept
+			out.WriteString(tok.String())
+		} else {
+			out.Write(raw)
+		}
+		if tok.Data == "head" && !styled {
+			out.WriteString(liteCSS)
+			styled = true
+		}
+	}
+	if !styled {
+		return append([]byte(liteCSS), out.Bytes()...)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// liveTemplate is the public results page for the audience's phones: the
+// room's timer and score, its splits view and its active result, kept
+// current by /ws/spectate. It reconnects on its own, so a phone that drops
+// off the venue Wi-Fi picks up again with a fresh state_sync.
+var liveTemplate = template.Must(template.New("live").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: #111; color: #fff; font-family: sans-serif; }
+body { display: flex; flex-direction: column; }
+header { display: flex; justify-content: space-between; align-items: baseline; padding: 8px 12px; background: #222; }
+header h1 { margin: 0; font-size: 18px; }
+#status { font-size: 13px; color: #f87171; }
+#status.live { color: #4ade80; }
+section { padding: 8px 12px; border-bottom: 1px solid #333; }
+#timer { text-align: center; }
+#clock { font: bold 56px 'Courier New', monospace; }
+#period, #penalties { font-size: 16px; }
+#penalties { display: flex; gap: 0 16px; flex-wrap: wrap; justify-content: center; }
+#penalties .away { opacity: 0.7; }
+#scoreboard { display: grid; grid-template-columns: 1em 1fr repeat(4, auto); gap: 2px 10px; align-items: baseline; font-size: 28px; text-align: left; }
+#scoreboard .name { font-size: 20px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+#scoreboard .serve, #scoreboard .done, #scoreboard .game { font-size: 16px; }
+#scoreboard .done { opacity: 0.6; }
+#scoreboard .winner { color: #facc15; }
+#splitsTitle { font-weight: bold; margin-bottom: 4px; }
+#splitsTable { width: 100%; border-collapse: collapse; font-size: 14px; }
+#splitsTable td { padding: 2px 4px; white-space: nowrap; }
+#splitsTable .num { text-align: right; font-family: 'Courier New', monospace; }
+#splitsTable .latest { background: rgba(250,204,21,0.35); }
+#result { flex: 1; min-height: 60vh; width: 100%; border: 0; background: #fff; }
+#noResult { padding: 24px 12px; text-align: center; color: #999; }
+</style>
+</head>
+<body>
+<header><h1>{{.Title}}</h1><span id="status">Connecting...</span></header>
+<section id="timer" hidden><div id="scoreboard" hidden></div><div id="period"></div><div id="clock"></div><div id="penalties"></div></section>
+<section id="splits" hidden><div id="splitsTitle"></div><table id="splitsTable"><tbody></tbody></table></section>
+<div id="noResult">No results yet</div>
+<iframe id="result" hidden></iframe>
+<script>
+const socketPath = {{.Socket}};
+const resultsPath = {{.Results}};
+let timerState = null;
+let scoreState = null;
+let clockOffset = 0; // Server time minus local time, from time_sync
+
+function mmss(seconds) {
+    return Math.floor(seconds / 60).toString().padStart(2, '0') + ':' + (seconds % 60).toString().padStart(2, '0');
+}
+
+// A running timer counts down to endsAt (server time), so the page
+// renders it locally between timer updates
+function renderTimer() {
+    const section = document.getElementById('timer');
+    const clockShown = !!timerState && timerState.clock !== 'none' && (timerState.running || timerState.totalTime > 0);
+    section.hidden = !clockShown && !(scoreState && scoreState.scoreboard);
+    document.getElementById('clock').hidden = !clockShown;
+    if (!timerState) return;
+    let left = timerState.timeLeft;
+    if (timerState.running && timerState.endsAt) {
+        left = Math.max(0, Math.ceil((timerState.endsAt - Date.now() - clockOffset) / 1000));
+    }
+    const shown = timerState.clock === 'up' && !timerState.break ? timerState.totalTime - left : left;
+    document.getElementById('clock').textContent = mmss(shown);
+    document.getElementById('period').textContent = !timerState.period ? '' : timerState.break ? 'Break' : 'Period ' + timerState.period;
+    const elapsed = timerState.timeLeft - left;
+    const rows = (timerState.penalties || [])
+        .map(p => ({ p, left: p.timeLeft - elapsed }))
+        .filter(({ left }) => left > 0);
+    document.getElementById('penalties').replaceChildren(...rows.map(({ p, left }) => {
+        const row = document.createElement('div');
+        row.className = p.team;
+        row.textContent = (p.team === 'home' ? 'Home' : 'Away') + (p.player ? ' #' + p.player : '') + ' ' + mmss(left);
+        return row;
+    }));
+}
+setInterval(renderTimer, 500);
+
+function renderScore() {
+    const board = document.getElementById('scoreboard');
+    board.hidden = !(scoreState && scoreState.scoreboard);
+    renderTimer();
+    if (board.hidden) return;
+    const bySets = scoreState.scoreboard === 'sets';
+    const sets = scoreState.sets || [];
+    const cell = (text, cls) => {
+        const el = document.createElement('div');
+        el.className = cls;
+        el.textContent = text;
+        return el;
+    };
+    board.replaceChildren(...['home', 'away'].flatMap(team => [
+        cell(scoreState.serve === team ? '●' : '', 'serve'),
+        cell(scoreState[team] || (team === 'home' ? 'Home' : 'Away'), 'name' + (scoreState.winner === team ? ' winner' : '')),
+        cell(bySets ? scoreState.setsWon[team] : '', 'sets'),
+        cell(sets.slice(0, -1).map(set => set[team]).join(' '), 'done'),
+        cell(sets.length ? sets[sets.length - 1][team] : 0, 'points'),
+        cell(scoreState.game ? scoreState.game[team] : '', 'game')
+    ]));
+}
+
+function renderSplits(state) {
+    document.getElementById('splits').hidden = !state || !state.control;
+    if (!state || !state.control) return;
+    document.getElementById('splitsTitle').textContent = state.control + (state.class ? ' – ' + state.class : '');
+    const rows = (state.rows || []).map(r => {
+        const tr = document.createElement('tr');
+        if (r.latest) tr.className = 'latest';
+        const cells = [[r.rank, 'num'], [r.name], [r.club || '']];
+        if (!state.class) cells.push([r.class || '']);
+        cells.push([r.time, 'num'], [r.behind || '', 'num']);
+        for (const [text, cls] of cells) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            if (cls) td.className = cls;
+            tr.appendChild(td);
+        }
+        return tr;
+    });
+    document.querySelector('#splitsTable tbody').replaceChildren(...rows);
+}
+
+function showResult(file) {
+    const frame = document.getElementById('result');
+    frame.hidden = !file;
+    document.getElementById('noResult').hidden = !!file;
+    const src = file ? resultsPath + file.split('/').map(encodeURIComponent).join('/') : '';
+    if (src && frame.getAttribute('src') !== src) {
+        frame.src = src;
+    }
+}
+
+function handleMessage(msg) {
+    const p = msg.payload;
+    switch (msg.type) {
+    case 'state_sync':
+        timerState = p.timer;
+        scoreState = p.score;
+        renderScore();
+        renderSplits(p.splits);
+        showResult(p.activeResult);
+        break;
+    case 'timer_update':
+        timerState = p;
+        renderTimer();
+        break;
+    case 'score_update':
+        scoreState = p;
+        renderScore();
+        break;
+    case 'splits_update':
+        renderSplits(p);
+        break;
+    case 'set_result':
+        showResult(p.file);
+        break;
+    case 'time_sync':
+        clockOffset = p.serverTime - Date.now();
+        break;
+    }
+}
+
+function connect() {
+    const status = document.getElementById('status');
+    const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + socketPath);
+    ws.onopen = () => {
+        status.textContent = 'Live';
+        status.className = 'live';
+    };
+    ws.onmessage = event => {
+        try {
+            handleMessage(JSON.parse(event.data));
+        } catch (e) {
+            console.error('Bad message:', event.data, e);
+        }
+    };
+    ws.onclose = () => {
+        status.textContent = 'Reconnecting...';
+        status.className = '';
+        setTimeout(connect, 3000);
+    };
+}
+connect();
+</script>
+</body>
+</html>
+`))
+
+// registerLivePage serves the spectator page at /live (?room= for other
+// rooms than the default one). Like /results/ it needs no token.
+func registerLivePage(hub *Hub, cfgMgr *ConfigManager) {
+	http.HandleFunc("GET /live", func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if err := hub.checkRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		title := cfgMgr.Current().CompetitionName
+		if title == "" {
+			title = "Live results"
+		}
+		if room != defaultRoom {
+			title += " – " + room
+		}
+		base := basePath(r)
+		data := struct {
+			Title   string
+			Socket  string
+			Results string
+		}{Title: title, Socket: base + "/ws/spectate?room=" + url.QueryEscape(room), Results: base + "/results/"}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := liveTemplate.Execute(w, data); err != nil {
+			slog.Error("Error writing live page", "err", err)
+		}
+	})
+}
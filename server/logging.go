@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logLevel is shared by all handlers so the level can follow config reloads.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel accepts debug, info, warn and error (case-insensitive).
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// setupLogging routes slog (and the standard log package) to stderr and to a
+// rotating file in dir, so the log of an event can be collected afterwards.
+// An empty dir logs to stderr only. The returned closer flushes the file.
+func setupLogging(level, format, dir string) (io.Closer, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(lvl)
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("create log directory: %w", err)
+		}
+		file := &lumberjack.Logger{
+			Filename:   filepath.Join(dir, "server.log"),
+			MaxSize:    10, // MB
+			MaxBackups: 10,
+			MaxAge:     90, // Days
+		}
+		out = io.MultiWriter(os.Stderr, file)
+		closer = file
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+// accessLogOff and accessLogLevel hold the accessLog setting, so it can
+// follow config reloads like logLevel.
+var (
+	accessLogOff   atomic.Bool
+	accessLogLevel = new(slog.LevelVar)
+)
+
+// parseAccessLog accepts "off" or the level to log requests at. Requests
+// logged below logLevel are dropped, so the default of debug keeps them out
+// of the log until logLevel is debug too.
+func parseAccessLog(s string) (level slog.Level, off bool, err error) {
+	if strings.EqualFold(s, "off") {
+		return 0, true, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, false, fmt.Errorf("unknown access log setting %q (use off, debug, info, warn or error)", s)
+	}
+	return level, false, nil
+}
+
+// setAccessLog applies the accessLog setting s.
+func setAccessLog(s string) error {
+	level, off, err := parseAccessLog(s)
+	if err != nil {
+		return err
+	}
+	accessLogOff.Store(off)
+	accessLogLevel.Set(level)
+	return nil
+}
+
+// accessLogHandler logs every request to next with its status, size,
+// duration and the client's IP, taken from X-Forwarded-For when proxy trusts
+// the sender. WebSocket upgrades are logged as status 101 once upgraded;
+// server-sent event streams when they end, with the time they were open.
+func accessLogHandler(proxy ProxyOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := accessLogLevel.Level()
+		if accessLogOff.Load() || !slog.Default().Enabled(r.Context(), level) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		method, path := r.Method, r.URL.Path // Before proxyHandler strips the base path
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.Log(r.Context(), level, "HTTP request", "method", method, "path", path, "status", rec.status,
+			"bytes", rec.bytes, "took", time.Since(start).Round(time.Millisecond), "ip", proxy.clientIP(r))
+	})
+}
+
+// statusRecorder remembers the status and size of a response. It passes
+// on Flush for server-sent events and Hijack for WebSocket upgrades.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection's own writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// fatal logs at error level and exits, replacing log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Club and country logos (flags are logos of countries) are kept in logos/
+// next to the config file as <code>.png, where a code is a short slug such
+// as "ifk-goteborg" or "swe". Operators upload them with POST
+// /api/logos/{code}: the PNG, JPEG or GIF is decoded and stored as a PNG at
+// most maxLogoSide pixels on its longest side, so what is served is always
+// a plain image whatever was uploaded. GET /logos/{code}.png?size=N scales
+// it down on the server to fit N pixels and keeps the result in memory, so
+// displays download a few kilobytes per logo.
+//
+// Rendered tables (table.go) and start lists (startlist.go) show the logo
+// of a row's club or country before its name. The name is looked up in
+// logos.aliases (as written in the results, any case) and otherwise turned
+// into a code itself (logoCode: "IFK Göteborg" is ifk-goteborg).
+const (
+	logosDir         = "logos"
+	maxLogoUpload    = 4 << 20 // Bytes of an uploaded image
+	maxLogoDecode    = 4096    // Pixels on either side of an uploaded image
+	maxLogoSide      = 512     // Pixels on the longest side of a stored logo
+	defaultLogoSize  = 64      // Pixels of the logos in rendered results
+	maxLogoCode      = 40
+	maxScaledLogos   = 512 // Scaled logos kept in memory
+	logoCacheSeconds = 300
+)
+
+var errNoLogo = errors.New("no such logo")
+
+// LogoOptions is the logos section of the config.
+type LogoOptions struct {
+	Aliases map[string]string `json:"aliases" yaml:"aliases" toml:"aliases"` // Club or country name as in the results -> logo code
+	Size    int               `json:"size" yaml:"size" toml:"size"`          // Pixels requested for rendered results; 0 = default (64)
+}
+
+func (o LogoOptions) validate() error {
+	for name, code := range o.Aliases {
+		if err := validateLogoCode(code); err != nil {
+			return fmt.Errorf("aliases: %q: %v", name, err)
+		}
+	}
+	if o.Size < 0 || o.Size > maxLogoSide {
+		return fmt.Errorf("size %d must be between 0 and %d", o.Size, maxLogoSide)
+	}
+	return nil
+}
+
+// validateLogoCode accepts lower case letters a-z, digits, - and _.
+func validateLogoCode(code string) error {
+	if code == "" || len(code) > maxLogoCode {
+		return fmt.Errorf("logo code must be 1-%d characters", maxLogoCode)
+	}
+	for _, r := range code {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return fmt.Errorf("logo code %q may only have a-z, 0-9, - and _", code)
+		}
+	}
+	return nil
+}
+
+// logoFold spells letters outside a-z the way codes do.
+var logoFold = map[rune]string{
+	'å': "a", 'ä': "a", 'á': "a", 'à': "a", 'â': "a", 'ã': "a",
+	'æ': "ae", 'ç': "c", 'ð': "d", 'é': "e", 'è': "e", 'ê': "e", 'ë': "e",
+	'í': "i", 'ì': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ö': "o", 'ø': "o", 'ó': "o", 'ò': "o", 'ô': "o", 'õ': "o",
+	'ß': "ss", 'þ': "th", 'ü': "u", 'ú': "u", 'ù': "u", 'û': "u", 'ý': "y", 'ÿ': "y",
+}
+
+// logoCode is the code a club or country name has without an alias: lower
+// case, accents dropped, anything else than letters and digits a single -.
+// It returns "" if no code can be made of it.
+func logoCode(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		var s string
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			s = string(r)
+		case logoFold[r] != "":
+			s = logoFold[r]
+		case unicode.IsLetter(r):
+			return "" // A script codes cannot spell
+		default:
+			dash = b.Len() > 0
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(s)
+	}
+	if b.Len() > maxLogoCode {
+		return ""
+	}
+	return b.String()
+}
+
+// Logo is a stored logo, as listed by GET /api/logos.
+type Logo struct {
+	Code    string    `json:"code"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Bytes   int       `json:"bytes"`
+	Updated time.Time `json:"updated"`
+}
+
+// scaledKey is a logo scaled to fit size pixels.
+type scaledKey struct {
+	code string
+	size int
+}
+
+// logoStore keeps the logos in dir and the scaled ones served.
+type logoStore struct {
+	mu     sync.Mutex
+	dir    string
+	logos  map[string]Logo
+	scaled map[scaledKey][]byte
+}
+
+// openLogos lists the logos saved in dir; a missing dir is no error.
+func openLogos(dir string) (*logoStore, error) {
+	s := &logoStore{dir: dir, logos: make(map[string]Logo), scaled: make(map[scaledKey][]byte)}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		code, ok := strings.CutSuffix(e.Name(), ".png")
+		if !ok || e.IsDir() || validateLogoCode(code) != nil {
+			continue
+		}
+		logo, err := readLogoInfo(filepath.Join(dir, e.Name()), code)
+		if err != nil {
+			slog.Warn("Ignoring unreadable logo", "file", e.Name(), "err", err)
+			continue
+		}
+		s.logos[code] = logo
+	}
+	slog.Debug("Loaded logos", "dir", dir, "logos", len(s.logos))
+	return s, nil
+}
+
+func readLogoInfo(path, code string) (Logo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Logo{}, err
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		return Logo{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return Logo{}, err
+	}
+	return Logo{Code: code, Width: cfg.Width, Height: cfg.Height, Bytes: int(info.Size()), Updated: info.ModTime()}, nil
+}
+
+// List returns the logos by code.
+func (s *logoStore) List() []Logo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Logo, 0, len(s.logos))
+	for _, logo := range s.logos {
+		list = append(list, logo)
+	}
+	slices.SortFunc(list, func(a, b Logo) int { return strings.Compare(a.Code, b.Code) })
+	return list
+}
+
+// Save stores the image data as the logo of code, replacing any before.
+func (s *logoStore) Save(code string, data []byte) (Logo, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Logo{}, fmt.Errorf("not a PNG, JPEG or GIF image: %w", err)
+	}
+	if cfg.Width > maxLogoDecode || cfg.Height > maxLogoDecode {
+		return Logo{}, fmt.Errorf("image is %dx%d, at most %dx%d is accepted", cfg.Width, cfg.Height, maxLogoDecode, maxLogoDecode)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Logo{}, err
+	}
+	img = scaleImage(img, maxLogoSide)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return Logo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return Logo{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, code+".png"), buf.Bytes()); err != nil {
+		return Logo{}, err
+	}
+	logo := Logo{Code: code, Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), Bytes: buf.Len(), Updated: time.Now()}
+	s.logos[code] = logo
+	s.dropScaled(code)
+	return logo, nil
+}
+
+// Delete removes the logo of code.
+func (s *logoStore) Delete(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.logos[code]; !ok {
+		return errNoLogo
+	}
+	if err := os.Remove(filepath.Join(s.dir, code+".png")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.logos, code)
+	s.dropScaled(code)
+	return nil
+}
+
+// dropScaled forgets the scaled versions of code. Caller holds s.mu.
+func (s *logoStore) dropScaled(code string) {
+	for key := range s.scaled {
+		if key.code == code {
+			delete(s.scaled, key)
+		}
+	}
+}
+
+// Scaled returns the logo of code as a PNG fitting size pixels, and when it
+// was stored.
+func (s *logoStore) Scaled(code string, size int) ([]byte, time.Time, error) {
+	s.mu.Lock()
+	logo, ok := s.logos[code]
+	data := s.scaled[scaledKey{code, size}]
+	s.mu.Unlock()
+	if !ok {
+		return nil, time.Time{}, errNoLogo
+	}
+	if data != nil {
+		return data, logo.Updated, nil
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, code+".png"))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, size)); err != nil {
+		return nil, time.Time{}, err
+	}
+	data = buf.Bytes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.logos[code]; ok && current.Updated.Equal(logo.Updated) {
+		if len(s.scaled) >= maxScaledLogos {
+			for key := range s.scaled {
+				delete(s.scaled, key) // Any one; it is scaled again when asked for
+				break
+			}
+		}
+		s.scaled[scaledKey{code, size}] = data
+	}
+	return data, logo.Updated, nil
+}
+
+// Has reports whether there is a logo of code.
+func (s *logoStore) Has(code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.logos[code]
+	return ok
+}
+
+// logoFinder returns the URL of the logo of a club or country name, or ""
+// if it has none.
+type logoFinder func(name string) string
+
+// finder looks names up with opts for pages of the server at base (see
+// basePath). A nil store finds nothing.
+func (s *logoStore) finder(opts LogoOptions, base string) logoFinder {
+	if s == nil {
+		return func(string) string { return "" }
+	}
+	aliases := make(map[string]string, len(opts.Aliases))
+	for name, code := range opts.Aliases {
+		aliases[strings.ToLower(strings.TrimSpace(name))] = code
+	}
+	size := opts.Size
+	if size == 0 {
+		size = defaultLogoSize
+	}
+	return func(name string) string {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return ""
+		}
+		code, ok := aliases[strings.ToLower(name)]
+		if !ok {
+			code = logoCode(name)
+		}
+		if code == "" || !s.Has(code) {
+			return ""
+		}
+		return base + "/logos/" + url.PathEscape(code) + ".png?size=" + strconv.Itoa(size)
+	}
+}
+
+// logoColumns are the header names of the table columns whose cells get a
+// logo, lower case: the start list's club column and countries.
+var logoColumns = append(slices.Clone(startListColumns["club"]), "country", "nation", "nationality", "land", "nat")
+
+// logoColumn returns the index of the first logo column of header, or -1.
+func logoColumn(header []string) int {
+	for i, h := range header {
+		if slices.Contains(logoColumns, strings.ToLower(strings.TrimSpace(h))) {
+			return i
+		}
+	}
+	return -1
+}
+
+// scaleImage shrinks img to fit side pixels, keeping its aspect ratio, by
+// averaging the source pixels under each destination pixel. Smaller images
+// are returned as they are.
+func scaleImage(img image.Image, side int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= side && h <= side {
+		return img
+	}
+	dw, dh := side, side
+	if w >= h {
+		dh = max(1, h*side/w)
+	} else {
+		dw = max(1, w*side/h)
+	}
+	src := image.NewRGBA(image.Rect(0, 0, w, h)) // Premultiplied, so transparent pixels do not darken edges
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0 := dy * h / dh
+		y1 := max((dy+1)*h/dh, y0+1)
+		for dx := 0; dx < dw; dx++ {
+			x0 := dx * w / dw
+			x1 := max((dx+1)*w/dw, x0+1)
+			var sum [4]uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += uint64(row[x*4+c])
+					}
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			i := dst.PixOffset(dx, dy)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+func registerLogosAPI(hub *Hub) {
+	// GET /api/logos -> []Logo
+	http.HandleFunc("GET /api/logos", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Logos.List())
+	})
+
+	// POST /api/logos/{code} (PNG, JPEG or GIF body) -> Logo
+	http.HandleFunc("POST /api/logos/{code}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		code := r.PathValue("code")
+		if err := validateLogoCode(code); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLogoUpload))
+		if err != nil {
+			http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logo, err := hub.Logos.Save(code, data)
+		if err != nil {
+			slog.Warn("Logo not saved", "code", code, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Logo saved", "code", code, "width", logo.Width, "height", logo.Height)
+		hub.Audit.Record(apiAudit(r, "", "logo_save", "", code))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logo)
+	})
+
+	// POST /api/logos/{code}/delete
+	http.HandleFunc("POST /api/logos/{code}/delete", func(w http.ResponseWriter, r *http.Request) {
+		if !requireController(hub, w, r) {
+			return
+		}
+		code := r.PathValue("code")
+		err := hub.Logos.Delete(code)
+		if errors.Is(err, errNoLogo) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Logo deleted", "code", code)
+		hub.Audit.Record(apiAudit(r, "", "logo_delete", "", code))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// GET /logos/{code}.png?size=64, public like /results/
+	http.HandleFunc("GET /logos/{file}", func(w http.ResponseWriter, r *http.Request) {
+		code, ok := strings.CutSuffix(r.PathValue("file"), ".png")
+		if !ok || validateLogoCode(code) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		size := defaultLogoSize
+		if s := r.URL.Query().Get("size"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxLogoSide {
+				http.Error(w, fmt.Sprintf("size must be 1-%d", maxLogoSide), http.StatusBadRequest)
+				return
+			}
+			size = n
+		}
+		data, updated, err := hub.Logos.Scaled(code, size)
+		if errors.Is(err, errNoLogo) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			slog.Warn("Failed to scale logo", "code", code, "err", err)
+			http.Error(w, "Failed to read logo", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(logoCacheSeconds))
+		http.ServeContent(w, r, code+".png", updated, bytes.NewReader(data))
+	})
+}
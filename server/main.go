@@ -6,21 +6,24 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 	"unicode/utf8"
 )
 
+// version is the server build, set with -ldflags "-X main.version=...".
+var version = "dev"
+
 func openBrowser(url string) {
 	var err error
 	switch runtime.GOOS {
@@ -34,9 +37,23 @@ func openBrowser(url string) {
 		err = fmt.Errorf("unsupported platform")
 	}
 	if err != nil {
-		log.Printf("Could not open browser automatically: %v", err)
-		log.Printf("Please open %s in your browser.", url)
+		slog.Warn("Could not open browser automatically, please open the admin UI manually", "url", url, "err", err)
+	}
+}
+
+// listenAddress joins the configured bind address and port. An empty address
+// listens on all interfaces.
+func listenAddress(addr string, port int) string {
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+// browserHost returns the host to use for local URLs. When bound to all
+// interfaces localhost works; otherwise only the bound address is reachable.
+func browserHost(addr string) string {
+	if ip := net.ParseIP(addr); ip == nil || ip.IsUnspecified() {
+		return "localhost"
 	}
+	return addr
 }
 
 func detectHTMLCharset(path string) string {
@@ -82,184 +99,586 @@ func main() {
 	// Parse flags
 	resultsDirFlag := flag.String("results", "", "Path to the folder containing result files (overrides config)")
 	portFlag := flag.Int("port", 0, "Port to run the server on (overrides config)")
+	addrFlag := flag.String("addr", "", "Address to bind to, e.g. 127.0.0.1 (overrides config, default all interfaces)")
+	installServiceFlag := flag.Bool("install-service", false, "Install as a Windows service that starts at boot, then exit")
+	uninstallServiceFlag := flag.Bool("uninstall-service", false, "Remove the Windows service, then exit")
+	installSystemdFlag := flag.Bool("install-systemd", false, "Install and start a systemd unit for this server (Linux, needs root), then exit")
+	logLevelFlag := flag.String("log-level", "", "Log level: debug, info, warn or error (overrides config)")
+	logFormatFlag := flag.String("log-format", "", "Log format: text or json (overrides config)")
+	accessLogFlag := flag.String("access-log", "", "Level to log HTTP requests at, or off (overrides config)")
+	standbyFlag := flag.String("standby", "", "Mirror the server at this address and take over when it stops answering, e.g. http://192.168.1.10:8080 (overrides config)")
+	simulateFlag := flag.Int("simulate", 0, "Connect this many simulated displays, to try out capacity and the admin UI")
+	recordFlag := flag.String("record", "", "Append every broadcast to this file, for -replay")
+	replayFlag := flag.String("replay", "", "Broadcast a file written by -record to the connected displays")
+	replaySpeedFlag := flag.Float64("replay-speed", 1, "How many times faster than recorded to replay")
+	replayLoopFlag := flag.Bool("replay-loop", false, "Start the replay over when it ends")
 	flag.Parse()
 
-	// Load Config
-	finalResultsDir := "./results" // Default
-	finalLanguage := "en"          // Default
-	finalPort := 8080              // Default
-
-	cfg, err := loadConfig("server.json")
-	if err == nil {
-		if cfg.ResultsDir != "" {
-			finalResultsDir = cfg.ResultsDir
+	// Service management
+	if *installServiceFlag {
+		if err := installService(serviceArgs()); err != nil {
+			fatal("Failed to install service", "err", err)
+		}
+		slog.Info("Service installed and started", "service", serviceName)
+		return
+	}
+	if *installSystemdFlag {
+		if err := installSystemdUnit(serviceArgs()); err != nil {
+			fatal("Failed to install systemd unit", "err", err)
+		}
+		slog.Info("systemd unit installed and started", "path", systemdUnitPath)
+		return
+	}
+	if *uninstallServiceFlag {
+		if err := uninstallService(); err != nil {
+			fatal("Failed to uninstall service", "err", err)
 		}
-		if cfg.Language != "" {
-			finalLanguage = cfg.Language
+		slog.Info("Service removed", "service", serviceName)
+		return
+	}
+
+	if *replaySpeedFlag <= 0 {
+		fatal("Invalid -replay-speed, must be above 0", "speed", *replaySpeedFlag)
+	}
+	opts := runOptions{
+		simulate:    *simulateFlag,
+		record:      *recordFlag,
+		replay:      *replayFlag,
+		replaySpeed: *replaySpeedFlag,
+		replayLoop:  *replayLoopFlag,
+	}
+	flags := Overrides{
+		ResultsDir: *resultsDirFlag,
+		Port:       *portFlag,
+		ListenAddr: *addrFlag,
+		LogLevel:   *logLevelFlag,
+		LogFormat:  *logFormatFlag,
+		AccessLog:  *accessLogFlag,
+	}
+	if *standbyFlag != "" {
+		flags.Standby = &StandbyOptions{Primary: *standbyFlag}
+	}
+
+	if isWindowsService() {
+		if err := runAsService(func(stop <-chan struct{}) {
+			run(flags, stop, false, opts)
+		}); err != nil {
+			fatal("Service failed", "err", err)
 		}
-		if cfg.Port != 0 {
-			finalPort = cfg.Port
+		return
+	}
+
+	// Setup signal handling for graceful shutdown
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		slog.Info("Shutdown signal received, gracefully shutting down")
+		close(stop)
+	}()
+
+	// No desktop to open the admin UI on when supervised by systemd
+	run(flags, stop, os.Getenv("NOTIFY_SOCKET") == "", opts)
+}
+
+// serviceArgs returns the flags given on the command line (minus the service
+// management flags and the runOptions ones for trying out and replaying) so
+// the installed service starts with the same settings.
+func serviceArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "install-service", "uninstall-service", "install-systemd",
+			"simulate", "record", "replay", "replay-speed", "replay-loop":
+			return
 		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// staticDir locates the admin UI files: server/static when running from the
+// repository, otherwise static/ next to the working directory (release layout).
+func staticDir() string {
+	if _, err := os.Stat("server/static"); err == nil {
+		return "server/static"
 	}
+	return "static"
+}
+
+// runOptions are the command-line flags for trying out and reproducing
+// events, which are not settings of the server.
+type runOptions struct {
+	simulate    int     // Simulated displays to connect (simulate.go)
+	record      string  // File to record broadcasts to (recording.go)
+	replay      string  // Recording to broadcast
+	replaySpeed float64 // Replay this many times faster
+	replayLoop  bool    // Replay over and over
+}
 
-	// Flag overrides config
-	if *resultsDirFlag != "" {
-		finalResultsDir = *resultsDirFlag
+// run starts the server and blocks until stop is closed. openAdmin launches
+// the admin UI in the local browser (not wanted when running as a service).
+func run(flags Overrides, stop <-chan struct{}, openAdmin bool, opts runOptions) {
+	// Load Config (flags override config, environment overrides both)
+	env, err := envOverrides()
+	if err != nil {
+		fatal("Invalid environment", "err", err)
 	}
-	if *portFlag != 0 {
-		finalPort = *portFlag
+	configPath := findConfigFile()
+	if p := os.Getenv(envConfig); p != "" {
+		configPath = p
+	}
+	cfgMgr, err := NewConfigManager(configPath, flags, env)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	settings := cfgMgr.Current()
+
+	logCloser, err := setupLogging(settings.LogLevel, settings.LogFormat, settings.LogDir)
+	if err != nil {
+		fatal("Failed to set up logging", "err", err)
 	}
+	defer logCloser.Close()
+	setAccessLog(settings.AccessLog) // Checked by resolveSettings
 
 	// Validate results directory
-	if _, err := os.Stat(finalResultsDir); os.IsNotExist(err) {
-		log.Printf("Results directory '%s' does not exist. Creating it...", finalResultsDir)
-		if err := os.MkdirAll(finalResultsDir, 0755); err != nil {
-			log.Fatalf("Failed to create results directory: %v", err)
+	if err := ensureResultsDir(settings.ResultsDir); err != nil {
+		fatal("Failed to create results directory", "err", err)
+	}
+	// Aliases are often network shares; they may come up later, so only warn.
+	for alias, dir := range settings.ResultsAliases {
+		if _, err := os.Stat(dir); err != nil {
+			slog.Warn("Results alias folder is not reachable", "alias", alias, "dir", dir, "err", err)
 		}
 	}
 
-	fmt.Printf("Starting Display Server on port %d...\n", finalPort)
-	fmt.Printf("Serving results from: %s\n", finalResultsDir)
-	fmt.Printf("Admin UI Language: %s\n", finalLanguage)
+	slog.Info("Starting Display Server", "version", version, "addr", listenAddress(settings.ListenAddr, settings.Port),
+		"resultsDir", settings.ResultsDir, "language", settings.Language, "logDir", settings.LogDir)
+
+	// With HTTPS, displays and the local browser use the plain HTTP port
+	localPort := settings.Port
+	if settings.ACME.enabled() {
+		localPort = settings.ACME.httpPort()
+	}
 
-	// Start mDNS discovery
-	startDiscovery(finalPort)
+	// Start mDNS and/or UDP broadcast discovery; a standby waits until it
+	// takes over
+	if settings.Standby.Primary == "" {
+		startDiscovery(settings.Discovery, discoveryInfo{Name: settings.ServerName, Competition: settings.CompetitionName}, settings.ListenAddr, localPort)
+	}
 	defer stopDiscovery()
 
 	// Start WebSocket Hub
-	hub := NewHub()
+	hub := NewHub(systemClock{})
+	hub.MaxClients = settings.MaxClients
+	hub.MaxSpectators = settings.MaxSpectators
+	hub.Connections = settings.Connections
+	hub.SlowClientPolicy = settings.SlowClientPolicy
+	hub.ControllerToken = settings.ControllerToken
+	setOriginPolicy(settings.Origins)
+	if settings.Origins.Disabled {
+		slog.Warn("Origin check disabled: any web page a browser on the network opens can control the server")
+	}
+	hub.ResultsDir = settings.ResultsDir
+	hub.ResultsAliases = settings.ResultsAliases
+	hub.FollowNewest = settings.FollowNewest
+	hub.PowerSchedules = settings.PowerSchedules
+	hub.Formatting = settings.Formatting
+	hub.ResultDiff = settings.ResultDiff
+	hub.MatchFlow = settings.MatchFlow
+	hub.IdleFallback = settings.IdleFallback
+	hub.Sports = loadSportProfiles(settings.SportsDir)
+	hub.SportsDir = settings.SportsDir
+	auditPath := ""
+	if settings.LogDir != "" { // setupLogging created it
+		auditPath = filepath.Join(settings.LogDir, "audit.jsonl")
+	}
+	audit, err := openAuditLog(auditPath)
+	if err != nil {
+		fatal("Failed to open audit log", "err", err)
+	}
+	defer audit.Close()
+	hub.Audit = audit
+	var history *History
+	if settings.HistoryDB != "" {
+		if history, err = openHistory(settings.HistoryDB); err != nil {
+			fatal("Failed to open history database", "err", err)
+		}
+		defer history.Close()
+		slog.Info("Recording history", "db", settings.HistoryDB)
+	}
+	hub.History = history
+	if hub.Scenes, err = openScenes(filepath.Join(filepath.Dir(configPath), scenesFile)); err != nil {
+		fatal("Failed to open scenes", "err", err)
+	}
+	if hub.Logos, err = openLogos(filepath.Join(filepath.Dir(configPath), logosDir)); err != nil {
+		fatal("Failed to open logos", "err", err)
+	}
+	if opts.record != "" {
+		if hub.Recorder, err = openRecorder(opts.record); err != nil {
+			fatal("Failed to open recording", "err", err)
+		}
+		defer hub.Recorder.Close()
+		slog.Info("Recording broadcasts", "file", opts.record)
+	}
+	publishQueueStats(hub)
 	go hub.Run()
 
-	// Initialize Timer Manager
-	timerMgr := NewTimerManager(hub)
+	// Apply server.json edits while running
+	cfgMgr.Hub = hub
+
+	// Download remote result pages (remoteSources) into the results folder
+	remote := NewRemoteFetcher(hub)
+	remote.Apply(settings)
+	defer remote.Stop()
+	cfgMgr.Remote = remote
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go cfgMgr.Watch(2*time.Second, stopWatch)
+	// Switch rooms with followNewest to new result files
+	go NewResultsWatcher(hub, cfgMgr).Run(stopWatch)
+	// Let clients count the timer down between updates
+	go hub.RunTimeSync(stopWatch)
+	go hub.RunIdleFallback(stopWatch)
+	// Find displays on the network, including ones that have not connected
+	scanner := NewClientScanner(hub, settings.ServerName, settings.ListenAddr)
+	go scanner.Run(stopWatch)
+	// Mirror the primary, and announce under its name once it is gone
+	var standby *Standby
+	if settings.Standby.Primary != "" {
+		standby = newStandby(hub, settings.Standby, func(name string) {
+			if name == "" {
+				name = settings.ServerName
+			}
+			startDiscovery(settings.Discovery, discoveryInfo{Name: name, Competition: cfgMgr.Current().CompetitionName}, settings.ListenAddr, localPort)
+		})
+		go standby.Run(stopWatch)
+	}
 
 	// 1. WebSocket Endpoint
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(hub, timerMgr, w, r)
+		serveWs(hub, w, r)
 	})
+	// Read-only live data for spectators' phones
+	http.HandleFunc("/ws/spectate", func(w http.ResponseWriter, r *http.Request) {
+		serveSpectator(hub, w, r)
+	})
+	// ...and its server-sent events fallback for displays behind proxies
+	// that break WebSockets
+	registerSSE(hub)
 
 	// 2. Admin UI
 	// Serve static files from 'server/static' mapped to /admin/
-	fs := http.FileServer(http.Dir("server/static"))
+	fs := http.FileServer(http.Dir(staticDir()))
 	http.Handle("/admin/", http.StripPrefix("/admin/", fs))
 
 	// Redirect root to admin for convenience
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
-			http.Redirect(w, r, "/admin/admin.html", http.StatusFound)
+			http.Redirect(w, r, basePath(r)+"/admin/admin.html", http.StatusFound)
 			return
 		}
 		http.NotFound(w, r)
 	})
 
 	// 3. Results File Server
-	// Maps /results/filename.html -> finalResultsDir/filename.html
-	absResultsDir, err := filepath.Abs(finalResultsDir)
-	if err != nil {
-		log.Fatalf("Failed to resolve results directory path: %v", err)
-	}
+	// Maps /results/filename.html -> resultsDir/filename.html and
+	// /results/<alias>/filename.html -> the alias's folder (resultsAliases).
+	// PDFs are shown as auto-paging page images, start lists (IOF XML, or
+	// CSV files matching startList.csvPattern) as a next-starters screen, CSV
+	// files as tables and, with pagination, HTML and text through a paging
+	// wrapper (?raw=1 serves the file itself). ?lite=1 serves HTML without
+	// images and styles to displays on a poor link (lite.go). Tables and
+	// start lists show club and country logos (logos.go) and format names
+	// for the ?profile= of the display (names.go).
+	pdf := NewPDFRenderer()
+	defer pdf.Close()
 	http.HandleFunc("/results/", func(w http.ResponseWriter, r *http.Request) {
 		rel := strings.TrimPrefix(r.URL.Path, "/results/")
-		rel = strings.TrimPrefix(filepath.Clean("/"+rel), "/")
-		if rel == "" || rel == "." {
+		if rel == "" {
 			http.NotFound(w, r)
 			return
 		}
-
-		fullPath := filepath.Join(absResultsDir, rel)
-		absPath, err := filepath.Abs(fullPath)
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
-			return
-		}
-
-		sep := string(os.PathSeparator)
-		if absPath != absResultsDir && !strings.HasPrefix(absPath, absResultsDir+sep) {
+		current := cfgMgr.Current()
+		absPath, ok := resolveResultPath(current.ResultsDir, current.ResultsAliases, rel)
+		if !ok {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		logos := hub.Logos.finder(current.Logos, basePath(r))
+		names := current.Formatting.formatter(r.URL.Query().Get("profile"))
 
 		switch ext := strings.ToLower(filepath.Ext(absPath)); ext {
 		case ".htm", ".html":
+			if current.Pagination.Enabled && r.URL.Query().Get("raw") == "" {
+				servePaginated(w, r, current.Pagination)
+				return
+			}
 			w.Header().Set("Content-Type", "text/html; charset="+detectHTMLCharset(absPath))
+			if r.URL.Query().Get("lite") != "" {
+				serveLiteHTML(w, r, absPath, current.SanitizeHTML)
+				return
+			}
+			if current.SanitizeHTML {
+				serveSanitizedHTML(w, r, absPath)
+				return
+			}
+		case ".csv":
+			if r.URL.Query().Get("raw") == "" && (serveStartList(w, r, absPath, current.StartList, current.CSV, logos, names) || serveTable(w, r, absPath, current.CSV, logos, names)) {
+				return
+			}
+			w.Header().Set("Content-Type", "text/csv; charset="+detectTextCharset(absPath))
 		case ".txt":
+			if current.CSV.Txt && r.URL.Query().Get("raw") == "" && serveTable(w, r, absPath, current.CSV, logos, names) {
+				return
+			}
+			if current.Pagination.Enabled && r.URL.Query().Get("raw") == "" {
+				servePaginated(w, r, current.Pagination)
+				return
+			}
 			w.Header().Set("Content-Type", "text/plain; charset="+detectTextCharset(absPath))
+		case ".xml":
+			if r.URL.Query().Get("raw") == "" && serveStartList(w, r, absPath, current.StartList, current.CSV, logos, names) {
+				return
+			}
+		case ".pdf":
+			if pdf.Available() && r.URL.Query().Get("raw") == "" {
+				pdf.servePDF(w, r, absPath, current.PDFPageSeconds)
+				return
+			}
 		}
 
 		http.ServeFile(w, r, absPath)
 	})
 
-	// 4. API: List Files
+	// 4. API: List Files (?room= lists the room's folder, as "room/file";
+	// the default room also lists every alias, as "alias/file")
+	// ?recursive=1 includes subfolders, ?ext=html,txt filters by extension and
+	// ?details=1 returns [{name, size, modTime}] instead of names.
 	http.HandleFunc("/api/files", func(w http.ResponseWriter, r *http.Request) {
-		files, err := ioutil.ReadDir(finalResultsDir)
+		q := r.URL.Query()
+		room := q.Get("room")
+		if err := hub.checkRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		opts := listOptions{Recursive: q.Get("recursive") == "1", Exts: parseExts(q.Get("ext"))}
+		files, err := listRoomResults(cfgMgr.Current(), room, opts)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].ModTime().After(files[j].ModTime())
-		})
-
-		var fileNames []string
-		for _, f := range files {
-			if !f.IsDir() {
-				fileNames = append(fileNames, f.Name())
+		w.Header().Set("Content-Type", "application/json")
+		if q.Get("details") == "1" {
+			if files == nil {
+				files = []ResultFile{}
 			}
+			json.NewEncoder(w).Encode(files)
+			return
+		}
+		fileNames := make([]string, len(files))
+		for i, f := range files {
+			fileNames[i] = f.Name
 		}
-		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(fileNames)
 	})
 
 	// 5. API: Server Info
 	http.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		current := cfgMgr.Current()
+		presets := current.TimerPresets
+		if presets == nil {
+			presets = []int{}
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(struct {
-			ResultsDir string `json:"resultsDir"`
-			Language   string `json:"language"`
+			ResultsDir     string            `json:"resultsDir"`
+			ResultsAliases map[string]string `json:"resultsAliases"`
+			Language       string            `json:"language"`
+			TimerPresets   []int             `json:"timerPresets"`
+			Version        string            `json:"version"`
+			Protocol       int               `json:"protocol"`
+			ServerName     string            `json:"serverName"`
+			Competition    string            `json:"competitionName"`
+			MatchFlow      MatchFlow         `json:"matchFlow"`
+			PublicURL      string            `json:"publicUrl"` // As the browser reached the server, proxy included
 		}{
-			ResultsDir: finalResultsDir,
-			Language:   finalLanguage,
+			ResultsDir:     current.ResultsDir,
+			ResultsAliases: current.ResultsAliases,
+			Language:       current.Language,
+			TimerPresets:   presets,
+			Version:        version,
+			Protocol:       protocolVersion,
+			ServerName:     current.ServerName,
+			Competition:    current.CompetitionName,
+			MatchFlow:      current.MatchFlow,
+			PublicURL:      publicURL(r),
 		})
 	})
 
+	// 6. Control API (used by score-displayctl)
+	registerControlAPI(hub)
+
+	// 7. Client auto-update builds
+	registerUpdateAPI(cfgMgr)
+
+	// 8. Remote client logs
+	registerLogAPI(hub)
+
+	// 9. Audit log of control actions
+	registerAuditAPI(audit)
+
+	// 10. Result, timer and session history
+	registerHistoryAPI(history)
+
+	// 11. Remote result sources
+	registerRemoteAPI(remote)
+
+	// 12. Result file previews for the admin UI
+	registerPreviewAPI(cfgMgr, pdf)
+
+	// 13. Display clients found via mDNS
+	registerDiscoveredAPI(scanner)
+
+	// 14. Speaker feed (server-sent events)
+	registerSpeakerAPI(hub)
+
+	// 15. Hub dump next to /debug/pprof/ (debugEndpoints, see debugGuard)
+	registerDebugAPI(hub)
+
+	// 16. Kicked and banned clients
+	registerBanAPI(hub)
+
+	// 17. Connections of each client since the server started
+	registerClientHistoryAPI(hub)
+
+	// 18. Connected controllers and their soft locks
+	registerOperatorsAPI(hub)
+
+	// 19. Undo and redo of result and display mode switches
+	registerUndoAPI(hub)
+
+	// 20. Saved display states
+	registerScenesAPI(hub)
+
+	// 21. Public results page for the audience's phones
+	registerLivePage(hub, cfgMgr)
+
+	// 22. QR codes of the live page and the admin UI
+	registerQRAPI(hub)
+
+	// 23. End-of-day ZIP of results, audit log and history
+	registerArchiveAPI(hub, cfgMgr, auditPath)
+
+	// 24. Backup and restore, to move to a spare server mid-event
+	registerBackupAPI(hub, cfgMgr)
+
+	// 25. Hot-standby status
+	registerStandbyAPI(standby)
+
+	// 26. Club and country logos for rendered results
+	registerLogosAPI(hub)
+
 	// Open Browser
-	go func() {
-		// Give the server a moment to bind
-		time.Sleep(500 * time.Millisecond)
-		url := fmt.Sprintf("http://localhost:%d/admin/admin.html", finalPort)
-		fmt.Printf("Launching browser at %s...\n", url)
-		openBrowser(url)
-	}()
+	if openAdmin {
+		go func() {
+			// Give the server a moment to bind
+			time.Sleep(500 * time.Millisecond)
+			url := fmt.Sprintf("http://%s/admin/admin.html", net.JoinHostPort(browserHost(settings.ListenAddr), strconv.Itoa(localPort)))
+			slog.Info("Launching browser", "url", url)
+			openBrowser(url)
+		}()
+	}
 
 	// Create HTTP server
+	app := accessLogHandler(settings.Proxy, proxyHandler(settings.Proxy, debugGuard(cfgMgr, hub, http.DefaultServeMux)))
 	server := &http.Server{
-		Addr: fmt.Sprintf(":%d", finalPort),
+		Addr:    listenAddress(settings.ListenAddr, settings.Port),
+		Handler: app,
+	}
+	// With acme, server serves HTTPS and plainServer the challenges,
+	// redirects and the local network
+	var plainServer *http.Server
+	if settings.ACME.enabled() {
+		certs := newCertManager(settings.ACME)
+		server.TLSConfig = certs.TLSConfig()
+		plainServer = &http.Server{
+			Addr:    listenAddress(settings.ListenAddr, localPort),
+			Handler: acmeHTTPHandler(certs, settings.Port, app),
+		}
 	}
 
-	// Setup signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Bind before reporting readiness so systemd only sees READY once clients can connect
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		fatal("Server error", "err", err)
+	}
+	var plainLn net.Listener
+	if plainServer != nil {
+		if plainLn, err = net.Listen("tcp", plainServer.Addr); err != nil {
+			fatal("Server error", "err", err)
+		}
+	}
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("Server listening on port %d\n", finalPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		var err error
+		if server.TLSConfig != nil {
+			slog.Info("Server listening (HTTPS)", "addr", server.Addr, "domains", settings.ACME.Domains)
+			err = server.ServeTLS(ln, "", "") // Certificates come from TLSConfig
+		} else {
+			slog.Info("Server listening", "addr", server.Addr)
+			err = server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server error", "err", err)
 		}
 	}()
+	if plainServer != nil {
+		go func() {
+			slog.Info("Server listening (HTTP)", "addr", plainServer.Addr)
+			if err := plainServer.Serve(plainLn); err != nil && err != http.ErrServerClosed {
+				fatal("Server error", "err", err)
+			}
+		}()
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("systemd notify failed", "err", err)
+	}
+	startSystemdWatchdog(stop)
+
+	if opts.simulate > 0 {
+		if settings.MaxClients > 0 && opts.simulate > settings.MaxClients {
+			slog.Warn("More simulated displays than maxClients allows, the rest will be rejected", "simulate", opts.simulate, "maxClients", settings.MaxClients)
+		}
+		wsURL := fmt.Sprintf("ws://%s/ws", net.JoinHostPort(browserHost(settings.ListenAddr), strconv.Itoa(localPort)))
+		go runSimulation(wsURL, opts.simulate, stop)
+	}
+	if opts.replay != "" {
+		go func() {
+			slog.Info("Replaying recording", "file", opts.replay, "speed", opts.replaySpeed, "loop", opts.replayLoop)
+			if err := hub.Replay(opts.replay, opts.replaySpeed, opts.replayLoop, stop); err != nil {
+				slog.Error("Replay failed", "file", opts.replay, "err", err)
+			}
+		}()
+	}
 
 	// Wait for shutdown signal
-	<-sigChan
-	log.Println("\nShutdown signal received, gracefully shutting down...")
+	<-stop
+	sdNotify("STOPPING=1")
 
 	// Graceful shutdown with timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		slog.Error("Server shutdown error", "err", err)
+	}
+	if plainServer != nil {
+		plainServer.Shutdown(shutdownCtx)
 	}
 
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// MatchFlow divides a match into periods (server.json "matchFlow"), so one
+// "next period" sets the clock for the next one instead of a manual reset.
+type MatchFlow struct {
+	Periods       int `json:"periods" yaml:"periods" toml:"periods"` // e.g. 2 halves or 3 periods; 0 = no match flow
+	PeriodMinutes int `json:"periodMinutes" yaml:"periodMinutes" toml:"periodMinutes"`
+	BreakMinutes  int `json:"breakMinutes" yaml:"breakMinutes" toml:"breakMinutes"` // Intermission between periods; 0 = none
+	// Count the intermission down as soon as a period's clock runs out, and
+	// set the clock for the next period when it is over
+	AutoIntermission bool `json:"autoIntermission" yaml:"autoIntermission" toml:"autoIntermission"`
+}
+
+func (f MatchFlow) validate() error {
+	switch {
+	case f.Periods < 0 || f.Periods > 10:
+		return errors.New("periods must be between 0 and 10")
+	case f.Periods == 0:
+		return nil
+	case f.PeriodMinutes <= 0 || f.PeriodMinutes*60 > maxTimerSeconds:
+		return errors.New("periodMinutes must be a positive number of minutes")
+	case f.BreakMinutes < 0 || f.BreakMinutes*60 > maxTimerSeconds:
+		return errors.New("breakMinutes must not be negative")
+	}
+	return nil
+}
+
+// matchFlow returns the configured match flow.
+func (h *Hub) matchFlow() MatchFlow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.MatchFlow
+}
+
+// matchFlow returns the room's match flow: its sport's periods, or the
+// configured ones.
+func (tm *TimerManager) matchFlow() MatchFlow {
+	tm.mu.Lock()
+	flow := tm.flow
+	tm.mu.Unlock()
+	if flow != nil {
+		return *flow
+	}
+	return tm.Hub.matchFlow()
+}
+
+// SetSport sets the clock up for the room's sport profile, nil when the
+// room has none: the clock's direction, the periods, starting with the first
+// if the profile has them, and the buzzer.
+func (tm *TimerManager) SetSport(p *SportProfile) {
+	tm.Pause()
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.flow, tm.buzzer, tm.State.Clock = nil, BuzzerRules{}, ""
+	tm.State.Period, tm.State.Periods, tm.State.Break = 0, 0, false
+	tm.State.Penalties = nil
+	if p == nil {
+		tm.broadcastState()
+		return
+	}
+	tm.flow, tm.buzzer = p.Periods, p.Buzzer
+	if p.Clock != "down" {
+		tm.State.Clock = p.Clock
+	}
+	if p.Periods != nil {
+		tm.setPeriod(*p.Periods, 1, false)
+		return
+	}
+	tm.broadcastState()
+}
+
+// soundBuzzer tells the room's displays to sound the buzzer, with tm.mu
+// held.
+func (tm *TimerManager) soundBuzzer(reason string) {
+	data, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		Payload any    `json:"payload"`
+	}{
+		Type: "buzzer",
+		Payload: struct {
+			Reason  string `json:"reason"`
+			Seconds int    `json:"seconds"`
+		}{reason, tm.buzzer.Seconds},
+	})
+	if err != nil {
+		slog.Error("Error marshaling buzzer", "err", err)
+		return
+	}
+	tm.Hub.RoomBroadcast <- roomMessage{Room: tm.Room, Msg: data}
+}
+
+// NewMatch sets the clock for the first period and clears the penalties.
+func (tm *TimerManager) NewMatch() error {
+	flow := tm.matchFlow()
+	if flow.Periods == 0 {
+		return errors.New("no matchFlow configured")
+	}
+	tm.Pause()
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.State.Penalties = nil
+	tm.setPeriod(flow, 1, false)
+	return nil
+}
+
+// NextPeriod sets the clock for the next period, ending the current one or
+// its intermission early. Before the first period it starts the match.
+func (tm *TimerManager) NextPeriod() error {
+	flow := tm.matchFlow()
+	if flow.Periods == 0 {
+		return errors.New("no matchFlow configured")
+	}
+	tm.Pause()
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.State.Period >= flow.Periods {
+		return fmt.Errorf("period %d is the last one; start a new match", tm.State.Period)
+	}
+	tm.setPeriod(flow, tm.State.Period+1, false)
+	return nil
+}
+
+// clockRanOut moves the match on after the clock reached zero: a period is
+// followed by its intermission with autoIntermission, and an intermission by
+// the next period. The intermission starts counting at once; a period waits
+// for the operator.
+func (tm *TimerManager) clockRanOut() {
+	flow := tm.matchFlow()
+	tm.mu.Lock()
+	period, inBreak := tm.State.Period, tm.State.Break
+	switch {
+	case flow.Periods == 0 || period == 0:
+		tm.mu.Unlock()
+	case inBreak:
+		tm.setPeriod(flow, period+1, false)
+		tm.mu.Unlock()
+	case flow.AutoIntermission && flow.BreakMinutes > 0 && period < flow.Periods:
+		tm.setPeriod(flow, period, true)
+		tm.mu.Unlock()
+		tm.Start()
+	default:
+		tm.mu.Unlock()
+	}
+}
+
+// setPeriod stops at the start of period, or of the intermission after it,
+// with tm.mu held and the clock paused.
+func (tm *TimerManager) setPeriod(flow MatchFlow, period int, intermission bool) {
+	seconds := flow.PeriodMinutes * 60
+	kind := "period_start"
+	if intermission {
+		seconds = flow.BreakMinutes * 60
+		kind = "intermission_start"
+	}
+	tm.State.Period = period
+	tm.State.Periods = flow.Periods
+	tm.State.Break = intermission
+	tm.State.TotalTime = seconds
+	tm.State.TimeLeft = seconds
+	tm.broadcastState()
+	tm.Hub.History.RecordEvent(tm.Room, kind, "", strconv.Itoa(period), "")
+	tm.Hub.Speaker.Timer(tm.Room, kind, period)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The formatting setting makes names read the same on every template the
+// server renders (tables, start lists and splits): surnames in upper or
+// title case, clubs shortened by an abbreviation table, and names and clubs
+// cut to the widths of a display profile so they fit a column on the
+// screen. A profile ("1080p", "ledwall") is picked per display in
+// formatting.displays and sent to it in the delivery message; the display
+// asks for results with ?profile=<name>. Others get formatting.default.
+// Splits go to a whole room at once and always use the default. Exported
+// HTML results are shown as exported.
+
+// minNameWidth keeps a profile from cutting names to nothing.
+const minNameWidth = 8
+
+// surnameParticles are the lower case words that belong to the surname
+// after them: "Ludwig van Beethoven".
+var surnameParticles = []string{"af", "av", "da", "de", "del", "della", "den", "der", "di", "du", "la", "le", "van", "von"}
+
+// FormatOptions is the formatting section of the config.
+type FormatOptions struct {
+	SurnameCase       string                 `json:"surnameCase" yaml:"surnameCase" toml:"surnameCase"`                   // "" (as written), "upper" or "title"
+	ClubAbbreviations map[string]string      `json:"clubAbbreviations" yaml:"clubAbbreviations" toml:"clubAbbreviations"` // Club as in the results -> shown instead
+	Profiles          map[string]NameProfile `json:"profiles" yaml:"profiles" toml:"profiles"`                            // Display profile -> widths
+	Default           string                 `json:"default" yaml:"default" toml:"default"`                               // Profile of other displays; "" = no widths
+	Displays          map[string]string      `json:"displays" yaml:"displays" toml:"displays"`                            // Display ID or name -> profile
+}
+
+// NameProfile is how wide names and clubs may be on a kind of screen, in
+// characters; 0 = no limit.
+type NameProfile struct {
+	NameWidth int `json:"nameWidth" yaml:"nameWidth" toml:"nameWidth"`
+	ClubWidth int `json:"clubWidth" yaml:"clubWidth" toml:"clubWidth"`
+}
+
+func (o FormatOptions) validate() error {
+	switch o.SurnameCase {
+	case "", "upper", "title":
+	default:
+		return fmt.Errorf("surnameCase %q must be upper, title or empty", o.SurnameCase)
+	}
+	for name, p := range o.Profiles {
+		if name == "" {
+			return errors.New("profiles: a profile needs a name")
+		}
+		for _, width := range []int{p.NameWidth, p.ClubWidth} {
+			if width != 0 && width < minNameWidth {
+				return fmt.Errorf("profiles: %s: widths must be 0 or at least %d", name, minNameWidth)
+			}
+		}
+	}
+	if _, ok := o.Profiles[o.Default]; o.Default != "" && !ok {
+		return fmt.Errorf("default: no profile %q", o.Default)
+	}
+	for display, profile := range o.Displays {
+		if _, ok := o.Profiles[profile]; !ok {
+			return fmt.Errorf("displays: %s: no profile %q", display, profile)
+		}
+	}
+	return nil
+}
+
+// displayProfile returns the profile of the display with id and name.
+func (o FormatOptions) displayProfile(id, name string) string {
+	if p, ok := o.Displays[id]; ok {
+		return p
+	}
+	if p, ok := o.Displays[name]; ok {
+		return p
+	}
+	return o.Default
+}
+
+// nameFormatter formats the names and clubs of one rendering. Its zero
+// value leaves them as they are.
+type nameFormatter struct {
+	surnameCase string
+	clubs       map[string]string // By lower case name
+	profile     NameProfile
+}
+
+// formatter returns the formatter of profile, or of the default profile if
+// there is none of that name.
+func (o FormatOptions) formatter(profile string) nameFormatter {
+	p, ok := o.Profiles[profile]
+	if !ok {
+		p = o.Profiles[o.Default]
+	}
+	f := nameFormatter{surnameCase: o.SurnameCase, profile: p}
+	if len(o.ClubAbbreviations) > 0 {
+		f.clubs = make(map[string]string, len(o.ClubAbbreviations))
+		for name, short := range o.ClubAbbreviations {
+			f.clubs[strings.ToLower(strings.Join(strings.Fields(name), " "))] = short
+		}
+	}
+	return f
+}
+
+// Name cases the surname of a person's name and fits it to the profile's
+// name width: first the given names become initials, then the end is cut
+// off. "Family, Given" is understood as well as "Given Family".
+func (f nameFormatter) Name(name string) string {
+	if f.surnameCase == "" && f.profile.NameWidth == 0 {
+		return name
+	}
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return name
+	}
+	var given, family []string
+	familyFirst := false
+	if i := slices.IndexFunc(words, func(w string) bool { return strings.HasSuffix(w, ",") }); i >= 0 {
+		familyFirst = true
+		family, given = words[:i+1], words[i+1:]
+		family[i] = strings.TrimSuffix(family[i], ",")
+	} else {
+		at := len(words) - 1
+		for at > 1 && slices.Contains(surnameParticles, words[at-1]) {
+			at--
+		}
+		given, family = words[:at], words[at:]
+	}
+	for i, w := range family {
+		family[i] = caseSurname(w, f.surnameCase)
+	}
+
+	join := func(given []string) string {
+		if len(given) == 0 {
+			return strings.Join(family, " ")
+		}
+		if familyFirst {
+			return strings.Join(family, " ") + ", " + strings.Join(given, " ")
+		}
+		return strings.Join(given, " ") + " " + strings.Join(family, " ")
+	}
+	out := join(given)
+	width := f.profile.NameWidth
+	if width == 0 || utf8.RuneCountInString(out) <= width {
+		return out
+	}
+	initials := make([]string, len(given))
+	for i, w := range given {
+		initials[i] = initial(w)
+	}
+	return truncate(join(initials), width)
+}
+
+// Club returns the club's abbreviation, if it has one, fitted to the
+// profile's club width.
+func (f nameFormatter) Club(club string) string {
+	if short, ok := f.clubs[strings.ToLower(strings.Join(strings.Fields(club), " "))]; ok {
+		club = short
+	}
+	if f.profile.ClubWidth == 0 {
+		return club
+	}
+	return truncate(club, f.profile.ClubWidth)
+}
+
+// caseSurname writes a word of a surname in upper or title case; particles
+// ("van") stay lower case in title case.
+func caseSurname(word, how string) string {
+	switch how {
+	case "upper":
+		return strings.ToUpper(word)
+	case "title":
+		if slices.Contains(surnameParticles, strings.ToLower(word)) {
+			return strings.ToLower(word)
+		}
+		runes := []rune(strings.ToLower(word))
+		start := true
+		for i, r := range runes {
+			if start && unicode.IsLetter(r) {
+				runes[i] = unicode.ToUpper(r)
+			}
+			start = r == '-' || r == '\''
+		}
+		return string(runes)
+	}
+	return word
+}
+
+// initial shortens a given name to its initial: "Anna-Karin" is "A-K.".
+func initial(word string) string {
+	parts := strings.Split(word, "-")
+	for i, part := range parts {
+		if r, _ := utf8.DecodeRuneInString(part); r != utf8.RuneError {
+			parts[i] = string(r)
+		}
+	}
+	return strings.Join(parts, "-") + "."
+}
+
+// truncate cuts s to width characters, the last of them an ellipsis.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimRight(string(runes[:width-1]), " ,.-") + "…"
+}
+
+// nameColumns are the header names of table columns holding names, lower
+// case: the start list's, and what result lists call them.
+var nameColumns = append(slices.Clone(startListColumns["name"]), "competitor", "athlete", "player", "deltagare", "tävlande", "spelare")
+
+// formatColumns returns a function formatting the cells of a table with
+// header: names and clubs found by their header names, other cells as
+// they are.
+func (f nameFormatter) formatColumns(header []string) func(col int, text string) string {
+	kinds := make([]string, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case slices.Contains(nameColumns, h):
+			kinds[i] = "name"
+		case slices.Contains(startListColumns["club"], h):
+			kinds[i] = "club"
+		}
+	}
+	return func(col int, text string) string {
+		if col >= len(kinds) {
+			return text
+		}
+		switch kinds[col] {
+		case "name":
+			return f.Name(text)
+		case "club":
+			return f.Club(text)
+		}
+		return text
+	}
+}
+
+// formatSplits formats the names and clubs of splits standings for a room's
+// displays, with the default profile.
+func (h *Hub) formatSplits(state SplitsState) SplitsState {
+	h.mu.Lock()
+	f := h.Formatting.formatter(h.Formatting.Default)
+	h.mu.Unlock()
+	rows := make([]SplitRow, len(state.Rows))
+	for i, row := range state.Rows {
+		row.Name, row.Club = f.Name(row.Name), f.Club(row.Club)
+		rows[i] = row
+	}
+	state.Rows = rows
+	return state
+}
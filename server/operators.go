@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Controllers are told who else is operating (the operators message), and
+// may take a soft lock on an area of the admin UI, e.g. "result" while
+// choosing the next result, so two volunteers do not undo each other's work.
+// Locks are advisory: the server still accepts set_result from everyone, the
+// admin UI asks before acting on an area someone else holds. A lock ends when
+// its holder releases it, leaves, or another controller takes it over.
+const maxLockAreaLen = 32
+
+// Operator is a connected controller, in operators messages and
+// GET /api/operators.
+type Operator struct {
+	Name     string     `json:"name"`
+	Addr     string     `json:"addr"`
+	Room     string     `json:"room"`
+	Since    time.Time  `json:"since"`
+	Lock     string     `json:"lock,omitempty"` // Area it holds a soft lock on
+	LockedAt *time.Time `json:"lockedAt,omitempty"`
+	Self     bool       `json:"self,omitempty"` // The connection receiving the message
+}
+
+// operatorLock is the payload of operator_lock.
+type operatorLock struct {
+	Action string `json:"action"` // "take" or "release"
+	Area   string `json:"area"`
+	Force  bool   `json:"force"` // Take it over from another controller
+}
+
+// softLock is the area a controller holds, protected by h.mu.
+type softLock struct {
+	Area string
+	At   time.Time
+}
+
+// operators lists the connected controllers, longest connected first.
+// Caller holds h.mu.
+func (h *Hub) operators() ([]*Client, []Operator) {
+	var clients []*Client
+	for client := range h.Clients {
+		if client.Role == roleController && client.listedID != "" {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].listedAt.Before(clients[j].listedAt) })
+	list := make([]Operator, len(clients))
+	for i, client := range clients {
+		list[i] = Operator{Name: client.Name, Addr: client.Addr, Room: client.Room, Since: client.listedAt}
+		if client.lock.Area != "" {
+			at := client.lock.At
+			list[i].Lock, list[i].LockedAt = client.lock.Area, &at
+		}
+	}
+	return clients, list
+}
+
+// Operators returns the connected controllers.
+func (h *Hub) Operators() []Operator {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, list := h.operators()
+	return list
+}
+
+// broadcastOperators sends every controller the operators message, marking
+// its own entry. Controllers are few, so each gets its own copy.
+func (h *Hub) broadcastOperators() {
+	h.mu.Lock()
+	clients, list := h.operators()
+	h.mu.Unlock()
+	for i, client := range clients {
+		list[i].Self = true
+		data, err := json.Marshal(struct {
+			Type    string     `json:"type"`
+			Payload []Operator `json:"payload"`
+		}{
+			Type:    "operators",
+			Payload: list,
+		})
+		list[i].Self = false
+		if err != nil {
+			slog.Error("Error marshaling operators message", "err", err)
+			return
+		}
+		h.sendDirect(client, data)
+	}
+}
+
+// takeLock gives client the soft lock on area in its room. Without force it
+// fails with the holder's name if another controller has it.
+func (h *Hub) takeLock(client *Client, area string, force bool) error {
+	h.mu.Lock()
+	var holder *Client
+	for other := range h.Clients {
+		if other != client && other.Role == roleController && other.Room == client.Room && other.lock.Area == area {
+			holder = other
+			break
+		}
+	}
+	if holder != nil && !force {
+		h.mu.Unlock()
+		return errors.New(holder.Name + " is editing " + area)
+	}
+	if holder != nil {
+		holder.lock = softLock{}
+	}
+	client.lock = softLock{Area: area, At: time.Now()}
+	h.mu.Unlock()
+	if holder != nil {
+		slog.Info("Soft lock taken over", "area", area, "name", client.Name, "from", holder.Name)
+	}
+	h.broadcastOperators()
+	return nil
+}
+
+// releaseLock ends client's soft lock, if it holds one.
+func (h *Hub) releaseLock(client *Client) {
+	h.mu.Lock()
+	held := client.lock.Area != ""
+	client.lock = softLock{}
+	h.mu.Unlock()
+	if held {
+		h.broadcastOperators()
+	}
+}
+
+func registerOperatorsAPI(hub *Hub) {
+	// GET /api/operators -> []Operator
+	http.HandleFunc("GET /api/operators", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Operators())
+	})
+}
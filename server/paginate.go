@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+const defaultPaginationSeconds = 10
+
+// PaginationOptions makes long HTML and text results page through themselves
+// on displays instead of showing only the top of the list.
+type PaginationOptions struct {
+	Enabled     bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	PageSeconds int  `json:"pageSeconds" yaml:"pageSeconds" toml:"pageSeconds"` // 0 = default (10)
+	Overlap     int  `json:"overlap" yaml:"overlap" toml:"overlap"`             // Pixels repeated from the previous page when no table row can be lined up
+}
+
+func (o PaginationOptions) validate() error {
+	if o.PageSeconds < 0 || o.Overlap < 0 {
+		return errors.New("pageSeconds and overlap must not be negative")
+	}
+	return nil
+}
+
+// paginationTemplate wraps a result in a frame and scrolls it one screen at
+// a time. Page breaks are worked out in the display's browser, so every
+// screen gets pages of its own height and zoom; a page starts at the table
+// row cut off by the previous one.
+var paginationTemplate = template.Must(template.New("paged").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #fff; }
+iframe { display: block; width: 100%; height: 100%; border: 0; }
+#pageNo { position: fixed; right: 12px; bottom: 8px; font: 14px sans-serif; color: #666; }
+</style>
+</head>
+<body>
+<iframe id="result" src="{{.Src}}"></iframe>
+<div id="pageNo" hidden></div>
+<script>
+const seconds = {{.Seconds}};
+const overlap = {{.Overlap}};
+const frame = document.getElementById("result");
+const pageNo = document.getElementById("pageNo");
+let timer = null;
+
+function pageOffsets(win, doc) {
+    const height = win.innerHeight;
+    const total = doc.documentElement.scrollHeight;
+    const offsets = [0];
+    while (offsets.length < 100) {
+        const last = offsets[offsets.length - 1];
+        if (last + height >= total) {
+            break;
+        }
+        let next = last + height - overlap;
+        win.scrollTo(0, last);
+        const cut = doc.elementFromPoint(win.innerWidth / 2, height - 1);
+        const row = cut && cut.closest("tr, li");
+        if (row) {
+            const top = row.getBoundingClientRect().top + last;
+            if (top > last + height / 2) {
+                next = top;
+            }
+        }
+        offsets.push(Math.min(next, total - height));
+    }
+    win.scrollTo(0, 0);
+    return offsets;
+}
+
+function start() {
+    clearInterval(timer);
+    const win = frame.contentWindow;
+    const doc = frame.contentDocument;
+    if (!doc || !doc.documentElement) {
+        return;
+    }
+    doc.documentElement.style.overflow = "hidden";
+    const offsets = pageOffsets(win, doc);
+    pageNo.hidden = offsets.length < 2;
+    if (offsets.length < 2) {
+        return;
+    }
+    let current = 0;
+    pageNo.textContent = "1 / " + offsets.length;
+    timer = setInterval(() => {
+        current = (current + 1) % offsets.length;
+        win.scrollTo(0, offsets[current]);
+        pageNo.textContent = (current + 1) + " / " + offsets.length;
+    }, seconds * 1000);
+}
+
+frame.addEventListener("load", start);
+window.addEventListener("resize", start);
+</script>
+</body>
+</html>
+`))
+
+// servePaginated answers a request for an HTML or text result with the
+// paging wrapper, which loads the result itself with ?raw=1.
+func servePaginated(w http.ResponseWriter, r *http.Request, opts PaginationOptions) {
+	seconds := opts.PageSeconds
+	if seconds == 0 {
+		seconds = defaultPaginationSeconds
+	}
+	name := path.Base(r.URL.Path)
+	data := struct {
+		Title   string
+		Src     string
+		Seconds int
+		Overlap int
+	}{Title: name, Src: url.PathEscape(name) + "?raw=1", Seconds: seconds, Overlap: opts.Overlap}
+	if r.URL.Query().Get("lite") != "" {
+		data.Src += "&lite=1" // Lite delivery (lite.go)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := paginationTemplate.Execute(w, data); err != nil {
+		slog.Error("Error writing paginated result", "err", err)
+	}
+}
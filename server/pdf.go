@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPDFPageSeconds = 10
+	maxPDFPages           = 50               // Longer documents are cut off; nobody reads page 51 on a wall screen
+	pdfRenderSize         = 1920             // Longest side of a page image in pixels
+	pdfRenderTimeout      = 60 * time.Second // Per document
+)
+
+// PDFRenderer turns PDF results into page images with pdftoppm (poppler), so
+// displays whose browser cannot show PDFs get an auto-paging HTML page
+// instead. Renders are cached by file, size and modification time.
+type PDFRenderer struct {
+	tool     string // Path of pdftoppm; empty if not installed
+	cacheDir string
+	mu       sync.Mutex
+	jobs     map[string]*pdfJob // By cache key
+	current  map[string]string  // Source path -> its latest cache key
+}
+
+// pdfJob is one render; concurrent requests for the same file wait for it.
+type pdfJob struct {
+	done  chan struct{}
+	pages []string
+	err   error
+}
+
+// NewPDFRenderer starts with an empty cache in a new temporary folder of
+// this process, so two servers on one machine never share or delete each
+// other's renders. Close removes it.
+func NewPDFRenderer() *PDFRenderer {
+	tool, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		slog.Warn("pdftoppm not found, PDF results are served as-is (install poppler-utils)")
+	}
+	cacheDir, err := os.MkdirTemp("", "score-display-pdf-")
+	if err != nil && tool != "" {
+		slog.Warn("Cannot create PDF render cache, PDF results are served as-is", "err", err)
+		tool = ""
+	}
+	return &PDFRenderer{
+		tool:     tool,
+		cacheDir: cacheDir,
+		jobs:     make(map[string]*pdfJob),
+		current:  make(map[string]string),
+	}
+}
+
+// Close removes the render cache.
+func (p *PDFRenderer) Close() {
+	if p.cacheDir != "" {
+		os.RemoveAll(p.cacheDir)
+	}
+}
+
+// Available reports whether PDFs can be rendered.
+func (p *PDFRenderer) Available() bool {
+	return p.tool != ""
+}
+
+// Pages renders the PDF at src (once per version of the file) and returns the
+// page images in order, along with the cache key identifying this version.
+func (p *PDFRenderer) Pages(src string) (pages []string, key string, err error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", src, info.Size(), info.ModTime().UnixNano())))
+	key = hex.EncodeToString(sum[:8])
+
+	p.mu.Lock()
+	job, ok := p.jobs[key]
+	if !ok {
+		job = &pdfJob{done: make(chan struct{})}
+		p.jobs[key] = job
+		if old, ok := p.current[src]; ok { // The file changed: drop the old render
+			delete(p.jobs, old)
+			os.RemoveAll(filepath.Join(p.cacheDir, old))
+		}
+		p.current[src] = key
+		go p.render(src, key, job)
+	}
+	p.mu.Unlock()
+
+	<-job.done
+	if job.err != nil {
+		p.mu.Lock()
+		if p.jobs[key] == job {
+			delete(p.jobs, key) // Retry on the next request
+		}
+		p.mu.Unlock()
+	}
+	return job.pages, key, job.err
+}
+
+func (p *PDFRenderer) render(src, key string, job *pdfJob) {
+	defer close(job.done)
+	dir := filepath.Join(p.cacheDir, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		job.err = err
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pdfRenderTimeout)
+	defer cancel()
+	start := time.Now()
+	out, err := exec.CommandContext(ctx, p.tool, "-png", "-scale-to", strconv.Itoa(pdfRenderSize),
+		"-l", strconv.Itoa(maxPDFPages), src, filepath.Join(dir, "page")).CombinedOutput()
+	if err != nil {
+		job.err = fmt.Errorf("pdftoppm: %v: %s", err, out)
+		os.RemoveAll(dir)
+		return
+	}
+	// pdftoppm zero-pads the page numbers, so the names sort in page order.
+	job.pages, _ = filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if len(job.pages) == 0 {
+		job.err = errors.New("pdftoppm produced no pages")
+		return
+	}
+	slog.Info("Rendered PDF result", "file", src, "pages", len(job.pages), "took", time.Since(start).Round(time.Millisecond))
+}
+
+// pdfPagerTemplate shows the page images full screen, one at a time.
+var pdfPagerTemplate = template.Must(template.New("pdf").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: #fff; overflow: hidden; }
+img { display: none; width: 100vw; height: 100vh; object-fit: contain; }
+img.active { display: block; }
+#pageNo { position: fixed; right: 12px; bottom: 8px; font: 14px sans-serif; color: #666; }
+</style>
+</head>
+<body>
+{{range $i, $src := .Pages}}<img src="{{$src}}" alt=""{{if eq $i 0}} class="active"{{end}}>
+{{end}}{{if gt (len .Pages) 1}}<div id="pageNo">1 / {{len .Pages}}</div>
+<script>
+const pages = document.querySelectorAll("img");
+let current = 0;
+setInterval(() => {
+    pages[current].classList.remove("active");
+    current = (current + 1) % pages.length;
+    pages[current].classList.add("active");
+    document.getElementById("pageNo").textContent = (current + 1) + " / " + pages.length;
+}, {{.Seconds}} * 1000);
+</script>
+{{end}}</body>
+</html>
+`))
+
+// servePDF answers /results/<file>.pdf: an HTML page cycling through the
+// rendered pages every pageSeconds, or with ?page=N one page image.
+func (p *PDFRenderer) servePDF(w http.ResponseWriter, r *http.Request, src string, pageSeconds int) {
+	pages, key, err := p.Pages(src)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to render PDF result", "file", src, "err", err)
+		http.Error(w, "Failed to render PDF", http.StatusInternalServerError)
+		return
+	}
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=86400") // The URL carries the version
+		http.ServeFile(w, r, pages[n-1])
+		return
+	}
+
+	name := path.Base(r.URL.Path)
+	data := struct {
+		Title   string
+		Pages   []string
+		Seconds int
+	}{Title: name, Seconds: pageSeconds}
+	for i := range pages {
+		data.Pages = append(data.Pages, url.PathEscape(name)+"?page="+strconv.Itoa(i+1)+"&v="+key)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := pdfPagerTemplate.Execute(w, data); err != nil {
+		slog.Error("Error writing PDF page", "err", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"display/internal/protocol"
+)
+
+// Penalties (handball's 2 minutes, hockey's minors) run with the game clock:
+// they only count down while the room's timer runs and are removed when they
+// run out. They are part of TimerState, so every timer_update carries them.
+const (
+	defaultPenaltySeconds = 2 * 60
+	maxPenaltySeconds     = 10 * 60
+	maxPenalties          = 12 // Per room
+	maxPlayerLen          = 8  // A shirt number or short name
+)
+
+// Penalty is a running penalty of a team or one of its players.
+type Penalty = protocol.Penalty
+
+// penaltyControl is the payload of penalty_control and POST /api/penalty.
+type penaltyControl struct {
+	Action  string `json:"action"` // "add", "remove" or "clear"
+	Team    string `json:"team"`
+	Player  string `json:"player"`
+	Seconds int    `json:"seconds"` // Length of an added penalty; defaultPenaltySeconds if 0
+	ID      int    `json:"id"`      // Penalty to remove
+}
+
+// ApplyPenalty carries out a validated penalty_control and broadcasts the
+// timer state. It returns the audit target and value of the action.
+func (tm *TimerManager) ApplyPenalty(p penaltyControl) (target, value string, err error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	switch p.Action {
+	case "add":
+		if len(tm.State.Penalties) >= maxPenalties {
+			return "", "", errors.New("too many penalties running")
+		}
+		seconds := p.Seconds
+		if seconds == 0 {
+			seconds = defaultPenaltySeconds
+		}
+		tm.nextPenalty++
+		penalty := Penalty{ID: tm.nextPenalty, Team: p.Team, Player: p.Player, Duration: seconds, TimeLeft: seconds}
+		tm.State.Penalties = append(slices.Clone(tm.State.Penalties), penalty)
+		target, value = penaltyTarget(penalty), strconv.Itoa(seconds)
+	case "remove":
+		left := make([]Penalty, 0, len(tm.State.Penalties))
+		for _, penalty := range tm.State.Penalties {
+			if penalty.ID == p.ID {
+				target = penaltyTarget(penalty)
+			} else {
+				left = append(left, penalty)
+			}
+		}
+		tm.State.Penalties = left
+		value = strconv.Itoa(p.ID)
+	case "clear":
+		tm.State.Penalties = nil
+	}
+	tm.broadcastState()
+	return target, value, nil
+}
+
+// penaltyTarget names whom a penalty is for in the audit log, e.g. "home #12".
+func penaltyTarget(p Penalty) string {
+	if p.Player == "" {
+		return p.Team
+	}
+	return p.Team + " #" + p.Player
+}
+
+// tickPenalties counts the penalties down with the game clock, with tm.mu
+// held, and removes those that ran out; it reports whether any did. The
+// slice is replaced rather than changed, since copies of State share it.
+func (tm *TimerManager) tickPenalties() (expired bool) {
+	if len(tm.State.Penalties) == 0 {
+		return false
+	}
+	left := make([]Penalty, 0, len(tm.State.Penalties))
+	for _, p := range tm.State.Penalties {
+		p.TimeLeft--
+		if p.TimeLeft > 0 {
+			left = append(left, p)
+			continue
+		}
+		expired = true
+		slog.Info("Penalty expired", "room", tm.Room, "team", p.Team, "player", p.Player)
+	}
+	tm.State.Penalties = left
+	return expired
+}
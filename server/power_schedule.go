@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"display/internal/protocol"
+)
+
+// Power schedules (server.json "powerSchedules": room -> PowerSchedule, ""
+// for the default room) switch a room's screens on and off each day and dim
+// them during breaks. The server sends a display its room's schedule as
+// power_schedule when it joins and whenever the config changes; the display
+// saves it and follows it with its own clock, so the screens switch even
+// when the server is down at the time.
+
+type PowerSchedule = protocol.PowerSchedule
+
+const maxDimPeriods = 24
+
+// validClock reports whether s is a zero-padded "HH:MM".
+func validClock(s string) bool {
+	_, err := time.Parse("15:04", s)
+	return err == nil && len(s) == 5
+}
+
+// validatePowerSchedules checks the powerSchedules setting.
+func validatePowerSchedules(schedules map[string]PowerSchedule) []string {
+	var problems []string
+	for room, s := range schedules {
+		prefix := fmt.Sprintf("powerSchedules: room %q: ", room)
+		if err := validateRoomName(room); err != nil {
+			problems = append(problems, prefix+err.Error())
+		}
+		switch {
+		case (s.On == "") != (s.Off == ""):
+			problems = append(problems, prefix+"on and off must both be set or both be empty")
+		case s.On != "" && (!validClock(s.On) || !validClock(s.Off)):
+			problems = append(problems, prefix+"on and off must be HH:MM")
+		case s.On != "" && s.On == s.Off:
+			problems = append(problems, prefix+"on and off must differ")
+		}
+		if s.Brightness < 0 || s.Brightness > 100 {
+			problems = append(problems, prefix+"brightness must be between 0 and 100")
+		}
+		if len(s.Dim) > maxDimPeriods {
+			problems = append(problems, fmt.Sprintf("%sat most %d dim periods", prefix, maxDimPeriods))
+		}
+		for i, d := range s.Dim {
+			switch {
+			case !validClock(d.From) || !validClock(d.To):
+				problems = append(problems, fmt.Sprintf("%sdim %d: from and to must be HH:MM", prefix, i+1))
+			case d.From == d.To:
+				problems = append(problems, fmt.Sprintf("%sdim %d: from and to must differ", prefix, i+1))
+			}
+			if d.Brightness < 0 || d.Brightness > 100 {
+				problems = append(problems, fmt.Sprintf("%sdim %d: brightness must be between 0 and 100", prefix, i+1))
+			}
+		}
+	}
+	return problems
+}
+
+// powerScheduleMessage marshals the power_schedule message of schedule;
+// nil if that fails.
+func powerScheduleMessage(schedule PowerSchedule) []byte {
+	data, err := json.Marshal(protocol.Envelope{Type: "power_schedule", Payload: schedule})
+	if err != nil {
+		slog.Error("Error marshaling power_schedule message", "err", err)
+		return nil
+	}
+	return data
+}
+
+// pushPowerSchedules sends every connected display its room's schedule,
+// after the config changed.
+func (h *Hub) pushPowerSchedules() {
+	h.mu.Lock()
+	var displays []*Client
+	var schedules []PowerSchedule
+	for client := range h.Clients {
+		if client.Role == roleDisplay && client.ID != "" {
+			displays = append(displays, client)
+			schedules = append(schedules, h.PowerSchedules[client.Room])
+		}
+	}
+	h.mu.Unlock()
+	for i, client := range displays {
+		if data := powerScheduleMessage(schedules[i]); data != nil {
+			h.SendTo <- struct {
+				Client *Client
+				Msg    []byte
+			}{Client: client, Msg: data}
+		}
+	}
+	slog.Info("Power schedules sent to displays", "displays", len(displays))
+}
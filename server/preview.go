@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	previewLines   = 15
+	previewLineLen = 120
+	previewMaxRead = 1 << 20 // Enough for the first lines of any export
+)
+
+// FilePreview is what GET /api/files/{name}/preview returns: enough of a
+// result file to recognise it before putting it on every screen.
+type FilePreview struct {
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind"` // html, text, csv, startlist or pdf
+	Title string   `json:"title,omitempty"`
+	Lines []string `json:"lines"`           // First lines of visible text or table rows
+	Image string   `json:"image,omitempty"` // First page image (PDFs, when pdftoppm is installed)
+}
+
+// previewBlockTags start a new line in the text of an HTML preview.
+var previewBlockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "tr": true, "li": true, "table": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// htmlPreview extracts the title and the first lines of visible text, with
+// table cells separated by " | ".
+func htmlPreview(data []byte) (title string, lines []string) {
+	z := html.NewTokenizer(strings.NewReader(decodeLatin1(data)))
+	var text strings.Builder
+	newlines, lineStart, cellStart := 0, true, false
+	newline := func() {
+		text.WriteByte('\n')
+		newlines++
+		lineStart = true
+	}
+	skip, inTitle := 0, false
+	for newlines < previewLines*4 { // Plenty, even after dropping empty lines
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case tag == "script" || tag == "style":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case tag == "title":
+				inTitle = true
+			case tag == "td" || tag == "th":
+				cellStart = true
+			case previewBlockTags[tag]:
+				newline()
+			}
+		case html.EndTagToken:
+			switch {
+			case (tag == "script" || tag == "style") && skip > 0:
+				skip--
+			case tag == "title":
+				inTitle = false
+			case previewBlockTags[tag]:
+				newline()
+			}
+		case html.TextToken:
+			s := strings.Join(strings.Fields(string(z.Text())), " ")
+			if skip > 0 || s == "" {
+				continue
+			}
+			if inTitle {
+				title = strings.TrimSpace(title + " " + s)
+				continue
+			}
+			switch {
+			case lineStart:
+			case cellStart:
+				text.WriteString(" | ")
+			default:
+				text.WriteByte(' ')
+			}
+			text.WriteString(s)
+			lineStart, cellStart = false, false
+		}
+	}
+	return title, firstLines(text.String())
+}
+
+// firstLines returns up to previewLines non-empty lines of text, shortened
+// to previewLineLen characters.
+func firstLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if utf8.RuneCountInString(line) > previewLineLen {
+			line = string([]rune(line)[:previewLineLen-1]) + "…"
+		}
+		lines = append(lines, line)
+		if len(lines) == previewLines {
+			break
+		}
+	}
+	return lines
+}
+
+// buildPreview reads the start of the result file at src.
+func buildPreview(name, src string, settings Settings, pdf *PDFRenderer) (*FilePreview, error) {
+	p := &FilePreview{Name: name, Lines: []string{}}
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".pdf" {
+		p.Kind = "pdf"
+		if _, err := os.Stat(src); err != nil {
+			return nil, err
+		}
+		if pdf.Available() {
+			_, key, err := pdf.Pages(src)
+			if err != nil {
+				return nil, err
+			}
+			p.Image = settings.Proxy.BasePath + "/results/" + escapeResultPath(name) + "?page=1&v=" + key
+		}
+		return p, nil
+	}
+
+	if ext == ".xml" || ext == ".csv" {
+		if title, starters, ok, err := readStartList(src, settings.StartList, settings.CSV, time.Now()); ok {
+			if err != nil {
+				return nil, err
+			}
+			p.Kind, p.Title = "startlist", title
+			for _, s := range starters[:min(len(starters), previewLines)] {
+				p.Lines = append(p.Lines, strings.Join(slices.DeleteFunc([]string{s.Start.Format("15:04:05"), s.Class, s.Name, s.Club}, func(f string) bool { return f == "" }), " "))
+			}
+			return p, nil
+		}
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, previewMaxRead))
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".htm", ".html":
+		p.Kind = "html"
+		p.Title, p.Lines = htmlPreview(data)
+	case ".csv", ".txt":
+		p.Kind = "text"
+		if t, err := parseTable(data, settings.CSV); err == nil && (ext == ".csv" || settings.CSV.Txt) {
+			p.Kind = "csv"
+			rows := t.Rows
+			if t.Header != nil {
+				rows = append([][]string{t.Header}, rows...)
+			}
+			var text strings.Builder
+			for _, row := range rows[:min(len(rows), previewLines)] {
+				text.WriteString(strings.Join(row, " | ") + "\n")
+			}
+			p.Lines = firstLines(text.String())
+		} else {
+			p.Lines = firstLines(decodeLatin1(bytes.ReplaceAll(data, []byte("\r"), nil)))
+		}
+	default:
+		p.Kind = "text"
+		p.Lines = firstLines(decodeLatin1(data))
+	}
+	return p, nil
+}
+
+// escapeResultPath escapes each segment of a result file name for a URL.
+func escapeResultPath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// registerPreviewAPI serves previews of result files.
+func registerPreviewAPI(cfgMgr *ConfigManager, pdf *PDFRenderer) {
+	// GET /api/files/{name}/preview -> FilePreview; name is path-escaped
+	// ("hall2%2Fheat1.html")
+	http.HandleFunc("GET /api/files/{name}/preview", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := validateResultFile(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings := cfgMgr.Current()
+		src, ok := resolveResultPath(settings.ResultsDir, settings.ResultsAliases, name)
+		if !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		p, err := buildPreview(name, src, settings, pdf)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to preview result", "file", name, "err", err)
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	})
+}
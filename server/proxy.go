@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyOptions is for running behind a reverse proxy such as nginx or Caddy,
+// e.g. at https://display.club.org/arena1/. Displays keep connecting to the
+// server directly; the proxy is for the admin UI and other browsers.
+type ProxyOptions struct {
+	// Path the proxy serves the server under, e.g. "/arena1". Requests are
+	// accepted with and without it, so it works whether or not the proxy
+	// strips it.
+	BasePath string `json:"basePath" yaml:"basePath" toml:"basePath"`
+	// Addresses (IPs or CIDRs) of proxies whose X-Forwarded-Host and
+	// X-Forwarded-Proto are believed, e.g. "127.0.0.1" for one on this machine
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies" toml:"trustedProxies"`
+}
+
+func (o ProxyOptions) validate() error {
+	if o.BasePath != "" {
+		if !strings.HasPrefix(o.BasePath, "/") || strings.HasSuffix(o.BasePath, "/") || strings.ContainsAny(o.BasePath, "?#") {
+			return fmt.Errorf("basePath %q must look like \"/arena1\"", o.BasePath)
+		}
+		if u, err := url.Parse(o.BasePath); err != nil || u.Path != o.BasePath {
+			return fmt.Errorf("basePath %q is not a plain URL path", o.BasePath)
+		}
+	}
+	for _, p := range o.TrustedProxies {
+		if _, err := parseIPOrCIDR(p); err != nil {
+			return fmt.Errorf("trustedProxies: %q is not an IP address or CIDR", p)
+		}
+	}
+	return nil
+}
+
+// parseIPOrCIDR reads "10.0.0.1" as 10.0.0.1/32 and "10.0.0.0/8" as is.
+func parseIPOrCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 128
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+// trusts reports whether remoteAddr is one of the trusted proxies.
+func (o ProxyOptions) trusts(remoteAddr string) bool {
+	ip := net.ParseIP(splitHostPortSafe(remoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, p := range o.TrustedProxies {
+		if network, err := parseIPOrCIDR(p); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the browser or display behind r: the first
+// X-Forwarded-For entry when r comes from a trusted proxy, otherwise the
+// remote address.
+func (o ProxyOptions) clientIP(r *http.Request) string {
+	if o.trusts(r.RemoteAddr) {
+		if ip, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ","); strings.TrimSpace(ip) != "" {
+			return strings.TrimSpace(ip)
+		}
+	}
+	return splitHostPortSafe(r.RemoteAddr)
+}
+
+// publicURLKey is the request context key of the URL the browser used to
+// reach the server (see publicURL).
+type publicURLKey struct{}
+
+// proxyHandler applies opts to every request: forwarded headers of trusted
+// proxies become the request's Host (which checkOrigin compares the Origin
+// with) and scheme, and the base path is stripped, so handlers need to know
+// about neither.
+func proxyHandler(opts ProxyOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if opts.trusts(r.RemoteAddr) {
+			// A chain of proxies lists one value per hop; the first is the
+			// browser's
+			if host, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ","); strings.TrimSpace(host) != "" {
+				r.Host = strings.TrimSpace(host)
+			}
+			if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); strings.TrimSpace(proto) != "" {
+				scheme = strings.ToLower(strings.TrimSpace(proto))
+			}
+		}
+		if opts.BasePath != "" {
+			if r.URL.Path == opts.BasePath {
+				http.Redirect(w, r, opts.BasePath+"/", http.StatusMovedPermanently)
+				return
+			}
+			if rest, ok := strings.CutPrefix(r.URL.Path, opts.BasePath+"/"); ok {
+				r.URL.Path = "/" + rest
+				r.URL.RawPath = ""
+			}
+		}
+		public := &url.URL{Scheme: scheme, Host: r.Host, Path: opts.BasePath}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), publicURLKey{}, public)))
+	})
+}
+
+// basePath is the proxy's base path ("" without one), to put in front of
+// absolute paths sent to browsers.
+func basePath(r *http.Request) string {
+	if u, ok := r.Context().Value(publicURLKey{}).(*url.URL); ok {
+		return u.Path
+	}
+	return ""
+}
+
+// publicURL is the server's base URL as the browser of r sees it, e.g.
+// https://display.club.org/arena1 behind a proxy.
+func publicURL(r *http.Request) string {
+	if u, ok := r.Context().Value(publicURLKey{}).(*url.URL); ok {
+		return u.String()
+	}
+	return "http://" + r.Host
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	maxQRSize     = 1024
+)
+
+// qrTarget is the URL GET /api/qr encodes for target: the /live page of a
+// room or the admin UI, as the requesting browser reaches the server (so a
+// proxy's public address ends up in the code).
+func qrTarget(r *http.Request, target, room string) (string, bool) {
+	switch target {
+	case "live":
+		u := publicURL(r) + "/live"
+		if room != defaultRoom {
+			u += "?room=" + url.QueryEscape(room)
+		}
+		return u, true
+	case "admin":
+		return publicURL(r) + "/admin/admin.html", true
+	}
+	return "", false
+}
+
+// registerQRAPI serves GET /api/qr?target=live|admin[&room=][&size=], a PNG
+// QR code to print or put on a screen so phones open the page without
+// anyone typing the server's address. Like /live it needs no token.
+func registerQRAPI(hub *Hub) {
+	http.HandleFunc("GET /api/qr", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		room := q.Get("room")
+		if err := hub.checkRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		target, ok := qrTarget(r, q.Get("target"), room)
+		if !ok {
+			http.Error(w, "target must be live or admin", http.StatusBadRequest)
+			return
+		}
+		size := defaultQRSize
+		if s := q.Get("size"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 64 || n > maxQRSize {
+				http.Error(w, "size must be 64-1024", http.StatusBadRequest)
+				return
+			}
+			size = n
+		}
+		png, err := qrcode.Encode(target, qrcode.Medium, size)
+		if err != nil {
+			slog.Error("Error encoding QR code", "target", target, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(png)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// Control messages (timer_control, penalty_control, score_control,
+// splits_view, set_result, client_command) change what every display shows, so each connection may
+// only send a few per second.
+const (
+	controlRate  = 10 // Messages per second, sustained
+	controlBurst = 20 // Messages allowed back to back
+	// A connection is closed after maxStrikes rejected messages (invalid or
+	// over the rate) within strikeWindow.
+	maxStrikes   = 30
+	strikeWindow = time.Minute
+)
+
+// controlMessages are the message types that are rate limited and validated.
+var controlMessages = map[string]bool{
+	"timer_control":   true,
+	"penalty_control": true,
+	"score_control":   true,
+	"splits_view":     true,
+	"set_result":      true,
+	"client_command":  true,
+	"operator_lock":   true,
+	"undo":            true,
+	"redo":            true,
+	"scene":           true,
+}
+
+// tokenBucket is a minimal rate limiter; it is only used by its connection's
+// readPump, so it needs no lock.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = controlBurst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * controlRate
+		if b.tokens > controlBurst {
+			b.tokens = controlBurst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// strikes counts rejected messages of one connection. Also readPump only.
+type strikes struct {
+	count int
+	since time.Time
+}
+
+// add records a rejection and reports whether the sender should be dropped.
+func (s *strikes) add(now time.Time) bool {
+	if now.Sub(s.since) > strikeWindow {
+		s.count, s.since = 0, now
+	}
+	s.count++
+	return s.count > maxStrikes
+}
+
+// reject counts a strike against the sender and tells it why msg was refused.
+// It reports false once the connection has collected too many strikes and
+// should be closed.
+func (c *Client) reject(msg Message, reason string) bool {
+	first := c.strikes.count == 0 || time.Since(c.strikes.since) > strikeWindow
+	if c.strikes.add(time.Now()) {
+		slog.Warn("Closing connection after repeated invalid or excessive messages",
+			"name", c.Name, "addr", c.Addr, "last", reason)
+		return false
+	}
+	if first {
+		slog.Warn("Rejected message", "type", msg.Type, "name", c.Name, "addr", c.Addr, "reason", reason)
+	} else {
+		slog.Debug("Rejected message", "type", msg.Type, "name", c.Name, "addr", c.Addr, "reason", reason)
+	}
+	c.sendError(msg, reason)
+	return true
+}
+
+// sendError answers msg with {"type":"error","replyTo":<msgId>,"payload":reason}.
+func (c *Client) sendError(msg Message, reason string) {
+	data, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		ReplyTo string `json:"replyTo,omitempty"`
+		Payload string `json:"payload"`
+	}{
+		Type:    "error",
+		ReplyTo: msg.MsgID,
+		Payload: reason,
+	})
+	if err == nil {
+		c.Hub.sendDirect(c, data)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxReplayGap shortens longer pauses in a recording, e.g. while the server
+// was stopped between two recorded sessions in the same file.
+const maxReplayGap = 5 * time.Minute
+
+// maxRecordedLine is the longest line a replay reads; client lists of big
+// venues are the longest messages.
+const maxRecordedLine = 4 << 20
+
+// replaySkipped are broadcasts that are not replayed: the recording server's
+// connections and clock, which the replaying server has its own of.
+var replaySkipped = map[string]bool{
+	"client_list": true, "client_joined": true, "client_left": true, "client_updated": true,
+	"config_changed": true, "time_sync": true,
+}
+
+// RecordedMessage is one line of a recording: a broadcast as the hub sent it.
+type RecordedMessage struct {
+	Time           int64           `json:"time"`                     // Unix milliseconds
+	All            bool            `json:"all,omitempty"`            // To every connection
+	Room           string          `json:"room,omitempty"`           // Otherwise to this room's
+	BeforeProtocol int             `json:"beforeProtocol,omitempty"` // ...only those older than this
+	Msg            json.RawMessage `json:"msg"`
+}
+
+// Recorder appends every broadcast to a JSON lines file (-record), so an
+// event session can be replayed later (-replay) for rehearsals, demos and
+// reproducing what a club saw.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openRecorder opens (or creates) path for appending.
+func openRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	return &Recorder{file: f}, nil
+}
+
+// Record appends one broadcast. A nil recorder records nothing.
+func (r *Recorder) Record(all bool, room string, beforeProtocol int, msg []byte) {
+	if r == nil {
+		return
+	}
+	line, err := json.Marshal(RecordedMessage{Time: time.Now().UnixMilli(), All: all, Room: room,
+		BeforeProtocol: beforeProtocol, Msg: msg})
+	if err != nil {
+		slog.Error("Error marshaling recorded message", "err", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write recording", "err", err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// Replay broadcasts the recording at path to the connected clients with the
+// recorded pauses divided by speed, from the start again when loop is set,
+// until the file ends or stop is closed. Result files are referred to by
+// name, so the results folder should hold the recorded event's files.
+func (h *Hub) Replay(path string, speed float64, loop bool, stop <-chan struct{}) error {
+	for {
+		if err := h.replayOnce(path, speed, stop); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if !loop {
+			slog.Info("Replay finished", "file", path)
+			return nil
+		}
+		slog.Info("Replaying again", "file", path)
+	}
+}
+
+func (h *Hub) replayOnce(path string, speed float64, stop <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordedLine)
+	var last int64
+	for scanner.Scan() {
+		var rec RecordedMessage
+		var msg Message
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil || json.Unmarshal(rec.Msg, &msg) != nil {
+			continue
+		}
+		if replaySkipped[msg.Type] {
+			continue
+		}
+		if last != 0 && rec.Time > last {
+			gap := min(time.Duration(rec.Time-last)*time.Millisecond, maxReplayGap)
+			select {
+			case <-time.After(time.Duration(float64(gap) / speed)):
+			case <-stop:
+				return nil
+			}
+		}
+		last = rec.Time
+
+		// Result switches become the room's result, so displays joining
+		// during the replay and the admin UI show it too
+		var result resultMessage
+		if msg.Type == "set_result" && !rec.All && rec.BeforeProtocol == 0 && json.Unmarshal(rec.Msg, &result) == nil {
+			h.switchResult(rec.Room, result.Payload.File, "replay", "")
+			continue
+		}
+		if msg.Type == "set_result" && rec.BeforeProtocol == resultPatchProtocol {
+			rec.BeforeProtocol = 0 // The refresh newer clients got as a patch, which is not recorded
+		}
+		data := []byte(rec.Msg)
+		if msg.Type == "timer_update" {
+			data = shiftTimerUpdate(data, rec.Time, speed)
+		}
+		if rec.All {
+			h.Broadcast <- data
+		} else {
+			h.RoomBroadcast <- roomMessage{Room: rec.Room, Msg: data, BeforeProtocol: rec.BeforeProtocol}
+		}
+	}
+	return scanner.Err()
+}
+
+// shiftTimerUpdate moves the endsAt of a timer_update recorded at recorded
+// (Unix ms) to now, so displays counting down to it show the recorded time
+// left rather than a timer that ran out long ago.
+func shiftTimerUpdate(data []byte, recorded int64, speed float64) []byte {
+	var update struct {
+		Type    string     `json:"type"`
+		Payload TimerState `json:"payload"`
+	}
+	if json.Unmarshal(data, &update) != nil || update.Payload.EndsAt == 0 {
+		return data
+	}
+	left := float64(update.Payload.EndsAt-recorded) / speed
+	update.Payload.EndsAt = time.Now().UnixMilli() + int64(left)
+	shifted, err := json.Marshal(update)
+	if err != nil {
+		return data
+	}
+	return shifted
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRemoteInterval = 30 * time.Second
+	minRemoteInterval     = 5 * time.Second  // Be polite to public result services
+	maxRemoteSize         = 10 << 20         // Result pages are small; refuse anything silly
+	remoteTimeout         = 20 * time.Second // Per request
+)
+
+// RemoteSource is a result page the server downloads into the results
+// folder, for venues where the timing PC cannot share a folder but publishes
+// its results on the web.
+type RemoteSource struct {
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	File     string `json:"file" yaml:"file" toml:"file"`             // Saved as this result file, e.g. "live/heat1.html"
+	Interval string `json:"interval" yaml:"interval" toml:"interval"` // Poll interval, e.g. "30s" (default 30s, at least 5s)
+}
+
+// interval returns the parsed poll interval, defaulting when empty.
+func (s RemoteSource) interval() (time.Duration, error) {
+	if s.Interval == "" {
+		return defaultRemoteInterval, nil
+	}
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("interval %q is not a duration like \"30s\"", s.Interval)
+	}
+	if d < minRemoteInterval {
+		return 0, fmt.Errorf("interval %s is shorter than %s", d, minRemoteInterval)
+	}
+	return d, nil
+}
+
+func (s RemoteSource) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an http(s) URL", s.URL)
+	}
+	if err := validateResultFile(s.File); err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	_, err = s.interval()
+	return err
+}
+
+// RemoteStatus is an entry of GET /api/remote.
+type RemoteStatus struct {
+	URL        string    `json:"url"`
+	File       string    `json:"file"`
+	LastCheck  time.Time `json:"lastCheck,omitzero"`
+	LastChange time.Time `json:"lastChange,omitzero"`
+	LastError  string    `json:"lastError,omitempty"` // Empty once a fetch succeeds again
+}
+
+// RemoteFetcher polls the configured remote sources. Each source has its own
+// goroutine, so a slow site only delays its own file.
+type RemoteFetcher struct {
+	Hub     *Hub
+	client  *http.Client
+	mu      sync.Mutex
+	targets []remoteTarget
+	stop    chan struct{} // Closed to stop the current pollers
+	status  map[string]*RemoteStatus
+}
+
+// remoteTarget is a source with the file it is saved to.
+type remoteTarget struct {
+	RemoteSource
+	path string
+}
+
+func NewRemoteFetcher(hub *Hub) *RemoteFetcher {
+	return &RemoteFetcher{Hub: hub, client: &http.Client{Timeout: remoteTimeout}}
+}
+
+// Apply (re)starts polling for the sources in settings. Nothing is restarted
+// if neither the sources nor the folders they are saved to changed.
+func (f *RemoteFetcher) Apply(settings Settings) {
+	var targets []remoteTarget
+	for _, src := range settings.RemoteSources {
+		path, ok := resolveResultPath(settings.ResultsDir, settings.ResultsAliases, src.File)
+		if !ok {
+			slog.Error("Remote source file is outside the results folder", "file", src.File)
+			continue
+		}
+		targets = append(targets, remoteTarget{RemoteSource: src, path: path})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stop != nil && slices.Equal(f.targets, targets) {
+		return
+	}
+	if f.stop != nil {
+		close(f.stop)
+	}
+	f.targets = targets
+	f.stop = make(chan struct{})
+	f.status = make(map[string]*RemoteStatus)
+	for _, t := range targets {
+		status := &RemoteStatus{URL: t.URL, File: t.File}
+		f.status[t.File] = status
+		go f.poll(t, status, f.stop)
+	}
+	if len(targets) > 0 {
+		slog.Info("Polling remote result sources", "count", len(targets))
+	}
+}
+
+// Stop ends all polling.
+func (f *RemoteFetcher) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+// Status returns the state of every source, in config order.
+func (f *RemoteFetcher) Status() []RemoteStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []RemoteStatus{}
+	for _, t := range f.targets {
+		out = append(out, *f.status[t.File])
+	}
+	return out
+}
+
+// poll fetches t into its file every interval until stop is closed.
+func (f *RemoteFetcher) poll(t remoteTarget, status *RemoteStatus, stop <-chan struct{}) {
+	src, path := t.RemoteSource, t.path
+	interval, _ := src.interval() // Validated with the config
+	var cache remoteCache
+	if data, err := os.ReadFile(path); err == nil {
+		cache.hash = sha256.Sum256(data) // Don't announce an unchanged file after a restart
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		changed, err := f.fetch(src, path, &cache)
+		f.mu.Lock()
+		status.LastCheck = time.Now()
+		if changed {
+			status.LastChange = status.LastCheck
+		}
+		prevErr := status.LastError
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+		}
+		f.mu.Unlock()
+
+		switch {
+		case err != nil && prevErr == "": // Log once per outage
+			slog.Warn("Remote source fetch failed", "url", src.URL, "err", err)
+		case err == nil && prevErr != "":
+			slog.Info("Remote source reachable again", "url", src.URL)
+		}
+		if changed {
+			slog.Info("Remote result updated", "file", src.File, "url", src.URL)
+			f.Hub.RefreshResult(src.File)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// remoteCache is what a poller remembers between requests.
+type remoteCache struct {
+	etag         string
+	lastModified string
+	hash         [sha256.Size]byte
+}
+
+// fetch downloads src with a conditional request and writes it to path if
+// the content changed. Servers that ignore ETag/Last-Modified are caught by
+// comparing a hash of the body.
+func (f *RemoteFetcher) fetch(src RemoteSource, path string, cache *remoteCache) (changed bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, src.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", "score-display/"+version)
+	if cache.etag != "" {
+		req.Header.Set("If-None-Match", cache.etag)
+	}
+	if cache.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.lastModified)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("server returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return false, err
+	}
+	if len(data) > maxRemoteSize {
+		return false, errors.New("page larger than 10 MB")
+	}
+	cache.etag = resp.Header.Get("ETag")
+	cache.lastModified = resp.Header.Get("Last-Modified")
+
+	hash := sha256.Sum256(data)
+	if bytes.Equal(hash[:], cache.hash[:]) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return false, err
+	}
+	cache.hash = hash
+	return true, nil
+}
+
+// writeFileAtomic replaces path via a temporary file, so displays never load
+// a half-written page. The file keeps the mode of the one it replaces, or
+// gets 0644.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".remote-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+	mode := os.FileMode(0644) // CreateTemp makes 0600 files, which a web server next to us cannot read
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// registerRemoteAPI serves the state of the remote sources.
+func registerRemoteAPI(remote *RemoteFetcher) {
+	// GET /api/remote -> [RemoteStatus]
+	http.HandleFunc("GET /api/remote", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(remote.Status())
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+	fresh := filepath.Join(dir, "new.html")
+	if err := writeFileAtomic(fresh, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(dir, "kept.html")
+	if err := os.WriteFile(kept, []byte("a"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(kept, 0o640); err != nil { // Regardless of the umask
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(kept, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{fresh: 0o644, kept: 0o640} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s: mode %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"display/internal/protocol"
+
+	"golang.org/x/net/html"
+)
+
+// With resultDiff on, set_result carries an ETag of the file and the server
+// keeps the rows of each table of the version it sent. When the file is
+// updated in place (the watcher or a remote source calls RefreshResult), the
+// new version is compared with it and displays from resultPatchProtocol on
+// get result_patch with row operations instead, which the page applies
+// without reloading (and flickering), animating rows to their new places; a
+// file rewritten unchanged sends nothing. Rows are matched by their key (the
+// first cell with a name in it, see rowKey) when every row of the table has
+// a different one, otherwise by position. Anything else than rows changing,
+// a page with nested tables or a patch inserting or deleting more than half
+// of the rows (most likely another list) falls back to a reload. Updates do
+// not count: on a leaderboard every row below a move gets a new place. Rows
+// are sanitized as with sanitizeHTML.
+
+const resultPatchProtocol = protocol.ResultPatchVersion
+
+// maxResultVersions bounds the versions kept; rooms seldom show more results
+// at once.
+const maxResultVersions = 32
+
+// resultVersion is what the diff needs of a result version.
+type resultVersion struct {
+	etag     string
+	skeleton [sha256.Size]byte // The page without its table rows
+	tables   [][]resultRow
+}
+
+// resultRow is a table row as HTML, and its key.
+type resultRow struct {
+	html string
+	key  string
+}
+
+// resultVersions holds the last version sent of each diffed result, by file
+// as in set_result.
+type resultVersions struct {
+	mu       sync.Mutex
+	versions map[string]*resultVersion
+}
+
+// store remembers v as the version of file that displays have, returning
+// the one before it (nil if none).
+func (rv *resultVersions) store(file string, v *resultVersion) *resultVersion {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	if rv.versions == nil {
+		rv.versions = make(map[string]*resultVersion)
+	}
+	prev := rv.versions[file]
+	if prev == nil && len(rv.versions) >= maxResultVersions {
+		for name := range rv.versions {
+			delete(rv.versions, name) // Any one; it only costs a reload
+			break
+		}
+	}
+	rv.versions[file] = v
+	return prev
+}
+
+// diffable reports whether file is diffed: resultDiff is on and it is an
+// HTML result.
+func (h *Hub) diffable(file string) bool {
+	h.mu.Lock()
+	on := h.ResultDiff
+	h.mu.Unlock()
+	ext := strings.ToLower(filepath.Ext(file))
+	return on && (ext == ".htm" || ext == ".html")
+}
+
+// readResultVersion reads and parses the current version of file; nil if
+// it cannot be read.
+func (h *Hub) readResultVersion(file string) *resultVersion {
+	h.mu.Lock()
+	abs, ok := resolveResultPath(h.ResultsDir, h.ResultsAliases, file)
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		slog.Debug("Result not diffed", "file", file, "err", err)
+		return nil
+	}
+	return parseResultVersion(data)
+}
+
+// resultETag returns the ETag of file for a set_result switching to it, and
+// keeps its rows for the next update; "" if it is not diffed.
+func (h *Hub) resultETag(file string) string {
+	if !h.diffable(file) {
+		return ""
+	}
+	v := h.readResultVersion(file)
+	if v == nil {
+		return ""
+	}
+	h.versions.store(file, v)
+	return v.etag
+}
+
+// refreshMessages returns what RefreshResult sends for file: a set_result
+// (with the new ETag if diffed) and, if the change can be patched, the
+// result_patch that replaces it for clients from resultPatchProtocol on. A
+// nil set_result means the content did not change.
+func (h *Hub) refreshMessages(file string) (result, patch []byte) {
+	msg := newResultMessage(file, "")
+	var next *resultVersion
+	if h.diffable(file) {
+		next = h.readResultVersion(file)
+	}
+	if next != nil {
+		msg.Payload.ETag = next.etag
+		prev := h.versions.store(file, next)
+		if prev != nil && prev.etag == next.etag {
+			return nil, nil // Rewritten with the same content
+		}
+		if p := diffResultVersions(file, prev, next); p != nil {
+			var err error
+			if patch, err = json.Marshal(protocol.Envelope{Type: "result_patch", Payload: p}); err != nil {
+				slog.Error("Error marshaling result_patch message", "err", err)
+				patch = nil
+			}
+		}
+	}
+	result, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error marshaling result message", "err", err)
+		return nil, nil
+	}
+	return result, patch
+}
+
+// parseResultVersion splits a result page into its table rows and the rest.
+// It returns nil for pages with nested tables, whose rows the page could not
+// find by index.
+func parseResultVersion(data []byte) *resultVersion {
+	v := &resultVersion{etag: resultETagOf(data)}
+	doc := decodeLatin1(sanitizeResultHTML(data))
+	skeleton := sha256.New()
+	z := html.NewTokenizer(strings.NewReader(doc))
+	inTable := false
+	var row *strings.Builder // The row being read, nil between rows
+	var cells []string       // Its cells' text
+	endRow := func() {
+		if row != nil {
+			last := len(v.tables) - 1
+			v.tables[last] = append(v.tables[last], resultRow{html: row.String(), key: rowKey(cells)})
+			row, cells = nil, nil
+		}
+	}
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return nil
+			}
+			break
+		}
+		raw := z.Raw()
+		name, _ := z.TagName()
+		isTag := tt == html.StartTagToken || tt == html.EndTagToken || tt == html.SelfClosingTagToken
+		switch {
+		case isTag && tt != html.EndTagToken && string(name) == "table":
+			if inTable {
+				return nil
+			}
+			inTable = true
+			v.tables = append(v.tables, nil)
+		case !inTable:
+		case isTag && tt != html.EndTagToken && string(name) == "tr":
+			endRow()
+			row = &strings.Builder{}
+		case isTag && tablePart(string(name)):
+			endRow() // A row without </tr>
+			if tt == html.EndTagToken && string(name) == "table" {
+				inTable = false
+			}
+		}
+
+		switch {
+		case row != nil:
+			row.Write(raw)
+			switch {
+			case tt == html.StartTagToken && (string(name) == "td" || string(name) == "th"):
+				cells = append(cells, "")
+			case tt == html.TextToken && len(cells) > 0:
+				cells[len(cells)-1] += string(z.Text())
+			case tt == html.EndTagToken && string(name) == "tr":
+				endRow()
+			}
+		case inTable && tt == html.TextToken && len(bytes.TrimSpace(raw)) == 0:
+			// Line breaks between rows come and go with them
+		default:
+			skeleton.Write(raw)
+		}
+	}
+	endRow()
+	copy(v.skeleton[:], skeleton.Sum(nil))
+	return v
+}
+
+// tablePart reports whether a tag starts or ends a part of a table that a
+// row does not continue into.
+func tablePart(name string) bool {
+	switch name {
+	case "table", "thead", "tbody", "tfoot", "caption", "colgroup":
+		return true
+	}
+	return false
+}
+
+func resultETagOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// rowKey is the first cell with three letters or more, with its white space
+// collapsed: a name, where other cells hold places, times and points. The
+// display page finds it the same way.
+func rowKey(cells []string) string {
+	for _, cell := range cells {
+		letters := 0
+		for _, r := range cell {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+		if letters >= 3 {
+			return strings.Join(strings.Fields(cell), " ")
+		}
+	}
+	return ""
+}
+
+// diffResultVersions returns the patch from prev to next, or nil if the
+// page has to be reloaded instead.
+func diffResultVersions(file string, prev, next *resultVersion) *protocol.ResultPatch {
+	if prev == nil || prev.skeleton != next.skeleton || len(prev.tables) != len(next.tables) {
+		return nil
+	}
+	p := &protocol.ResultPatch{File: file, From: prev.etag, ETag: next.etag, TableCount: len(next.tables)}
+	edits, total := 0, 0 // Rows inserted or deleted, rows after
+	for i, rows := range next.tables {
+		tp, n := diffTable(prev.tables[i], rows)
+		if len(tp.Ops) > 0 {
+			tp.Table = i
+			p.Tables = append(p.Tables, tp)
+		}
+		edits += n
+		total += len(rows)
+	}
+	if edits*2 > total {
+		return nil
+	}
+	return p
+}
+
+// diffTable returns the ops turning the rows old into rows, and how many of
+// them insert or delete a row.
+func diffTable(old, rows []resultRow) (protocol.TablePatch, int) {
+	tp := protocol.TablePatch{FromRows: len(old), Rows: len(rows)}
+	var deletes, moves, inserts, updates []protocol.RowOp
+	if !uniqueKeys(old) || !uniqueKeys(rows) {
+		for j, row := range rows {
+			if j >= len(old) {
+				inserts = append(inserts, protocol.RowOp{Op: protocol.RowInsert, Row: j, Key: row.key, HTML: row.html})
+			} else if old[j].html != row.html {
+				updates = append(updates, protocol.RowOp{Op: protocol.RowUpdate, Row: j, Key: row.key, HTML: row.html})
+			}
+		}
+		for i := len(rows); i < len(old); i++ {
+			deletes = append(deletes, protocol.RowOp{Op: protocol.RowDelete, From: i, Key: old[i].key})
+		}
+	} else {
+		oldAt := make(map[string]int, len(old))
+		for i, row := range old {
+			oldAt[row.key] = i
+		}
+		kept := make(map[string]bool, len(rows))
+		var from, to []int // Rows in both, old and new positions in new order
+		for j, row := range rows {
+			if i, ok := oldAt[row.key]; ok {
+				from, to = append(from, i), append(to, j)
+				kept[row.key] = true
+			} else {
+				inserts = append(inserts, protocol.RowOp{Op: protocol.RowInsert, Row: j, Key: row.key, HTML: row.html})
+			}
+		}
+		for i, row := range old {
+			if !kept[row.key] {
+				deletes = append(deletes, protocol.RowOp{Op: protocol.RowDelete, From: i, Key: row.key})
+			}
+		}
+		// The longest run of rows already in order stays; the others move
+		stay := longestIncreasing(from)
+		for n, i := range from {
+			j := to[n]
+			if !stay[n] {
+				moves = append(moves, protocol.RowOp{Op: protocol.RowMove, Row: j, From: i, Key: old[i].key})
+			}
+			if old[i].html != rows[j].html {
+				updates = append(updates, protocol.RowOp{Op: protocol.RowUpdate, Row: j, Key: rows[j].key, HTML: rows[j].html})
+			}
+		}
+	}
+	tp.Ops = slices.Concat(deletes, moves, inserts, updates)
+	return tp, len(deletes) + len(inserts)
+}
+
+// uniqueKeys reports whether every row has a key of its own.
+func uniqueKeys(rows []resultRow) bool {
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if row.key == "" || seen[row.key] {
+			return false
+		}
+		seen[row.key] = true
+	}
+	return true
+}
+
+// longestIncreasing marks the elements of a longest strictly increasing
+// subsequence of seq.
+func longestIncreasing(seq []int) []bool {
+	tails := []int{}              // Index in seq of the last element of the best run of each length
+	prev := make([]int, len(seq)) // Element before each one in its run
+	for n, v := range seq {
+		k, _ := slices.BinarySearchFunc(tails, v, func(t, v int) int { return cmp.Compare(seq[t], v) })
+		if k > 0 {
+			prev[n] = tails[k-1]
+		} else {
+			prev[n] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, n)
+		} else {
+			tails[k] = n
+		}
+	}
+	in := make([]bool, len(seq))
+	if len(tails) > 0 {
+		for n := tails[len(tails)-1]; n >= 0; n = prev[n] {
+			in[n] = true
+		}
+	}
+	return in
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resultsFolder returns the folder behind the first path segment of result
+// file names: an alias from resultsAliases ("live" -> the timing PC's share)
+// or, failing that, the subfolder of resultsDir of the same name.
+func resultsFolder(resultsDir string, aliases map[string]string, prefix string) string {
+	if dir, ok := aliases[prefix]; ok {
+		return dir
+	}
+	return filepath.Join(resultsDir, prefix)
+}
+
+// resolveResultPath maps a result file name as used in /results/ and
+// set_result ("heat1.html", "live/heat1.html") to a file on disk. ok is false
+// if the cleaned name is the folder itself or would escape it.
+func resolveResultPath(resultsDir string, aliases map[string]string, name string) (abs string, ok bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	root, rel := resultsDir, name
+	if prefix, rest, found := strings.Cut(name, "/"); found {
+		if dir, isAlias := aliases[prefix]; isAlias {
+			root, rel = dir, rest
+		}
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	abs, err = filepath.Abs(filepath.Join(absRoot, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(abs, absRoot+string(os.PathSeparator)) {
+		return "", false
+	}
+	return abs, true
+}
+
+// maxListedResults bounds a listing, so pointing an alias at a huge share
+// cannot stall the admin UI.
+const maxListedResults = 10000
+
+// ResultFile is an entry of GET /api/files?details=1.
+type ResultFile struct {
+	Name    string    `json:"name"` // Relative path as used in /results/ and set_result
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listOptions selects what listResults returns.
+type listOptions struct {
+	Recursive bool            // Include subfolders, as "sub/file"
+	Exts      map[string]bool // Lower case extensions with dot; nil = all files
+	All       bool            // No maxListedResults cap, for the archive
+	// Called for each alias that cannot be listed (a share whose mount
+	// dropped); nil logs a warning
+	AliasError func(alias string, err error)
+}
+
+// tempExts are the extensions exporters and Office give files while they
+// write them, before renaming them into place.
+var tempExts = parseExts("tmp,temp,part,partial,crdownload,swp")
+
+// isTempFile reports whether name looks like a file still being written:
+// Windows' "~$" lock files, "~" prefixes and suffixes and tempExts.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, "~") || strings.HasSuffix(name, "~") || tempExts[strings.ToLower(path.Ext(name))]
+}
+
+// parseExts turns "html,.TXT" into a set of ".html" and ".txt".
+func parseExts(list string) map[string]bool {
+	if list == "" {
+		return nil
+	}
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" {
+			exts["."+strings.TrimPrefix(ext, ".")] = true
+		}
+	}
+	return exts
+}
+
+// listResults returns the files in dir, named with prefix ("" for none) and,
+// when recursive, their path below dir. Hidden files and folders are skipped,
+// as are temporary files and the top-level folders in skip (shadowed by an
+// alias).
+func listResults(dir, prefix string, opts listOptions, skip map[string]string) ([]ResultFile, error) {
+	var files []ResultFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return nil // Unreadable subfolder or file removed while listing
+		}
+		if p == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if _, shadowed := skip[rel]; shadowed || !opts.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isTempFile(d.Name()) || (opts.Exts != nil && !opts.Exts[strings.ToLower(path.Ext(rel))]) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, ResultFile{Name: path.Join(prefix, rel), Size: info.Size(), ModTime: info.ModTime()})
+		if len(files) >= maxListedResults && !opts.All {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return files, err
+}
+
+// listRoomResults lists the result files a room may show, newest first. The
+// default room sees the results folder and every alias; a named room sees its
+// own folder.
+func listRoomResults(settings Settings, room string, opts listOptions) ([]ResultFile, error) {
+	var files []ResultFile
+	if room != defaultRoom {
+		list, err := listResults(resultsFolder(settings.ResultsDir, settings.ResultsAliases, room), room, opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		files = list
+	} else {
+		list, err := listResults(settings.ResultsDir, "", opts, settings.ResultsAliases)
+		if err != nil {
+			return nil, err
+		}
+		files = list
+		for alias, dir := range settings.ResultsAliases {
+			list, err := listResults(dir, alias, opts, nil)
+			if err != nil {
+				// An unreachable share must not hide the other sources.
+				if opts.AliasError != nil {
+					opts.AliasError(alias, err)
+				} else {
+					slog.Warn("Cannot list results alias", "alias", alias, "dir", dir, "err", err)
+				}
+				continue
+			}
+			files = append(files, list...)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	if len(files) > maxListedResults && !opts.All {
+		files = files[:maxListedResults]
+	}
+	return files, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListRoomResultsCap(t *testing.T) {
+	dir := t.TempDir()
+	for i := range maxListedResults + 1 {
+		sub := filepath.Join(dir, fmt.Sprintf("d%d", i%10))
+		os.MkdirAll(sub, 0o755)
+		if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("%d.txt", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	settings := Settings{ResultsDir: dir}
+	for _, tt := range []struct {
+		opts listOptions
+		want int
+	}{
+		{listOptions{Recursive: true}, maxListedResults},
+		{listOptions{Recursive: true, All: true}, maxListedResults + 1},
+	} {
+		files, err := listRoomResults(settings, defaultRoom, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != tt.want {
+			t.Errorf("listRoomResults(All: %v) = %d files, want %d", tt.opts.All, len(files), tt.want)
+		}
+	}
+}
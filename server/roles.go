@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Connection roles, chosen in the handshake. Anything that does not ask to be
+// a controller (including clients from before roles existed) is a display.
+const (
+	roleDisplay    = "display"    // Shows results; may only report about itself
+	roleController = "controller" // Admin UI; switches results, runs the timer, commands displays
+)
+
+// grantRole returns the role for a handshake that asked for requested with
+// token. ok is false when a controller presented a wrong token; the
+// connection then stays a display. Caller holds h.mu.
+func (h *Hub) grantRole(requested, token string) (role string, ok bool) {
+	if requested != roleController {
+		return roleDisplay, true
+	}
+	if !h.validToken(token) {
+		return roleDisplay, false
+	}
+	return roleController, true
+}
+
+// validToken checks token against ControllerToken; with no token configured
+// everyone may control. Caller holds h.mu.
+func (h *Hub) validToken(token string) bool {
+	if h.ControllerToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.ControllerToken)) == 1
+}
+
+// requireController rejects HTTP API calls without the controller token
+// ("Authorization: Bearer <token>") when one is configured.
+func requireController(hub *Hub, w http.ResponseWriter, r *http.Request) bool {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	hub.mu.Lock()
+	ok := hub.validToken(token)
+	hub.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Controller token required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"display/internal/protocol"
+)
+
+// defaultRoom is the room of clients that do not ask for one. Its results are
+// the top level of the results folder.
+const defaultRoom = ""
+
+// Room is one arena: displays and controllers in it share an active result,
+// a timer, a scoreboard and a splits view and never see another room's. A named room's result files live
+// in the results subfolder of the same name, and the file names the hub sends
+// include that folder ("hall2/heat1.html"), so displays load them from
+// /results/ as usual.
+type Room struct {
+	Name         string
+	ActiveResult string
+	Timer        *TimerManager
+	Score        *ScoreManager
+	Splits       SplitView     // The control displays in show_splits mode rank
+	switches     switchHistory // Undo and redo of content switches (undo.go)
+	activity     time.Time     // Last message to its displays (idle.go)
+	idle         bool          // Fell back to the idle scene since activity
+}
+
+// RoomInfo is an entry of GET /api/rooms.
+type RoomInfo struct {
+	Name         string     `json:"name"` // "" is the default room
+	ActiveResult string     `json:"activeResult"`
+	Timer        TimerState `json:"timer"`
+	Clients      int        `json:"clients"`                 // Connected displays
+	Spectators   int        `json:"spectators"`              // On /ws/spectate (spectate.go)
+	Following    bool       `json:"following"`               // Switches to the newest result (followNewest)
+	FollowGlob   string     `json:"followPattern,omitempty"` // Only files matching this
+}
+
+// validateRoomName accepts "" (the default room) or a plain folder name
+// (protocol.ValidateRoomName, which the client's setup page uses too).
+func validateRoomName(name string) error {
+	return protocol.ValidateRoomName(name)
+}
+
+// roomExists reports whether name has a results folder (or an alias in
+// resultsAliases). Rooms are made by creating the folder, so displays cannot
+// invent rooms. Caller holds h.mu.
+func (h *Hub) roomExists(name string) bool {
+	if name == defaultRoom {
+		return true
+	}
+	if _, ok := h.rooms[name]; ok {
+		return true
+	}
+	fi, err := os.Stat(resultsFolder(h.ResultsDir, h.ResultsAliases, name))
+	return err == nil && fi.IsDir()
+}
+
+// room returns the state of the named room, creating it on first use.
+// Caller holds h.mu.
+func (h *Hub) room(name string) *Room {
+	r := h.rooms[name]
+	if r == nil {
+		timer := NewTimerManager(h, name, h.clock)
+		r = &Room{Name: name, Timer: timer, Score: NewScoreManager(h, name, timer), activity: time.Now()}
+		h.rooms[name] = r
+	}
+	return r
+}
+
+// Room is room() for callers that do not hold h.mu.
+func (h *Hub) Room(name string) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.room(name)
+}
+
+// ActiveResult returns the file room is showing, "" if none.
+func (h *Hub) ActiveResult(room string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.room(room).ActiveResult
+}
+
+// roomFile reports whether file belongs to room, i.e. lies in its folder.
+// The default room may show any file, as before rooms existed.
+func roomFile(room, file string) bool {
+	return room == defaultRoom || strings.HasPrefix(file, room+"/")
+}
+
+// Rooms lists the rooms in use, default room first.
+func (h *Hub) Rooms() []RoomInfo {
+	h.mu.Lock()
+	h.room(defaultRoom)
+	rooms := make([]*Room, 0, len(h.rooms))
+	counts := make(map[string]int)
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	for client := range h.Clients {
+		if client.Role != roleController {
+			counts[client.Room]++
+		}
+	}
+	spectators := h.spectatorCounts()
+	list := make([]RoomInfo, 0, len(rooms))
+	for _, r := range rooms {
+		pattern, following := h.FollowNewest[r.Name]
+		list = append(list, RoomInfo{Name: r.Name, ActiveResult: r.ActiveResult, Clients: counts[r.Name], Spectators: spectators[r.Name], Following: following, FollowGlob: pattern})
+	}
+	h.mu.Unlock()
+
+	for i, r := range rooms { // Timer has its own lock
+		r.Timer.mu.Lock()
+		list[i].Timer = r.Timer.State
+		r.Timer.mu.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// stateSyncProtocol is the first protocol version that understands
+// state_sync; older clients get the state as separate messages.
+const stateSyncProtocol = protocol.StateSyncVersion
+
+// stateSync is the state_sync message: everything a display shows, in one
+// message, so a reconnect cannot interleave with updates sent meanwhile.
+type stateSync struct {
+	Type    string `json:"type"`
+	Payload struct {
+		Room         string       `json:"room"`
+		Timer        TimerState   `json:"timer"`
+		Score        ScoreState   `json:"score"`
+		Splits       *SplitsState `json:"splits,omitempty"` // With a splits view
+		ActiveResult string       `json:"activeResult,omitempty"`
+		DisplayMode  string       `json:"displayMode"`
+	} `json:"payload"`
+}
+
+// joinMessages marshals what client needs on entering its room: the server
+// time, a display's power schedule and delivery, then one state_sync, or for clients before stateSyncProtocol the
+// timer state and active result, preceded by the display mode on the first
+// join.
+func (h *Hub) joinMessages(client *Client, first bool) [][]byte {
+	msgs := make([][]byte, 0, 4)
+	if data, err := json.Marshal(newTimeSync(time.Now())); err != nil {
+		slog.Error("Error marshaling time_sync message", "err", err)
+	} else {
+		msgs = append(msgs, data)
+	}
+
+	h.mu.Lock()
+	room, protocol, mode := client.Room, client.Protocol, client.DisplayMode
+	display, schedule := client.Role == roleDisplay, h.PowerSchedules[room]
+	profile := h.Formatting.displayProfile(client.ID, client.Name)
+	h.mu.Unlock()
+	if mode == "" {
+		mode = "show_result"
+	}
+	if display {
+		if data := powerScheduleMessage(schedule); data != nil {
+			msgs = append(msgs, data)
+		}
+		if data := deliveryMessage(client.quality.isLite(), profile); data != nil {
+			msgs = append(msgs, data)
+		}
+	}
+
+	if protocol >= stateSyncProtocol {
+		h.mu.Lock()
+		r := h.room(room)
+		msg := stateSync{Type: "state_sync"}
+		msg.Payload.Room = room
+		msg.Payload.ActiveResult = r.ActiveResult
+		msg.Payload.DisplayMode = mode
+		view := r.Splits
+		h.mu.Unlock()
+		if view.Control != "" {
+			splits := h.formatSplits(h.Splits.Standings(view))
+			msg.Payload.Splits = &splits
+		}
+		r.Timer.mu.Lock()
+		msg.Payload.Timer = r.Timer.State
+		r.Timer.mu.Unlock()
+		msg.Payload.Score = r.Score.Snapshot()
+		data, err := json.Marshal(msg)
+		if err != nil {
+			slog.Error("Error marshaling state_sync message", "err", err)
+			return msgs
+		}
+		return append(msgs, data)
+	}
+
+	if first {
+		modeMsg, err := json.Marshal(struct {
+			Type    string `json:"type"`
+			Payload string `json:"payload"`
+		}{
+			Type:    "display_mode",
+			Payload: mode,
+		})
+		if err != nil {
+			slog.Error("Error marshaling display mode message", "err", err)
+		} else {
+			msgs = append(msgs, modeMsg)
+		}
+	}
+	return append(msgs, h.roomStateMessages(room)...)
+}
+
+// roomStateMessages marshals what a client before stateSyncProtocol needs on
+// entering room: the timer state and the active result, if any.
+func (h *Hub) roomStateMessages(room string) [][]byte {
+	h.mu.Lock()
+	r := h.room(room)
+	active := r.ActiveResult
+	h.mu.Unlock()
+
+	var msgs [][]byte
+	r.Timer.mu.Lock()
+	timerMsg, err := json.Marshal(struct {
+		Type    string     `json:"type"`
+		Payload TimerState `json:"payload"`
+	}{
+		Type:    "timer_update",
+		Payload: r.Timer.State,
+	})
+	r.Timer.mu.Unlock()
+	if err != nil {
+		slog.Error("Error marshaling timer state", "err", err)
+	} else {
+		msgs = append(msgs, timerMsg)
+	}
+
+	if active != "" {
+		resultMsg, err := json.Marshal(newResultMessage(active, ""))
+		if err != nil {
+			slog.Error("Error marshaling result message", "err", err)
+		} else {
+			msgs = append(msgs, resultMsg)
+		}
+	}
+	return msgs
+}
+
+// broadcastRoomData is broadcastData limited to the clients in room, and to
+// those reporting a protocol before beforeProtocol, or from fromProtocol on,
+// unless that is 0. Spectators only get messages for every protocol.
+func (h *Hub) broadcastRoomData(room string, message []byte, beforeProtocol, fromProtocol int) {
+	if fromProtocol == 0 { // Patches are not replayed (recording.go)
+		h.Recorder.Record(false, room, beforeProtocol, message)
+	}
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.Clients))
+	var encodings []string
+	for client := range h.Clients {
+		if client.Room == room && (beforeProtocol == 0 || client.Protocol < beforeProtocol) && client.Protocol >= fromProtocol {
+			clients = append(clients, client)
+			encodings = append(encodings, client.Encoding)
+		}
+	}
+	h.touch(room)
+	policy := h.SlowClientPolicy
+	h.mu.Unlock()
+
+	encoded := &encodedBroadcast{msg: message}
+	for i, client := range clients {
+		if !deliver(client, encoded.forEncoding(encodings[i]), policy) {
+			h.dropClient(client)
+		}
+	}
+	if beforeProtocol == 0 && fromProtocol == 0 {
+		h.broadcastSpectators(message, room, false)
+	}
+}
+
+// BroadcastRoomJSON marshals msg and queues it for everyone in room.
+func (h *Hub) BroadcastRoomJSON(room string, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error marshaling broadcast message", "err", err)
+		return
+	}
+	h.RoomBroadcast <- roomMessage{Room: room, Msg: data}
+}
+
+// roomMessage is a broadcast limited to one room.
+type roomMessage struct {
+	Room           string
+	Msg            []byte
+	BeforeProtocol int // Only to clients reporting an older protocol; 0 = all
+	FromProtocol   int // Only to clients reporting this protocol or newer; 0 = all
+}
+
+// checkRoom validates a room name from a client or API call and makes sure
+// the room exists.
+func (h *Hub) checkRoom(name string) error {
+	if err := validateRoomName(name); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.roomExists(name) {
+		return errors.New("unknown room " + name + " (create its folder in the results folder)")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// serveSanitizedHTML serves the result page at path through
+// sanitizeResultHTML. A Content-Security-Policy backs it up in case a browser
+// finds something the sanitizer missed.
+func serveSanitizedHTML(w http.ResponseWriter, r *http.Request, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Failed to read result", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "default-src 'self' data:; style-src 'self' 'unsafe-inline'; script-src 'none'; frame-src 'none'; object-src 'none'")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(sanitizeResultHTML(data)))
+}
+
+// droppedElements are removed together with their content: scripts and
+// embedded foreign documents have no place on a results screen.
+var droppedElements = map[string]bool{
+	"script": true,
+	"iframe": true,
+	"frame":  true,
+	"object": true,
+	"applet": true,
+}
+
+// droppedVoidElements have no content and are removed on their own.
+var droppedVoidElements = map[string]bool{
+	"embed": true,
+	"base":  true, // Would redirect relative links away from /results/
+}
+
+// externalResourceAttrs load something when the page is shown, unlike a link
+// the kiosk never follows.
+var externalResourceAttrs = map[string]map[string]bool{
+	"img":    {"src": true, "srcset": true},
+	"link":   {"href": true},
+	"source": {"src": true, "srcset": true},
+	"video":  {"src": true, "poster": true},
+	"audio":  {"src": true},
+	"input":  {"src": true},
+}
+
+// sanitizeResultHTML strips scripts, embedded frames, meta refresh, event
+// handler attributes, javascript: URLs and anything loaded from another site
+// (trackers, web fonts, remote images) from a third-party result export.
+// Untouched markup is copied byte for byte, so the page's charset survives.
+func sanitizeResultHTML(data []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+	z := html.NewTokenizer(bytes.NewReader(data))
+	skipping, depth := "", 0 // Element whose content is being dropped
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				out.Write(z.Raw()) // Unparsable tail: keep it as text
+			}
+			break
+		}
+		raw := append([]byte(nil), z.Raw()...)
+
+		if skipping != "" {
+			tok := z.Token()
+			switch {
+			case tt == html.StartTagToken && tok.Data == skipping:
+				depth++
+			case tt == html.EndTagToken && tok.Data == skipping:
+				if depth--; depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			if tt == html.EndTagToken {
+				if name, _ := z.TagName(); droppedElements[string(name)] {
+					continue // Stray end tag of a dropped element
+				}
+			}
+			out.Write(raw)
+			continue
+		}
+
+		tok := z.Token()
+		switch {
+		case droppedElements[tok.Data]:
+			if tt == html.StartTagToken {
+				skipping, depth = tok.Data, 1
+			}
+			continue
+		case droppedVoidElements[tok.Data], isMetaRefresh(tok), loadsExternal(tok):
+			continue
+		}
+		if cleaned, changed := cleanAttrs(tok.Attr); changed {
+			tok.Attr = cleaned
+			out.WriteString(tok.String())
+			continue
+		}
+		out.Write(raw)
+	}
+	return out.Bytes()
+}
+
+// isMetaRefresh reports whether tok is <meta http-equiv="refresh">, which
+// would navigate the display away from the result.
+func isMetaRefresh(tok html.Token) bool {
+	if tok.Data != "meta" {
+		return false
+	}
+	for _, a := range tok.Attr {
+		if a.Key == "http-equiv" && strings.EqualFold(strings.TrimSpace(a.Val), "refresh") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadsExternal reports whether tok fetches a resource from another site.
+func loadsExternal(tok html.Token) bool {
+	attrs := externalResourceAttrs[tok.Data]
+	for _, a := range tok.Attr {
+		if attrs[a.Key] && isExternalURL(a.Val) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExternalURL reports whether u points to another host. Result pages are
+// served by this server, so anything absolute is external.
+func isExternalURL(u string) bool {
+	u = strings.ToLower(strings.TrimSpace(u))
+	return strings.HasPrefix(u, "http:") || strings.HasPrefix(u, "https:") || strings.HasPrefix(u, "//")
+}
+
+// cleanAttrs drops event handlers (onload, onclick, ...) and javascript: URLs.
+func cleanAttrs(attrs []html.Attribute) ([]html.Attribute, bool) {
+	kept := attrs[:0:0]
+	for _, a := range attrs {
+		if strings.HasPrefix(a.Key, "on") {
+			continue
+		}
+		if (a.Key == "href" || a.Key == "src" || a.Key == "action" || a.Key == "formaction") && isJavaScriptURL(a.Val) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept, len(kept) != len(attrs)
+}
+
+// isJavaScriptURL reports whether u has the javascript: scheme. Browsers
+// ignore whitespace and control characters inside it ("java\tscript:").
+func isJavaScriptURL(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, u)
+	return strings.HasPrefix(strings.ToLower(u), "javascript:")
+}
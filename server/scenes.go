@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A scene is a saved state of a room's displays: the active result, the
+// splits view and each display's mode, theme, zoom, rotation and screen
+// power. Operators save one per part of the event ("Prize ceremony", "Live
+// results", "Sponsors") and recall it with one command. Scenes are kept in
+// scenes.json next to the config file.
+const (
+	scenesFile   = "scenes.json"
+	maxScenes    = 100
+	maxSceneName = 64
+)
+
+var (
+	errNoScene       = errors.New("no such scene")
+	errTooManyScenes = fmt.Errorf("at most %d scenes can be saved", maxScenes)
+)
+
+// Scene is a saved room state, as listed by GET /api/scenes.
+type Scene struct {
+	Name     string                  `json:"name"`
+	Room     string                  `json:"room"`
+	Result   string                  `json:"result,omitempty"`
+	Splits   *SplitView              `json:"splits,omitempty"` // With a splits view
+	Displays map[string]SceneDisplay `json:"displays"`         // By client ID
+	SavedAt  time.Time               `json:"savedAt"`
+	SavedBy  string                  `json:"savedBy"`
+}
+
+// SceneDisplay is what a scene sets on one display.
+type SceneDisplay struct {
+	Mode        string `json:"mode"`
+	Theme       string `json:"theme"`
+	Zoom        int    `json:"zoom"`
+	Rotation    int    `json:"rotation"`
+	ScreenPower string `json:"screenPower,omitempty"` // "" leaves the screen as it is
+}
+
+// sceneControl is the payload of the scene message.
+type sceneControl struct {
+	Action string `json:"action"` // "save", "recall" or "delete"
+	Name   string `json:"name"`
+}
+
+// SceneRecall is the answer of recalling a scene.
+type SceneRecall struct {
+	Scene    string   `json:"scene"`
+	Displays int      `json:"displays"`          // Displays set
+	Missing  []string `json:"missing,omitempty"` // IDs of the scene not connected to the room
+}
+
+// sceneStore keeps the scenes and writes them to path on every change.
+type sceneStore struct {
+	mu     sync.Mutex
+	path   string
+	scenes []Scene
+}
+
+// openScenes loads the scenes saved at path; a missing file is no error.
+func openScenes(path string) (*sceneStore, error) {
+	s := &sceneStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.scenes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// List returns the scenes of room by name.
+func (s *sceneStore) List(room string) []Scene {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Scene{}
+	for _, sc := range s.scenes {
+		if sc.Room == room {
+			list = append(list, sc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *sceneStore) get(room, name string) (Scene, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.scenes {
+		if sc.Room == room && sc.Name == name {
+			return sc, true
+		}
+	}
+	return Scene{}, false
+}
+
+// put saves sc, replacing the scene of the same room and name.
+func (s *sceneStore) put(sc Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.scenes {
+		if s.scenes[i].Room == sc.Room && s.scenes[i].Name == sc.Name {
+			s.scenes[i] = sc
+			return s.write()
+		}
+	}
+	if len(s.scenes) >= maxScenes {
+		return errTooManyScenes
+	}
+	s.scenes = append(s.scenes, sc)
+	return s.write()
+}
+
+// remove deletes a scene and reports whether it existed.
+func (s *sceneStore) remove(room, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.scenes {
+		if s.scenes[i].Room == room && s.scenes[i].Name == name {
+			s.scenes = append(s.scenes[:i], s.scenes[i+1:]...)
+			return true, s.write()
+		}
+	}
+	return false, nil
+}
+
+// write saves the scenes to s.path. Caller holds s.mu.
+func (s *sceneStore) write() error {
+	data, err := json.MarshalIndent(s.scenes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// SaveScene saves room's current state as the scene name.
+func (h *Hub) SaveScene(room, name, actor string) (Scene, error) {
+	h.mu.Lock()
+	r := h.room(room)
+	sc := Scene{Name: name, Room: room, Result: r.ActiveResult, Displays: make(map[string]SceneDisplay), SavedAt: time.Now(), SavedBy: actor}
+	if r.Splits.Control != "" {
+		view := r.Splits
+		sc.Splits = &view
+	}
+	for id, client := range h.byID {
+		if client.Room != room || client.Role != roleDisplay {
+			continue
+		}
+		d := SceneDisplay{Mode: client.DisplayMode, Theme: client.ThemeMode, Zoom: client.Zoom, Rotation: client.Rotation, ScreenPower: client.ScreenPower}
+		if d.Mode == "" {
+			d.Mode = "show_result"
+		}
+		sc.Displays[id] = d
+	}
+	h.mu.Unlock()
+	if err := h.Scenes.put(sc); err != nil {
+		return Scene{}, err
+	}
+	slog.Info("Scene saved", "room", room, "scene", name, "displays", len(sc.Displays), "by", actor)
+	return sc, nil
+}
+
+// DeleteScene deletes the scene name of room.
+func (h *Hub) DeleteScene(room, name string) error {
+	found, err := h.Scenes.remove(room, name)
+	if err == nil && !found {
+		return errNoScene
+	}
+	return err
+}
+
+// RecallScene puts room's displays into the saved scene name. Displays
+// already showing what the scene wants are left alone, so recalling does not
+// reload or rotate screens needlessly. origin is the controller asking, nil
+// for the API; the result switch can be undone like any other.
+func (h *Hub) RecallScene(room, name string, origin *Client) (SceneRecall, error) {
+	sc, ok := h.Scenes.get(room, name)
+	if !ok {
+		return SceneRecall{}, errNoScene
+	}
+	recall := SceneRecall{Scene: name}
+	if sc.Result != "" && h.ActiveResult(room) != sc.Result {
+		h.SetActiveResult(room, sc.Result, origin, "")
+	}
+	var view SplitView
+	if sc.Splits != nil {
+		view = *sc.Splits
+	}
+	if h.SplitView(room) != view {
+		h.SetSplitView(room, view)
+	}
+
+	ids := make([]string, 0, len(sc.Displays))
+	for id := range sc.Displays {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		want := sc.Displays[id]
+		h.mu.Lock()
+		client := h.byID[id]
+		if client == nil || client.Room != room {
+			h.mu.Unlock()
+			recall.Missing = append(recall.Missing, id)
+			continue
+		}
+		var commands [][2]string
+		if mode := client.DisplayMode; displayModes[want.Mode] && mode != want.Mode && !(mode == "" && want.Mode == "show_result") {
+			commands = append(commands, [2]string{want.Mode, ""})
+		}
+		if want.Theme != "" && client.ThemeMode != want.Theme {
+			commands = append(commands, [2]string{"theme_" + want.Theme, ""})
+		}
+		if want.Zoom != 0 && client.Zoom != want.Zoom {
+			commands = append(commands, [2]string{"set_zoom", strconv.Itoa(want.Zoom)})
+		}
+		if client.Rotation != want.Rotation {
+			commands = append(commands, [2]string{"set_rotation", strconv.Itoa(want.Rotation)})
+		}
+		if want.ScreenPower != "" && client.ScreenPower != want.ScreenPower {
+			commands = append(commands, [2]string{"screen_power", want.ScreenPower})
+		}
+		h.mu.Unlock()
+		for _, c := range commands {
+			if err := validateClientCommand(id, c[0], c[1]); err != nil {
+				slog.Warn("Skipping invalid scene setting", "scene", name, "id", id, "err", err)
+				continue
+			}
+			h.ClientCommand(id, c[0], c[1], origin, "")
+		}
+		recall.Displays++
+	}
+	slog.Info("Scene recalled", "room", room, "scene", name, "displays", recall.Displays, "missing", len(recall.Missing))
+	return recall, nil
+}
+
+func registerScenesAPI(hub *Hub) {
+	// GET /api/scenes?room= -> []Scene
+	http.HandleFunc("GET /api/scenes", func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if err := hub.checkRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Scenes.List(room))
+	})
+
+	// POST /api/scenes/save, /api/scenes/recall and /api/scenes/delete {"name": "Prize ceremony", "room": ""}
+	for _, action := range []string{"save", "recall", "delete"} {
+		http.HandleFunc("POST /api/scenes/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if !requirePost(w, r) || !requireController(hub, w, r) {
+				return
+			}
+			var payload struct {
+				Name string `json:"name"`
+				Room string `json:"room"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid body", http.StatusBadRequest)
+				return
+			}
+			if err := validateSceneName(payload.Name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := hub.checkRoom(payload.Room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			var out interface{}
+			var err error
+			switch action {
+			case "save":
+				out, err = hub.SaveScene(payload.Room, payload.Name, "api")
+			case "recall":
+				out, err = hub.RecallScene(payload.Room, payload.Name, nil)
+			case "delete":
+				err = hub.DeleteScene(payload.Room, payload.Name)
+			}
+			if errors.Is(err, errNoScene) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if errors.Is(err, errTooManyScenes) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			hub.Audit.Record(apiAudit(r, payload.Room, "scene_"+action, "", payload.Name))
+			if out == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(out)
+		})
+	}
+}
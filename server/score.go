@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// The scoreboard keeps the score of the sport a room plays. Each room has
+// one; it is off until the operator picks a sport profile (sports.go), and
+// then counts what the profile says: a running score such as goals, or sets
+// by the sport's rules, including who wins a set, when the match is over and
+// who serves.
+const (
+	maxTeamNameLen = 32
+	maxScoreUndo   = 50 // Points that can be taken back
+)
+
+// SetScore is the score of one set, or the sets won.
+type SetScore struct {
+	Home int `json:"home"`
+	Away int `json:"away"`
+}
+
+func (s SetScore) of(team string) int {
+	if team == "home" {
+		return s.Home
+	}
+	return s.Away
+}
+
+func (s *SetScore) add(team string) {
+	if team == "home" {
+		s.Home++
+	} else {
+		s.Away++
+	}
+}
+
+// GameScore is the current game as displays show it: "0", "15", "30", "40"
+// or "AD", or the points of a tiebreak.
+type GameScore struct {
+	Home string `json:"home"`
+	Away string `json:"away"`
+}
+
+// ScoreState is the payload of score_update.
+type ScoreState struct {
+	Sport      string `json:"sport"`                // Profile name; "" = scoreboard off
+	Scoreboard string `json:"scoreboard,omitempty"` // The profile's scoreboard type, "sets" or "points"; "" shows none
+	Home       string `json:"home,omitempty"`
+	Away       string `json:"away,omitempty"`
+	// Every set played, the current one last; shared by copies of the
+	// state, so it is replaced rather than changed
+	Sets    []SetScore `json:"sets"`
+	SetsWon SetScore   `json:"setsWon"`
+	Game    *GameScore `json:"game,omitempty"`   // With a profile that counts games
+	Serve   string     `json:"serve,omitempty"`  // "home" or "away"
+	Winner  string     `json:"winner,omitempty"` // Set when the match is over
+}
+
+// scoreControl is the payload of score_control and POST /api/score.
+type scoreControl struct {
+	Action string `json:"action"` // "point", "undo", "serve", "teams", "sport" or "reset"
+	Team   string `json:"team"`   // Scoring or serving team
+	Sport  string `json:"sport"`  // Profile to switch to; "" turns the scoreboard off
+	Home   string `json:"home"`   // Team names
+	Away   string `json:"away"`
+}
+
+// scoreSnapshot is what undo goes back to.
+type scoreSnapshot struct {
+	state ScoreState
+	game  SetScore
+}
+
+type ScoreManager struct {
+	Hub     *Hub
+	Room    string // Updates go to this room only
+	State   ScoreState
+	mu      sync.Mutex
+	timer   *TimerManager // The room's clock, which the profile sets up
+	profile SportProfile  // As it was when chosen, so a reload does not change a match
+	game    SetScore      // Points of the current game, with a profile that counts games
+	undo    []scoreSnapshot
+}
+
+func NewScoreManager(hub *Hub, room string, timer *TimerManager) *ScoreManager {
+	return &ScoreManager{Hub: hub, Room: room, timer: timer, State: ScoreState{Sets: []SetScore{}}}
+}
+
+// Snapshot returns the current score.
+func (sm *ScoreManager) Snapshot() ScoreState {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.State
+}
+
+// Apply carries out a validated score_control and broadcasts the score. It
+// returns the audit target and value of the action.
+func (sm *ScoreManager) Apply(c scoreControl) (target, value string, err error) {
+	var profile SportProfile
+	if c.Action == "sport" && c.Sport != "" {
+		var ok bool
+		if profile, ok = sm.Hub.sport(c.Sport); !ok {
+			return "", "", fmt.Errorf("unknown sport %q", c.Sport)
+		}
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	switch {
+	case c.Action == "sport" || c.Action == "teams":
+	case sm.State.Sport == "":
+		return "", "", errors.New("scoreboard is off; choose a sport first")
+	case sm.State.Scoreboard == "" && c.Action != "reset":
+		return "", "", errors.New("this sport has no scoreboard")
+	case sm.State.Scoreboard != "sets" && c.Action == "serve":
+		return "", "", errors.New("this sport has no serve")
+	}
+	switch c.Action {
+	case "point":
+		if sm.State.Winner != "" {
+			return "", "", errors.New("the match is over")
+		}
+		sm.save()
+		sm.point(c.Team)
+		target, value = c.Team, sm.summary()
+	case "undo":
+		if len(sm.undo) == 0 {
+			return "", "", errors.New("nothing to undo")
+		}
+		last := sm.undo[len(sm.undo)-1]
+		sm.undo = sm.undo[:len(sm.undo)-1]
+		sm.State, sm.game = last.state, last.game
+		value = sm.summary()
+	case "serve":
+		sm.save()
+		sm.State.Serve = c.Team
+		target = c.Team
+	case "teams":
+		sm.State.Home, sm.State.Away = c.Home, c.Away
+		value = c.Home + " - " + c.Away
+	case "sport":
+		sm.profile = profile
+		sm.State.Sport, sm.State.Scoreboard = profile.Name, profile.Scoreboard.Type
+		sm.reset()
+		sm.undo = nil
+		value = c.Sport
+		if c.Sport == "" {
+			sm.timer.SetSport(nil)
+		} else {
+			sm.timer.SetSport(&profile)
+		}
+	case "reset":
+		sm.save()
+		sm.reset()
+	}
+	sm.broadcast()
+	return target, value, nil
+}
+
+// save remembers the score for undo, with sm.mu held.
+func (sm *ScoreManager) save() {
+	if len(sm.undo) == maxScoreUndo {
+		sm.undo = slices.Delete(sm.undo, 0, 1)
+	}
+	sm.undo = append(sm.undo, scoreSnapshot{sm.State, sm.game})
+}
+
+// reset starts a new match of the same sport and teams, with sm.mu held.
+func (sm *ScoreManager) reset() {
+	sm.State.Sets = []SetScore{}
+	if sm.State.Scoreboard != "" {
+		sm.State.Sets = []SetScore{{}}
+	}
+	sm.State.SetsWon = SetScore{}
+	sm.State.Serve = ""
+	sm.State.Winner = ""
+	sm.game = SetScore{}
+	sm.State.Game = sm.gameScore()
+}
+
+// point gives team a point (a goal, or a rally) and applies the sport's
+// rules, with sm.mu held.
+func (sm *ScoreManager) point(team string) {
+	p := sm.profile.Scoreboard
+	sets := slices.Clone(sm.State.Sets)
+	set := &sets[len(sets)-1]
+	sm.State.Sets = sets
+	if p.Type == "points" {
+		set.add(team)
+		return
+	}
+	if p.Games {
+		need := 4
+		if sm.tiebreak(p, *set) {
+			need = p.TiebreakPoints
+		}
+		sm.game.add(team)
+		won := sm.game.of(team)
+		if won < need || won-sm.game.of(otherTeam(team)) < 2 {
+			sm.State.Game = sm.gameScore()
+			return
+		}
+		sm.game = SetScore{}
+		if sm.State.Serve != "" {
+			sm.State.Serve = otherTeam(sm.State.Serve)
+		}
+	} else {
+		sm.State.Serve = team
+	}
+	set.add(team)
+	sm.State.Game = sm.gameScore()
+
+	target := p.SetPoints
+	if p.DecidingPoints > 0 && sm.State.SetsWon.Home == p.SetsToWin-1 && sm.State.SetsWon.Away == p.SetsToWin-1 {
+		target = p.DecidingPoints
+	}
+	points, lead := set.of(team), set.of(team)-set.of(otherTeam(team))
+	if !(points >= target && lead >= p.WinBy) && !(p.SetCap > 0 && points >= p.SetCap) {
+		return
+	}
+	sm.State.SetsWon.add(team)
+	if sm.State.SetsWon.of(team) == p.SetsToWin {
+		sm.State.Winner = team
+		slog.Info("Match over", "room", sm.Room, "winner", team, "sets", sm.summary())
+		return
+	}
+	sm.State.Sets = append(sets, SetScore{})
+}
+
+// tiebreak reports whether the current game of set is a tiebreak.
+func (sm *ScoreManager) tiebreak(p ScoreboardRules, set SetScore) bool {
+	return p.TiebreakPoints > 0 && set.Home == p.SetCap-1 && set.Away == p.SetCap-1
+}
+
+// gameScore labels the points of the current game, nil if the sport does
+// not count games. Caller holds sm.mu.
+func (sm *ScoreManager) gameScore() *GameScore {
+	p := sm.profile.Scoreboard
+	if sm.State.Sport == "" || !p.Games {
+		return nil
+	}
+	home, away := sm.game.Home, sm.game.Away
+	if len(sm.State.Sets) > 0 && sm.tiebreak(p, sm.State.Sets[len(sm.State.Sets)-1]) {
+		return &GameScore{strconv.Itoa(home), strconv.Itoa(away)}
+	}
+	if home >= 3 && away >= 3 {
+		switch {
+		case home > away:
+			return &GameScore{"AD", "40"}
+		case away > home:
+			return &GameScore{"40", "AD"}
+		}
+		return &GameScore{"40", "40"}
+	}
+	calls := []string{"0", "15", "30", "40"}
+	return &GameScore{calls[min(home, 3)], calls[min(away, 3)]}
+}
+
+// summary is the score in sets for the audit log, e.g. "25-21 14-12".
+func (sm *ScoreManager) summary() string {
+	s := ""
+	for i, set := range sm.State.Sets {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%d-%d", set.Home, set.Away)
+	}
+	return s
+}
+
+func (sm *ScoreManager) broadcast() {
+	data, err := json.Marshal(struct {
+		Type    string     `json:"type"`
+		Payload ScoreState `json:"payload"`
+	}{
+		Type:    "score_update",
+		Payload: sm.State,
+	})
+	if err != nil {
+		slog.Error("Error marshaling score", "err", err)
+		return
+	}
+	sm.Hub.RoomBroadcast <- roomMessage{Room: sm.Room, Msg: data}
+}
+
+func otherTeam(team string) string {
+	if team == "home" {
+		return "away"
+	}
+	return "home"
+}
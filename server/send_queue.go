@@ -0,0 +1,144 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+)
+
+// offerResult tells deliver what sendQueue.offer did with a message.
+type offerResult int
+
+const (
+	offerQueued     offerResult = iota // Queued normally
+	offerDropped                       // Queue full, oldest message discarded (drop_oldest)
+	offerOverflowed                    // Queued past the limit (grow)
+	offerRejected                      // Queue full, the client has to go
+	offerClosed                        // Client already disconnected
+)
+
+// sendQueue holds the outgoing messages of one client. Broadcasts only append
+// to it, which never blocks; the client's writePump is the worker that drains
+// it, so a stalled connection only holds up its own messages while the other
+// clients' writers keep going.
+type sendQueue struct {
+	mu      sync.Mutex
+	msgs    [][]byte
+	limit   int           // Messages that may wait before the slow-client policy kicks in
+	ready   chan struct{} // Signals writePump, capacity 1
+	closed  bool
+	lagging bool // Reached the limit; cleared once drained (log once per episode)
+	// Metrics for GET /debug/vars
+	peak    int
+	sent    uint64
+	dropped uint64
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{limit: limit, ready: make(chan struct{}, 1)}
+}
+
+// offer appends msg, applying policy when the queue is at its limit.
+func (q *sendQueue) offer(msg []byte, policy SlowClientPolicy) offerResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return offerClosed
+	}
+
+	result := offerQueued
+	if len(q.msgs) >= q.limit {
+		switch {
+		case policy == SlowClientDropOldest:
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+			q.dropped++
+			result = offerDropped
+		case policy == SlowClientGrow && len(q.msgs) < q.limit+maxSendOverflow:
+			result = offerOverflowed
+		default:
+			return offerRejected
+		}
+	}
+	q.msgs = append(q.msgs, msg)
+	if len(q.msgs) > q.peak {
+		q.peak = len(q.msgs)
+	}
+	q.signal()
+	return result
+}
+
+// take removes and returns everything queued. ok is false once the queue is
+// closed and empty, i.e. writePump should send a close frame and stop.
+func (q *sendQueue) take() (msgs [][]byte, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msgs, q.msgs = q.msgs, nil
+	q.sent += uint64(len(msgs))
+	q.lagging = false
+	return msgs, len(msgs) > 0 || !q.closed
+}
+
+// close stops further offers; what is already queued is still written.
+// Safe to call more than once.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+// signal wakes writePump without blocking. Caller holds q.mu.
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default: // Already pending
+	}
+}
+
+// startLagging reports whether this is the first limit hit since the queue
+// last drained, so the slow-client warning is logged once per episode.
+func (q *sendQueue) startLagging() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	first := !q.lagging
+	q.lagging = true
+	return first
+}
+
+// SendQueueStats is one client's entry in the send_queues expvar.
+type SendQueueStats struct {
+	Name    string `json:"name"`
+	Depth   int    `json:"depth"`
+	Peak    int    `json:"peak"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+func (q *sendQueue) stats() SendQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return SendQueueStats{Depth: len(q.msgs), Peak: q.peak, Sent: q.sent, Dropped: q.dropped}
+}
+
+// publishQueueStats exposes the queue of every connected client, keyed by
+// remote address, as "send_queues" at /debug/vars.
+func publishQueueStats(h *Hub) {
+	expvar.Publish("send_queues", expvar.Func(func() any {
+		h.mu.Lock()
+		clients := make(map[*Client]string, len(h.Clients))
+		for client := range h.Clients {
+			clients[client] = client.Name
+		}
+		h.mu.Unlock()
+
+		out := make(map[string]SendQueueStats, len(clients))
+		for client, name := range clients {
+			s := client.Send.stats()
+			s.Name = name
+			out[client.Addr] = s
+		}
+		return out
+	}))
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+const serviceName = "ScoreDisplayServer"
+
+var errServiceUnsupported = errors.New("Windows services are only supported on Windows")
+
+func installService(args []string) error { return errServiceUnsupported }
+
+func uninstallService() error { return errServiceUnsupported }
+
+func isWindowsService() bool { return false }
+
+func runAsService(run func(stop <-chan struct{})) error { return errServiceUnsupported }
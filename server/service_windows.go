@@ -0,0 +1,148 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "ScoreDisplayServer"
+
+// installService registers the current executable as an auto-start service
+// that is restarted by the service manager if it crashes.
+func installService(args []string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exePath, err = filepath.Abs(exePath)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %q already exists", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exePath, mgr.Config{
+		DisplayName: "Score Display Server",
+		Description: "Broadcasts timer and results to display clients.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		slog.Warn("Could not set service recovery actions", "err", err)
+	}
+
+	return s.Start()
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %q is not installed", serviceName)
+	}
+	defer s.Close()
+
+	// Stop it first; ignore errors if it isn't running.
+	if status, err := s.Control(svc.Stop); err == nil {
+		deadline := time.Now().Add(10 * time.Second)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	return s.Delete()
+}
+
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	if err != nil {
+		slog.Warn("Could not determine if running as a service", "err", err)
+		return false
+	}
+	return ok
+}
+
+// runAsService runs the server under the service control manager. Services
+// start in System32 without a console, so the working directory is moved next
+// to the executable (where server.json and static/ live); the default logDir
+// then puts the rotating logs in logs/ next to it.
+func runAsService(run func(stop <-chan struct{})) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(exePath)
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	return svc.Run(serviceName, &serviceHandler{run: run})
+}
+
+type serviceHandler struct {
+	run func(stop <-chan struct{})
+}
+
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		h.run(stop)
+		close(done)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				slog.Info("Service stop requested, gracefully shutting down")
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		case <-done:
+			// Server exited on its own; let the recovery actions restart it.
+			return false, 1
+		}
+	}
+}
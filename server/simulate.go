@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"display/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// Simulated displays (-simulate N) connect to the server's own /ws like real
+// ones: handshake, heartbeats, pongs and acks. They load the hub and fill the
+// admin UI as N displays would, so capacity and the UI can be tried out
+// before event day without that many screens.
+const (
+	simulateHeartbeat = 30 * time.Second // Like the display client
+	simulateRetry     = 3 * time.Second
+	simulateReport    = 30 * time.Second
+)
+
+// simulationStats are counted by all simulated displays together.
+type simulationStats struct {
+	connected  atomic.Int64
+	received   atomic.Int64
+	acked      atomic.Int64
+	reconnects atomic.Int64
+}
+
+// runSimulation starts n simulated displays against wsURL and logs how they
+// are doing every simulateReport until stop is closed.
+func runSimulation(wsURL string, n int, stop <-chan struct{}) {
+	stats := &simulationStats{}
+	slog.Info("Starting simulated displays", "count", n, "url", wsURL)
+	for i := 1; i <= n; i++ {
+		go simulateDisplay(wsURL, i, stats, stop)
+	}
+	ticker := time.NewTicker(simulateReport)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			slog.Info("Simulated displays", "connected", stats.connected.Load(), "of", n,
+				"received", stats.received.Load(), "acked", stats.acked.Load(), "reconnects", stats.reconnects.Load())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// simulateDisplay is one simulated display, reconnecting until stop is closed.
+func simulateDisplay(wsURL string, i int, stats *simulationStats, stop <-chan struct{}) {
+	// Spread the first connections like displays being switched on
+	select {
+	case <-time.After(time.Duration(rand.IntN(1000)) * time.Millisecond):
+	case <-stop:
+		return
+	}
+	for first := true; ; first = false {
+		if !first {
+			stats.reconnects.Add(1)
+		}
+		if err := simulateSession(wsURL, i, stats, stop); err != nil {
+			slog.Debug("Simulated display disconnected", "display", i, "err", err)
+		}
+		select {
+		case <-time.After(simulateRetry):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// simulateSession runs one connection of simulated display i.
+func simulateSession(wsURL string, i int, stats *simulationStats, stop <-chan struct{}) error {
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stats.connected.Add(1)
+	defer stats.connected.Add(-1)
+
+	// Writes come from the read loop (acks) and the heartbeat loop
+	outgoing := make(chan any, 16)
+	done := make(chan struct{})       // The read loop ended
+	writerDone := make(chan struct{}) // The writer ended, closing conn
+	defer close(done)
+	go func() {
+		defer close(writerDone)
+		defer conn.Close() // Also ends the read loop
+		for {
+			select {
+			case v := <-outgoing:
+				conn.SetWriteDeadline(time.Now().Add(defaultWriteWait))
+				if err := conn.WriteJSON(v); err != nil {
+					return
+				}
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	outgoing <- protocol.Envelope{Type: "handshake", Payload: protocol.Handshake{
+		Name:     fmt.Sprintf("Simulated display %02d", i),
+		ID:       fmt.Sprintf("simulated-%02d", i),
+		Protocol: protocolVersion,
+		Version:  version,
+	}}
+	go func() {
+		started := time.Now()
+		ticker := time.NewTicker(simulateHeartbeat)
+		defer ticker.Stop()
+		for {
+			health := protocol.Health{Load1: rand.Float64(), MemUsedPct: 30 + 20*rand.Float64(),
+				DiskUsedPct: 40, UptimeSec: int64(time.Since(started).Seconds())}
+			select {
+			case outgoing <- protocol.Envelope{Type: "heartbeat", Payload: health}:
+			case <-writerDone:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-writerDone:
+				return
+			}
+		}
+	}()
+
+	// The default ping handler answers the server's pings while reading
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		stats.received.Add(1)
+		if msg.MsgID != "" {
+			select {
+			case outgoing <- Message{Type: "ack", ReplyTo: msg.MsgID}:
+				stats.acked.Add(1)
+			case <-writerDone:
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+)
+
+// SlowClientPolicy decides what happens when a client's send queue is full,
+// i.e. its connection is not keeping up with broadcasts.
+type SlowClientPolicy string
+
+const (
+	SlowClientDisconnect SlowClientPolicy = "disconnect"  // Drop the connection; the client reconnects and resyncs
+	SlowClientDropOldest SlowClientPolicy = "drop_oldest" // Discard the oldest queued message to make room
+	SlowClientGrow       SlowClientPolicy = "grow"        // Queue up to maxSendOverflow more messages, then disconnect
+)
+
+// maxSendOverflow caps how far the grow policy lets a queue exceed
+// its limit, so a dead connection cannot eat the server's memory.
+const maxSendOverflow = 4096
+
+// slowClientStats is published at /debug/vars (expvar) as "slow_clients".
+var slowClientStats = expvar.NewMap("slow_clients")
+
+func parseSlowClientPolicy(s string) (SlowClientPolicy, error) {
+	switch p := SlowClientPolicy(s); p {
+	case SlowClientDisconnect, SlowClientDropOldest, SlowClientGrow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow client policy %q (use disconnect, drop_oldest or grow)", s)
+}
+
+// deliver queues msg for client without blocking, applying policy when the
+// client's queue is full. It reports false if the client has to be
+// disconnected.
+func deliver(client *Client, msg []byte, policy SlowClientPolicy) bool {
+	var event string
+	switch client.Send.offer(msg, policy) {
+	case offerQueued, offerClosed:
+		return true
+	case offerDropped:
+		slowClientStats.Add("dropped_messages", 1)
+		event = "Slow client, dropping oldest messages"
+	case offerOverflowed:
+		slowClientStats.Add("overflowed_messages", 1)
+		event = "Slow client, growing send queue"
+	case offerRejected:
+		slowClientStats.Add("disconnects", 1)
+		slog.Warn("Slow client disconnected", "name", client.Name, "addr", client.Addr, "policy", policy)
+		return false
+	}
+	if client.Send.startLagging() {
+		slog.Warn(event, "name", client.Name, "addr", client.Addr)
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The speaker feed is a server-sent event stream of what a commentator
+// wants to know as it happens: passings and finishers from the splits,
+// changes of the lead at a control and timer milestones. The speaker's
+// laptop subscribes to GET /api/speaker with a plain browser or curl and
+// needs no display client. Events are kept in a short ring so a reconnect
+// (Last-Event-ID) misses nothing.
+const (
+	speakerRecent    = 100 // Events kept for Last-Event-ID
+	speakerBuffer    = 64  // Events queued per subscriber before it misses some
+	speakerKeepalive = 15 * time.Second
+	// finishControl is the splits control whose passings are finishers
+	finishControl = "finish"
+)
+
+// SpeakerEvent is one event of the speaker feed. Type is "passing",
+// "finisher", "lead_change" or "timer".
+type SpeakerEvent struct {
+	ID   int       `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Passings, finishers and lead changes
+	Control  string `json:"control,omitempty"`
+	Class    string `json:"class,omitempty"`
+	Bib      string `json:"bib,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Club     string `json:"club,omitempty"`
+	Result   string `json:"result,omitempty"`   // The competitor's time
+	Rank     int    `json:"rank,omitempty"`     // In the class, at the control
+	Behind   string `json:"behind,omitempty"`   // After the leader
+	Previous string `json:"previous,omitempty"` // Lead changes: the leader before
+	// Timer milestones: "start", "pause", "one_minute", "end",
+	// "period_start" or "intermission_start"
+	Room      string `json:"room,omitempty"`
+	Milestone string `json:"milestone,omitempty"`
+	Value     int    `json:"value,omitempty"` // Seconds left, or the period
+}
+
+// speakerFilter is what a subscriber asked for; empty fields match all.
+type speakerFilter struct {
+	types   []string
+	room    *string
+	control string
+	class   string
+}
+
+func (f speakerFilter) match(ev SpeakerEvent) bool {
+	switch {
+	case len(f.types) > 0 && !slices.Contains(f.types, ev.Type):
+		return false
+	case f.room != nil && ev.Type == "timer" && ev.Room != *f.room:
+		return false
+	case f.control != "" && ev.Type != "timer" && ev.Control != f.control:
+		return false
+	case f.class != "" && ev.Type != "timer" && ev.Class != f.class:
+		return false
+	}
+	return true
+}
+
+// Speaker fans events out to the subscribers of the feed. A nil *Speaker
+// publishes nothing.
+type Speaker struct {
+	mu     sync.Mutex
+	nextID int
+	recent []SpeakerEvent
+	subs   map[chan SpeakerEvent]speakerFilter
+}
+
+func NewSpeaker() *Speaker {
+	return &Speaker{nextID: 1, subs: make(map[chan SpeakerEvent]speakerFilter)}
+}
+
+// Publish numbers ev and queues it for every matching subscriber. It never
+// blocks, since the timer calls it with its lock held; a subscriber that is
+// that far behind misses events and is told so by the gap in the IDs.
+func (s *Speaker) Publish(ev SpeakerEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ev.ID = s.nextID
+	s.nextID++
+	ev.Time = time.Now()
+	if len(s.recent) == speakerRecent {
+		s.recent = slices.Delete(s.recent, 0, 1)
+	}
+	s.recent = append(s.recent, ev)
+	for ch, filter := range s.subs {
+		if !filter.match(ev) {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+			slog.Debug("Speaker feed subscriber is behind; event dropped", "id", ev.ID)
+		}
+	}
+}
+
+// Timer publishes a timer milestone of room.
+func (s *Speaker) Timer(room, milestone string, value int) {
+	s.Publish(SpeakerEvent{Type: "timer", Room: room, Milestone: milestone, Value: value})
+}
+
+// subscribe registers a subscriber and returns the events after lastID it
+// missed.
+func (s *Speaker) subscribe(filter speakerFilter, lastID int) (chan SpeakerEvent, []SpeakerEvent) {
+	ch := make(chan SpeakerEvent, speakerBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[ch] = filter
+	var missed []SpeakerEvent
+	if lastID > 0 {
+		for _, ev := range s.recent {
+			if ev.ID > lastID && filter.match(ev) {
+				missed = append(missed, ev)
+			}
+		}
+	}
+	return ch, missed
+}
+
+func (s *Speaker) unsubscribe(ch chan SpeakerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+}
+
+// passingEvents turns a stored passing into speaker events: the passing (or
+// finisher) itself, and a lead change when it leads its class.
+func passingEvents(r passingResult) []SpeakerEvent {
+	p := r.Passing
+	ev := SpeakerEvent{Type: "passing", Control: p.Control, Class: p.Class, Bib: p.Bib, Name: p.Name, Club: p.Club, Result: formatSplit(int64(p.Time)), Rank: r.Rank}
+	if strings.EqualFold(p.Control, finishControl) {
+		ev.Type = "finisher"
+	}
+	if r.Rank > 1 {
+		ev.Behind = "+" + formatSplit(int64(p.Time-r.Leader.Time))
+	}
+	events := []SpeakerEvent{ev}
+	if r.LeadChanged {
+		lead := ev
+		lead.Type = "lead_change"
+		lead.Previous = r.Previous
+		events = append(events, lead)
+	}
+	return events
+}
+
+// registerSpeakerAPI serves the speaker feed.
+func registerSpeakerAPI(hub *Hub) {
+	// GET /api/speaker[?types=finisher,lead_change&room=&control=&class=]: text/event-stream
+	// of SpeakerEvent, "event: <type>" and "id: <n>"; Last-Event-ID resumes
+	http.HandleFunc("GET /api/speaker", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		filter := speakerFilter{control: q.Get("control"), class: q.Get("class")}
+		if types := q.Get("types"); types != "" {
+			filter.types = strings.Split(types, ",")
+		}
+		if q.Has("room") {
+			room := q.Get("room")
+			filter.room = &room
+		}
+		lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+		ch, missed := hub.Speaker.subscribe(filter, lastID)
+		defer hub.Speaker.unsubscribe(ch)
+		slog.Info("Speaker feed subscribed", "addr", r.RemoteAddr)
+		defer slog.Info("Speaker feed unsubscribed", "addr", r.RemoteAddr)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // Behind nginx
+		fmt.Fprint(w, "retry: 3000\n\n")
+		send := func(ev SpeakerEvent) bool {
+			data, err := json.Marshal(ev)
+			if err != nil {
+				slog.Error("Error marshaling speaker event", "err", err)
+				return true
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			return err == nil
+		}
+		for _, ev := range missed {
+			if !send(ev) {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(speakerKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case ev := <-ch:
+				if !send(ev) {
+					return
+				}
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
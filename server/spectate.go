@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/flate"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Spectators are phones in the stands following a room's live data on a
+// results page: GET /ws/spectate?room=<name> streams what the room's
+// displays get (state_sync on joining, then timer, score, splits and result
+// switches, and time_sync), but nothing about the display fleet, and
+// anything they send is discarded. They are kept apart from Hub.Clients, so
+// they neither take up maxClients nor appear in client lists; maxSpectators
+// limits them instead.
+const (
+	roleSpectator          = "spectator"
+	defaultMaxSpectators   = 500
+	spectatorMaxMessageLen = 512
+)
+
+// addSpectator registers client for its room's broadcasts, unless
+// MaxSpectators are connected already.
+func (h *Hub) addSpectator(client *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxSpectators > 0 && len(h.spectators) >= h.MaxSpectators {
+		return false
+	}
+	h.spectators[client] = true
+	return true
+}
+
+func (h *Hub) removeSpectator(client *Client) {
+	h.mu.Lock()
+	if _, ok := h.spectators[client]; ok {
+		delete(h.spectators, client)
+		client.Send.close()
+	}
+	h.mu.Unlock()
+}
+
+// broadcastSpectators queues message for every spectator, or those in room
+// unless all.
+func (h *Hub) broadcastSpectators(message []byte, room string, all bool) {
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.spectators))
+	for client := range h.spectators {
+		if all || client.Room == room {
+			clients = append(clients, client)
+		}
+	}
+	policy := h.SlowClientPolicy
+	h.mu.Unlock()
+	for _, client := range clients {
+		if !deliver(client, message, policy) {
+			h.removeSpectator(client)
+		}
+	}
+}
+
+func serveSpectator(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	room := r.URL.Query().Get("room")
+	if err := hub.checkRoom(room); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("WebSocket upgrade failed", "addr", r.RemoteAddr, "err", err)
+		return
+	}
+	conn.SetCompressionLevel(flate.BestSpeed)
+	client := hub.newClient(conn, conn.RemoteAddr().String(), transportWS)
+	client.Role, client.Room, client.Protocol, client.joined = roleSpectator, room, protocolVersion, true
+	go client.writePump()
+	if !hub.addSpectator(client) {
+		slog.Debug("Spectator rejected (limit reached)", "addr", client.Addr)
+		hub.turnAway(client, "Too many spectators")
+		return
+	}
+	slog.Debug("Spectator connected", "addr", client.Addr, "room", room)
+	hub.mu.Lock()
+	policy := hub.SlowClientPolicy
+	hub.mu.Unlock()
+	for _, data := range hub.joinMessages(client, true) {
+		deliver(client, data, policy)
+	}
+	client.spectatorReadPump()
+}
+
+// spectatorReadPump keeps the connection's deadlines and discards what the
+// spectator sends; it unregisters the spectator when the connection ends.
+func (c *Client) spectatorReadPump() {
+	defer func() {
+		c.Hub.removeSpectator(c)
+		c.Conn.Close()
+		slog.Debug("Spectator disconnected", "addr", c.Addr)
+	}()
+	c.Conn.SetReadLimit(spectatorMaxMessageLen)
+	c.Conn.SetReadDeadline(time.Now().Add(c.limits.pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(c.limits.pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := c.Conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Debug("Spectator read error", "addr", c.Addr, "err", err)
+			}
+			return
+		}
+	}
+}
+
+// spectatorCounts returns the number of spectators per room. Caller holds
+// h.mu.
+func (h *Hub) spectatorCounts() map[string]int {
+	counts := make(map[string]int)
+	for client := range h.spectators {
+		counts[client.Room]++
+	}
+	return counts
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Intermediate times ("splits") are pushed by radio controls or a timing
+// system to POST /api/splits. The hub keeps each competitor's latest time at
+// each control and ranks them; a room shows one control (and optionally one
+// class) on displays in the show_splits mode, which update the table in place
+// on every splits_update instead of reloading a result file. Splits are kept
+// in memory only: the timing system remains the record.
+const (
+	maxSplitControls    = 100
+	maxSplitsPerControl = 5000
+	maxSplitPush        = 500 // Passings in one POST
+	maxSplitFieldLen    = 64
+	defaultSplitsTop    = 10
+	maxSplitsTop        = 50
+	maxSplitMillis      = 24 * 60 * 60 * 1000
+)
+
+// splitTime is a time since the competitor's start in milliseconds, sent as
+// seconds (754.2) or text ("12:34", "1:02:03.4").
+type splitTime int64
+
+func (t *splitTime) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var seconds float64
+		if err := json.Unmarshal(data, &seconds); err != nil {
+			return errors.New("time must be seconds or [h:]mm:ss")
+		}
+		*t = splitTime(seconds * 1000)
+		return nil
+	}
+	ms, err := parseSplitTime(text)
+	*t = splitTime(ms)
+	return err
+}
+
+// parseSplitTime reads "754.2", "12:34" or "1:02:03.4" as milliseconds.
+func parseSplitTime(text string) (int64, error) {
+	parts := strings.Split(strings.TrimSpace(text), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("time %q must be [h:]mm:ss", text)
+	}
+	var ms float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("time %q must be [h:]mm:ss", text)
+		}
+		ms = ms*60 + n*1000
+	}
+	return int64(ms), nil
+}
+
+// formatSplit shows ms as m:ss or h:mm:ss, with tenths when there are any.
+func formatSplit(ms int64) string {
+	tenths := ""
+	if ms%1000 != 0 {
+		tenths = fmt.Sprintf(".%d", ms%1000/100)
+	}
+	s := ms / 1000
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d%s", s/3600, s/60%60, s%60, tenths)
+	}
+	return fmt.Sprintf("%d:%02d%s", s/60, s%60, tenths)
+}
+
+// Passing is one competitor at one control, as pushed to POST /api/splits.
+// A competitor is known by bib, or by class and name without one; a later
+// passing replaces the earlier one, so a timing system can correct times.
+type Passing struct {
+	Control string    `json:"control"`
+	Class   string    `json:"class,omitempty"`
+	Bib     string    `json:"bib,omitempty"`
+	Name    string    `json:"name"`
+	Club    string    `json:"club,omitempty"`
+	Time    splitTime `json:"time"` // Since the competitor's start
+	seq     int       // Order of arrival, for equal times and the newest passing
+}
+
+func (p Passing) key() string {
+	if p.Bib != "" {
+		return "#" + p.Bib
+	}
+	return p.Class + "\x00" + p.Name
+}
+
+// SplitView is what a room shows: the top of one control.
+type SplitView struct {
+	Control string `json:"control"`         // "" = nothing
+	Class   string `json:"class,omitempty"` // "" = all classes together
+	Top     int    `json:"top,omitempty"`   // 0 = default (10)
+}
+
+// SplitRow is one line of a control's standings.
+type SplitRow struct {
+	Rank   int    `json:"rank"` // Equal times share a rank
+	Bib    string `json:"bib,omitempty"`
+	Name   string `json:"name"`
+	Club   string `json:"club,omitempty"`
+	Class  string `json:"class,omitempty"`
+	Time   string `json:"time"`
+	Behind string `json:"behind,omitempty"` // "+0:12" after the leader
+	Latest bool   `json:"latest,omitempty"` // The control's newest passing, which displays highlight
+}
+
+// SplitsState is the payload of splits_update: a room's view and its
+// standings.
+type SplitsState struct {
+	SplitView
+	Rows     []SplitRow `json:"rows"`
+	Passings int        `json:"passings"` // Competitors through the control (in the class)
+}
+
+// SplitControl is an entry of GET /api/splits.
+type SplitControl struct {
+	Control  string   `json:"control"`
+	Classes  []string `json:"classes"`
+	Passings int      `json:"passings"`
+}
+
+// SplitBoard holds the passings of every control.
+type SplitBoard struct {
+	mu       sync.Mutex
+	controls map[string]map[string]Passing // Control -> competitor key -> passing
+	seq      int
+}
+
+func NewSplitBoard() *SplitBoard {
+	return &SplitBoard{controls: make(map[string]map[string]Passing)}
+}
+
+// passingResult is where a new passing places in its class at the control.
+type passingResult struct {
+	Passing
+	Rank        int
+	Leader      Passing // Fastest other competitor, if any
+	LeadChanged bool    // The passing took the lead (or is the first)
+	Previous    string  // Who led before
+}
+
+// Add stores validated passings and returns the controls that changed and
+// where each passing placed.
+func (b *SplitBoard) Add(passings []Passing) ([]string, []passingResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var changed []string
+	var results []passingResult
+	for _, p := range passings {
+		control := b.controls[p.Control]
+		if control == nil {
+			if len(b.controls) >= maxSplitControls {
+				return changed, results, fmt.Errorf("at most %d controls", maxSplitControls)
+			}
+			control = make(map[string]Passing)
+			b.controls[p.Control] = control
+		}
+		if _, ok := control[p.key()]; !ok && len(control) >= maxSplitsPerControl {
+			return changed, results, fmt.Errorf("control %s has %d passings already", p.Control, maxSplitsPerControl)
+		}
+		b.seq++
+		p.seq = b.seq
+		r := passingResult{Passing: p, Rank: 1}
+		var before Passing
+		for key, other := range control {
+			if other.Class != p.Class {
+				continue
+			}
+			if before.seq == 0 || faster(other, before) {
+				before = other
+			}
+			if key == p.key() {
+				continue
+			}
+			if other.Time < p.Time {
+				r.Rank++
+			}
+			if r.Leader.seq == 0 || faster(other, r.Leader) {
+				r.Leader = other
+			}
+		}
+		r.LeadChanged = r.Rank == 1 && (r.Leader.seq == 0 || p.Time < r.Leader.Time) && before.key() != p.key()
+		r.Previous = before.Name
+		results = append(results, r)
+		control[p.key()] = p
+		if !slices.Contains(changed, p.Control) {
+			changed = append(changed, p.Control)
+		}
+	}
+	return changed, results, nil
+}
+
+// faster orders passings by time, then arrival.
+func faster(a, b Passing) bool {
+	if a.Time != b.Time {
+		return a.Time < b.Time
+	}
+	return a.seq < b.seq
+}
+
+// Clear forgets the passings of control, or of all controls for "".
+func (b *SplitBoard) Clear(control string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if control == "" {
+		b.controls = make(map[string]map[string]Passing)
+		return
+	}
+	delete(b.controls, control)
+}
+
+// Controls lists the controls with passings, by name.
+func (b *SplitBoard) Controls() []SplitControl {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]SplitControl, 0, len(b.controls))
+	for name, passings := range b.controls {
+		c := SplitControl{Control: name, Classes: []string{}, Passings: len(passings)}
+		for _, p := range passings {
+			if p.Class != "" && !slices.Contains(c.Classes, p.Class) {
+				c.Classes = append(c.Classes, p.Class)
+			}
+		}
+		sort.Strings(c.Classes)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Control < list[j].Control })
+	return list
+}
+
+// Standings ranks the passings of the view's control, fastest first.
+func (b *SplitBoard) Standings(view SplitView) SplitsState {
+	state := SplitsState{SplitView: view, Rows: []SplitRow{}}
+	if view.Control == "" {
+		return state
+	}
+	top := view.Top
+	if top == 0 {
+		top = defaultSplitsTop
+	}
+	b.mu.Lock()
+	var list []Passing
+	latest := 0
+	for _, p := range b.controls[view.Control] {
+		if view.Class == "" || p.Class == view.Class {
+			list = append(list, p)
+			latest = max(latest, p.seq)
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return faster(list[i], list[j]) })
+	state.Passings = len(list)
+	for i, p := range list[:min(len(list), top)] {
+		row := SplitRow{Rank: i + 1, Bib: p.Bib, Name: p.Name, Club: p.Club, Class: p.Class, Time: formatSplit(int64(p.Time)), Latest: p.seq == latest}
+		if i > 0 {
+			if p.Time == list[i-1].Time {
+				row.Rank = state.Rows[i-1].Rank
+			}
+			row.Behind = "+" + formatSplit(int64(p.Time-list[0].Time))
+		}
+		state.Rows = append(state.Rows, row)
+	}
+	return state
+}
+
+// SplitView returns what room shows.
+func (h *Hub) SplitView(room string) SplitView {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.room(room).Splits
+}
+
+// SetSplitView makes room show view and sends it the standings.
+func (h *Hub) SetSplitView(room string, view SplitView) SplitsState {
+	h.mu.Lock()
+	h.room(room).Splits = view
+	h.mu.Unlock()
+	state := h.Splits.Standings(view)
+	h.broadcastSplits(room, state)
+	return state
+}
+
+// AddPassings stores passings, updates the rooms showing their controls and
+// tells the speaker feed.
+func (h *Hub) AddPassings(passings []Passing) error {
+	changed, results, err := h.Splits.Add(passings)
+	h.splitsChanged(changed)
+	for _, r := range results {
+		for _, ev := range passingEvents(r) {
+			h.Speaker.Publish(ev)
+		}
+	}
+	return err
+}
+
+// ClearSplits forgets the passings of control ("" = all) and updates the
+// rooms showing it.
+func (h *Hub) ClearSplits(control string) {
+	h.Splits.Clear(control)
+	h.splitsChanged(nil)
+}
+
+// splitsChanged sends the standings to the rooms showing one of controls,
+// or to every room with a view when controls is nil.
+func (h *Hub) splitsChanged(controls []string) {
+	h.mu.Lock()
+	views := make(map[string]SplitView)
+	for name, r := range h.rooms {
+		if r.Splits.Control != "" && (controls == nil || slices.Contains(controls, r.Splits.Control)) {
+			views[name] = r.Splits
+		}
+	}
+	h.mu.Unlock()
+	for room, view := range views {
+		h.broadcastSplits(room, h.Splits.Standings(view))
+	}
+}
+
+func (h *Hub) broadcastSplits(room string, state SplitsState) {
+	state = h.formatSplits(state)
+	data, err := json.Marshal(struct {
+		Type    string      `json:"type"`
+		Payload SplitsState `json:"payload"`
+	}{
+		Type:    "splits_update",
+		Payload: state,
+	})
+	if err != nil {
+		slog.Error("Error marshaling splits", "err", err)
+		return
+	}
+	h.RoomBroadcast <- roomMessage{Room: room, Msg: data}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Sport profiles describe a sport in JSON: which way the clock runs, its
+// periods, what the scoreboard counts and when the buzzer sounds. The
+// built-in ones are embedded from sports/; files in sportsDir (server.json)
+// add sports or replace built-in ones of the same name, so a new sport needs
+// no code change.
+
+//go:embed sports/*.json
+var builtinSports embed.FS
+
+const maxSportsFileSize = 64 << 10
+
+var sportNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// SportProfile is one sport, read from <name>.json.
+type SportProfile struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// "down" (the default) counts the period down, "up" shows the time
+	// played, "none" hides the clock
+	Clock string `json:"clock,omitempty"`
+	// Replaces the server's matchFlow in a room playing this sport
+	Periods    *MatchFlow      `json:"periods,omitempty"`
+	Scoreboard ScoreboardRules `json:"scoreboard"`
+	Buzzer     BuzzerRules     `json:"buzzer"`
+}
+
+// ScoreboardRules say what the scoreboard counts. With type "sets" the
+// remaining fields are the rules of a set.
+type ScoreboardRules struct {
+	Type           string `json:"type"`                     // "sets", "points" (a running score, e.g. goals) or "" for none
+	SetsToWin      int    `json:"setsToWin,omitempty"`      // 3 for best of five
+	SetPoints      int    `json:"setPoints,omitempty"`      // Points (games, with Games) that win a set
+	DecidingPoints int    `json:"decidingPoints,omitempty"` // Instead of SetPoints in the deciding set, e.g. volleyball's 15
+	WinBy          int    `json:"winBy,omitempty"`          // Lead needed to win a set
+	SetCap         int    `json:"setCap,omitempty"`         // Wins a set without the lead, e.g. badminton's 30
+	// Sets are won in games of 0, 15, 30, 40 (tennis), and the serve
+	// changes every game; otherwise the team that wins a rally serves.
+	Games bool `json:"games,omitempty"`
+	// With Games: the game played at SetCap-1 games all is a tiebreak to
+	// this many points
+	TiebreakPoints int `json:"tiebreakPoints,omitempty"`
+}
+
+// BuzzerRules say when displays showing the clock sound the buzzer.
+type BuzzerRules struct {
+	ClockEnd   bool `json:"clockEnd,omitempty"`   // The clock ran out (a period or an intermission)
+	PenaltyEnd bool `json:"penaltyEnd,omitempty"` // A penalty ran out
+	Seconds    int  `json:"seconds,omitempty"`    // Length of the sound; default 1
+}
+
+func (p *SportProfile) validate() error {
+	switch {
+	case !sportNamePattern.MatchString(p.Name):
+		return fmt.Errorf("name %q must be 1 to 32 lower case letters, digits, - or _", p.Name)
+	case p.Clock != "" && p.Clock != "down" && p.Clock != "up" && p.Clock != "none":
+		return fmt.Errorf("clock %q must be down, up or none", p.Clock)
+	case p.Buzzer.Seconds < 0 || p.Buzzer.Seconds > 10:
+		return errors.New("buzzer seconds must be between 0 and 10")
+	}
+	if p.Periods != nil {
+		if p.Periods.Periods == 0 {
+			return errors.New("periods: periods must be at least 1")
+		}
+		if err := p.Periods.validate(); err != nil {
+			return fmt.Errorf("periods: %w", err)
+		}
+	}
+	r := p.Scoreboard
+	switch r.Type {
+	case "", "points":
+	case "sets":
+		switch {
+		case r.SetsToWin < 1 || r.SetsToWin > 5:
+			return errors.New("scoreboard: setsToWin must be between 1 and 5")
+		case r.SetPoints < 1 || r.WinBy < 1:
+			return errors.New("scoreboard: setPoints and winBy must be positive")
+		case r.DecidingPoints < 0 || r.TiebreakPoints < 0:
+			return errors.New("scoreboard: decidingPoints and tiebreakPoints must not be negative")
+		case r.SetCap != 0 && r.SetCap <= r.SetPoints:
+			return errors.New("scoreboard: setCap must be above setPoints")
+		case r.TiebreakPoints > 0 && (!r.Games || r.SetCap == 0):
+			return errors.New("scoreboard: tiebreakPoints needs games and setCap")
+		}
+	default:
+		return fmt.Errorf("scoreboard: type %q must be sets, points or empty", r.Type)
+	}
+	return nil
+}
+
+// loadSportProfiles reads the built-in profiles, then those in dir, which
+// replace built-in ones of the same name. A broken file in dir is logged and
+// skipped; a missing dir is not an error.
+func loadSportProfiles(dir string) map[string]SportProfile {
+	sports := make(map[string]SportProfile)
+	builtin, _ := fs.Sub(builtinSports, "sports")
+	readSportProfiles(builtin, "built-in", sports)
+	if dir != "" {
+		if _, err := os.Stat(dir); err == nil {
+			readSportProfiles(os.DirFS(dir), dir, sports)
+		} else if !os.IsNotExist(err) {
+			slog.Warn("Cannot read sports directory", "dir", dir, "err", err)
+		}
+	}
+	return sports
+}
+
+func readSportProfiles(fsys fs.FS, from string, sports map[string]SportProfile) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		slog.Warn("Cannot list sport profiles", "dir", from, "err", err)
+		return
+	}
+	for _, file := range files {
+		p, err := readSportProfile(fsys, file)
+		if err != nil {
+			slog.Error("Skipping sport profile", "dir", from, "file", file, "err", err)
+			continue
+		}
+		if _, ok := sports[p.Name]; ok && from != "built-in" {
+			slog.Info("Sport profile replaces the built-in one", "sport", p.Name, "file", filepath.Join(from, file))
+		}
+		sports[p.Name] = p
+	}
+}
+
+func readSportProfile(fsys fs.FS, file string) (SportProfile, error) {
+	var p SportProfile
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return p, err
+	}
+	if len(data) > maxSportsFileSize {
+		return p, errors.New("file too large")
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(file, ".json")
+	}
+	if p.Title == "" {
+		p.Title = p.Name
+	}
+	if p.Buzzer.Seconds == 0 {
+		p.Buzzer.Seconds = 1
+	}
+	return p, p.validate()
+}
+
+// ReloadSports reads the profiles again, after files in SportsDir changed.
+// Rooms keep the profile of their current match until a sport is chosen.
+func (h *Hub) ReloadSports() {
+	h.mu.Lock()
+	dir := h.SportsDir
+	h.mu.Unlock()
+	sports := loadSportProfiles(dir)
+	h.mu.Lock()
+	h.Sports = sports
+	h.mu.Unlock()
+	slog.Info("Sport profiles reloaded", "dir", dir, "sports", len(sports))
+}
+
+// sport returns the named profile.
+func (h *Hub) sport(name string) (SportProfile, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.Sports[name]
+	return p, ok
+}
+
+// SportProfiles lists the profiles by name, for GET /api/sports.
+func (h *Hub) SportProfiles() []SportProfile {
+	h.mu.Lock()
+	list := make([]SportProfile, 0, len(h.Sports))
+	for _, p := range h.Sports {
+		list = append(list, p)
+	}
+	h.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Transports a display can be connected over.
+const (
+	transportWS  = "ws"
+	transportSSE = "sse"
+)
+
+// Some proxies and captive networks break WebSockets. Displays behind them
+// fall back to server-sent events: GET /sse streams exactly what the
+// WebSocket would carry, one "data:" line per message, after an opening
+// "session" event with a token; the few messages a display sends (its
+// handshake, heartbeats and acks) are POSTed to /sse?session=<token>. The
+// fallback is read-only: a display on it cannot become a controller.
+var sseUpstream = map[string]bool{"handshake": true, "heartbeat": true, "ack": true, "network_events": true}
+
+// sseSession is one stream. mu makes POSTed messages take turns, since the
+// client's message handling assumes one reader like readPump.
+type sseSession struct {
+	mu     sync.Mutex
+	client *Client
+}
+
+type sseSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+func (s *sseSessions) add(client *Client) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	s.mu.Lock()
+	s.sessions[token] = &sseSession{client: client}
+	s.mu.Unlock()
+	return token
+}
+
+func (s *sseSessions) get(token string) *sseSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[token]
+}
+
+func (s *sseSessions) remove(token string) {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+}
+
+// registerSSE serves the server-sent events fallback of /ws.
+func registerSSE(hub *Hub) {
+	sessions := &sseSessions{sessions: make(map[string]*sseSession)}
+
+	// GET /sse: text/event-stream, "event: session" with the token first, then
+	// the WebSocket's messages
+	http.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		if !checkOrigin(r) {
+			http.Error(w, "Forbidden origin", http.StatusForbidden)
+			return
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		// Origins passed the check above, like for /ws; the Tizen app is
+		// always cross-origin
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		client := hub.newClient(nil, r.RemoteAddr, transportSSE)
+		token := sessions.add(client)
+		defer func() {
+			sessions.remove(token)
+			hub.Unregister <- client
+		}()
+		hub.Register <- client
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // Behind nginx
+		rc := http.NewResponseController(w)
+		write := func(format string, args ...any) bool {
+			rc.SetWriteDeadline(time.Now().Add(client.limits.writeWait))
+			if _, err := fmt.Fprintf(w, format, args...); err != nil {
+				return false
+			}
+			return rc.Flush() == nil
+		}
+		if !write("retry: 3000\nevent: session\ndata: %s\n\n", token) {
+			return
+		}
+
+		// Comments keep proxies from closing an idle stream, like pings do
+		// for the WebSocket
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-client.Send.ready:
+				messages, ok := client.Send.take()
+				for _, message := range messages {
+					if !write("data: %s\n\n", message) {
+						return
+					}
+				}
+				if !ok {
+					return
+				}
+			case <-ticker.C:
+				if !write(": ping\n\n") {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	// POST /sse?session=<token>: one message from the display, as it would
+	// send it over the WebSocket (any content type, so pages need no CORS
+	// preflight). The session token is what authorizes it.
+	http.HandleFunc("POST /sse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		session := sessions.get(r.URL.Query().Get("session"))
+		if session == nil {
+			http.Error(w, "Unknown session", http.StatusNotFound)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, session.client.limits.maxMessageSize))
+		if err != nil {
+			http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err == nil && !sseUpstream[msg.Type] {
+			http.Error(w, msg.Type+" is not allowed over server-sent events", http.StatusForbidden)
+			return
+		}
+		session.mu.Lock()
+		ok := session.client.handleMessage(data)
+		session.mu.Unlock()
+		if !ok {
+			slog.Warn("Closing server-sent events session", "addr", session.client.Addr)
+			session.client.Send.close() // Ends the stream, which unregisters the client
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
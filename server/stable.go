@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFileStableMs = 1000
+	maxFileStableMs     = 10000
+	// incompleteGrace is how long a file whose size has settled may keep
+	// failing completeFile() before a followed room shows it anyway, for
+	// exporters whose files never look complete (no </html>, no trailing
+	// record)
+	incompleteGrace = 30 * time.Second
+	// pdfTrailerWindow is how far from the end of a PDF %%EOF is looked for.
+	pdfTrailerWindow = 1024
+)
+
+// pendingFile is a new or changed result that is not shown yet: how it was
+// listed, when it was first listed like that, and since when it has failed
+// completeFile() (zero if it has not).
+type pendingFile struct {
+	size       int64
+	modTime    time.Time
+	since      time.Time
+	incomplete time.Time
+}
+
+// ready reports whether f, found new or changed by the last listing, may be
+// shown: its size and modification time must not have changed for
+// fileStableMs, across at least two polls, and it must look complete
+// (completeFile()), or have looked incomplete but unchanged for
+// incompleteGrace. A file that is not ready is kept in rw.pending and looked
+// at again on the next poll.
+func (rw *ResultsWatcher) ready(settings Settings, f ResultFile, now time.Time) bool {
+	if settings.FileStableMs <= 0 {
+		delete(rw.pending, f.Name)
+		return true
+	}
+	abs, ok := resolveResultPath(settings.ResultsDir, settings.ResultsAliases, f.Name)
+	if !ok {
+		return false
+	}
+	p, seen := rw.pending[f.Name]
+	if !seen || p.size != f.Size || !p.modTime.Equal(f.ModTime) {
+		if seen {
+			slog.Debug("Result file still being written", "file", f.Name)
+		}
+		rw.pending[f.Name] = pendingFile{size: f.Size, modTime: f.ModTime, since: now}
+		return false
+	}
+	if now.Sub(p.since) < time.Duration(settings.FileStableMs)*time.Millisecond {
+		return false
+	}
+	err := completeFile(abs, settings.CSV)
+	if err == nil {
+		delete(rw.pending, f.Name)
+		return true
+	}
+	if p.incomplete.IsZero() {
+		slog.Info("Result file looks incomplete, waiting", "file", f.Name, "reason", err)
+		p.incomplete = now
+		rw.pending[f.Name] = p
+		return false
+	}
+	if now.Sub(p.incomplete) < incompleteGrace {
+		return false
+	}
+	slog.Warn("Showing result file that still looks incomplete", "file", f.Name, "reason", err)
+	delete(rw.pending, f.Name)
+	return true
+}
+
+// completeFile reports why the result at abs looks cut off, by type: an HTML
+// page that opens <html> without closing it, a PDF without %%EOF near the
+// end, a CSV that is cut off (csvComplete()), or XML that does not parse to
+// the end. Other files always pass.
+func completeFile(abs string, opts CSVOptions) error {
+	switch strings.ToLower(filepath.Ext(abs)) {
+	case ".htm", ".html":
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		lower := bytes.ToLower(data)
+		if bytes.Contains(lower, []byte("<html")) && !bytes.Contains(lower, []byte("</html")) {
+			return errors.New("no </html>")
+		}
+	case ".pdf":
+		return pdfComplete(abs)
+	case ".csv":
+		return csvComplete(abs, opts)
+	case ".xml":
+		f, err := os.Open(abs)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dec := xml.NewDecoder(f)
+		dec.CharsetReader = xmlCharsetReader
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func pdfComplete(abs string) error {
+	f, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := max(info.Size()-pdfTrailerWindow, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Contains(tail, []byte("%%EOF")) {
+		return errors.New("no %%EOF trailer")
+	}
+	return nil
+}
+
+// csvComplete parses the CSV file at abs like serveTable() does. Exports
+// have ragged rows, so a last record shorter than the first only counts as
+// cut off when the file does not end with a line break either.
+func csvComplete(abs string, opts CSVOptions) error {
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return err
+	}
+	t, err := parseTable(data, opts)
+	if errors.Is(err, errNoDelimiter) {
+		return nil // A single column, which serveTable() leaves alone
+	} else if err != nil {
+		return err
+	}
+	rows := t.Rows
+	if t.Header != nil {
+		rows = append([][]string{t.Header}, rows...)
+	}
+	if len(rows) == 0 || bytes.HasSuffix(data, []byte("\n")) {
+		return nil
+	}
+	if first, last := len(rows[0]), len(rows[len(rows)-1]); last < first {
+		return fmt.Errorf("last record has %d of %d fields", last, first)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadyWaitsForStableSizeAcrossPolls(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(dir, "r.html")
+	write := func(content string) ResultFile {
+		t.Helper()
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ResultFile{Name: "r.html", Size: info.Size(), ModTime: info.ModTime()}
+	}
+	rw := NewResultsWatcher(nil, nil)
+	settings := Settings{ResultsDir: dir, FileStableMs: 1000}
+	now := time.Now()
+	poll := func(f ResultFile) bool {
+		now = now.Add(resultsPollInterval)
+		return rw.ready(settings, f, now)
+	}
+
+	f := write("<html><body>1")
+	start := time.Now()
+	if poll(f) {
+		t.Fatal("ready on the first listing")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ready blocked for %v", elapsed)
+	}
+	if poll(f) {
+		t.Fatal("ready while incomplete")
+	}
+	f = write("<html><body>12</body></html>")
+	if poll(f) {
+		t.Fatal("ready right after the file grew")
+	}
+	if !poll(f) {
+		t.Fatal("not ready after a poll without change")
+	}
+	if _, ok := rw.pending["r.html"]; ok {
+		t.Error("shown file still pending")
+	}
+}
+
+func TestReadyShowsIncompleteFileAfterGrace(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "r.html"), []byte("<html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(filepath.Join(dir, "r.html"))
+	f := ResultFile{Name: "r.html", Size: info.Size(), ModTime: info.ModTime()}
+	rw := NewResultsWatcher(nil, nil)
+	settings := Settings{ResultsDir: dir, FileStableMs: 1000}
+	now := time.Now()
+	for polls := 0; !rw.ready(settings, f, now); polls++ {
+		if polls > 20 {
+			t.Fatal("incomplete file never shown")
+		}
+		now = now.Add(resultsPollInterval)
+	}
+}
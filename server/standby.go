@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A standby server mirrors a primary for events where a blank arena screen
+// is not an option. It does not announce itself; it follows each room the
+// primary has on /ws/spectate (the result, timer and score, as spectators
+// see them) and checks the primary every few seconds. When the primary has
+// not answered for takeoverSeconds, the standby announces itself under the
+// primary's serverName, so displays looking for it reconnect to the standby
+// and find the same result, a running timer still running and the same
+// score.
+const (
+	defaultTakeoverSeconds = 15
+	maxTakeoverSeconds     = 600
+	standbyProbeInterval   = 3 * time.Second
+	standbyRedial          = 2 * time.Second
+	standbyReadTimeout     = 75 * time.Second // time_sync arrives every 30s
+)
+
+// Standby states, as GET /api/standby reports them.
+const (
+	standbyOff         = "off"
+	standbyWaiting     = "waiting"     // The primary has not answered yet
+	standbyMirroring   = "mirroring"   // Following the primary
+	standbyUnreachable = "unreachable" // The primary does not answer
+	standbyActive      = "active"      // Took over
+)
+
+// StandbyOptions make the server a standby of another (server.json
+// "standby").
+type StandbyOptions struct {
+	Primary         string `json:"primary" yaml:"primary" toml:"primary"`                         // Its address, e.g. http://192.168.1.10:8080
+	TakeoverSeconds int    `json:"takeoverSeconds" yaml:"takeoverSeconds" toml:"takeoverSeconds"` // 0 = default, 15
+}
+
+func (o StandbyOptions) validate() error {
+	if o.TakeoverSeconds < 0 || o.TakeoverSeconds > maxTakeoverSeconds {
+		return fmt.Errorf("takeoverSeconds must be between 0 and %d", maxTakeoverSeconds)
+	}
+	if o.Primary == "" {
+		return nil
+	}
+	u, err := url.Parse(o.Primary)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("primary %q must be an http:// or https:// address", o.Primary)
+	}
+	return nil
+}
+
+// StandbyStatus is the answer of GET /api/standby.
+type StandbyStatus struct {
+	State       string    `json:"state"`
+	Primary     string    `json:"primary,omitempty"`
+	Name        string    `json:"name,omitempty"` // The primary's serverName, announced after a takeover
+	LastContact time.Time `json:"lastContact,omitzero"`
+	Rooms       []string  `json:"rooms,omitempty"` // Mirrored
+}
+
+// Standby follows the primary until it takes over.
+type Standby struct {
+	hub      *Hub
+	primary  *url.URL
+	takeover time.Duration
+	announce func(name string) // Starts discovery under the primary's serverName
+	client   *http.Client
+
+	mu          sync.Mutex
+	state       string
+	name        string
+	lastContact time.Time
+	offset      time.Duration // Primary's clock minus ours, from time_sync
+	rooms       map[string]*mirroredRoom
+	done        chan struct{} // Closed on takeover or shutdown
+}
+
+// mirroredRoom is the primary's timer in a room as last received, to run
+// it on from there after a takeover.
+type mirroredRoom struct {
+	timer      TimerState
+	receivedAt time.Time
+}
+
+func newStandby(hub *Hub, opts StandbyOptions, announce func(name string)) *Standby {
+	primary, _ := url.Parse(opts.Primary) // Checked by validate
+	takeover := time.Duration(opts.TakeoverSeconds) * time.Second
+	if takeover == 0 {
+		takeover = defaultTakeoverSeconds * time.Second
+	}
+	return &Standby{hub: hub, primary: primary, takeover: takeover, announce: announce,
+		client: &http.Client{Timeout: standbyProbeInterval}, state: standbyWaiting,
+		rooms: make(map[string]*mirroredRoom), done: make(chan struct{})}
+}
+
+// Status reports what the standby is doing; nil reports standbyOff.
+func (s *Standby) Status() StandbyStatus {
+	if s == nil {
+		return StandbyStatus{State: standbyOff}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := StandbyStatus{State: s.state, Primary: s.primary.String(), Name: s.name, LastContact: s.lastContact}
+	for room := range s.rooms {
+		st.Rooms = append(st.Rooms, room)
+	}
+	slices.Sort(st.Rooms)
+	return st
+}
+
+// Run checks the primary until it takes over or stop is closed. The primary
+// must have answered once before it can be taken over, so a mistyped
+// address does not put two servers on the air.
+func (s *Standby) Run(stop <-chan struct{}) {
+	slog.Info("Standby: following primary", "primary", s.primary.String(), "takeover", s.takeover)
+	ticker := time.NewTicker(standbyProbeInterval)
+	defer ticker.Stop()
+	for {
+		err := s.probe()
+		s.mu.Lock()
+		switch {
+		case err == nil:
+			if s.state != standbyMirroring {
+				slog.Info("Standby: primary answering", "name", s.name)
+			}
+			s.state = standbyMirroring
+		case s.lastContact.IsZero():
+			if s.state == standbyWaiting {
+				slog.Warn("Standby: primary not answering yet", "primary", s.primary.String(), "err", err)
+				s.state = standbyUnreachable
+			}
+		default:
+			if s.state == standbyMirroring {
+				slog.Warn("Standby: primary stopped answering", "primary", s.primary.String(), "err", err)
+				s.state = standbyUnreachable
+			}
+		}
+		takeOver := !s.lastContact.IsZero() && time.Since(s.lastContact) > s.takeover
+		s.mu.Unlock()
+		if takeOver {
+			s.takeOver()
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			close(s.done)
+			return
+		}
+	}
+}
+
+// probe asks the primary for its serverName and rooms, and starts mirroring
+// the rooms this server has as well.
+func (s *Standby) probe() error {
+	var servers []struct {
+		Name string `json:"name"`
+		Self bool   `json:"self"`
+	}
+	if err := s.getJSON("api/servers", &servers); err != nil {
+		return err
+	}
+	var rooms []RoomInfo
+	if err := s.getJSON("api/rooms", &rooms); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lastContact = time.Now()
+	for _, srv := range servers {
+		if srv.Self {
+			s.name = srv.Name
+		}
+	}
+	var start []string
+	for _, room := range rooms {
+		if _, ok := s.rooms[room.Name]; ok {
+			continue
+		}
+		s.hub.mu.Lock()
+		exists := s.hub.roomExists(room.Name)
+		s.hub.mu.Unlock()
+		if !exists {
+			continue // Its results folder is not shared with this server
+		}
+		s.rooms[room.Name] = &mirroredRoom{}
+		start = append(start, room.Name)
+	}
+	s.mu.Unlock()
+	for _, room := range start {
+		go s.mirror(room)
+	}
+	return nil
+}
+
+func (s *Standby) getJSON(path string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, s.primary.JoinPath(path).String(), nil)
+	if err != nil {
+		return err
+	}
+	// The primary's rooms are for controllers; the standby shares its token
+	s.hub.mu.Lock()
+	token := s.hub.ControllerToken
+	s.hub.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// mirror follows room on the primary's /ws/spectate, dialing again after
+// a dropped connection, until the takeover.
+func (s *Standby) mirror(room string) {
+	u := s.primary.JoinPath("ws/spectate")
+	u.Scheme = map[string]string{"http": "ws", "https": "wss"}[u.Scheme]
+	u.RawQuery = url.Values{"room": {room}}.Encode()
+	for {
+		err := s.follow(u.String(), room)
+		select {
+		case <-s.done:
+			return
+		case <-time.After(standbyRedial):
+		}
+		slog.Debug("Standby: room stream dropped, dialing again", "room", room, "err", err)
+	}
+}
+
+// follow applies the messages of one spectator connection to room.
+func (s *Standby) follow(addr, room string) error {
+	conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-s.done
+		conn.Close()
+	}()
+	for {
+		conn.SetReadDeadline(time.Now().Add(standbyReadTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if err := s.apply(room, msg.Type, msg.Payload); err != nil {
+			slog.Debug("Standby: ignoring message", "room", room, "type", msg.Type, "err", err)
+		}
+	}
+}
+
+// apply copies one message of the primary into room, without telling
+// anyone: the standby has no displays until it takes over.
+func (s *Standby) apply(room, kind string, payload json.RawMessage) error {
+	s.mu.Lock()
+	if s.state == standbyActive {
+		s.mu.Unlock()
+		return errors.New("took over")
+	}
+	s.lastContact = time.Now()
+	s.mu.Unlock()
+	switch kind {
+	case "time_sync":
+		var ts struct {
+			ServerTime int64 `json:"serverTime"`
+		}
+		if err := json.Unmarshal(payload, &ts); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.offset = time.UnixMilli(ts.ServerTime).Sub(time.Now())
+		s.mu.Unlock()
+	case "state_sync":
+		var st struct {
+			Timer        TimerState `json:"timer"`
+			Score        ScoreState `json:"score"`
+			ActiveResult string     `json:"activeResult"`
+		}
+		if err := json.Unmarshal(payload, &st); err != nil {
+			return err
+		}
+		s.mirrorResult(room, st.ActiveResult)
+		s.mirrorScore(room, st.Score)
+		s.mirrorTimer(room, st.Timer)
+	case "set_result":
+		var res struct {
+			File string `json:"file"`
+		}
+		if err := json.Unmarshal(payload, &res); err != nil {
+			return err
+		}
+		s.mirrorResult(room, res.File)
+	case "timer_update":
+		var timer TimerState
+		if err := json.Unmarshal(payload, &timer); err != nil {
+			return err
+		}
+		s.mirrorTimer(room, timer)
+	case "score_update":
+		var score ScoreState
+		if err := json.Unmarshal(payload, &score); err != nil {
+			return err
+		}
+		s.mirrorScore(room, score)
+	}
+	return nil
+}
+
+func (s *Standby) mirrorResult(room, file string) {
+	s.hub.mu.Lock()
+	s.hub.room(room).ActiveResult = file
+	s.hub.mu.Unlock()
+}
+
+// mirrorTimer keeps the primary's timer paused at its state; takeOver()
+// starts it again if it was running.
+func (s *Standby) mirrorTimer(room string, state TimerState) {
+	s.hub.mu.Lock()
+	tm := s.hub.room(room).Timer
+	s.hub.mu.Unlock()
+	tm.mu.Lock()
+	tm.State = state
+	tm.State.Running, tm.State.EndsAt = false, 0
+	tm.mu.Unlock()
+	s.mu.Lock()
+	if m := s.rooms[room]; m != nil {
+		m.timer, m.receivedAt = state, time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// mirrorScore takes over the primary's score, and with a new sport its
+// profile, so points scored after a takeover count by the same rules.
+func (s *Standby) mirrorScore(room string, state ScoreState) {
+	profile, hasProfile := s.hub.sport(state.Sport)
+	s.hub.mu.Lock()
+	sm := s.hub.room(room).Score
+	s.hub.mu.Unlock()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if state.Sport != sm.State.Sport && hasProfile {
+		sm.profile = profile
+		sm.timer.mu.Lock()
+		sm.timer.flow, sm.timer.buzzer = profile.Periods, profile.Buzzer
+		sm.timer.mu.Unlock()
+	}
+	sm.State = state
+	sm.game = SetScore{}
+	sm.undo = nil
+	if state.Game != nil && len(state.Sets) > 0 {
+		tiebreak := sm.tiebreak(sm.profile.Scoreboard, state.Sets[len(state.Sets)-1])
+		sm.game = SetScore{Home: gamePoints(state.Game.Home, tiebreak), Away: gamePoints(state.Game.Away, tiebreak)}
+	}
+}
+
+// gamePoints turns a game score as displays show it back into points.
+func gamePoints(call string, tiebreak bool) int {
+	if tiebreak {
+		n, _ := strconv.Atoi(call)
+		return n
+	}
+	switch call {
+	case "15":
+		return 1
+	case "30":
+		return 2
+	case "40":
+		return 3
+	case "AD":
+		return 4
+	}
+	return 0
+}
+
+// takeOver runs on the timers that were running, from when the primary
+// says they end, and announces this server under the primary's name.
+func (s *Standby) takeOver() {
+	s.mu.Lock()
+	s.state = standbyActive
+	name, offset, last := s.name, s.offset, s.lastContact
+	rooms := make(map[string]mirroredRoom, len(s.rooms))
+	for room, m := range s.rooms {
+		rooms[room] = *m
+	}
+	close(s.done)
+	s.mu.Unlock()
+	slog.Warn("Standby: primary gone, taking over", "primary", s.primary.String(), "name", name, "lastContact", last)
+
+	for room, m := range rooms {
+		if !m.timer.Running {
+			continue
+		}
+		left := m.timer.TimeLeft - int(time.Since(m.receivedAt).Seconds())
+		if m.timer.EndsAt > 0 {
+			left = int(math.Ceil(time.Until(time.UnixMilli(m.timer.EndsAt).Add(-offset)).Seconds()))
+		}
+		s.hub.mu.Lock()
+		tm := s.hub.room(room).Timer
+		s.hub.mu.Unlock()
+		tm.mu.Lock()
+		tm.State.TimeLeft = max(left, 0)
+		tm.mu.Unlock()
+		tm.Start()
+	}
+	s.hub.Audit.Record(AuditEntry{Source: "standby", Action: "standby_takeover", Target: s.primary.String(), Value: name})
+	s.announce(name)
+}
+
+// registerStandbyAPI serves GET /api/standby, the StandbyStatus.
+func registerStandbyAPI(standby *Standby) {
+	http.HandleFunc("GET /api/standby", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(standby.Status())
+	})
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Start lists (IOF XML StartList, or CSV files matching csvPattern) are
+// shown as a pre-start screen: the starters of the next windowMinutes, the
+// current start minute first, moving on as the display's clock passes each
+// start time. The page does that itself, so a start list is set once, like
+// any result, and runs until the last start.
+const (
+	defaultStartWindowMinutes = 10
+	defaultStartListPattern   = "*start*.csv"
+	maxStarters               = 10000
+)
+
+// StartListOptions controls how start lists are shown (server.json
+// "startList").
+type StartListOptions struct {
+	WindowMinutes int `json:"windowMinutes" yaml:"windowMinutes" toml:"windowMinutes"` // Starters ahead that are shown; 0 = default (10)
+	// Glob (case-insensitive) of the CSV files that are start lists rather
+	// than results; default "*start*.csv", "-" for none
+	CSVPattern string `json:"csvPattern" yaml:"csvPattern" toml:"csvPattern"`
+}
+
+func (o StartListOptions) validate() error {
+	if o.WindowMinutes < 0 || o.WindowMinutes > 24*60 {
+		return errors.New("windowMinutes must be between 0 and 1440")
+	}
+	if _, err := path.Match(o.CSVPattern, ""); err != nil {
+		return fmt.Errorf("csvPattern %q: %w", o.CSVPattern, err)
+	}
+	return nil
+}
+
+// isStartListCSV reports whether the CSV file name is a start list.
+func (o StartListOptions) isStartListCSV(name string) bool {
+	pattern := o.CSVPattern
+	if pattern == "" {
+		pattern = defaultStartListPattern
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(path.Base(name)))
+	return ok
+}
+
+// starter is one entry of a start list.
+type starter struct {
+	Start time.Time `json:"-"`
+	At    int64     `json:"at"` // Start time, Unix ms, for the page
+	Name  string    `json:"name"`
+	Club  string    `json:"club,omitempty"`
+	Class string    `json:"class,omitempty"`
+	Bib   string    `json:"bib,omitempty"`
+	Logo  string    `json:"logo,omitempty"` // URL of the club's logo
+}
+
+// iofStartList covers the parts of IOF XML 3.0 and 2.0.3 start lists that
+// are shown.
+type iofStartList struct {
+	XMLName xml.Name `xml:"StartList"`
+	Event   struct {
+		Name string `xml:"Name"`
+	} `xml:"Event"`
+	ClassStarts []struct {
+		ClassName      string `xml:"Class>Name"`     // 3.0
+		ClassShortName string `xml:"ClassShortName"` // 2.0.3
+		PersonStarts   []struct {
+			Given        string `xml:"Person>Name>Given"`        // 3.0
+			Family       string `xml:"Person>Name>Family"`       // 3.0
+			OldGiven     string `xml:"Person>PersonName>Given"`  // 2.0.3
+			OldFamily    string `xml:"Person>PersonName>Family"` // 2.0.3
+			Organisation string `xml:"Organisation>Name"`        // 3.0
+			ClubName     string `xml:"Club>ShortName"`           // 2.0.3
+			StartTime    struct {
+				Text  string `xml:",chardata"` // 3.0: 2026-05-01T10:02:00+02:00
+				Clock string `xml:"Clock"`     // 2.0.3: 10:02:00
+			} `xml:"Start>StartTime"`
+			Bib string `xml:"Start>BibNumber"`
+		} `xml:"PersonStart"`
+	} `xml:"ClassStart"`
+}
+
+// isStartListXML reports whether data is an IOF XML start list.
+func isStartListXML(data []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = xmlCharsetReader
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			return el.Name.Local == "StartList"
+		}
+	}
+}
+
+// xmlCharsetReader lets encoding/xml read the Latin-1 files older
+// orienteering software writes.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+// parseIOFStartList reads an IOF XML 3.0 or 2.0.3 start list. Times without
+// a date are today's, in local time.
+func parseIOFStartList(data []byte, now time.Time) (title string, starters []starter, err error) {
+	var list iofStartList
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = xmlCharsetReader
+	if err := d.Decode(&list); err != nil {
+		return "", nil, err
+	}
+	for _, cs := range list.ClassStarts {
+		class := cs.ClassName
+		if class == "" {
+			class = cs.ClassShortName
+		}
+		for _, ps := range cs.PersonStarts {
+			given, family, club := ps.Given, ps.Family, ps.Organisation
+			if given == "" && family == "" {
+				given, family = ps.OldGiven, ps.OldFamily
+			}
+			if club == "" {
+				club = ps.ClubName
+			}
+			text := ps.StartTime.Clock
+			if text == "" {
+				text = ps.StartTime.Text
+			}
+			start, ok := parseStartTime(strings.TrimSpace(text), now)
+			if !ok {
+				continue // Vacant or not yet drawn
+			}
+			starters = append(starters, starter{Start: start, Name: strings.TrimSpace(given + " " + family), Club: club, Class: class, Bib: ps.Bib})
+		}
+	}
+	return list.Event.Name, starters, nil
+}
+
+// startListColumns are the header names of CSV start list columns, lower
+// case, in English and Swedish.
+var startListColumns = map[string][]string{
+	"start":  {"start", "start time", "starttime", "starttid", "start tid"},
+	"name":   {"name", "namn", "runner", "löpare"},
+	"given":  {"first name", "given", "firstname", "förnamn"},
+	"family": {"last name", "family", "surname", "lastname", "efternamn"},
+	"club":   {"club", "klubb", "organisation", "organization", "team"},
+	"class":  {"class", "klass"},
+	"bib":    {"bib", "number", "nr", "no", "nummerlapp", "startnummer"},
+}
+
+// parseCSVStartList reads a start list table, finding its columns by their
+// headers; the start time column is required.
+func parseCSVStartList(data []byte, opts CSVOptions, now time.Time) ([]starter, error) {
+	opts.Header = "yes"
+	t, err := parseTable(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, h := range t.Header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for field, names := range startListColumns {
+			if _, found := col[field]; !found && slices.Contains(names, h) {
+				col[field] = i
+			}
+		}
+	}
+	if _, ok := col["start"]; !ok {
+		return nil, errors.New("no start time column")
+	}
+	cell := func(row []string, field string) string {
+		if i, ok := col[field]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var starters []starter
+	for _, row := range t.Rows {
+		start, ok := parseStartTime(cell(row, "start"), now)
+		if !ok {
+			continue
+		}
+		name := cell(row, "name")
+		if name == "" {
+			name = strings.TrimSpace(cell(row, "given") + " " + cell(row, "family"))
+		}
+		starters = append(starters, starter{Start: start, Name: name, Club: cell(row, "club"), Class: cell(row, "class"), Bib: cell(row, "bib")})
+	}
+	return starters, nil
+}
+
+// parseStartTime accepts a full RFC 3339 time, a local date and time, or a
+// time of day (today).
+func parseStartTime(text string, now time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			y, m, d := now.Date()
+			return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, now.Location()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readStartList parses the start list at src, reporting ok=false (without
+// error) if the file is not one.
+func readStartList(src string, opts StartListOptions, csvOpts CSVOptions, now time.Time) (title string, starters []starter, ok bool, err error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", nil, false, err
+	}
+	if strings.EqualFold(path.Ext(src), ".xml") {
+		if !isStartListXML(data) {
+			return "", nil, false, nil
+		}
+		title, starters, err = parseIOFStartList(data, now)
+	} else {
+		if !opts.isStartListCSV(src) {
+			return "", nil, false, nil
+		}
+		starters, err = parseCSVStartList(data, csvOpts, now)
+		if err != nil {
+			return "", nil, false, nil // A results CSV after all; shown as a table
+		}
+	}
+	if err != nil {
+		return "", nil, true, err
+	}
+	if len(starters) > maxStarters {
+		starters = starters[:maxStarters]
+	}
+	slices.SortStableFunc(starters, func(a, b starter) int { return a.Start.Compare(b.Start) })
+	for i := range starters {
+		starters[i].At = starters[i].Start.UnixMilli()
+	}
+	return title, starters, true, nil
+}
+
+// startListTemplate shows the starters of the next minutes under a clock.
+var startListTemplate = template.Must(template.New("startlist").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #fff; color: #111; font-family: sans-serif; }
+header { display: flex; justify-content: space-between; align-items: baseline; padding: 1vh 2vw; background: #1e293b; color: #fff; }
+#title { font-size: 4vh; }
+#clock { font: bold 7vh monospace; }
+table { width: 100%; border-collapse: collapse; font-size: 3.2vh; }
+td { padding: 0.3em 0.6em; white-space: nowrap; }
+tr.minute td { border-top: 2px solid #94a3b8; }
+tr.next { background: #fef08a; font-weight: bold; }
+td.time { font-family: monospace; }
+img.logo { height: 1.2em; vertical-align: middle; margin-right: 0.4em; }
+#empty { padding: 4vh 2vw; font-size: 4vh; color: #64748b; }
+</style>
+</head>
+<body>
+<header><span id="title">{{.Title}}</span><span id="clock"></span></header>
+<table><tbody id="rows"></tbody></table>
+<div id="empty"></div>
+<script>
+const starters = {{.Starters}};
+const windowMs = {{.WindowMinutes}} * 60000;
+
+function hhmm(ms, seconds) {
+    const d = new Date(ms);
+    const parts = [d.getHours(), d.getMinutes()].concat(seconds ? [d.getSeconds()] : []);
+    return parts.map(n => n.toString().padStart(2, '0')).join(':');
+}
+
+// Starters from the current minute to windowMs ahead; the first start
+// minute that has not passed is highlighted
+function render() {
+    const now = Date.now();
+    document.getElementById('clock').textContent = hhmm(now, true);
+    const from = now - now % 60000;
+    const shown = starters.filter(s => s.at >= from && s.at < now + windowMs);
+    const key = shown.map(s => s.at).join(',') + '|' + from;
+    const rows = document.getElementById('rows');
+    if (rows.dataset.key === key) return;
+    rows.dataset.key = key;
+    const next = shown.length ? shown[0].at : 0;
+    rows.replaceChildren(...shown.map((s, i) => {
+        const tr = document.createElement('tr');
+        if (i > 0 && s.at !== shown[i - 1].at) tr.className = 'minute';
+        if (s.at === next) tr.classList.add('next');
+        for (const [text, cls, logo] of [[hhmm(s.at, s.at % 60000 !== 0), 'time'], [s.bib || ''], [s.name], [s.club || '', '', s.logo], [s.class || '']]) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            if (cls) td.className = cls;
+            if (logo) {
+                const img = document.createElement('img');
+                img.className = 'logo';
+                img.src = logo;
+                img.alt = '';
+                td.prepend(img);
+            }
+            tr.appendChild(td);
+        }
+        return tr;
+    }));
+    const last = starters.length ? starters[starters.length - 1].at : 0;
+    document.getElementById('empty').textContent = shown.length ? '' : (last < now ? 'All competitors have started' : 'Next start ' + hhmm(starters.find(s => s.at >= now).at, false));
+}
+render();
+setInterval(render, 1000);
+</script>
+</body>
+</html>
+`))
+
+// serveStartList renders the start list at src as the pre-start screen, with
+// the club logos found by logos and names and clubs formatted by names. It
+// reports false without writing if src is not a start list.
+func serveStartList(w http.ResponseWriter, r *http.Request, src string, opts StartListOptions, csvOpts CSVOptions, logos logoFinder, names nameFormatter) bool {
+	title, starters, ok, err := readStartList(src, opts, csvOpts, time.Now())
+	if !ok {
+		return false
+	}
+	if err != nil {
+		slog.Warn("Failed to read start list", "file", src, "err", err)
+		http.Error(w, "Failed to read start list: "+err.Error(), http.StatusUnprocessableEntity)
+		return true
+	}
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(r.URL.Path), path.Ext(r.URL.Path))
+	}
+	window := opts.WindowMinutes
+	if window == 0 {
+		window = defaultStartWindowMinutes
+	}
+	for i := range starters {
+		starters[i].Logo = logos(starters[i].Club)
+		starters[i].Name, starters[i].Club = names.Name(starters[i].Name), names.Club(starters[i].Club)
+	}
+	list, err := json.Marshal(starters)
+	if err != nil {
+		slog.Error("Error marshaling start list", "err", err)
+		http.Error(w, "Failed to render start list", http.StatusInternalServerError)
+		return true
+	}
+	view := struct {
+		Title         string
+		Starters      template.JS
+		WindowMinutes int
+	}{Title: title, Starters: template.JS(list), WindowMinutes: window}
+
+	var buf bytes.Buffer
+	if err := startListTemplate.Execute(&buf, view); err != nil {
+		slog.Error("Error rendering start list", "err", err)
+		http.Error(w, "Failed to render start list", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+	return true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultTableRowsPerPage = 25
+	defaultTablePageSeconds = 10
+	maxTableRows            = 5000 // A results screen, not a spreadsheet
+)
+
+// errNoDelimiter means no candidate delimiter splits the file into columns.
+var errNoDelimiter = errors.New("no delimiter found")
+
+// tableDelimiters are tried, in order, when no delimiter is configured.
+var tableDelimiters = []rune{';', ',', '\t', '|'}
+
+// CSVOptions controls how .csv results (and, with Txt, delimited .txt
+// results) are rendered as HTML tables.
+type CSVOptions struct {
+	Delimiter   string `json:"delimiter" yaml:"delimiter" toml:"delimiter"`       // ",", ";", "\t" or "|"; empty = detect
+	Header      string `json:"header" yaml:"header" toml:"header"`                // auto (default), yes or no
+	RowsPerPage int    `json:"rowsPerPage" yaml:"rowsPerPage" toml:"rowsPerPage"` // 0 = default (25)
+	PageSeconds int    `json:"pageSeconds" yaml:"pageSeconds" toml:"pageSeconds"` // 0 = default (10)
+	Txt         bool   `json:"txt" yaml:"txt" toml:"txt"`                         // Also render .txt files that parse as a table
+}
+
+func (o CSVOptions) validate() error {
+	if o.Delimiter != "" && (utf8.RuneCountInString(o.Delimiter) != 1 || o.Delimiter == "\"" || o.Delimiter == "\n" || o.Delimiter == "\r") {
+		return fmt.Errorf("delimiter %q must be a single character", o.Delimiter)
+	}
+	switch o.Header {
+	case "", "auto", "yes", "no":
+	default:
+		return fmt.Errorf("header %q must be auto, yes or no", o.Header)
+	}
+	if o.RowsPerPage < 0 || o.PageSeconds < 0 {
+		return errors.New("rowsPerPage and pageSeconds must not be negative")
+	}
+	return nil
+}
+
+// resultTable is a parsed delimited file.
+type resultTable struct {
+	Header []string
+	Rows   [][]string
+}
+
+// parseTable reads data as a delimited table. Files that are not valid UTF-8
+// are taken to be Latin-1, like plain text results.
+func parseTable(data []byte, opts CSVOptions) (*resultTable, error) {
+	text := decodeLatin1(data)
+	delim, _ := utf8.DecodeRuneInString(opts.Delimiter)
+	if opts.Delimiter == "" {
+		delim = detectDelimiter(text)
+		if delim == 0 {
+			return nil, errNoDelimiter
+		}
+	}
+
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	r.Comma = delim
+	r.FieldsPerRecord = -1 // Exports often have ragged rows
+	r.LazyQuotes = true
+	var rows [][]string
+	for len(rows) < maxTableRows {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		rows = append(rows, rec)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty table")
+	}
+
+	t := &resultTable{Rows: rows}
+	if opts.Header == "yes" || (opts.Header != "no" && looksLikeHeader(rows)) {
+		t.Header, t.Rows = rows[0], rows[1:]
+	}
+	return t, nil
+}
+
+// decodeLatin1 returns data as a string, converting from Latin-1 unless it is
+// already valid UTF-8.
+func decodeLatin1(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// detectDelimiter picks the candidate that splits the first records into the
+// same number (at least two) of fields, preferring the most fields. Quoted
+// fields are honoured, so "Ek; Bo" does not count. It returns 0 if none fits.
+func detectDelimiter(text string) rune {
+	var best rune
+	bestCols := 1
+	for _, d := range tableDelimiters {
+		r := csv.NewReader(strings.NewReader(text))
+		r.Comma = d
+		r.FieldsPerRecord = 0 // Error as soon as the field count changes
+		r.LazyQuotes = true
+		cols := 0
+		for i := 0; i < 5; i++ {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cols = 0
+				break
+			}
+			cols = len(rec)
+		}
+		if cols > bestCols {
+			best, bestCols = d, cols
+		}
+	}
+	return best
+}
+
+// looksLikeHeader reports whether the first row is a header: none of its
+// cells start with a digit while a cell of the second row does (times,
+// places, points).
+func looksLikeHeader(rows [][]string) bool {
+	if len(rows) < 2 {
+		return false
+	}
+	startsWithDigit := func(s string) bool {
+		s = strings.TrimSpace(s)
+		return s != "" && s[0] >= '0' && s[0] <= '9'
+	}
+	for _, cell := range rows[0] {
+		if startsWithDigit(cell) {
+			return false
+		}
+	}
+	for _, cell := range rows[1] {
+		if startsWithDigit(cell) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableCell is a cell of a rendered table, with the URL of the logo shown
+// before its text if it has one.
+type tableCell struct {
+	Text string
+	Logo string
+}
+
+// tableTemplate shows a table a page of rows at a time, repeating the header.
+var tableTemplate = template.Must(template.New("table").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; background: #fff; color: #111; font-family: sans-serif; }
+table { width: 100%; border-collapse: collapse; font-size: 2.2vh; }
+th, td { padding: 0.4em 0.6em; text-align: left; white-space: nowrap; }
+img.logo { height: 1.2em; vertical-align: middle; margin-right: 0.4em; }
+th { background: #1e293b; color: #fff; }
+tbody tr:nth-child(even) { background: #f1f5f9; }
+tbody { display: none; }
+tbody.active { display: table-row-group; }
+#pageNo { position: fixed; right: 12px; bottom: 8px; font: 14px sans-serif; color: #666; }
+</style>
+</head>
+<body>
+<table>
+{{if .Header}}<thead><tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr></thead>
+{{end}}{{range $i, $page := .Pages}}<tbody{{if eq $i 0}} class="active"{{end}}>
+{{range $page}}<tr>{{range .}}<td>{{if .Logo}}<img class="logo" src="{{.Logo}}" alt="">{{end}}{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+{{end}}</table>
+{{if gt (len .Pages) 1}}<div id="pageNo">1 / {{len .Pages}}</div>
+<script>
+const pages = document.querySelectorAll("tbody");
+let current = 0;
+setInterval(() => {
+    pages[current].classList.remove("active");
+    current = (current + 1) % pages.length;
+    pages[current].classList.add("active");
+    document.getElementById("pageNo").textContent = (current + 1) + " / " + pages.length;
+}, {{.Seconds}} * 1000);
+</script>
+{{end}}</body>
+</html>
+`))
+
+// serveTable renders the delimited file at src as an HTML table, with the
+// logos found by logos in its club or country column and its names and
+// clubs formatted by names. For .txt files that do not parse as a table it
+// reports false without writing, so they can be served as plain text.
+func serveTable(w http.ResponseWriter, r *http.Request, src string, opts CSVOptions, logos logoFinder, names nameFormatter) bool {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		http.NotFound(w, r)
+		return true
+	}
+	isTxt := strings.EqualFold(path.Ext(src), ".txt")
+	t, err := parseTable(data, opts)
+	if errors.Is(err, errNoDelimiter) && !isTxt {
+		opts.Delimiter = "," // A single column is still a table
+		t, err = parseTable(data, opts)
+	}
+	if err != nil {
+		if isTxt {
+			return false
+		}
+		slog.Warn("Failed to read CSV result", "file", src, "err", err)
+		http.Error(w, "Failed to read table: "+err.Error(), http.StatusUnprocessableEntity)
+		return true
+	}
+
+	perPage := opts.RowsPerPage
+	if perPage == 0 {
+		perPage = defaultTableRowsPerPage
+	}
+	seconds := opts.PageSeconds
+	if seconds == 0 {
+		seconds = defaultTablePageSeconds
+	}
+	view := struct {
+		Title   string
+		Header  []string
+		Pages   [][][]tableCell
+		Seconds int
+	}{Title: path.Base(r.URL.Path), Header: t.Header, Seconds: seconds}
+	logoCol := logoColumn(t.Header)
+	format := names.formatColumns(t.Header)
+	rows := make([][]tableCell, len(t.Rows))
+	for i, row := range t.Rows {
+		rows[i] = make([]tableCell, len(row))
+		for j, text := range row {
+			rows[i][j].Text = format(j, text)
+			if j == logoCol {
+				rows[i][j].Logo = logos(text)
+			}
+		}
+	}
+	for start := 0; start < len(rows); start += perPage {
+		view.Pages = append(view.Pages, rows[start:min(start+perPage, len(rows))])
+	}
+	if len(view.Pages) == 0 {
+		view.Pages = [][][]tableCell{nil} // Header only
+	}
+
+	var buf bytes.Buffer
+	if err := tableTemplate.Execute(&buf, view); err != nil {
+		slog.Error("Error rendering table", "err", err)
+		http.Error(w, "Failed to render table", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+	return true
+}
@@ -1,28 +1,48 @@
 package main
 
 import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
 	"sync"
 	"time"
+
+	"display/internal/protocol"
 )
 
-type TimerState struct {
-	Running   bool `json:"running"`
-	TimeLeft  int  `json:"timeLeft"`
-	TotalTime int  `json:"totalTime"`
-}
+// A running timer is broadcast when it starts, pauses, is reset or runs out,
+// and otherwise only every timerKeepalive seconds: clients from
+// interpolatingProtocol on count down to endsAt themselves (time_sync).
+// Older clients still get every second.
+const (
+	timerKeepalive        = 10
+	interpolatingProtocol = protocol.TimeSyncVersion
+)
+
+// TimerState is a room's timer as clients see it (internal/protocol). Its
+// penalties are run by penalty.go, its periods by match.go and its clock
+// direction comes from the room's sport profile (sports.go).
+type TimerState = protocol.TimerState
 
 type TimerManager struct {
 	Hub              *Hub
+	Room             string // Updates go to this room only
 	State            TimerState
-	ticker           *time.Ticker
+	clock            Clock
+	ticker           Ticker
 	stopChan         chan bool
 	mu               sync.Mutex
 	goroutineRunning bool
+	nextPenalty      int         // Last Penalty.ID handed out
+	flow             *MatchFlow  // The sport profile's periods, instead of Hub.MatchFlow
+	buzzer           BuzzerRules // The sport profile's
 }
 
-func NewTimerManager(hub *Hub) *TimerManager {
+func NewTimerManager(hub *Hub, room string, clock Clock) *TimerManager {
 	return &TimerManager{
 		Hub:              hub,
+		Room:             room,
+		clock:            clock,
 		stopChan:         make(chan bool, 1),
 		State:            TimerState{Running: false, TimeLeft: 0},
 		goroutineRunning: false,
@@ -42,6 +62,7 @@ func (tm *TimerManager) Start() {
 	}
 
 	tm.State.Running = true
+	tm.State.EndsAt = tm.clock.Now().Add(time.Duration(tm.State.TimeLeft) * time.Second).UnixMilli()
 	tm.goroutineRunning = true
 
 	// Drain any stale stop signal from a previous round
@@ -50,27 +71,50 @@ func (tm *TimerManager) Start() {
 	default:
 	}
 
-	tm.ticker = time.NewTicker(1 * time.Second)
+	tm.ticker = tm.clock.NewTicker(1 * time.Second)
+	// Pause clears tm.ticker, so the goroutine keeps its own reference
+	ticker := tm.ticker
 
 	tm.broadcastState()
+	tm.Hub.History.RecordEvent(tm.Room, "timer_start", "", strconv.Itoa(tm.State.TimeLeft), "")
+	tm.Hub.Speaker.Timer(tm.Room, "start", tm.State.TimeLeft)
 
 	go func() {
+		ranOut := false
 		defer func() {
 			tm.mu.Lock()
 			tm.goroutineRunning = false
 			tm.mu.Unlock()
+			if ranOut {
+				tm.clockRanOut()
+			}
 		}()
 
 		for {
 			select {
-			case <-tm.ticker.C:
+			case <-ticker.C():
 				tm.mu.Lock()
 				if tm.State.TimeLeft > 0 {
 					tm.State.TimeLeft--
-					tm.broadcastState()
+					expired := !tm.State.Break && tm.tickPenalties()
+					tm.broadcastTick(expired)
+					if expired && tm.buzzer.PenaltyEnd {
+						tm.soundBuzzer("penalty_end")
+					}
+					if tm.State.TimeLeft == 60 && tm.State.TotalTime > 60 {
+						tm.Hub.Speaker.Timer(tm.Room, "one_minute", 60)
+					}
+					if tm.State.TimeLeft == 0 {
+						tm.Hub.History.RecordEvent(tm.Room, "timer_finished", "", strconv.Itoa(tm.State.TotalTime), "")
+						tm.Hub.Speaker.Timer(tm.Room, "end", 0)
+						if tm.buzzer.ClockEnd {
+							tm.soundBuzzer("clock_end")
+						}
+					}
 				} else {
 					tm.mu.Unlock()
 					tm.Pause()
+					ranOut = true
 					return
 				}
 				tm.mu.Unlock()
@@ -88,6 +132,7 @@ func (tm *TimerManager) Pause() {
 
 	if tm.State.Running {
 		tm.State.Running = false
+		tm.State.EndsAt = 0
 		if tm.ticker != nil {
 			tm.ticker.Stop()
 			tm.ticker = nil
@@ -98,6 +143,10 @@ func (tm *TimerManager) Pause() {
 		default:
 		}
 		tm.broadcastState()
+		if tm.State.TimeLeft > 0 { // Running out is recorded as timer_finished
+			tm.Hub.History.RecordEvent(tm.Room, "timer_pause", "", strconv.Itoa(tm.State.TimeLeft), "")
+			tm.Hub.Speaker.Timer(tm.Room, "pause", tm.State.TimeLeft)
+		}
 	}
 }
 
@@ -109,14 +158,36 @@ func (tm *TimerManager) Reset(seconds int) {
 	tm.State.TotalTime = seconds
 	tm.State.TimeLeft = seconds
 	tm.broadcastState()
+	tm.Hub.History.RecordEvent(tm.Room, "timer_reset", "", strconv.Itoa(seconds), "")
+}
+
+// broadcastTick sends the state after a second of a running timer went by;
+// changed is set when a penalty ran out.
+func (tm *TimerManager) broadcastTick(changed bool) {
+	if changed || tm.State.TimeLeft == 0 || tm.State.TimeLeft%timerKeepalive == 0 {
+		tm.broadcastState()
+		return
+	}
+	tm.broadcastTo(interpolatingProtocol)
 }
 
 func (tm *TimerManager) broadcastState() {
-	tm.Hub.BroadcastJSON(struct {
+	tm.broadcastTo(0)
+}
+
+// broadcastTo sends the state to the room's clients reporting a protocol
+// before beforeProtocol, or to all of them if it is 0.
+func (tm *TimerManager) broadcastTo(beforeProtocol int) {
+	data, err := json.Marshal(struct {
 		Type    string     `json:"type"`
 		Payload TimerState `json:"payload"`
 	}{
 		Type:    "timer_update",
 		Payload: tm.State,
 	})
+	if err != nil {
+		slog.Error("Error marshaling timer state", "err", err)
+		return
+	}
+	tm.Hub.RoomBroadcast <- roomMessage{Room: tm.Room, Msg: data, BeforeProtocol: beforeProtocol}
 }
\ No newline at end of file
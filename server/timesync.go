@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"display/internal/protocol"
+)
+
+// timeSyncInterval is how often every client gets the server's clock. With
+// the offset to their own clock, clients count a running timer down to its
+// endsAt between timer updates; the interval only has to catch clock drift.
+const timeSyncInterval = 30 * time.Second
+
+type timeSync struct {
+	Type    string            `json:"type"` // Always "time_sync"
+	Payload protocol.TimeSync `json:"payload"`
+}
+
+func newTimeSync(now time.Time) timeSync {
+	msg := timeSync{Type: "time_sync"}
+	msg.Payload.ServerTime = now.UnixMilli()
+	return msg
+}
+
+// RunTimeSync broadcasts the server's clock every timeSyncInterval until
+// stop is closed. Clients also get it when they join a room (joinMessages).
+func (h *Hub) RunTimeSync(stop <-chan struct{}) {
+	ticker := time.NewTicker(timeSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			data, err := json.Marshal(newTimeSync(time.Now()))
+			if err != nil {
+				slog.Error("Error marshaling time_sync message", "err", err)
+				continue
+			}
+			h.Broadcast <- data
+			h.broadcastSpectators(data, "", true)
+		}
+	}
+}
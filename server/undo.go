@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Operators' content switches (set_result, and display modes set with
+// client_command) are kept per room so a wrong switch during a prize-giving
+// can be taken back at once: undo shows what was shown before, redo applies
+// the switch again. A new switch clears what could be redone. Results switched
+// by followNewest are not recorded.
+const maxUndoSwitches = 50
+
+// ContentSwitch is one recorded switch.
+type ContentSwitch struct {
+	Kind   string    `json:"kind"`             // "result" or "display_mode"
+	Target string    `json:"target,omitempty"` // Client ID of a display_mode switch
+	Before string    `json:"before"`
+	After  string    `json:"after"`
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+}
+
+// UndoState is the answer of GET /api/undo, newest first.
+type UndoState struct {
+	Undo []ContentSwitch `json:"undo"`
+	Redo []ContentSwitch `json:"redo"`
+}
+
+// switchHistory holds a room's undo and redo stacks, protected by h.mu.
+type switchHistory struct {
+	undo, redo []ContentSwitch
+}
+
+func (s *switchHistory) record(sw ContentSwitch) {
+	s.undo = append(s.undo, sw)
+	if len(s.undo) > maxUndoSwitches {
+		s.undo = s.undo[len(s.undo)-maxUndoSwitches:]
+	}
+	s.redo = nil
+}
+
+// recordResultSwitch records that an operator switches room to file. There
+// is nothing to go back to while no result was shown. Caller holds h.mu.
+func (h *Hub) recordResultSwitch(room, file, actor string) {
+	r := h.room(room)
+	if r.ActiveResult == "" || r.ActiveResult == file {
+		return
+	}
+	r.switches.record(ContentSwitch{Kind: "result", Before: r.ActiveResult, After: file, Actor: actor, At: time.Now()})
+}
+
+// recordModeSwitch records that origin (nil for the API) sets client's
+// display mode. Caller holds h.mu.
+func (h *Hub) recordModeSwitch(client *Client, mode string, origin *Client) {
+	before := client.DisplayMode
+	if before == "" {
+		before = "show_result"
+	}
+	if before == mode {
+		return
+	}
+	actor := "api"
+	if origin != nil {
+		actor = origin.Name
+	}
+	h.room(client.Room).switches.record(ContentSwitch{Kind: "display_mode", Target: client.ID, Before: before, After: mode, Actor: actor, At: time.Now()})
+}
+
+// Undo reverts the latest switch in room and returns it.
+func (h *Hub) Undo(room, actor string) (ContentSwitch, error) {
+	return h.unwind(room, actor, false)
+}
+
+// Redo applies the latest undone switch in room again and returns it.
+func (h *Hub) Redo(room, actor string) (ContentSwitch, error) {
+	return h.unwind(room, actor, true)
+}
+
+// unwind moves the top switch from one stack of room to the other and shows
+// its Before (undo) or After (redo). A display that has left since is skipped
+// with an error; the switch is dropped, so the next call goes on with the one
+// before it.
+func (h *Hub) unwind(room, actor string, redo bool) (ContentSwitch, error) {
+	h.mu.Lock()
+	s := &h.room(room).switches
+	from, to := &s.undo, &s.redo
+	if redo {
+		from, to = to, from
+	}
+	if len(*from) == 0 {
+		h.mu.Unlock()
+		if redo {
+			return ContentSwitch{}, errors.New("nothing to redo")
+		}
+		return ContentSwitch{}, errors.New("nothing to undo")
+	}
+	sw := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	h.mu.Unlock()
+
+	value, done := sw.Before, "undone"
+	if redo {
+		value, done = sw.After, "redone"
+	}
+	if sw.Kind == "result" {
+		h.switchResult(room, value, actor, "")
+	} else if !h.clientCommand(sw.Target, value, "", nil, "", false) {
+		return sw, errors.New(sw.Target + " is no longer connected")
+	}
+	slog.Info("Content switch "+done, "room", room, "kind", sw.Kind, "target", sw.Target, "to", value, "by", actor)
+
+	h.mu.Lock()
+	*to = append(*to, sw)
+	h.mu.Unlock()
+	return sw, nil
+}
+
+// UndoState returns room's undo and redo stacks.
+func (h *Hub) UndoState(room string) UndoState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.room(room).switches
+	state := UndoState{Undo: make([]ContentSwitch, 0, len(s.undo)), Redo: make([]ContentSwitch, 0, len(s.redo))}
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		state.Undo = append(state.Undo, s.undo[i])
+	}
+	for i := len(s.redo) - 1; i >= 0; i-- {
+		state.Redo = append(state.Redo, s.redo[i])
+	}
+	return state
+}
+
+func registerUndoAPI(hub *Hub) {
+	// GET /api/undo?room= -> UndoState
+	http.HandleFunc("GET /api/undo", func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if err := hub.checkRoom(room); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.UndoState(room))
+	})
+
+	// POST /api/undo and /api/redo {"room": ""} -> the ContentSwitch reverted or applied again
+	for _, action := range []string{"undo", "redo"} {
+		http.HandleFunc("POST /api/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if !requirePost(w, r) || !requireController(hub, w, r) {
+				return
+			}
+			var payload struct {
+				Room string `json:"room"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid body", http.StatusBadRequest)
+				return
+			}
+			if err := hub.checkRoom(payload.Room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			step := hub.Undo
+			if action == "redo" {
+				step = hub.Redo
+			}
+			sw, err := step(payload.Room, "api")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			hub.Audit.Record(apiAudit(r, payload.Room, action, sw.Target, sw.Kind))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sw)
+		})
+	}
+}
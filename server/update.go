@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client builds are laid out as <updatesDir>/<os>/<arch>/ containing:
+//
+//	client      the binary (client.exe on Windows is also accepted)
+//	VERSION     the version string the binary was built with
+//	client.sig  optional base64 ed25519 signature (score-displayctl update sign)
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+type updateManifest struct {
+	Version   string `json:"version"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Signature string `json:"signature,omitempty"`
+	URL       string `json:"url"`
+}
+
+// checksumCache avoids re-hashing a binary on every client poll.
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedChecksum
+}
+
+type cachedChecksum struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+func (c *checksumCache) sum(path string, info os.FileInfo) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[path]; ok && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+		return e.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	c.entries[path] = cachedChecksum{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	return sum, nil
+}
+
+// clientBinary returns the path of the client build for a platform.
+func clientBinary(dir string) (string, os.FileInfo, error) {
+	var lastErr error
+	for _, name := range []string{"client", "client.exe"} {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, info, nil
+		}
+		lastErr = err
+	}
+	return "", nil, lastErr
+}
+
+// registerUpdateAPI serves client builds so display clients can update
+// themselves from the server they are connected to.
+func registerUpdateAPI(cfgMgr *ConfigManager) {
+	cache := &checksumCache{entries: make(map[string]cachedChecksum)}
+
+	// GET /api/update/{os}/{arch}         -> manifest
+	// GET /api/update/{os}/{arch}/binary  -> client binary
+	http.HandleFunc("/api/update/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/update/"), "/")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "binary") ||
+			!platformPattern.MatchString(parts[0]) || !platformPattern.MatchString(parts[1]) {
+			http.NotFound(w, r)
+			return
+		}
+		goos, goarch := parts[0], parts[1]
+
+		dir := filepath.Join(cfgMgr.Current().UpdatesDir, goos, goarch)
+		path, info, err := clientBinary(dir)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if len(parts) == 3 {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			http.ServeFile(w, r, path)
+			return
+		}
+
+		versionData, err := os.ReadFile(filepath.Join(dir, "VERSION"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		sum, err := cache.sum(path, info)
+		if err != nil {
+			http.Error(w, "Unable to read client build", http.StatusInternalServerError)
+			return
+		}
+		signature, _ := os.ReadFile(filepath.Join(dir, "client.sig"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updateManifest{
+			Version:   strings.TrimSpace(string(versionData)),
+			SHA256:    sum,
+			Size:      info.Size(),
+			Signature: strings.TrimSpace(string(signature)),
+			URL:       "/api/update/" + goos + "/" + goarch + "/binary",
+		})
+	})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Limits for control messages, shared by readPump and the HTTP API.
+const (
+	maxTimerSeconds   = 24 * 60 * 60
+	maxResultFileLen  = 255
+	maxClientNameLen  = 64
+	minZoom, maxZoom  = 50, 300
+	maxClientIDLength = 128
+	maxServerNameLen  = 63 // One DNS label, since it is the mDNS instance name
+	maxCompetitionLen = 100
+)
+
+// clientCommands are the commands ClientCommand understands.
+var clientCommands = map[string]bool{
+	"rename":         true,
+	"show_timer":     true,
+	"show_result":    true,
+	"show_splits":    true,
+	"theme_dark":     true,
+	"theme_light":    true,
+	"set_zoom":       true,
+	"set_rotation":   true,
+	"screen_power":   true,
+	"set_brightness": true,
+	"set_volume":     true,
+	"switch_server":  true,
+	"reload":         true,
+	"clear_cache":    true,
+	"identify":       true,
+	"set_wifi":       true, // Value: WifiCredentials as JSON (wifi.go)
+	"kick":           true,
+	"ban":            true, // Value "ip" bans the address too
+}
+
+// displayModes are the modes a display can be put in; the other client
+// commands are actions, not state to keep.
+var displayModes = map[string]bool{
+	"show_timer":  true,
+	"show_result": true,
+	"show_splits": true,
+}
+
+// validateDisplayState checks a display mode and screen power kept to be
+// applied later (backups, scenes); empty values are left alone.
+func validateDisplayState(mode, power string) error {
+	if mode != "" && !displayModes[mode] {
+		return fmt.Errorf("display mode %q must be show_timer, show_result or show_splits", mode)
+	}
+	if power != "" && power != "on" && power != "off" {
+		return fmt.Errorf("screen power %q must be on or off", power)
+	}
+	return nil
+}
+
+// validateScene checks a scene from outside the store (a restored backup):
+// its name, room, result and what it sets on each display.
+func validateScene(sc Scene) error {
+	if err := validateSceneName(sc.Name); err != nil {
+		return err
+	}
+	if err := validateRoomName(sc.Room); err != nil {
+		return fmt.Errorf("scene %q: %v", sc.Name, err)
+	}
+	if sc.Result != "" {
+		if err := validateResultFile(sc.Result); err != nil {
+			return fmt.Errorf("scene %q: %v", sc.Name, err)
+		}
+	}
+	for id, d := range sc.Displays {
+		if err := validateDisplayState(d.Mode, d.ScreenPower); err != nil {
+			return fmt.Errorf("scene %q, display %s: %v", sc.Name, id, err)
+		}
+		if d.Theme != "" && d.Theme != "dark" && d.Theme != "light" {
+			return fmt.Errorf("scene %q, display %s: theme %q must be dark or light", sc.Name, id, d.Theme)
+		}
+	}
+	return nil
+}
+
+func validateTimerControl(action string, seconds int) error {
+	switch action {
+	case "start", "pause", "next_period", "new_match":
+		return nil
+	case "reset":
+		if seconds <= 0 || seconds > maxTimerSeconds {
+			return fmt.Errorf("seconds must be between 1 and %d", maxTimerSeconds)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown timer action %q", action)
+}
+
+func validatePenaltyControl(p penaltyControl) error {
+	switch p.Action {
+	case "add":
+		if p.Team != "home" && p.Team != "away" {
+			return errors.New("team must be home or away")
+		}
+		if len(p.Player) > maxPlayerLen || strings.ContainsFunc(p.Player, unicode.IsControl) {
+			return fmt.Errorf("player must be at most %d characters", maxPlayerLen)
+		}
+		if p.Seconds < 0 || p.Seconds > maxPenaltySeconds {
+			return fmt.Errorf("seconds must be between 1 and %d", maxPenaltySeconds)
+		}
+	case "remove":
+		if p.ID <= 0 {
+			return errors.New("id must be a penalty ID")
+		}
+	case "clear":
+	default:
+		return fmt.Errorf("unknown penalty action %q", p.Action)
+	}
+	return nil
+}
+
+func validateScoreControl(c scoreControl) error {
+	switch c.Action {
+	case "point", "serve":
+		if c.Team != "home" && c.Team != "away" {
+			return errors.New("team must be home or away")
+		}
+	case "teams":
+		for _, name := range []string{c.Home, c.Away} {
+			if len(name) > maxTeamNameLen || strings.ContainsFunc(name, unicode.IsControl) {
+				return fmt.Errorf("team names must be at most %d characters", maxTeamNameLen)
+			}
+		}
+	case "sport":
+		if c.Sport != "" && !sportNamePattern.MatchString(c.Sport) {
+			return fmt.Errorf("unknown sport %q", c.Sport)
+		}
+	case "undo", "reset":
+	default:
+		return fmt.Errorf("unknown score action %q", c.Action)
+	}
+	return nil
+}
+
+// validatePassing checks a passing pushed to /api/splits.
+func validatePassing(p Passing) error {
+	switch {
+	case p.Control == "":
+		return errors.New("control is required")
+	case strings.TrimSpace(p.Name) == "" && p.Bib == "":
+		return errors.New("name or bib is required")
+	case p.Time <= 0 || p.Time > maxSplitMillis:
+		return errors.New("time must be between 0 and 24 hours")
+	}
+	for _, field := range []string{p.Control, p.Class, p.Bib, p.Name, p.Club} {
+		if len(field) > maxSplitFieldLen || strings.ContainsFunc(field, unicode.IsControl) {
+			return fmt.Errorf("control, class, bib, name and club must be at most %d characters", maxSplitFieldLen)
+		}
+	}
+	return nil
+}
+
+func validateSplitView(v SplitView) error {
+	if len(v.Control) > maxSplitFieldLen || len(v.Class) > maxSplitFieldLen || strings.ContainsFunc(v.Control+v.Class, unicode.IsControl) {
+		return fmt.Errorf("control and class must be at most %d characters", maxSplitFieldLen)
+	}
+	if v.Top < 0 || v.Top > maxSplitsTop {
+		return fmt.Errorf("top must be between 1 and %d", maxSplitsTop)
+	}
+	return nil
+}
+
+// validateResultFile accepts a path relative to the results folder, as listed
+// by /api/files. The /results/ handler cleans paths as well; this rejects
+// nonsense before it is broadcast to every display.
+func validateResultFile(file string) error {
+	switch {
+	case file == "":
+		return errors.New("file is required")
+	case len(file) > maxResultFileLen:
+		return fmt.Errorf("file name longer than %d bytes", maxResultFileLen)
+	case strings.ContainsFunc(file, unicode.IsControl), strings.Contains(file, `\`):
+		return errors.New("file name contains invalid characters")
+	case strings.HasPrefix(file, "/") || path.Clean(file) != file || file == "." || file == ".." || strings.HasPrefix(file, "../"):
+		return errors.New("file must be a plain path inside the results folder")
+	}
+	return nil
+}
+
+func validateClientCommand(target, command, value string) error {
+	if target == "" || len(target) > maxClientIDLength {
+		return errors.New("target must be a client ID")
+	}
+	if !clientCommands[command] {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	switch command {
+	case "rename":
+		name := strings.TrimSpace(value)
+		if name == "" || len(name) > maxClientNameLen || strings.ContainsFunc(name, unicode.IsControl) {
+			return fmt.Errorf("name must be 1 to %d characters", maxClientNameLen)
+		}
+	case "set_zoom":
+		if z, err := strconv.Atoi(value); err != nil || z < minZoom || z > maxZoom {
+			return fmt.Errorf("zoom must be between %d and %d", minZoom, maxZoom)
+		}
+	case "set_rotation":
+		if r, err := strconv.Atoi(value); err != nil || !validRotation(r) {
+			return errors.New("rotation must be 0, 90, 180 or 270")
+		}
+	case "screen_power":
+		if value != "on" && value != "off" {
+			return errors.New("screen power must be on or off")
+		}
+	case "set_brightness", "set_volume":
+		if p, err := strconv.Atoi(value); err != nil || p < 0 || p > 100 {
+			return errors.New(strings.TrimPrefix(command, "set_") + " must be between 0 and 100")
+		}
+	case "switch_server":
+		if err := validateServerName(value); err != nil {
+			return err
+		}
+	case "set_wifi":
+		if _, err := parseWifi(value); err != nil {
+			return err
+		}
+	case "ban":
+		if value != "" && value != "ip" {
+			return errors.New(`ban value must be empty or "ip"`)
+		}
+	}
+	return nil
+}
+
+// validateOperatorLock checks an operator_lock; areas are short words like
+// "result".
+func validateOperatorLock(l operatorLock) error {
+	switch l.Action {
+	case "take":
+		if l.Area == "" || len(l.Area) > maxLockAreaLen || strings.ContainsFunc(l.Area, func(r rune) bool { return r <= ' ' }) {
+			return errors.New("invalid lock area")
+		}
+	case "release":
+	default:
+		return errors.New("unknown lock action")
+	}
+	return nil
+}
+
+// validateSceneName checks the name a scene is saved under.
+func validateSceneName(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > maxSceneName || strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Errorf("scene name must be 1 to %d characters", maxSceneName)
+	}
+	return nil
+}
+
+// validateServerName checks a serverName, which displays match when they
+// choose between servers.
+func validateServerName(name string) error {
+	if name == "" || len(name) > maxServerNameLen || strings.ContainsFunc(name, unicode.IsControl) || strings.Contains(name, ".") {
+		return fmt.Errorf("server name must be 1 to %d characters without dots", maxServerNameLen)
+	}
+	return nil
+}
+
+// validateCompetitionName checks competitionName, which goes into the mDNS
+// TXT record (at most 255 bytes per entry); empty is allowed.
+func validateCompetitionName(name string) error {
+	if len(name) > maxCompetitionLen || strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Errorf("competition name must be at most %d characters", maxCompetitionLen)
+	}
+	return nil
+}
+
+// validRotation reports whether degrees is a screen rotation displays support.
+func validRotation(degrees int) bool {
+	return degrees == 0 || degrees == 90 || degrees == 180 || degrees == 270
+}
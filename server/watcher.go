@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// resultsPollInterval is how often the results folders are scanned.
+	// Polling rather than file system events works on network shares too.
+	resultsPollInterval = 3 * time.Second
+	// maxListBackoff caps the wait between listings of a folder that keeps
+	// failing, e.g. the timing PC's share while its mount is down.
+	maxListBackoff = time.Minute
+)
+
+// followExts are the result files a display can show.
+var followExts = parseExts("html,htm,txt,csv,pdf")
+
+// ResultsWatcher switches rooms that follow the newest result file
+// (followNewest) to each new or updated file, for events that run without an
+// operator.
+type ResultsWatcher struct {
+	Hub       *Hub
+	Config    *ConfigManager
+	seen      map[string]ResultFile   // Newest matching file per room at the last poll
+	failed    map[string]*listFailure // Rooms whose listing fails
+	aliasDown map[string]bool         // Aliases the main room could not list, to log once
+	pending   map[string]pendingFile  // Newest files not shown yet, by name (stable.go)
+}
+
+// listFailure is a room whose folder cannot be listed: it is retried with a
+// backoff instead of every poll, and logged when it fails first and when it
+// comes back.
+type listFailure struct {
+	failures int
+	retryAt  time.Time
+}
+
+func NewResultsWatcher(hub *Hub, cfg *ConfigManager) *ResultsWatcher {
+	return &ResultsWatcher{Hub: hub, Config: cfg, seen: make(map[string]ResultFile), failed: make(map[string]*listFailure), aliasDown: make(map[string]bool), pending: make(map[string]pendingFile)}
+}
+
+// Run polls until stop is closed.
+func (rw *ResultsWatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(resultsPollInterval)
+	defer ticker.Stop()
+	for {
+		rw.poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rw *ResultsWatcher) poll() {
+	settings := rw.Config.Current()
+	for room := range rw.seen {
+		if _, ok := settings.FollowNewest[room]; !ok {
+			delete(rw.seen, room) // Start over if following is turned on again
+		}
+	}
+	now := time.Now()
+	candidates := make(map[string]bool)
+	for room, pattern := range settings.FollowNewest {
+		if f := rw.failed[room]; f != nil && now.Before(f.retryAt) {
+			continue
+		}
+		if rw.Hub.checkRoom(room) != nil {
+			continue // Folder not (yet) there
+		}
+		files, err := rw.list(settings, room)
+		if err != nil {
+			rw.listFailed(room, err, now)
+			continue
+		}
+		if f := rw.failed[room]; f != nil {
+			slog.Info("Results to follow reachable again", "room", room, "failures", f.failures)
+			delete(rw.failed, room)
+		}
+		newest, ok := newestMatching(files, pattern)
+		if !ok || newest.Size == 0 {
+			continue // An empty file is most likely truncated by an exporter writing in place
+		}
+		prev, seen := rw.seen[room]
+		if seen && newest.Name == prev.Name && newest.ModTime.Equal(prev.ModTime) {
+			continue
+		}
+		candidates[newest.Name] = true
+		if !rw.ready(settings, newest, now) {
+			continue // Not settled or complete yet; looked at again next poll
+		}
+		rw.seen[room] = newest
+		if newest.Name == rw.Hub.ActiveResult(room) {
+			if seen {
+				rw.Hub.RefreshResult(newest.Name) // Updated in place
+			}
+			continue
+		}
+		slog.Info("Following newest result", "room", room, "file", newest.Name)
+		rw.Hub.FollowResult(room, newest.Name)
+		rw.Hub.Audit.Record(AuditEntry{Source: "follow", Action: "set_result", Room: room, Value: newest.Name})
+	}
+	for name := range rw.pending {
+		if !candidates[name] {
+			delete(rw.pending, name) // Removed, or no longer the newest
+		}
+	}
+}
+
+// list lists room's files to follow. A share whose mount dropped and came
+// back can answer with a stale file handle until its path is looked up
+// afresh, so that error gets one retry after a stat of the folder. Aliases
+// the main room cannot reach are logged once per outage.
+func (rw *ResultsWatcher) list(settings Settings, room string) ([]ResultFile, error) {
+	down := make(map[string]bool)
+	opts := listOptions{Recursive: true, Exts: followExts}
+	if room == defaultRoom {
+		opts.AliasError = func(alias string, err error) {
+			down[alias] = true
+			if !rw.aliasDown[alias] {
+				slog.Warn("Cannot list results alias, retrying", "alias", alias, "dir", settings.ResultsAliases[alias], "err", err)
+			}
+		}
+	}
+	files, err := listRoomResults(settings, room, opts)
+	if isStaleHandle(err) {
+		dir := settings.ResultsDir
+		if room != defaultRoom {
+			dir = resultsFolder(settings.ResultsDir, settings.ResultsAliases, room)
+		}
+		slog.Debug("Stale handle on results folder, retrying", "room", room, "dir", dir)
+		os.Stat(dir)
+		files, err = listRoomResults(settings, room, opts)
+	}
+	if room == defaultRoom && err == nil {
+		for alias := range rw.aliasDown {
+			if !down[alias] {
+				slog.Info("Results alias reachable again", "alias", alias)
+			}
+		}
+		rw.aliasDown = down
+	}
+	return files, err
+}
+
+// listFailed logs the first failure of room's folder and schedules the next
+// attempt, doubling the wait up to maxListBackoff.
+func (rw *ResultsWatcher) listFailed(room string, err error, now time.Time) {
+	f := rw.failed[room]
+	if f == nil {
+		f = &listFailure{}
+		rw.failed[room] = f
+		slog.Warn("Cannot list results to follow, retrying", "room", room, "err", err)
+	} else {
+		slog.Debug("Results to follow still unreachable", "room", room, "failures", f.failures+1, "err", err)
+	}
+	f.failures++
+	backoff := resultsPollInterval << min(f.failures-1, 5)
+	f.retryAt = now.Add(min(backoff, maxListBackoff))
+}
+
+// isStaleHandle reports whether err is a stale file handle (ESTALE), which
+// network file systems return for handles that did not survive a remount.
+func isStaleHandle(err error) bool {
+	return err != nil && errors.Is(err, syscall.ESTALE)
+}
+
+// newestMatching returns the first of files (sorted newest first) whose base
+// name, or whole name if pattern has a slash, matches the glob pattern. An
+// empty pattern matches every file.
+func newestMatching(files []ResultFile, pattern string) (ResultFile, bool) {
+	for _, f := range files {
+		name := path.Base(f.Name)
+		if strings.Contains(pattern, "/") {
+			name = f.Name
+		}
+		if ok, _ := path.Match(pattern, name); ok || pattern == "" {
+			return f, true
+		}
+	}
+	return ResultFile{}, false
+}
+
+// validateFollowNewest checks the followNewest setting: room -> file glob.
+func validateFollowNewest(follow map[string]string) []string {
+	var problems []string
+	for room, pattern := range follow {
+		if err := validateRoomName(room); err != nil {
+			problems = append(problems, fmt.Sprintf("followNewest: room %q: %v", room, err))
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("followNewest: pattern %q is not a valid glob", pattern))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+
+	"display/internal/protocol"
+)
+
+// set_wifi gives a display the credentials of another network (value: a
+// protocol.WifiCredentials as JSON), so it can be moved between the club
+// and the venue without a keyboard. Since the value holds a password and
+// the command can take a display off the network, it is only accepted when
+// the server has a controllerToken, and only the network name is written to
+// the audit log.
+
+type WifiCredentials = protocol.WifiCredentials
+
+const maxSSIDLength = 32 // Bytes, as 802.11 allows
+
+var errWifiNeedsToken = errors.New("set_wifi needs a controllerToken on the server")
+
+// parseWifi decodes and checks the value of set_wifi.
+func parseWifi(value string) (WifiCredentials, error) {
+	var creds WifiCredentials
+	if err := json.Unmarshal([]byte(value), &creds); err != nil {
+		return creds, errors.New(`Wi-Fi value must be {"ssid": ..., "password": ...}`)
+	}
+	if creds.SSID == "" || len(creds.SSID) > maxSSIDLength || strings.ContainsFunc(creds.SSID, unicode.IsControl) {
+		return creds, errors.New("network name must be 1 to 32 bytes")
+	}
+	if p := creds.Password; p != "" && !validPassphrase(p) && !(len(p) == 64 && isHex(p)) {
+		return creds, errors.New("password must be 8 to 63 printable ASCII characters or 64 hex digits")
+	}
+	return creds, nil
+}
+
+// validPassphrase reports whether p is a WPA passphrase.
+func validPassphrase(p string) bool {
+	if len(p) < 8 || len(p) > 63 {
+		return false
+	}
+	for _, r := range p {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	return strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
+
+// checkCommandAllowed rejects commands the server's settings do not allow.
+func (h *Hub) checkCommandAllowed(command string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if command == "set_wifi" && h.ControllerToken == "" {
+		return errWifiNeedsToken
+	}
+	return nil
+}
+
+// auditValue is what the audit log keeps of a command's value: the network
+// name of set_wifi, without the password.
+func auditValue(command, value string) string {
+	if command != "set_wifi" {
+		return value
+	}
+	creds, _ := parseWifi(value)
+	return creds.SSID
+}